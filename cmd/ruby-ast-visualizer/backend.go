@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// rubyBinFlag adds -ruby-bin, which defaults to RUBY_AST_RUBY_BIN as it
+// does for the server.
+func rubyBinFlag(fs *flag.FlagSet) *string {
+	rubyBin := os.Getenv("RUBY_AST_RUBY_BIN")
+	if rubyBin == "" {
+		rubyBin = "ruby"
+	}
+	return fs.String("ruby-bin", rubyBin, "Ruby interpreter used to run the parsers")
+}
+
+// backendFlags choose the Ruby and parser a command runs.
+type backendFlags struct {
+	rubyBin *string
+	parser  string
+}
+
+func (b *backendFlags) register(fs *flag.FlagSet) {
+	b.rubyBin = rubyBinFlag(fs)
+	fs.StringVar(&b.parser, "parser", parser.DefaultParser, "parser backend: prism, ripper or stree")
+}
+
+// open starts the parser -parser names. Only stree keeps a Ruby process
+// running, which stop ends. The code is the user's own, so it's parsed
+// without the server's sandbox.
+func (b *backendFlags) open() (p parser.Parser, stop func(), err error) {
+	stop = func() {}
+	var pool *parser.WorkerPool
+	if b.parser == "stree" {
+		if pool, err = parser.NewWorkerPool(*b.rubyBin, nil, 1); err != nil {
+			return nil, nil, err
+		}
+		stop = pool.Close
+	}
+	p, ok := parser.NewParsers(*b.rubyBin, nil, pool)[b.parser]
+	if !ok {
+		stop()
+		return nil, nil, fmt.Errorf("unknown parser %q", b.parser)
+	}
+	return p, stop, nil
+}
+
+// parseArgs parses flags wherever they appear among the file names, so that
+// `parse file.rb -format dot` works as well as the flags first. Everything
+// after -- is a file name.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return files, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(files, rest...), nil
+		}
+		files = append(files, rest[0])
+		args = rest[1:]
+	}
+}
+
+// flagError is the exit code for a failure to parse flags, which the flag
+// set has already reported.
+func flagError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return exitFailure
+}
+
+// newFlagSet returns a flag set for the named command whose usage message
+// describes its arguments.
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ruby-ast-visualizer %s [flags] %s\n\nflags:\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// readSource reads a file, or standard input for -.
+func readSource(name string) (string, error) {
+	var b []byte
+	var err error
+	if name == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(name)
+	}
+	return string(b), err
+}
+
+// reportError prints a failure to parse name and returns the exit code for
+// it: a syntax error is something found in the file, anything else a
+// failure to run.
+func reportError(name string, err error) int {
+	var se *parser.SyntaxError
+	if !errors.As(err, &se) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return exitFailure
+	}
+	if se.Line > 0 {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", name, se.Line, se.Column+1, se.Message)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, se.Message)
+	}
+	return exitFound
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// runDiff compares the trees of two files as /diff does, exiting 1 if they
+// differ.
+func runDiff(args []string) int {
+	fs := newFlagSet("diff", "before.rb after.rb")
+	var backend backendFlags
+	backend.register(fs)
+	asJSON := fs.Bool("json", false, "print the diff as /diff returns it instead of one change per line")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(files) != 2 {
+		fs.Usage()
+		return exitFailure
+	}
+
+	p, stop, err := backend.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return exitFailure
+	}
+	defer stop()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	var roots [2]*analyze.Node
+	for i, name := range files {
+		code, err := readSource(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		output, err := p.Parse(ctx, code)
+		if err != nil {
+			// Unlike a syntax error in parse, one here means no diff.
+			reportError(name, err)
+			return exitFailure
+		}
+		if roots[i], err = analyze.DecodeAST(output); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return exitFailure
+		}
+	}
+
+	result := analyze.DiffTrees(roots[0], roots[1])
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		for _, c := range result.Changes {
+			fmt.Println(describeChange(c, files[0], files[1]))
+		}
+	}
+	if !result.Identical {
+		return exitFound
+	}
+	return 0
+}
+
+// describeChange formats a change as one line, such as
+//
+//	changed int a.rb:3 -> b.rb:3
+func describeChange(c analyze.NodeChange, before, after string) string {
+	line := c.Kind + " " + c.Type
+	if c.Before != nil {
+		line += " " + describeRef(c.Before, before)
+	}
+	if c.Before != nil && c.After != nil {
+		line += " ->"
+	}
+	if c.After != nil {
+		line += " " + describeRef(c.After, after)
+	}
+	return line
+}
+
+// describeRef points at a node by its line, or its path in the tree when
+// the parser gave no location.
+func describeRef(ref *analyze.NodeRef, name string) string {
+	if ref.Location == nil {
+		return name + ":" + ref.Path
+	}
+	return fmt.Sprintf("%s:%d", name, ref.Location.StartLine)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// runFormat prints files formatted by syntax_tree, as /format does, or with
+// -check lists those that aren't.
+func runFormat(args []string) int {
+	fs := newFlagSet("format", "file.rb...")
+	rubyBin := rubyBinFlag(fs)
+	check := fs.Bool("check", false, "print the names of files that aren't formatted instead, and exit 1 if there are any")
+	write := fs.Bool("w", false, "write formatted code back to the files instead of printing it")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return exitFailure
+	}
+
+	formatter := parser.NewFormatter(*rubyBin, nil)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	status := 0
+	for _, name := range files {
+		code, err := readSource(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = max(status, exitFailure)
+			continue
+		}
+		output, err := formatter.Parse(ctx, code)
+		if err != nil {
+			status = max(status, reportError(name, err))
+			continue
+		}
+		formatted := string(output)
+		switch {
+		case *check:
+			if formatted != code {
+				fmt.Println(name)
+				status = max(status, exitFound)
+			}
+		case *write && name != "-":
+			if formatted == code {
+				continue
+			}
+			if err := os.WriteFile(name, output, 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = max(status, exitFailure)
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	return status
+}
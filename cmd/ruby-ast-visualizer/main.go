@@ -0,0 +1,69 @@
+// Command ruby-ast-visualizer parses Ruby from the command line with the
+// same backends and output formats as the server, so trees can be used in
+// scripts and CI, or runs the server itself:
+//
+//	ruby-ast-visualizer parse [flags] file.rb
+//	ruby-ast-visualizer format [flags] file.rb...
+//	ruby-ast-visualizer diff [flags] before.rb after.rb
+//	ruby-ast-visualizer serve [server flags]
+//
+// A file named - is read from standard input. Commands exit 1 when they
+// find a syntax error, a difference or unformatted code, and 2 when they
+// can't run.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/httpapi"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+const (
+	exitFound   = 1
+	exitFailure = 2
+)
+
+const usage = `usage: ruby-ast-visualizer <command> [flags] [files]
+
+commands:
+  parse   print a file's syntax tree
+  format  print files formatted by syntax_tree
+  diff    compare the syntax trees of two files
+  serve   run the HTTP server
+
+Run a command with -h for its flags.
+`
+
+var commands = map[string]func(args []string) int{
+	"parse":  runParse,
+	"format": runFormat,
+	"diff":   runDiff,
+	"serve": func(args []string) int {
+		httpapi.Run(args)
+		return 0
+	},
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == parser.SandboxExecArg {
+		parser.SandboxExec(os.Args[2:])
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitFailure)
+	}
+	switch name := os.Args[1]; name {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		run, ok := commands[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "ruby-ast-visualizer: unknown command %q\n\n%s", name, usage)
+			os.Exit(exitFailure)
+		}
+		os.Exit(run(os.Args[2:]))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// formatNames lists the -format values, as /parse takes them.
+func formatNames() string {
+	names := []string{analyze.DefaultFormat}
+	for name := range analyze.OutputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// runParse prints a file's tree in any of the server's output formats.
+func runParse(args []string) int {
+	fs := newFlagSet("parse", "file.rb")
+	var backend backendFlags
+	backend.register(fs)
+	format := fs.String("format", analyze.DefaultFormat, "output format: "+formatNames())
+	var opts analyze.FormatOptions
+	fs.IntVar(&opts.MaxDepth, "max-depth", 0, "cut the tree off below this depth, or 0 for no limit")
+	fs.IntVar(&opts.MaxNodes, "max-nodes", 0, "cut the tree off after this many nodes, or 0 for no limit")
+	fs.BoolVar(&opts.CollapseLiterals, "collapse-literals", false, "draw literals inside their parent node in mermaid output")
+	fs.BoolVar(&opts.Metrics, "metrics", false, "attach code metrics to def, class and module nodes in JSON")
+	fs.BoolVar(&opts.Scopes, "scopes", false, "mark local variable bindings and uses in JSON")
+	fs.StringVar(&opts.Filter, "filter", "", "JSONPath expression selecting the parts of the JSON tree to print")
+	fs.BoolVar(&opts.Compact, "compact", false, "strip locations, comments and other parser detail from JSON")
+	fs.BoolVar(&opts.Raw, "raw", false, "print JSON in the parser's own shape rather than normalized")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return exitFailure
+	}
+	if !analyze.KnownFormat(*format) {
+		fmt.Fprintf(os.Stderr, "parse: unknown format %q; use one of %s\n", *format, formatNames())
+		return exitFailure
+	}
+
+	name := files[0]
+	code, err := readSource(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	p, stop, err := backend.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse: %v\n", err)
+		return exitFailure
+	}
+	defer stop()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	output, err := p.Parse(ctx, code)
+	if err != nil {
+		return reportError(name, err)
+	}
+	_, body, err := analyze.RenderFormat(*format, output, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return exitFailure
+	}
+	os.Stdout.Write(body)
+	if !strings.HasSuffix(string(body), "\n") {
+		fmt.Println()
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+// Command server serves the Ruby AST Visualizer API, and the frontend when
+// built with -tags embedfrontend.
+package main
+
+import (
+	"os"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/httpapi"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == parser.SandboxExecArg {
+		parser.SandboxExec(os.Args[2:])
+	}
+	httpapi.Run(os.Args[1:])
+}
@@ -0,0 +1,20 @@
+//go:build embedfrontend
+
+package visualizer
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The frontend is embedded from the output of `npm run build`, which has to
+// run first.
+//
+//go:embed build
+var frontendFS embed.FS
+
+// Frontend returns the files of the built frontend, if there are any.
+func Frontend() (fs.FS, bool) {
+	assets, err := fs.Sub(frontendFS, "build")
+	return assets, err == nil
+}
@@ -0,0 +1,15 @@
+//go:build !embedfrontend
+
+// Package visualizer holds the built frontend for the server to embed. It
+// lives at the repository root because go:embed can only reach files below
+// the package.
+package visualizer
+
+import "io/fs"
+
+// Frontend returns the files of the built frontend. Without the
+// embedfrontend tag there are none: the binary serves only the API, and the
+// frontend is hosted separately.
+func Frontend() (fs.FS, bool) {
+	return nil, false
+}
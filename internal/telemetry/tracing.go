@@ -0,0 +1,322 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows OpenTelemetry conventions and exports spans with OTLP over
+// HTTP, JSON-encoded, to any collector. Incoming W3C traceparent headers are
+// honoured so the server's spans join the caller's trace. When no endpoint
+// is configured every span is a no-op nil *span.
+
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+	traceQueueSize     = 4096
+)
+
+// spanKind values from the OTLP protobuf enum.
+const (
+	spanKindInternal = 1
+	SpanKindServer   = 2
+)
+
+type SpanAttr struct {
+	Key   string        `json:"key"`
+	Value SpanAttrValue `json:"value"`
+}
+
+type SpanAttrValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func Attr(key string, value interface{}) SpanAttr {
+	a := SpanAttr{Key: key}
+	switch v := value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		a.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case bool:
+		a.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+type Span struct {
+	tracer  *Tracer
+	TraceID string
+	spanID  string
+	parent  string
+	name    string
+	kind    int
+	start   time.Time
+
+	mu      sync.Mutex
+	attrs   []SpanAttr
+	errMsg  string
+	errored bool
+}
+
+type spanKey struct{}
+
+func SpanFrom(ctx context.Context) *Span {
+	sp, _ := ctx.Value(spanKey{}).(*Span)
+	return sp
+}
+
+// StartSpan starts a span as a child of the one in ctx, if any, and returns
+// a context carrying it.
+func StartSpan(ctx context.Context, name string, attrs ...SpanAttr) (context.Context, *Span) {
+	return ActiveTracer.Start(ctx, name, spanKindInternal, "", "", attrs)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (sp *Span) SetAttrs(attrs ...SpanAttr) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.attrs = append(sp.attrs, attrs...)
+	sp.mu.Unlock()
+}
+
+// setError marks the span as failed if err is non-nil.
+func (sp *Span) SetError(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.errored = true
+	sp.errMsg = err.Error()
+	sp.mu.Unlock()
+}
+
+func (sp *Span) End() {
+	if sp == nil {
+		return
+	}
+	sp.tracer.export(sp, time.Now())
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []SpanAttr `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []SpanAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// Tracer batches finished spans and posts them to an OTLP/HTTP endpoint.
+// Spans are dropped rather than blocking requests if the collector can't
+// keep up.
+type Tracer struct {
+	Endpoint string
+	service  string
+	version  string
+	client   *http.Client
+	queue    chan otlpSpan
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// ActiveTracer is the process tracer; nil disables tracing.
+var ActiveTracer *Tracer
+
+// NewTracer starts a tracer reporting spans as from version of service.
+func NewTracer(endpoint, service, version string) *Tracer {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	t := &Tracer{
+		Endpoint: endpoint,
+		service:  service,
+		version:  version,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan otlpSpan, traceQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kind int, traceID, parentID string, attrs []SpanAttr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if parent := SpanFrom(ctx); parent != nil && traceID == "" {
+		traceID, parentID = parent.TraceID, parent.spanID
+	}
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	sp := &Span{
+		tracer:  t,
+		TraceID: traceID,
+		spanID:  randomHex(8),
+		parent:  parentID,
+		name:    name,
+		kind:    kind,
+		start:   time.Now(),
+		attrs:   attrs,
+	}
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+func (t *Tracer) export(sp *Span, end time.Time) {
+	sp.mu.Lock()
+	s := otlpSpan{
+		TraceID:           sp.TraceID,
+		SpanID:            sp.spanID,
+		ParentSpanID:      sp.parent,
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        sp.attrs,
+	}
+	if sp.errored {
+		s.Status.Code = 2 // STATUS_CODE_ERROR
+		s.Status.Message = sp.errMsg
+	}
+	sp.mu.Unlock()
+
+	select {
+	case t.queue <- s:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stop:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.send(batch)
+			return
+		}
+		t.send(batch)
+		batch = nil
+	}
+}
+
+func (t *Tracer) send(spans []otlpSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	payload := otlpExport{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []SpanAttr{Attr("service.name", t.service), Attr("service.version", t.version)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "ruby-ast-visualizer"},
+			Spans: spans,
+		}},
+	}}}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding spans", "err", err)
+		return
+	}
+	resp, err := t.client.Post(t.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Error exporting spans", "endpoint", t.Endpoint, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Error exporting spans", "endpoint", t.Endpoint, "status", resp.Status)
+	}
+}
+
+// shutdown flushes the spans queued so far. Spans ended later are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+}
+
+// ParseTraceparent extracts the trace and parent span IDs from a W3C
+// traceparent header: version-traceid-spanid-flags.
+func ParseTraceparent(header string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	for _, id := range parts[1:3] {
+		if _, err := hex.DecodeString(id); err != nil || strings.Trim(id, "0") == "" {
+			return "", "", false
+		}
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
@@ -0,0 +1,319 @@
+// Package analyze works on the JSON trees from package parser: it decodes
+// them into Nodes, normalizes them into one shape across parsers, renders
+// them in other formats, and derives metrics, symbols, scopes and graphs
+// from them.
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is one JSON object from a parser's output. Fields keep their
+// original order so that anything rendered from the tree reads the same way
+// as the source (receiver before message, and so on). Type is the object's
+// "type" field and is empty for untyped objects.
+//
+// Field values are *Node, []interface{}, string, json.Number, bool or nil.
+type Node struct {
+	Type   string
+	Fields []ASTField
+}
+
+type ASTField struct {
+	Name  string
+	Value interface{}
+}
+
+// Location is a node's source range as [start_line, start_char, end_line,
+// end_char], with lines 1-based and chars 0-based offsets into the source.
+type Location struct {
+	StartLine, StartChar, EndLine, EndChar int
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", l.StartLine, l.StartChar, l.EndLine, l.EndChar)
+}
+
+func (l Location) MarshalJSON() ([]byte, error) {
+	return json.Marshal([4]int{l.StartLine, l.StartChar, l.EndLine, l.EndChar})
+}
+
+func DecodeAST(data []byte) (*Node, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := value.(*Node)
+	if !ok {
+		return nil, errors.New("AST root is not an object")
+	}
+	return root, nil
+}
+
+// decodeJSON decodes any parser output, including ripper's s-expressions,
+// which are arrays rather than objects.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		n := &Node{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if t, ok := value.(string); ok && key == "type" {
+				n.Type = t
+			}
+			n.Fields = append(n.Fields, ASTField{Name: key, Value: value})
+		}
+		_, err := dec.Token()
+		return n, err
+	case json.Delim('['):
+		values := []interface{}{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err := dec.Token()
+		return values, err
+	default:
+		return tok, nil
+	}
+}
+
+func (n *Node) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range n.Fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (n *Node) field(name string) (interface{}, bool) {
+	for _, f := range n.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (n *Node) location() (Location, bool) {
+	value, ok := n.field("location")
+	if !ok {
+		return Location{}, false
+	}
+	parts, ok := value.([]interface{})
+	if !ok || len(parts) != 4 {
+		return Location{}, false
+	}
+
+	var ints [4]int
+	for i, part := range parts {
+		num, ok := part.(json.Number)
+		if !ok {
+			return Location{}, false
+		}
+		v, err := num.Int64()
+		if err != nil {
+			return Location{}, false
+		}
+		ints[i] = int(v)
+	}
+	return Location{ints[0], ints[1], ints[2], ints[3]}, true
+}
+
+// value returns the node's literal "value" field, if it has a string one.
+func (n *Node) value() (string, bool) {
+	v, ok := n.field("value")
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// label is the node's display name, matching what the frontend shows:
+// the type, followed by the literal value for token nodes.
+func (n *Node) label() string {
+	if v, ok := n.value(); ok {
+		return fmt.Sprintf("%s: %q", n.Type, v)
+	}
+	return n.Type
+}
+
+// astEdge is a child node together with the name of the field it hangs off
+// and its path segment relative to the parent, e.g. "body[2]".
+type astEdge struct {
+	Field string
+	Path  string
+	Node  *Node
+}
+
+// children returns the typed nodes directly below n, in field order. Nodes
+// nested inside arrays or untyped objects are flattened into the field that
+// contains them.
+func (n *Node) children() []astEdge {
+	var edges []astEdge
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" {
+			continue
+		}
+		collectNodes(f.Name, f.Name, f.Value, &edges)
+	}
+	return edges
+}
+
+func collectNodes(field, path string, value interface{}, edges *[]astEdge) {
+	switch v := value.(type) {
+	case *Node:
+		if v.Type != "" {
+			*edges = append(*edges, astEdge{Field: field, Path: path, Node: v})
+			return
+		}
+		for _, f := range v.Fields {
+			collectNodes(field, path+"."+f.Name, f.Value, edges)
+		}
+	case []interface{}:
+		for i, element := range v {
+			collectNodes(field, fmt.Sprintf("%s[%d]", path, i), element, edges)
+		}
+	}
+}
+
+// joinPath appends a child's path segment to its parent's node path. The
+// root's path is empty.
+func joinPath(parent, segment string) string {
+	if parent == "" {
+		return segment
+	}
+	return parent + "." + segment
+}
+
+// Walk calls fn for n and every typed node below it in depth-first order,
+// with the root at depth 0. Returning false from fn skips that node's
+// children.
+func Walk(n *Node, fn func(n *Node, depth int) bool) {
+	walkDepth(n, 0, fn)
+}
+
+func walkDepth(n *Node, depth int, fn func(n *Node, depth int) bool) {
+	if !fn(n, depth) {
+		return
+	}
+	for _, edge := range n.children() {
+		walkDepth(edge.Node, depth+1, fn)
+	}
+}
+
+// TreeNode is an AST node together with its place in the tree.
+type TreeNode struct {
+	Node   *Node
+	Path   string
+	Field  string
+	Parent *TreeNode
+	Depth  int
+}
+
+// flattenTree lists every typed node in the tree in depth-first order, so
+// parents always come before their children.
+func flattenTree(root *Node) []*TreeNode {
+	var nodes []*TreeNode
+	var visit func(t *TreeNode)
+	visit = func(t *TreeNode) {
+		nodes = append(nodes, t)
+		for _, edge := range t.Node.children() {
+			visit(&TreeNode{
+				Node:   edge.Node,
+				Path:   joinPath(t.Path, edge.Path),
+				Field:  edge.Field,
+				Parent: t,
+				Depth:  t.Depth + 1,
+			})
+		}
+	}
+	visit(&TreeNode{Node: root})
+	return nodes
+}
+
+// LookupPath follows a node path as produced by flattenTree, such as
+// "statements.body[0].arguments", from root. The empty path is the root.
+func LookupPath(root *Node, path string) (*Node, bool) {
+	if path == "" {
+		return root, true
+	}
+
+	var current interface{} = root
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name = segment[:i]
+		}
+		n, ok := current.(*Node)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = n.field(name); !ok {
+			return nil, false
+		}
+
+		for rest := segment[len(name):]; rest != ""; {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return nil, false
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			values, ok := current.([]interface{})
+			if err != nil || !ok || index < 0 || index >= len(values) {
+				return nil, false
+			}
+			current = values[index]
+			rest = rest[end+1:]
+		}
+	}
+
+	n, ok := current.(*Node)
+	if !ok || n.Type == "" {
+		return nil, false
+	}
+	return n, true
+}
@@ -0,0 +1,160 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// calleeName returns the name of the method a call node calls. stree keeps it
+// in an ident node, under message for calls with a receiver or arguments and
+// under value for fcall and vcall; Prism has a single call type with a plain
+// string name. Calls through .() and super have no name to give.
+func calleeName(n *Node) string {
+	var v interface{}
+	switch n.Type {
+	case "call":
+		if name, ok := n.field("name"); ok {
+			s, _ := name.(string)
+			return s
+		}
+		v, _ = n.field("message")
+	case "command", "command_call":
+		v, _ = n.field("message")
+	case "fcall", "vcall":
+		v, _ = n.field("value")
+	}
+	ident, ok := v.(*Node)
+	if !ok {
+		return ""
+	}
+	name, _ := ident.value()
+	return name
+}
+
+type CallGraphMethod struct {
+	Name        string       `json:"name"`
+	Definitions []SourceSite `json:"definitions"`
+
+	namespace string
+	bare      string
+}
+
+type CallGraphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Calls int    `json:"calls"`
+}
+
+type CallGraph struct {
+	Methods []*CallGraphMethod `json:"methods"`
+	Edges   []CallGraphEdge    `json:"edges"`
+}
+
+// callSite is a call made from inside a method, by the name called.
+type callSite struct {
+	Caller *CallGraphMethod
+	Callee string
+}
+
+// BuildCallGraph links the methods defined across files by the calls their
+// bodies make. Receivers aren't resolved: a call goes to the method of that
+// name in the caller's own class or module if there is one, and otherwise to
+// every method of that name. Calls to methods defined nowhere in the files,
+// such as the standard library's, are left out. A nested def is a method of
+// its own, and the calls in it aren't the enclosing method's.
+func BuildCallGraph(files []ParsedFile) *CallGraph {
+	methods := make(map[string]*CallGraphMethod)
+	byBare := make(map[string][]*CallGraphMethod)
+	var calls []callSite
+
+	for _, f := range files {
+		var visit func(n *Node, namespace string, caller *CallGraphMethod)
+		visit = func(n *Node, namespace string, caller *CallGraphMethod) {
+			switch n.Type {
+			case "class", "module":
+				namespace = enterNamespace(n, namespace)
+				caller = nil
+			case "def", "defs":
+				name := qualifiedMethodName(n, namespace)
+				m := methods[name]
+				if m == nil {
+					bare, _ := methodName(n)
+					m = &CallGraphMethod{Name: name, namespace: namespace, bare: bare}
+					methods[name] = m
+					byBare[bare] = append(byBare[bare], m)
+				}
+				site := SourceSite{Path: f.Path}
+				if loc, ok := n.location(); ok {
+					site.Location = &loc
+				}
+				m.Definitions = append(m.Definitions, site)
+				caller = m
+			default:
+				if name := calleeName(n); name != "" && caller != nil {
+					calls = append(calls, callSite{Caller: caller, Callee: name})
+				}
+			}
+			for _, edge := range n.children() {
+				visit(edge.Node, namespace, caller)
+			}
+		}
+		visit(f.Root, "", nil)
+	}
+
+	counts := make(map[[2]string]int)
+	for _, c := range calls {
+		candidates := byBare[c.Callee]
+		var local []*CallGraphMethod
+		for _, m := range candidates {
+			if m.namespace == c.Caller.namespace {
+				local = append(local, m)
+			}
+		}
+		if len(local) > 0 {
+			candidates = local
+		}
+		for _, m := range candidates {
+			counts[[2]string{c.Caller.Name, m.Name}]++
+		}
+	}
+
+	g := &CallGraph{Methods: make([]*CallGraphMethod, 0, len(methods)), Edges: make([]CallGraphEdge, 0, len(counts))}
+	for _, m := range methods {
+		g.Methods = append(g.Methods, m)
+	}
+	sort.Slice(g.Methods, func(i, j int) bool { return g.Methods[i].Name < g.Methods[j].Name })
+	for pair, n := range counts {
+		g.Edges = append(g.Edges, CallGraphEdge{From: pair[0], To: pair[1], Calls: n})
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return g
+}
+
+// WriteCallGraphDOT renders the call graph for Graphviz, labelling edges made
+// by more than one call with how many.
+func WriteCallGraphDOT(w io.Writer, g *CallGraph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph calls {")
+	fmt.Fprintln(bw, `  rankdir=LR;`)
+	fmt.Fprintln(bw, `  node [shape=box, fontname="monospace"];`)
+	for _, m := range g.Methods {
+		fmt.Fprintf(bw, "  \"%s\";\n", dotEscaper.Replace(m.Name))
+	}
+	for _, e := range g.Edges {
+		attrs := ""
+		if e.Calls > 1 {
+			attrs = fmt.Sprintf(" [label=\"%d\"]", e.Calls)
+		}
+		fmt.Fprintf(bw, "  \"%s\" -> \"%s\"%s;\n", dotEscaper.Replace(e.From), dotEscaper.Replace(e.To), attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,705 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ControlFlowGraph is the basic blocks of one method and the jumps between
+// them. Edges with no kind fall through; the others are the outcome of a
+// block's last statement: true and false for a condition, loop for the way
+// back to a loop's test or a block's call, yield into a block, raise to a
+// rescue clause or out of the method, and break, next, redo, retry and
+// return for those keywords.
+type ControlFlowGraph struct {
+	Method   string      `json:"method"`
+	Location *Location   `json:"location,omitempty"`
+	Blocks   []*CFGBlock `json:"blocks"`
+	Edges    []CFGEdge   `json:"edges"`
+}
+
+// CFGBlock is a basic block: statements that run one after another. The
+// first block is the entry and the last the exit, which holds nothing.
+// Unreachable marks code no path from the entry gets to, like statements
+// after a return.
+type CFGBlock struct {
+	ID          int            `json:"id"`
+	Kind        string         `json:"kind,omitempty"`
+	Statements  []CFGStatement `json:"statements"`
+	Unreachable bool           `json:"unreachable,omitempty"`
+}
+
+// CFGStatement is a statement in a block, or the condition a block ends by
+// testing. Source is its first line.
+type CFGStatement struct {
+	Type     string    `json:"type"`
+	Path     string    `json:"path"`
+	Location *Location `json:"location,omitempty"`
+	Source   string    `json:"source,omitempty"`
+}
+
+type CFGEdge struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Kind string `json:"kind,omitempty"`
+}
+
+// BuildCFG builds the control flow graph of the method called method, by
+// its qualified name (Foo#bar, Foo.build) or its bare one, or of the first
+// method if method is empty. It reports false if there's no such method.
+//
+// The graph is statement-level: branching inside an expression, like the
+// right side of x = (a || b), stays within its statement, though a
+// conditional or loop in statement position is split up. A block passed to
+// a call is drawn as running zero or more times from the call, and an
+// exception can leave any block inside a begin with a rescue. Ensure
+// clauses are only drawn on the path that doesn't raise or jump out.
+func BuildCFG(root *Node, code, method string) (*ControlFlowGraph, bool) {
+	def, name := findMethod(root, method)
+	if def == nil {
+		return nil, false
+	}
+
+	b := &cfgBuilder{
+		g:     &ControlFlowGraph{Method: name},
+		paths: make(map[*Node]string),
+		runes: []rune(code),
+	}
+	for _, t := range flattenTree(def) {
+		b.paths[t.Node] = t.Path
+	}
+	if loc, ok := def.location(); ok {
+		b.g.Location = &loc
+	}
+	entry := b.newBlock()
+	entry.Kind = "entry"
+	b.exit = b.newBlock()
+	b.exit.Kind = "exit"
+	b.cur = entry
+	b.visit(fieldOf(def, "bodystmt", "body"))
+	b.edge(b.cur, b.exit, "")
+	b.finish(entry)
+	return b.g, true
+}
+
+// findMethod finds the def named method, naming methods as /metrics/code
+// does.
+func findMethod(root *Node, method string) (def *Node, name string) {
+	var visit func(n *Node, namespace string)
+	visit = func(n *Node, namespace string) {
+		if def != nil {
+			return
+		}
+		switch n.Type {
+		case "class", "module":
+			namespace = enterNamespace(n, namespace)
+		case "def", "defs":
+			qualified := qualifiedMethodName(n, namespace)
+			bare, _ := methodName(n)
+			if method == "" || method == qualified || method == bare {
+				def, name = n, qualified
+				return
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, namespace)
+		}
+	}
+	visit(root, "")
+	return def, name
+}
+
+// fieldOf returns the first of the fields n has set.
+func fieldOf(n *Node, names ...string) interface{} {
+	for _, name := range names {
+		if v, ok := n.field(name); ok && v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func nodeField(n *Node, names ...string) *Node {
+	v, _ := fieldOf(n, names...).(*Node)
+	return v
+}
+
+// cfgJumps are where the keywords that jump go, inside the innermost loop
+// or block.
+type cfgJumps struct {
+	next, brk, redo *CFGBlock
+}
+
+// cfgRescue collects the blocks of a begin body that an exception could
+// leave for handler.
+type cfgRescue struct {
+	handler *CFGBlock
+	covered map[*CFGBlock]bool
+	order   []*CFGBlock
+}
+
+type cfgExit struct {
+	from *CFGBlock
+	kind string
+}
+
+type cfgBuilder struct {
+	g     *ControlFlowGraph
+	paths map[*Node]string
+	runes []rune
+	exit  *CFGBlock
+
+	// cur is the block statements are added to, or nil after a jump, when
+	// the next statement starts an unreachable block.
+	cur     *CFGBlock
+	loops   []cfgJumps
+	rescues []*cfgRescue
+	retries []*CFGBlock
+}
+
+func (b *cfgBuilder) newBlock() *CFGBlock {
+	blk := &CFGBlock{ID: len(b.g.Blocks), Statements: []CFGStatement{}}
+	b.g.Blocks = append(b.g.Blocks, blk)
+	return blk
+}
+
+func (b *cfgBuilder) edge(from, to *CFGBlock, kind string) {
+	if from != nil {
+		b.g.Edges = append(b.g.Edges, CFGEdge{From: from.ID, To: to.ID, Kind: kind})
+	}
+}
+
+// jump ends the current block with an edge to to.
+func (b *cfgBuilder) jump(to *CFGBlock, kind string) {
+	b.edge(b.cur, to, kind)
+	b.cur = nil
+}
+
+// branch starts a block that the current one leads to by an edge of kind.
+func (b *cfgBuilder) branch(kind string) *CFGBlock {
+	blk := b.newBlock()
+	b.edge(b.cur, blk, kind)
+	return blk
+}
+
+// join continues in a block that all of exits lead to, or in none if every
+// path jumped away.
+func (b *cfgBuilder) join(exits ...cfgExit) {
+	b.cur = nil
+	for _, e := range exits {
+		if e.from == nil {
+			continue
+		}
+		if b.cur == nil {
+			b.cur = b.newBlock()
+		}
+		b.edge(e.from, b.cur, e.kind)
+	}
+}
+
+// raiseTarget is where an exception raised here goes.
+func (b *cfgBuilder) raiseTarget() *CFGBlock {
+	if len(b.rescues) > 0 {
+		return b.rescues[len(b.rescues)-1].handler
+	}
+	return b.exit
+}
+
+// add appends a statement to the current block, starting one if there's
+// none.
+func (b *cfgBuilder) add(n *Node) {
+	if n == nil {
+		return
+	}
+	if b.cur == nil {
+		b.cur = b.newBlock()
+	}
+	stmt := CFGStatement{Type: n.Type, Path: b.paths[n]}
+	if loc, ok := n.location(); ok {
+		stmt.Location = &loc
+		if loc.StartChar <= loc.EndChar && loc.EndChar <= len(b.runes) {
+			text := strings.TrimSpace(string(b.runes[loc.StartChar:loc.EndChar]))
+			if i := strings.IndexByte(text, '\n'); i >= 0 {
+				text = strings.TrimSpace(text[:i]) + " ..."
+			}
+			stmt.Source = text
+		}
+	}
+	b.cur.Statements = append(b.cur.Statements, stmt)
+	if len(b.rescues) > 0 {
+		r := b.rescues[len(b.rescues)-1]
+		if !r.covered[b.cur] {
+			r.covered[b.cur] = true
+			r.order = append(r.order, b.cur)
+		}
+	}
+}
+
+func (b *cfgBuilder) visit(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, element := range v {
+			b.visit(element)
+		}
+	case *Node:
+		if v != nil && v.Type != "" {
+			b.visitNode(v)
+		}
+	}
+}
+
+func (b *cfgBuilder) visitNode(n *Node) {
+	switch n.Type {
+	case "statements":
+		b.visit(fieldOf(n, "body"))
+	case "else", "ensure":
+		b.visit(fieldOf(n, "statements"))
+	case "bodystmt":
+		b.protected(fieldOf(n, "statements"), nodeField(n, "rescue"), fieldOf(n, "else", "else_clause"), nodeField(n, "ensure"))
+	case "begin":
+		if body := nodeField(n, "bodystmt"); body != nil {
+			b.visit(body)
+			return
+		}
+		b.protected(fieldOf(n, "statements"), nodeField(n, "rescue_clause"), fieldOf(n, "else_clause"), nodeField(n, "ensure_clause"))
+	case "if", "unless", "elsif":
+		b.conditional(n, n.Type == "unless", fieldOf(n, "statements"), fieldOf(n, "consequent", "subsequent", "else_clause"))
+	case "if_mod", "unless_mod":
+		b.conditional(n, n.Type == "unless_mod", fieldOf(n, "statement"), nil)
+	case "ifop":
+		b.conditional(n, false, fieldOf(n, "truthy"), fieldOf(n, "falsy"))
+	case "case", "case_match":
+		b.caseStatement(n)
+	case "while", "until", "while_mod", "until_mod":
+		b.loop(n)
+	case "for":
+		b.forLoop(n)
+	case "rescue_mod", "rescue_modifier":
+		b.rescueModifier(n)
+	case "return":
+		b.add(n)
+		b.jump(b.exit, "return")
+	case "break", "next", "redo":
+		b.add(n)
+		if len(b.loops) == 0 {
+			b.jump(b.exit, n.Type)
+			return
+		}
+		jumps := b.loops[len(b.loops)-1]
+		target := map[string]*CFGBlock{"break": jumps.brk, "next": jumps.next, "redo": jumps.redo}[n.Type]
+		b.jump(target, n.Type)
+	case "retry":
+		b.add(n)
+		if len(b.retries) == 0 {
+			b.jump(b.exit, "retry")
+			return
+		}
+		b.jump(b.retries[len(b.retries)-1], "retry")
+	default:
+		switch name := calleeName(n); {
+		case (name == "raise" || name == "fail") && fieldOf(n, "receiver") == nil:
+			b.add(n)
+			b.jump(b.raiseTarget(), "raise")
+		case n.Type == "method_add_block":
+			b.iterate(nodeField(n, "call"), nodeField(n, "block"))
+		case callTypes[n.Type] && nodeField(n, "block") != nil && nodeField(n, "block").Type == "block":
+			b.iterate(n, nodeField(n, "block"))
+		case (n.Type == "command" || n.Type == "command_call") && nodeField(n, "block") != nil:
+			b.iterate(n, nodeField(n, "block"))
+		default:
+			b.add(n)
+		}
+	}
+}
+
+// conditional splits on n's predicate, or on n itself if it has none to
+// show, running then when it holds and otherwise when it doesn't.
+func (b *cfgBuilder) conditional(n *Node, negated bool, then, otherwise interface{}) {
+	if pred := nodeField(n, "predicate"); pred != nil {
+		b.add(pred)
+	} else {
+		b.add(n)
+	}
+	yes, no := "true", "false"
+	if negated {
+		yes, no = no, yes
+	}
+	test := b.cur
+	b.cur = b.branch(yes)
+	b.visit(then)
+	thenEnd := b.cur
+	b.cur = test
+	if otherwise == nil {
+		b.join(cfgExit{thenEnd, ""}, cfgExit{test, no})
+		return
+	}
+	b.cur = b.branch(no)
+	b.visit(otherwise)
+	b.join(cfgExit{thenEnd, ""}, cfgExit{b.cur, ""})
+}
+
+// caseStatement tests each when or in clause in turn, stree chaining them
+// through consequent and Prism listing them under conditions.
+func (b *cfgBuilder) caseStatement(n *Node) {
+	if subject := nodeField(n, "value", "predicate"); subject != nil {
+		b.add(subject)
+	}
+	var clauses []*Node
+	if list, ok := fieldOf(n, "conditions").([]interface{}); ok {
+		for _, element := range list {
+			if c, ok := element.(*Node); ok {
+				clauses = append(clauses, c)
+			}
+		}
+		if c := nodeField(n, "else_clause", "consequent"); c != nil {
+			clauses = append(clauses, c)
+		}
+	} else {
+		for c := nodeField(n, "consequent"); c != nil; c = nodeField(c, "consequent") {
+			clauses = append(clauses, c)
+		}
+	}
+
+	var exits []cfgExit
+	var test *CFGBlock
+	for _, c := range clauses {
+		if test != nil {
+			b.cur = test
+			b.cur = b.branch("false")
+		}
+		if c.Type == "else" {
+			b.visit(c)
+			exits = append(exits, cfgExit{b.cur, ""})
+			test = nil
+			break
+		}
+		switch conds := fieldOf(c, "arguments", "pattern", "conditions").(type) {
+		case *Node:
+			b.add(conds)
+		case []interface{}:
+			for _, element := range conds {
+				if cond, ok := element.(*Node); ok {
+					b.add(cond)
+				}
+			}
+		}
+		if b.cur == nil || len(b.cur.Statements) == 0 {
+			b.add(c)
+		}
+		test = b.cur
+		b.cur = b.branch("true")
+		b.visit(fieldOf(c, "statements"))
+		exits = append(exits, cfgExit{b.cur, ""})
+	}
+	if test != nil {
+		exits = append(exits, cfgExit{test, "false"})
+	}
+	if len(clauses) == 0 {
+		exits = append(exits, cfgExit{b.cur, ""})
+	}
+	b.join(exits...)
+}
+
+// loop handles while and until, and their modifier forms. begin ... end
+// while runs its body once before the first test.
+func (b *cfgBuilder) loop(n *Node) {
+	body := fieldOf(n, "statements", "statement")
+	yes, no := "true", "false"
+	if n.Type == "until" || n.Type == "until_mod" {
+		yes, no = no, yes
+	}
+	doWhile := false
+	if stmt, ok := body.(*Node); ok && (n.Type == "while_mod" || n.Type == "until_mod") && stmt.Type == "begin" {
+		doWhile = true
+	}
+
+	test := b.newBlock()
+	after := b.newBlock()
+	start := test
+	if doWhile {
+		start = b.newBlock()
+	}
+	b.edge(b.cur, start, "")
+
+	b.cur = test
+	if pred := nodeField(n, "predicate"); pred != nil {
+		b.add(pred)
+	} else {
+		b.add(n)
+	}
+	b.edge(test, after, no)
+	if !doWhile {
+		b.cur = b.branch(yes)
+		start = b.cur
+	} else {
+		b.edge(test, start, yes)
+	}
+
+	b.loops = append(b.loops, cfgJumps{next: test, brk: after, redo: start})
+	b.cur = start
+	b.visit(body)
+	b.loops = b.loops[:len(b.loops)-1]
+	if doWhile {
+		b.edge(b.cur, test, "")
+	} else {
+		b.edge(b.cur, test, "loop")
+	}
+	b.cur = after
+}
+
+// forLoop runs its body for each element, with the test standing for
+// whether there is another.
+func (b *cfgBuilder) forLoop(n *Node) {
+	if collection := nodeField(n, "collection"); collection != nil {
+		b.add(collection)
+	}
+	test := b.branch("")
+	after := b.newBlock()
+	b.cur = test
+	if index := nodeField(n, "index"); index != nil {
+		b.add(index)
+	} else {
+		b.add(n)
+	}
+	b.edge(test, after, "false")
+	b.cur = b.branch("true")
+
+	b.loops = append(b.loops, cfgJumps{next: test, brk: after, redo: b.cur})
+	b.visit(fieldOf(n, "statements"))
+	b.loops = b.loops[:len(b.loops)-1]
+	b.edge(b.cur, test, "loop")
+	b.cur = after
+}
+
+// iterate handles a call passed a block. The call may yield to the block
+// any number of times before it returns; next ends one run of the block
+// and break returns from the call.
+func (b *cfgBuilder) iterate(call, block *Node) {
+	if call == nil {
+		call = block
+	}
+	b.add(call)
+	caller := b.cur
+	body := b.branch("yield")
+	after := b.newBlock()
+
+	b.loops = append(b.loops, cfgJumps{next: caller, brk: after, redo: body})
+	b.cur = body
+	b.visit(fieldOf(block, "bodystmt", "statements", "body"))
+	b.loops = b.loops[:len(b.loops)-1]
+	b.edge(b.cur, caller, "loop")
+	b.edge(caller, after, "")
+	b.cur = after
+}
+
+func (b *cfgBuilder) rescueModifier(n *Node) {
+	if stmt := nodeField(n, "statement", "expression"); stmt != nil {
+		b.add(stmt)
+	} else {
+		b.add(n)
+	}
+	stmt := b.cur
+	b.cur = b.branch("raise")
+	b.visit(fieldOf(n, "value", "rescue_expression"))
+	b.join(cfgExit{stmt, ""}, cfgExit{b.cur, ""})
+}
+
+// protected lays out a body with its rescue, else and ensure clauses. Any
+// block of the body might raise into the first rescue clause, and each
+// clause tests its exception classes before the next.
+func (b *cfgBuilder) protected(body interface{}, rescue *Node, otherwise interface{}, ensure *Node) {
+	if rescue == nil {
+		b.visit(body)
+		b.visit(otherwise)
+		b.visit(ensure)
+		return
+	}
+
+	start := b.branch("")
+	handler := b.newBlock()
+	r := &cfgRescue{handler: handler, covered: make(map[*CFGBlock]bool)}
+	b.rescues = append(b.rescues, r)
+	b.cur = start
+	b.visit(body)
+	b.rescues = b.rescues[:len(b.rescues)-1]
+	for _, blk := range r.order {
+		b.edge(blk, handler, "raise")
+	}
+	b.visit(otherwise)
+	exits := []cfgExit{{b.cur, ""}}
+
+	b.retries = append(b.retries, start)
+	b.cur = handler
+	for c := rescue; c != nil; c = nodeField(c, "consequent", "subsequent") {
+		b.add(c)
+		test := b.cur
+		b.cur = b.branch("true")
+		b.visit(fieldOf(c, "statements"))
+		exits = append(exits, cfgExit{b.cur, ""})
+		b.cur = test
+		if nodeField(c, "consequent", "subsequent") == nil {
+			b.edge(test, b.raiseTarget(), "raise")
+		} else {
+			b.cur = b.branch("false")
+		}
+	}
+	b.retries = b.retries[:len(b.retries)-1]
+
+	b.join(exits...)
+	b.visit(ensure)
+}
+
+// finish drops the empty blocks that only pass control on, marks what
+// can't be reached, and numbers the blocks from the entry outwards with the
+// exit last.
+func (b *cfgBuilder) finish(entry *CFGBlock) {
+	g := b.g
+	for changed := true; changed; {
+		changed = false
+		incoming := make(map[int]int)
+		outgoing := make(map[int][]CFGEdge)
+		for _, e := range g.Edges {
+			incoming[e.To]++
+			outgoing[e.From] = append(outgoing[e.From], e)
+		}
+		for _, blk := range g.Blocks {
+			if blk.Kind != "" || len(blk.Statements) > 0 {
+				continue
+			}
+			out := outgoing[blk.ID]
+			switch {
+			case incoming[blk.ID] == 0:
+				g.Edges = dropEdges(g.Edges, blk.ID, CFGEdge{To: -1})
+			case len(out) == 1 && out[0].To != blk.ID:
+				g.Edges = dropEdges(g.Edges, blk.ID, out[0])
+			default:
+				continue
+			}
+			g.Blocks = removeBlock(g.Blocks, blk)
+			changed = true
+			break
+		}
+	}
+
+	seen := make(map[[2]int]map[string]bool)
+	edges := g.Edges[:0]
+	for _, e := range g.Edges {
+		key := [2]int{e.From, e.To}
+		if seen[key] == nil {
+			seen[key] = make(map[string]bool)
+		}
+		if !seen[key][e.Kind] {
+			seen[key][e.Kind] = true
+			edges = append(edges, e)
+		}
+	}
+	g.Edges = edges
+
+	byID := make(map[int]*CFGBlock)
+	for _, blk := range g.Blocks {
+		byID[blk.ID] = blk
+	}
+	order := []*CFGBlock{entry}
+	numbered := map[*CFGBlock]bool{entry: true, b.exit: true}
+	for i := 0; i < len(order); i++ {
+		for _, e := range g.Edges {
+			if to := byID[e.To]; e.From == order[i].ID && !numbered[to] {
+				numbered[to] = true
+				order = append(order, to)
+			}
+		}
+	}
+	for _, blk := range g.Blocks {
+		if !numbered[blk] {
+			blk.Unreachable = true
+			order = append(order, blk)
+		}
+	}
+	order = append(order, b.exit)
+
+	ids := make(map[int]int)
+	for i, blk := range order {
+		ids[blk.ID] = i
+		blk.ID = i
+	}
+	for i := range g.Edges {
+		g.Edges[i].From, g.Edges[i].To = ids[g.Edges[i].From], ids[g.Edges[i].To]
+	}
+	g.Blocks = order
+}
+
+// dropEdges removes the edges out of block id and sends those into it on
+// along out, the edge leaving it, or removes them too if out goes to -1. An
+// edge sent on keeps its own kind if it has one.
+func dropEdges(edges []CFGEdge, id int, out CFGEdge) []CFGEdge {
+	kept := edges[:0]
+	for _, e := range edges {
+		switch {
+		case e.From == id:
+			continue
+		case e.To == id && out.To < 0:
+			continue
+		case e.To == id:
+			e.To = out.To
+			if e.Kind == "" {
+				e.Kind = out.Kind
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+func removeBlock(blocks []*CFGBlock, blk *CFGBlock) []*CFGBlock {
+	for i, other := range blocks {
+		if other == blk {
+			return append(blocks[:i], blocks[i+1:]...)
+		}
+	}
+	return blocks
+}
+
+// WriteCFGDOT renders a control flow graph as a Graphviz digraph, each
+// block listing its statements' first lines.
+func WriteCFGDOT(w io.Writer, g *ControlFlowGraph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph cfg {")
+	fmt.Fprintf(bw, "  label=\"%s\";\n", dotEscaper.Replace(g.Method))
+	fmt.Fprintln(bw, `  node [shape=box, fontname="monospace"];`)
+	for _, blk := range g.Blocks {
+		lines := []string{fmt.Sprintf("B%d", blk.ID)}
+		if blk.Kind != "" {
+			lines[0] = blk.Kind
+		}
+		for _, stmt := range blk.Statements {
+			text := stmt.Source
+			if text == "" {
+				text = stmt.Type
+			}
+			lines = append(lines, text)
+		}
+		attrs := ""
+		switch {
+		case blk.Kind != "":
+			attrs = ", shape=oval"
+		case blk.Unreachable:
+			attrs = ", style=dashed"
+		}
+		fmt.Fprintf(bw, "  b%d [label=\"%s\"%s];\n", blk.ID, dotEscaper.Replace(strings.Join(lines, "\n")), attrs)
+	}
+	for _, e := range g.Edges {
+		attrs := ""
+		if e.Kind != "" {
+			attrs = fmt.Sprintf(" [label=\"%s\"", e.Kind)
+			if e.Kind == "raise" {
+				attrs += ", style=dashed"
+			}
+			attrs += "]"
+		}
+		fmt.Fprintf(bw, "  b%d -> b%d%s;\n", e.From, e.To, attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,195 @@
+package analyze
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// decisionTypes are the node types that add a path through a method, in the
+// spellings of both stree and Prism. Ternaries and modifier forms count like
+// the statements they abbreviate.
+var decisionTypes = map[string]bool{
+	"if": true, "unless": true, "elsif": true, "if_op": true, "ifop": true,
+	"if_mod": true, "unless_mod": true,
+	"while": true, "until": true, "while_mod": true, "until_mod": true, "for": true,
+	"when": true, "in": true,
+	"rescue": true, "rescue_mod": true, "rescue_modifier": true,
+	"and": true, "or": true,
+}
+
+// Node types that call a method, which ABC counts as branches.
+var callTypes = map[string]bool{
+	"call": true, "command": true, "command_call": true, "fcall": true, "vcall": true,
+	"super": true, "zsuper": true, "forwarding_super": true, "yield": true, "yield0": true,
+}
+
+var assignmentTypes = map[string]bool{
+	"assign": true, "opassign": true, "massign": true,
+}
+
+var comparisonOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true,
+	"<=>": true, "===": true, "=~": true, "!~": true,
+}
+
+type ABCScore struct {
+	Assignments int     `json:"assignments"`
+	Branches    int     `json:"branches"`
+	Conditions  int     `json:"conditions"`
+	Score       float64 `json:"score"`
+}
+
+type MethodMetrics struct {
+	Name       string    `json:"name"`
+	Location   *Location `json:"location,omitempty"`
+	Lines      int       `json:"lines"`
+	Complexity int       `json:"complexity"`
+	ABC        ABCScore  `json:"abc"`
+}
+
+type ClassMetrics struct {
+	Kind          string    `json:"kind"`
+	Name          string    `json:"name"`
+	Location      *Location `json:"location,omitempty"`
+	Lines         int       `json:"lines"`
+	Methods       int       `json:"methods"`
+	Complexity    int       `json:"complexity"`
+	MaxComplexity int       `json:"max_complexity"`
+}
+
+type CodeMetrics struct {
+	Methods []*MethodMetrics `json:"methods"`
+	Classes []*ClassMetrics  `json:"classes"`
+
+	// byNode finds the metrics for a def, class or module node, for
+	// attaching them to the tree.
+	byNode map[*Node]interface{}
+}
+
+// operatorOf returns a binary node's operator, which stree gives as a string
+// or an op node.
+func operatorOf(n *Node) string {
+	switch v, _ := n.field("operator"); v := v.(type) {
+	case string:
+		return v
+	case *Node:
+		s, _ := v.value()
+		return s
+	}
+	return ""
+}
+
+// count adds a node inside a method body to its complexity and ABC score.
+func (m *MethodMetrics) count(n *Node) {
+	switch {
+	case decisionTypes[n.Type]:
+		m.Complexity++
+		m.ABC.Conditions++
+	case n.Type == "binary":
+		switch op := operatorOf(n); {
+		case op == "&&" || op == "||" || op == "and" || op == "or":
+			m.Complexity++
+			m.ABC.Conditions++
+		case comparisonOperators[op]:
+			m.ABC.Conditions++
+		}
+	case n.Type == "else":
+		m.ABC.Conditions++
+	case callTypes[n.Type]:
+		// Prism spells comparisons as calls.
+		name, _ := n.field("name")
+		if op, _ := name.(string); comparisonOperators[op] {
+			m.ABC.Conditions++
+		} else {
+			m.ABC.Branches++
+		}
+	case assignmentTypes[n.Type] || strings.HasSuffix(n.Type, "_write"):
+		m.ABC.Assignments++
+	}
+}
+
+func lineCount(n *Node) (int, *Location) {
+	loc, ok := n.location()
+	if !ok {
+		return 0, nil
+	}
+	return loc.EndLine - loc.StartLine + 1, &loc
+}
+
+// ComputeCodeMetrics measures every method, class and module in a tree.
+// Cyclomatic complexity is one plus the number of branching constructs in a
+// method's body; the ABC score counts assignments, branches (method calls)
+// and conditions, and is the length of that vector, after RuboCop's
+// Metrics/AbcSize. A nested def is measured on its own and not as part of
+// the method around it.
+func ComputeCodeMetrics(root *Node) *CodeMetrics {
+	cm := &CodeMetrics{Methods: []*MethodMetrics{}, Classes: []*ClassMetrics{}, byNode: make(map[*Node]interface{})}
+	var visit func(n *Node, namespace string, method *MethodMetrics, class *ClassMetrics)
+	visit = func(n *Node, namespace string, method *MethodMetrics, class *ClassMetrics) {
+		switch n.Type {
+		case "def", "defs":
+			m := &MethodMetrics{Name: qualifiedMethodName(n, namespace), Complexity: 1}
+			m.Lines, m.Location = lineCount(n)
+			cm.Methods = append(cm.Methods, m)
+			cm.byNode[n] = m
+			for _, edge := range n.children() {
+				visit(edge.Node, namespace, m, class)
+			}
+			a, b, c := float64(m.ABC.Assignments), float64(m.ABC.Branches), float64(m.ABC.Conditions)
+			m.ABC.Score = math.Round(math.Sqrt(a*a+b*b+c*c)*100) / 100
+			if class != nil {
+				class.Methods++
+				class.Complexity += m.Complexity
+				if m.Complexity > class.MaxComplexity {
+					class.MaxComplexity = m.Complexity
+				}
+			}
+			return
+		case "class", "module":
+			namespace = enterNamespace(n, namespace)
+			c := &ClassMetrics{Kind: n.Type, Name: namespace}
+			c.Lines, c.Location = lineCount(n)
+			cm.Classes = append(cm.Classes, c)
+			cm.byNode[n] = c
+			method, class = nil, c
+		default:
+			if method != nil {
+				method.count(n)
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, namespace, method, class)
+		}
+	}
+	visit(root, "", nil, nil)
+	return cm
+}
+
+// attachMetrics adds a "metrics" object to every def, class and module node
+// in the tree, for JSON output requested with the metrics option.
+func attachMetrics(root *Node) {
+	cm := ComputeCodeMetrics(root)
+	Walk(root, func(n *Node, _ int) bool {
+		if v, ok := cm.byNode[n]; ok {
+			n.Fields = append(n.Fields, ASTField{Name: "metrics", Value: metricsNode(v)})
+		}
+		return true
+	})
+}
+
+// metricsNode converts metrics into an untyped node, keeping to the value
+// types Node allows, and leaves out what the node they hang off already
+// says.
+func metricsNode(v interface{}) *Node {
+	data, _ := json.Marshal(v)
+	node, _ := DecodeAST(data)
+	kept := node.Fields[:0]
+	for _, f := range node.Fields {
+		if f.Name != "name" && f.Name != "kind" && f.Name != "location" {
+			kept = append(kept, f)
+		}
+	}
+	node.Fields = kept
+	return node
+}
@@ -0,0 +1,110 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+)
+
+type CommentInfo struct {
+	Value      string       `json:"value"`
+	Location   Location     `json:"location"`
+	Attachment string       `json:"attachment"`
+	AttachedTo *NodeSummary `json:"attached_to"`
+}
+
+// ExtractComments lists every comment node in the tree, in source order,
+// classifying how each relates to the code around it:
+//
+//   - trailing: code precedes it on the same line; attached to the
+//     outermost node ending right before it.
+//   - leading: on its own line and followed by more code in the same
+//     enclosing node; attached to the outermost node that follows.
+//   - inline: on its own line inside a node with nothing after it, such as
+//     at the end of a method body; attached to the enclosing node.
+//   - none: on its own line at the top level with no code after it.
+func ExtractComments(root *Node, code string) []CommentInfo {
+	runes := []rune(code)
+
+	var comments, nodes []*TreeNode
+	seen := make(map[*Node]bool)
+	for _, t := range flattenTree(root) {
+		if _, ok := t.Node.location(); !ok || seen[t.Node] {
+			continue
+		}
+		seen[t.Node] = true
+		if t.Node.Type == "comment" {
+			comments = append(comments, t)
+		} else {
+			nodes = append(nodes, t)
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		a, _ := comments[i].Node.location()
+		b, _ := comments[j].Node.location()
+		return a.StartChar < b.StartChar
+	})
+
+	result := []CommentInfo{}
+	for _, c := range comments {
+		loc, _ := c.Node.location()
+		value, _ := c.Node.value()
+		info := CommentInfo{Value: value, Location: loc, Attachment: "none"}
+
+		lineStart := loc.StartChar
+		for lineStart > 0 && lineStart <= len(runes) && runes[lineStart-1] != '\n' {
+			lineStart--
+		}
+		ownLine := lineStart > len(runes) || strings.TrimSpace(string(runes[lineStart:loc.StartChar])) == ""
+
+		var enclosing, target *TreeNode
+		var targetLoc Location
+		for _, t := range nodes {
+			l, _ := t.Node.location()
+			if l.StartChar <= loc.StartChar && loc.EndChar <= l.EndChar {
+				if enclosing == nil || t.Depth > enclosing.Depth {
+					enclosing = t
+				}
+			}
+		}
+		var bounds Location
+		if enclosing != nil {
+			bounds, _ = enclosing.Node.location()
+		}
+
+		for _, t := range nodes {
+			l, _ := t.Node.location()
+			if !ownLine {
+				if l.EndLine != loc.StartLine || l.EndChar > loc.StartChar {
+					continue
+				}
+				if target == nil || l.EndChar > targetLoc.EndChar || (l.EndChar == targetLoc.EndChar && t.Depth < target.Depth) {
+					target, targetLoc = t, l
+				}
+				continue
+			}
+			if l.StartChar < loc.EndChar || (enclosing != nil && l.EndChar > bounds.EndChar) {
+				continue
+			}
+			if target == nil || l.StartChar < targetLoc.StartChar || (l.StartChar == targetLoc.StartChar && t.Depth < target.Depth) {
+				target, targetLoc = t, l
+			}
+		}
+
+		switch {
+		case !ownLine && target != nil:
+			info.Attachment = "trailing"
+		case ownLine && target != nil:
+			info.Attachment = "leading"
+		case enclosing != nil && enclosing.Depth > 1:
+			// Depth 0 and 1 are the program and its top-level statements.
+			info.Attachment = "inline"
+			target = enclosing
+		}
+		if target != nil {
+			summary := Summarize(target)
+			info.AttachedTo = &summary
+		}
+		result = append(result, info)
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package analyze
+
+import "strings"
+
+// compactField reports whether compact output drops a field: locations,
+// comments, Prism's *_loc ranges and flags, and fields with no value.
+func compactField(f ASTField) bool {
+	switch {
+	case f.Value == nil:
+		return true
+	case f.Name == "location" || f.Name == "comments" || f.Name == "flags":
+		return true
+	}
+	return strings.HasSuffix(f.Name, "_loc")
+}
+
+// compactTree strips everything but the structure from a tree, in place.
+// Node paths are unchanged, since they are built from the field names and
+// array indexes that remain.
+func compactTree(value interface{}) {
+	switch v := value.(type) {
+	case *Node:
+		kept := v.Fields[:0]
+		for _, f := range v.Fields {
+			if !compactField(f) {
+				compactTree(f.Value)
+				kept = append(kept, f)
+			}
+		}
+		v.Fields = kept
+	case []interface{}:
+		for _, element := range v {
+			compactTree(element)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package analyze
+
+import (
+	"sort"
+)
+
+// compareMaxDivergences caps how many diverging ranges are listed per pair
+// of backends; the counts are always complete.
+const compareMaxDivergences = 50
+
+type BackendStats struct {
+	Nodes    int `json:"nodes"`
+	MaxDepth int `json:"max_depth"`
+	Types    int `json:"types"`
+	Ranges   int `json:"ranges"`
+}
+
+// Divergence is a source range that one backend has a node for and the other
+// doesn't, with the types of the nodes it does have there.
+type Divergence struct {
+	Location Location `json:"location"`
+	OnlyIn   string   `json:"only_in"`
+	Types    []string `json:"types"`
+}
+
+type PairComparison struct {
+	Parsers [2]string `json:"parsers"`
+
+	// Comparable is false when either side has no tree to compare, because
+	// it rejected the code or its output isn't a tree of typed nodes.
+	Comparable   bool           `json:"comparable"`
+	SharedRanges int            `json:"shared_ranges"`
+	OnlyRanges   map[string]int `json:"only_ranges,omitempty"`
+	Similarity   float64        `json:"similarity"`
+	Divergences  []Divergence   `json:"divergences,omitempty"`
+	Truncated    bool           `json:"truncated,omitempty"`
+}
+
+type CompareSummary struct {
+	// ValidityAgrees reports whether the backends agree on whether the
+	// code is valid Ruby at all.
+	ValidityAgrees bool                     `json:"validity_agrees"`
+	Backends       map[string]*BackendStats `json:"backends"`
+	Pairs          []PairComparison         `json:"pairs"`
+}
+
+// NodeRanges groups a tree's nodes by source range. Backends name their nodes
+// differently, but where they put node boundaries can be compared directly.
+func NodeRanges(root *Node) (map[Location][]string, BackendStats) {
+	ranges := make(map[Location][]string)
+	types := make(map[string]bool)
+	nodes := flattenTree(root)
+	stats := BackendStats{Nodes: len(nodes)}
+	for _, t := range nodes {
+		types[t.Node.Type] = true
+		if t.Depth > stats.MaxDepth {
+			stats.MaxDepth = t.Depth
+		}
+		if loc, ok := t.Node.location(); ok {
+			ranges[loc] = append(ranges[loc], t.Node.Type)
+		}
+	}
+	stats.Types = len(types)
+	stats.Ranges = len(ranges)
+	return ranges, stats
+}
+
+func CompareRanges(names [2]string, a, b map[Location][]string) PairComparison {
+	pc := PairComparison{
+		Parsers:    names,
+		Comparable: true,
+		OnlyRanges: map[string]int{names[0]: 0, names[1]: 0},
+	}
+	for loc, types := range a {
+		if _, ok := b[loc]; ok {
+			pc.SharedRanges++
+			continue
+		}
+		pc.OnlyRanges[names[0]]++
+		pc.Divergences = append(pc.Divergences, Divergence{Location: loc, OnlyIn: names[0], Types: types})
+	}
+	for loc, types := range b {
+		if _, ok := a[loc]; !ok {
+			pc.OnlyRanges[names[1]]++
+			pc.Divergences = append(pc.Divergences, Divergence{Location: loc, OnlyIn: names[1], Types: types})
+		}
+	}
+
+	if union := len(a) + len(b) - pc.SharedRanges; union > 0 {
+		pc.Similarity = float64(pc.SharedRanges) / float64(union)
+	} else {
+		pc.Similarity = 1
+	}
+
+	sort.Slice(pc.Divergences, func(i, j int) bool {
+		li, lj := pc.Divergences[i].Location, pc.Divergences[j].Location
+		if li.StartLine != lj.StartLine {
+			return li.StartLine < lj.StartLine
+		}
+		if li.StartChar != lj.StartChar {
+			return li.StartChar < lj.StartChar
+		}
+		if li.EndLine != lj.EndLine {
+			return li.EndLine > lj.EndLine
+		}
+		if li.EndChar != lj.EndChar {
+			return li.EndChar > lj.EndChar
+		}
+		return pc.Divergences[i].OnlyIn < pc.Divergences[j].OnlyIn
+	})
+	if len(pc.Divergences) > compareMaxDivergences {
+		pc.Divergences = pc.Divergences[:compareMaxDivergences]
+		pc.Truncated = true
+	}
+	return pc
+}
@@ -0,0 +1,323 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Kinds of dead code.
+const (
+	DeadUnreachable       = "unreachable"
+	DeadConstantCondition = "constant_condition"
+	DeadUnusedPrivate     = "unused_private_method"
+)
+
+// DeadCode is code that can never run, or a private method nothing in the
+// file calls. Location spans all of it, such as every statement after a
+// return.
+type DeadCode struct {
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// jumpTypes are the statements that always leave the statement list
+// they're in.
+var jumpTypes = map[string]bool{
+	"return": true, "break": true, "next": true, "redo": true, "retry": true,
+}
+
+// hookMethods are private methods Ruby calls itself, so they're used
+// without a call in the file.
+var hookMethods = map[string]bool{
+	"initialize": true, "initialize_copy": true, "initialize_clone": true, "initialize_dup": true,
+	"method_missing": true, "respond_to_missing?": true,
+	"inherited": true, "included": true, "extended": true, "prepended": true,
+	"method_added": true, "const_missing": true,
+}
+
+// FindDeadCode reports statements after an unconditional return, raise,
+// break, next, redo or retry; branches a literal condition such as if false
+// or unless true rules out; and private methods never called in the file.
+// The last is a guess from one file: a method called only through send with
+// a computed name, or from another file with send, is reported too.
+func FindDeadCode(root *Node) []DeadCode {
+	found := []DeadCode{}
+	paths := make(map[*Node]string)
+	for _, t := range flattenTree(root) {
+		paths[t.Node] = t.Path
+	}
+	report := func(kind, message string, first, last *Node) {
+		d := DeadCode{Kind: kind, Message: message, Path: paths[first], Type: first.Type}
+		if loc, ok := first.location(); ok {
+			if end, ok := last.location(); ok {
+				loc.EndLine, loc.EndChar = end.EndLine, end.EndChar
+			}
+			d.Location = &loc
+		}
+		found = append(found, d)
+	}
+
+	for _, t := range flattenTree(root) {
+		n := t.Node
+		switch n.Type {
+		case "statements":
+			body, _ := n.field("body")
+			stmts := typedNodes(body)
+			for i := 0; i+1 < len(stmts); i++ {
+				if jump := jumpName(stmts[i]); jump != "" {
+					report(DeadUnreachable, fmt.Sprintf("Unreachable code after %s", jump), stmts[i+1], stmts[len(stmts)-1])
+					break
+				}
+			}
+		case "if", "unless", "elsif", "if_mod", "unless_mod", "ifop",
+			"while", "until", "while_mod", "until_mod":
+			truth, ok := constantTruth(nodeField(n, "predicate"))
+			if !ok {
+				continue
+			}
+			taken := truth
+			if n.Type == "unless" || n.Type == "unless_mod" || n.Type == "until" || n.Type == "until_mod" {
+				taken = !truth
+			}
+			if dead := deadBranch(n, taken); dead != nil {
+				word := map[bool]string{true: "truthy", false: "falsy"}[truth]
+				report(DeadConstantCondition, fmt.Sprintf("The condition is always %s, so this never runs", word), dead, dead)
+			}
+		}
+	}
+
+	calls := calledNames(root)
+	for _, def := range privateMethods(root) {
+		name, _ := methodName(def)
+		if !calls[name] && !hookMethods[name] {
+			report(DeadUnusedPrivate, fmt.Sprintf("Private method %s is never called in this file", name), def, def)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i].Location, found[j].Location
+		return a != nil && (b == nil || a.StartChar < b.StartChar)
+	})
+	return found
+}
+
+func typedNodes(v interface{}) []*Node {
+	var nodes []*Node
+	list, _ := v.([]interface{})
+	for _, element := range list {
+		if n, ok := element.(*Node); ok && n.Type != "" && n.Type != "void_stmt" && n.Type != "comment" && n.Type != "embdoc" {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// jumpName names the keyword or raise that makes a statement always jump,
+// or returns "" if it doesn't.
+func jumpName(n *Node) string {
+	if jumpTypes[n.Type] {
+		return n.Type
+	}
+	if name := calleeName(n); (name == "raise" || name == "fail") && fieldOf(n, "receiver") == nil {
+		return name
+	}
+	return ""
+}
+
+// constantTruth reports whether a condition is a literal that's always
+// truthy or always falsy. Ranges and regular expressions aren't counted, as
+// they're flip-flops and matches against $_ in a condition.
+func constantTruth(n *Node) (truth, ok bool) {
+	for n != nil && (n.Type == "paren" || n.Type == "parentheses") {
+		inner := typedNodes([]interface{}{fieldOf(n, "contents", "body")})
+		if len(inner) == 1 && inner[0].Type == "statements" {
+			body, _ := inner[0].field("body")
+			inner = typedNodes(body)
+		}
+		if len(inner) != 1 {
+			return false, false
+		}
+		n = inner[0]
+	}
+	if n == nil {
+		return false, false
+	}
+	switch n.Type {
+	case "true":
+		return true, true
+	case "false", "nil":
+		return false, true
+	case "var_ref":
+		if kw := nodeField(n, "value"); kw != nil && kw.Type == "kw" {
+			switch v, _ := kw.value(); v {
+			case "true":
+				return true, true
+			case "false", "nil":
+				return false, true
+			}
+		}
+	case "int", "integer", "float", "rational", "imaginary",
+		"string_literal", "string", "xstring_literal", "symbol_literal", "symbol", "dyna_symbol",
+		"array", "hash", "lambda":
+		return true, true
+	}
+	return false, false
+}
+
+// deadBranch is the part of n a condition that's always taken, or never
+// taken, rules out. A loop whose condition always holds has nothing dead, and
+// neither does begin ... end while, which runs once before testing.
+func deadBranch(n *Node, taken bool) *Node {
+	switch n.Type {
+	case "while", "until":
+		if !taken {
+			return nodeField(n, "statements")
+		}
+	case "while_mod", "until_mod":
+		if stmt := nodeField(n, "statement"); !taken && stmt != nil && stmt.Type != "begin" {
+			return stmt
+		}
+	case "if_mod", "unless_mod":
+		if !taken {
+			return nodeField(n, "statement")
+		}
+	case "ifop":
+		if taken {
+			return nodeField(n, "falsy")
+		}
+		return nodeField(n, "truthy")
+	default:
+		if taken {
+			return nodeField(n, "consequent", "subsequent", "else_clause")
+		}
+		return nodeField(n, "statements")
+	}
+	return nil
+}
+
+// calledNames is every method name the file calls, or names with a symbol
+// as send(:name), method(:name) and callbacks do, other than in the
+// private calls that make methods private.
+func calledNames(root *Node) map[string]bool {
+	marked := make(map[*Node]bool)
+	Walk(root, func(n *Node, _ int) bool {
+		if isVisibilityCall(n) {
+			visibilityArguments(n, func(def *Node) {}, func(sym *Node, _ string) { marked[sym] = true })
+		}
+		return true
+	})
+
+	names := make(map[string]bool)
+	Walk(root, func(n *Node, _ int) bool {
+		if name := calleeName(n); name != "" && !isVisibilityCall(n) {
+			names[name] = true
+		}
+		if name, ok := symbolName(n); ok && !marked[n] {
+			names[name] = true
+		}
+		return true
+	})
+	return names
+}
+
+func isVisibilityCall(n *Node) bool {
+	return visibilityNames[calleeName(n)] && fieldOf(n, "receiver") == nil
+}
+
+// visibilityArguments calls def for each method defined in the arguments
+// of a private, public or protected call and sym for each method named by
+// a symbol. It reports whether there were any, as without them the call
+// changes the default for the methods after it.
+func visibilityArguments(n *Node, def func(*Node), sym func(*Node, string)) bool {
+	defined := false
+	for _, edge := range n.children() {
+		if edge.Field != "arguments" {
+			continue
+		}
+		Walk(edge.Node, func(arg *Node, _ int) bool {
+			if arg.Type == "def" {
+				def(arg)
+				defined = true
+				return false
+			}
+			if name, ok := symbolName(arg); ok {
+				sym(arg, name)
+				defined = true
+				return false
+			}
+			return true
+		})
+	}
+	return defined
+}
+
+var visibilityNames = map[string]bool{"private": true, "public": true, "protected": true}
+
+// symbolName returns the name in a plain symbol literal, :name.
+func symbolName(n *Node) (string, bool) {
+	switch n.Type {
+	case "symbol_literal":
+		if v := nodeField(n, "value"); v != nil {
+			return v.value()
+		}
+	case "symbol":
+		v, _ := n.field("unescaped")
+		s, ok := v.(string)
+		return s, ok
+	}
+	return "", false
+}
+
+// privateMethods finds the instance methods a class or module body makes
+// private, with a bare private before them, private def, or private :name.
+func privateMethods(root *Node) []*Node {
+	var found []*Node
+	Walk(root, func(n *Node, _ int) bool {
+		if n.Type != "class" && n.Type != "module" {
+			return true
+		}
+		body := nodeField(n, "bodystmt", "body")
+		if body != nil && body.Type == "bodystmt" {
+			body = nodeField(body, "statements")
+		}
+		if body == nil {
+			return true
+		}
+		stmts, _ := body.field("body")
+		defs := make(map[string]*Node)
+		var order []string
+		private := make(map[string]bool)
+		define := func(def *Node, isPrivate bool) {
+			name, _ := methodName(def)
+			if _, ok := defs[name]; !ok {
+				order = append(order, name)
+			}
+			defs[name], private[name] = def, isPrivate
+		}
+		section := false
+		for _, stmt := range typedNodes(stmts) {
+			if stmt.Type == "def" && fieldOf(stmt, "target", "receiver") == nil {
+				define(stmt, section)
+				continue
+			}
+			if !isVisibilityCall(stmt) {
+				continue
+			}
+			isPrivate := calleeName(stmt) == "private"
+			if !visibilityArguments(stmt,
+				func(def *Node) { define(def, isPrivate) },
+				func(_ *Node, name string) { private[name] = isPrivate }) {
+				section = isPrivate
+			}
+		}
+		for _, name := range order {
+			if private[name] {
+				found = append(found, defs[name])
+			}
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,79 @@
+package analyze
+
+// Definition is a class or module declared in a file, with its name
+// qualified by the declarations it is nested in.
+type Definition struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// constName spells out a constant reference from stree's JSON, such as the
+// const_path_ref for Foo::Bar. It returns "" for anything else, e.g. a
+// dynamic `class self::Foo`.
+func constName(n *Node) string {
+	switch n.Type {
+	case "const":
+		v, _ := n.value()
+		return v
+	case "const_ref", "var_ref", "top_const_ref":
+		c, ok := n.field("constant")
+		if !ok {
+			c, _ = n.field("value")
+		}
+		child, ok := c.(*Node)
+		if !ok {
+			return ""
+		}
+		name := constName(child)
+		if n.Type == "top_const_ref" && name != "" {
+			return "::" + name
+		}
+		return name
+	case "const_path_ref":
+		parent, _ := n.field("parent")
+		constant, _ := n.field("constant")
+		p, ok1 := parent.(*Node)
+		c, ok2 := constant.(*Node)
+		if !ok1 || !ok2 {
+			return ""
+		}
+		if left, right := constName(p), constName(c); left != "" && right != "" {
+			return left + "::" + right
+		}
+	}
+	return ""
+}
+
+// FindDefinitions lists the classes and modules declared in a stree tree.
+// Other parsers use different node types and yield nothing.
+func FindDefinitions(root *Node) []Definition {
+	var defs []Definition
+	var visit func(n *Node, namespace string)
+	visit = func(n *Node, namespace string) {
+		if n.Type == "class" || n.Type == "module" {
+			if c, ok := n.field("constant"); ok {
+				if ref, ok := c.(*Node); ok {
+					if name := constName(ref); name != "" {
+						if name[0] == ':' {
+							name = name[2:]
+						} else if namespace != "" {
+							name = namespace + "::" + name
+						}
+						def := Definition{Kind: n.Type, Name: name}
+						if loc, ok := n.location(); ok {
+							def.Location = &loc
+						}
+						defs = append(defs, def)
+						namespace = name
+					}
+				}
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, namespace)
+		}
+	}
+	visit(root, "")
+	return defs
+}
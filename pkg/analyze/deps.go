@@ -0,0 +1,278 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// loadMethods are the calls that load another file.
+var loadMethods = map[string]bool{"require": true, "require_relative": true, "autoload": true}
+
+// stringLiteral returns the value of a string literal without interpolation,
+// as stree (string_literal with tstring_content parts) and Prism (string with
+// its unescaped value) spell it.
+func stringLiteral(n *Node) (string, bool) {
+	switch n.Type {
+	case "string_literal":
+		var b strings.Builder
+		for _, edge := range n.children() {
+			v, ok := edge.Node.value()
+			if edge.Node.Type != "tstring_content" || !ok {
+				return "", false
+			}
+			b.WriteString(v)
+		}
+		return b.String(), true
+	case "string":
+		v, ok := n.field("unescaped")
+		s, isString := v.(string)
+		return s, ok && isString
+	}
+	return "", false
+}
+
+// stringArguments lists the literal strings passed to a call, in order.
+func stringArguments(n *Node) []string {
+	var values []string
+	for _, edge := range n.children() {
+		if edge.Field != "arguments" {
+			continue
+		}
+		Walk(edge.Node, func(arg *Node, _ int) bool {
+			if s, ok := stringLiteral(arg); ok {
+				values = append(values, s)
+				return false
+			}
+			return true
+		})
+	}
+	return values
+}
+
+// loadStatement is a require, require_relative or autoload with a literal
+// path. Paths built at runtime, such as File.expand_path, aren't followed.
+type loadStatement struct {
+	Kind     string
+	Name     string
+	Location *Location
+}
+
+func findLoadStatements(root *Node) []loadStatement {
+	var loads []loadStatement
+	Walk(root, func(n *Node, _ int) bool {
+		kind := calleeName(n)
+		if !loadMethods[kind] {
+			return true
+		}
+		if receiver, _ := n.field("receiver"); receiver != nil {
+			return true
+		}
+		args := stringArguments(n)
+		if len(args) == 0 {
+			return true
+		}
+		// autoload's path comes after the constant.
+		load := loadStatement{Kind: kind, Name: args[len(args)-1]}
+		if loc, ok := n.location(); ok {
+			load.Location = &loc
+		}
+		loads = append(loads, load)
+		return false
+	})
+	return loads
+}
+
+type DepEdge struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Kind     string    `json:"kind"`
+	External bool      `json:"external,omitempty"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// DepGraph has the project's files, what they load from outside it (gems,
+// the standard library, or files that don't exist), and cycles of files that
+// require each other.
+type DepGraph struct {
+	Files    []string   `json:"files"`
+	External []string   `json:"external"`
+	Edges    []DepEdge  `json:"edges"`
+	Cycles   [][]string `json:"cycles"`
+}
+
+// resolveRequire finds the project file a plain require loads. The load
+// path isn't known, so any file whose path ends in the required name will
+// do, preferring one under lib/ and then the shortest path.
+func resolveRequire(name string, files []string) (string, bool) {
+	if path.Ext(name) == "" {
+		name += ".rb"
+	}
+	best := ""
+	for _, f := range files {
+		if f != name && !strings.HasSuffix(f, "/"+name) {
+			continue
+		}
+		lib := func(p string) bool { return strings.HasPrefix(p, "lib/") || strings.Contains(p, "/lib/") }
+		switch {
+		case best == "":
+			best = f
+		case lib(f) != lib(best):
+			if lib(f) {
+				best = f
+			}
+		case len(f) < len(best):
+			best = f
+		}
+	}
+	return best, best != ""
+}
+
+// BuildDepGraph resolves the load statements in the parsed files against all
+// the project's paths, including those of files that failed to parse.
+// require_relative is resolved against the requiring file's directory;
+// require and autoload by resolveRequire.
+func BuildDepGraph(paths []string, files []ParsedFile) *DepGraph {
+	g := &DepGraph{Files: []string{}, External: []string{}, Edges: []DepEdge{}, Cycles: [][]string{}}
+	known := make(map[string]bool)
+	for _, name := range paths {
+		p := path.Clean(name)
+		if known[p] {
+			continue
+		}
+		g.Files = append(g.Files, p)
+		known[p] = true
+	}
+	sort.Strings(g.Files)
+
+	external := make(map[string]bool)
+	for _, f := range files {
+		from := path.Clean(f.Path)
+		for _, load := range findLoadStatements(f.Root) {
+			edge := DepEdge{From: from, Kind: load.Kind, Location: load.Location}
+			if load.Kind == "require_relative" {
+				target := path.Join(path.Dir(from), load.Name)
+				if path.Ext(target) == "" {
+					target += ".rb"
+				}
+				edge.To, edge.External = target, !known[target]
+			} else if target, ok := resolveRequire(load.Name, g.Files); ok {
+				edge.To = target
+			} else {
+				edge.To, edge.External = load.Name, true
+			}
+			if edge.External && !external[edge.To] {
+				external[edge.To] = true
+				g.External = append(g.External, edge.To)
+			}
+			g.Edges = append(g.Edges, edge)
+		}
+	}
+	sort.Strings(g.External)
+	g.Cycles = findCycles(g.Files, g.Edges)
+	return g
+}
+
+// findCycles returns the groups of files that load each other, directly or
+// not, using Tarjan's algorithm. A file loading itself is a cycle of one.
+func findCycles(files []string, edges []DepEdge) [][]string {
+	out := make(map[string][]string)
+	self := make(map[string]bool)
+	for _, e := range edges {
+		if e.External {
+			continue
+		}
+		out[e.From] = append(out[e.From], e.To)
+		if e.From == e.To {
+			self[e.From] = true
+		}
+	}
+
+	cycles := [][]string{}
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var connect func(v string)
+	connect = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range out[v] {
+			if _, seen := index[w]; !seen {
+				connect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var component []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 || self[v] {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, f := range files {
+		if _, seen := index[f]; !seen {
+			connect(f)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// WriteDepsDOT renders the dependency graph for Graphviz. Files outside the
+// project are dashed, autoloads are dashed edges, and edges within a cycle
+// are red.
+func WriteDepsDOT(w io.Writer, g *DepGraph) error {
+	inCycle := make(map[string]int)
+	for i, cycle := range g.Cycles {
+		for _, f := range cycle {
+			inCycle[f] = i + 1
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph deps {")
+	fmt.Fprintln(bw, `  node [shape=box, fontname="monospace"];`)
+	for _, f := range g.Files {
+		fmt.Fprintf(bw, "  \"%s\";\n", dotEscaper.Replace(f))
+	}
+	for _, f := range g.External {
+		fmt.Fprintf(bw, "  \"%s\" [style=dashed];\n", dotEscaper.Replace(f))
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if e.Kind == "autoload" {
+			attrs = append(attrs, "style=dashed")
+		}
+		if c := inCycle[e.From]; c != 0 && inCycle[e.To] == c && !e.External {
+			attrs = append(attrs, "color=red")
+		}
+		suffix := ""
+		if len(attrs) > 0 {
+			suffix = " [" + strings.Join(attrs, ", ") + "]"
+		}
+		fmt.Fprintf(bw, "  \"%s\" -> \"%s\"%s;\n", dotEscaper.Replace(e.From), dotEscaper.Replace(e.To), suffix)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
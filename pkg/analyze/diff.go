@@ -0,0 +1,228 @@
+package analyze
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+)
+
+// NodeRef points at a node on one side of a diff.
+type NodeRef struct {
+	Path     string    `json:"path"`
+	Location *Location `json:"location,omitempty"`
+	Value    *string   `json:"value,omitempty"`
+}
+
+type NodeChange struct {
+	Kind   string   `json:"kind"`
+	Type   string   `json:"type"`
+	Before *NodeRef `json:"before,omitempty"`
+	After  *NodeRef `json:"after,omitempty"`
+}
+
+type DiffSummary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Moved   int `json:"moved"`
+	Changed int `json:"changed"`
+}
+
+type DiffResult struct {
+	Identical bool         `json:"identical"`
+	Summary   DiffSummary  `json:"summary"`
+	Changes   []NodeChange `json:"changes"`
+}
+
+func newNodeRef(t *TreeNode) *NodeRef {
+	ref := &NodeRef{Path: t.Path}
+	if loc, ok := t.Node.location(); ok {
+		ref.Location = &loc
+	}
+	if v, ok := t.Node.value(); ok {
+		ref.Value = &v
+	}
+	return ref
+}
+
+// scalars returns a signature of a node's non-node fields, type included:
+// everything that identifies the node apart from its children.
+func scalars(n *Node) string {
+	var sig []byte
+	for _, f := range n.Fields {
+		if f.Name == "location" {
+			continue
+		}
+		switch v := f.Value.(type) {
+		case string, json.Number, bool, nil:
+			b, _ := json.Marshal(v)
+			sig = append(sig, f.Name...)
+			sig = append(sig, '=')
+			sig = append(sig, b...)
+			sig = append(sig, ';')
+		}
+	}
+	return string(sig)
+}
+
+// subtreeHashes hashes every subtree's structure, ignoring locations, so
+// that identical code hashes the same wherever it appears.
+func subtreeHashes(nodes []*TreeNode) map[*TreeNode]uint64 {
+	children := make(map[*TreeNode][]*TreeNode)
+	for _, t := range nodes {
+		if t.Parent != nil {
+			children[t.Parent] = append(children[t.Parent], t)
+		}
+	}
+
+	hashes := make(map[*TreeNode]uint64, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		t := nodes[i]
+		h := fnv.New64a()
+		h.Write([]byte(scalars(t.Node)))
+		for _, child := range children[t] {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], hashes[child])
+			h.Write([]byte(child.Field))
+			h.Write(b[:])
+		}
+		hashes[t] = h.Sum64()
+	}
+	return hashes
+}
+
+// DiffTrees computes a structural diff between two trees in three passes:
+//
+//  1. Identical subtrees are matched wherever they are, preferring the same
+//     path, so relocated code is recognised as moved rather than removed
+//     and re-added.
+//  2. Remaining nodes under matched parents are paired by field and type;
+//     pairs whose own scalar fields differ are reported as changed.
+//  3. Whatever is still unmatched was added or removed. Only the top of
+//     each added, removed or moved subtree is reported.
+func DiffTrees(before, after *Node) DiffResult {
+	beforeNodes := flattenTree(before)
+	afterNodes := flattenTree(after)
+	beforeHashes := subtreeHashes(beforeNodes)
+	afterHashes := subtreeHashes(afterNodes)
+
+	subtree := func(nodes []*TreeNode, root int) []*TreeNode {
+		end := root + 1
+		for end < len(nodes) && nodes[end].Depth > nodes[root].Depth {
+			end++
+		}
+		return nodes[root:end]
+	}
+
+	matched := make(map[*TreeNode]*TreeNode)
+	reverse := make(map[*TreeNode]*TreeNode)
+	pair := func(b, a *TreeNode) {
+		matched[a] = b
+		reverse[b] = a
+	}
+
+	byHash := make(map[uint64][]int)
+	for i, t := range beforeNodes {
+		byHash[beforeHashes[t]] = append(byHash[beforeHashes[t]], i)
+	}
+
+	// Pass 1: identical subtrees.
+	for ai := 0; ai < len(afterNodes); ai++ {
+		a := afterNodes[ai]
+		if _, ok := matched[a]; ok {
+			continue
+		}
+		best := -1
+		for _, bi := range byHash[afterHashes[a]] {
+			if _, taken := reverse[beforeNodes[bi]]; taken {
+				continue
+			}
+			if best < 0 || beforeNodes[bi].Path == a.Path {
+				best = bi
+			}
+			if beforeNodes[bi].Path == a.Path {
+				break
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		bs, as := subtree(beforeNodes, best), subtree(afterNodes, ai)
+		for i := range as {
+			pair(bs[i], as[i])
+		}
+	}
+
+	// Pass 2: same field and type under matched parents.
+	var changes []NodeChange
+	var summary DiffSummary
+	if _, ok := matched[afterNodes[0]]; !ok && before.Type == after.Type {
+		if _, taken := reverse[beforeNodes[0]]; !taken {
+			pair(beforeNodes[0], afterNodes[0])
+		}
+	}
+	beforeChildren := make(map[*TreeNode][]*TreeNode)
+	for _, t := range beforeNodes {
+		if t.Parent != nil {
+			beforeChildren[t.Parent] = append(beforeChildren[t.Parent], t)
+		}
+	}
+	for _, a := range afterNodes {
+		if _, ok := matched[a]; ok || a.Parent == nil {
+			continue
+		}
+		bp, ok := matched[a.Parent]
+		if !ok {
+			continue
+		}
+		for _, b := range beforeChildren[bp] {
+			if _, taken := reverse[b]; taken {
+				continue
+			}
+			if b.Field == a.Field && b.Node.Type == a.Node.Type {
+				pair(b, a)
+				if scalars(b.Node) != scalars(a.Node) {
+					changes = append(changes, NodeChange{Kind: "changed", Type: a.Node.Type, Before: newNodeRef(b), After: newNodeRef(a)})
+					summary.Changed++
+				}
+				break
+			}
+		}
+	}
+
+	// Pass 3: moves, additions and removals.
+	for _, a := range afterNodes {
+		b, ok := matched[a]
+		if !ok {
+			if a.Parent == nil || matched[a.Parent] != nil {
+				changes = append(changes, NodeChange{Kind: "added", Type: a.Node.Type, After: newNodeRef(a)})
+				summary.Added++
+			}
+			continue
+		}
+		if a.Parent == nil || b.Parent == nil {
+			continue
+		}
+		if matched[a.Parent] != b.Parent || a.Field != b.Field {
+			changes = append(changes, NodeChange{Kind: "moved", Type: a.Node.Type, Before: newNodeRef(b), After: newNodeRef(a)})
+			summary.Moved++
+		}
+	}
+	for _, b := range beforeNodes {
+		if _, ok := reverse[b]; ok {
+			continue
+		}
+		if b.Parent == nil || reverse[b.Parent] != nil {
+			changes = append(changes, NodeChange{Kind: "removed", Type: b.Node.Type, Before: newNodeRef(b)})
+			summary.Removed++
+		}
+	}
+
+	if changes == nil {
+		changes = []NodeChange{}
+	}
+	return DiffResult{
+		Identical: len(changes) == 0,
+		Summary:   summary,
+		Changes:   changes,
+	}
+}
@@ -0,0 +1,42 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteDOT renders the tree as a Graphviz digraph. Each node is labelled
+// with its type (and literal value) plus its source range, and each edge
+// with the field the child came from.
+func WriteDOT(w io.Writer, root *Node) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph ast {")
+	fmt.Fprintln(bw, `  node [shape=box, fontname="monospace"];`)
+
+	ids := make(map[*Node]int)
+	Walk(root, func(n *Node, depth int) bool {
+		id := len(ids)
+		ids[n] = id
+
+		label := n.label()
+		if loc, ok := n.location(); ok {
+			label += "\n" + loc.String()
+		}
+		fmt.Fprintf(bw, "  n%d [label=\"%s\"];\n", id, dotEscaper.Replace(label))
+		return true
+	})
+
+	Walk(root, func(n *Node, depth int) bool {
+		for _, edge := range n.children() {
+			fmt.Fprintf(bw, "  n%d -> n%d [label=\"%s\"];\n", ids[n], ids[edge.Node], dotEscaper.Replace(edge.Field))
+		}
+		return true
+	})
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
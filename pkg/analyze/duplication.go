@@ -0,0 +1,237 @@
+package analyze
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// DefaultMinMass is the smallest subtree, in nodes, that FindDuplicates
+// reports unless asked otherwise. It's flay's default too, though trees
+// here have a few more nodes per construct than flay's s-expressions.
+const DefaultMinMass = 16
+
+// maxDuplicateGroups caps how many groups FindDuplicates returns.
+const maxDuplicateGroups = 200
+
+// Duplicate is a group of subtrees with the same structure: the same node
+// types in the same places, whatever their names and literal values.
+type Duplicate struct {
+	Type string `json:"type"`
+	// Mass is the number of nodes in each copy, and Score that times the
+	// number of copies, for ranking groups as flay does.
+	Mass  int `json:"mass"`
+	Score int `json:"score"`
+	// Similarity is the share of names and literal values the copies all
+	// agree on, so identical copies have 1.
+	Similarity float64         `json:"similarity"`
+	Identical  bool            `json:"identical"`
+	Copies     []DuplicateCopy `json:"copies"`
+}
+
+// DuplicateCopy is one copy in a Duplicate group. NodePath is the node's
+// path within its file's tree.
+type DuplicateCopy struct {
+	SourceSite
+	NodePath string `json:"node_path"`
+}
+
+// subtreeHash is what FindDuplicates knows about a subtree: a hash of its
+// shape, one that also covers its values, and its node count.
+type subtreeHash struct {
+	shape, exact uint64
+	mass         int
+}
+
+type duplicateCandidate struct {
+	file string
+	tree *TreeNode
+}
+
+// FindDuplicates reports subtrees of at least minMass nodes whose structure
+// appears more than once across the files, largest first. A group is left
+// out when every copy of it lies inside copies of a larger group already
+// reported, so one duplicated method isn't also reported as its duplicated
+// statements.
+func FindDuplicates(files []ParsedFile, minMass int) (groups []Duplicate, truncated bool) {
+	hashes := make(map[*Node]subtreeHash)
+	byShape := make(map[uint64][]duplicateCandidate)
+	var shapes []uint64
+	for _, f := range files {
+		hashSubtree(f.Root, hashes)
+		for _, t := range flattenTree(f.Root) {
+			h := hashes[t.Node]
+			if h.mass < minMass {
+				continue
+			}
+			if _, ok := byShape[h.shape]; !ok {
+				shapes = append(shapes, h.shape)
+			}
+			byShape[h.shape] = append(byShape[h.shape], duplicateCandidate{f.Path, t})
+		}
+	}
+
+	var repeated [][]duplicateCandidate
+	for _, shape := range shapes {
+		if c := byShape[shape]; len(c) > 1 {
+			repeated = append(repeated, c)
+		}
+	}
+	sort.SliceStable(repeated, func(i, j int) bool {
+		return hashes[repeated[i][0].tree.Node].mass > hashes[repeated[j][0].tree.Node].mass
+	})
+
+	covered := make(map[*Node]bool)
+	groups = []Duplicate{}
+	for _, candidates := range repeated {
+		inside := 0
+		for _, c := range candidates {
+			if covered[c.tree.Node] {
+				inside++
+			}
+		}
+		if inside == len(candidates) {
+			continue
+		}
+		for _, c := range candidates {
+			for _, edge := range c.tree.Node.children() {
+				Walk(edge.Node, func(n *Node, _ int) bool {
+					covered[n] = true
+					return true
+				})
+			}
+		}
+		groups = append(groups, newDuplicate(candidates, hashes))
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Score > groups[j].Score })
+	if len(groups) > maxDuplicateGroups {
+		return groups[:maxDuplicateGroups], true
+	}
+	return groups, false
+}
+
+func newDuplicate(candidates []duplicateCandidate, hashes map[*Node]subtreeHash) Duplicate {
+	first := candidates[0].tree.Node
+	d := Duplicate{
+		Type:      first.Type,
+		Mass:      hashes[first].mass,
+		Score:     hashes[first].mass * len(candidates),
+		Identical: true,
+	}
+	var values [][]string
+	for _, c := range candidates {
+		if hashes[c.tree.Node].exact != hashes[first].exact {
+			d.Identical = false
+		}
+		var v []string
+		subtreeValues(c.tree.Node, &v)
+		values = append(values, v)
+
+		site := SourceSite{Path: c.file}
+		if loc, ok := c.tree.Node.location(); ok {
+			site.Location = &loc
+		}
+		d.Copies = append(d.Copies, DuplicateCopy{SourceSite: site, NodePath: c.tree.Path})
+	}
+
+	d.Similarity = 1
+	if n := len(values[0]); n > 0 && !d.Identical {
+		agreed := 0
+		for i := 0; i < n; i++ {
+			same := true
+			for _, v := range values[1:] {
+				if i >= len(v) || v[i] != values[0][i] {
+					same = false
+					break
+				}
+			}
+			if same {
+				agreed++
+			}
+		}
+		d.Similarity = math.Round(float64(agreed)/float64(n)*100) / 100
+	}
+	return d
+}
+
+// hashSubtree records the hashes of n and every node below it.
+func hashSubtree(n *Node, hashes map[*Node]subtreeHash) subtreeHash {
+	shape, exact := fnv.New64a(), fnv.New64a()
+	shape.Write([]byte(n.Type))
+	exact.Write([]byte(n.Type))
+	mass := 1
+
+	var add func(path string, value interface{})
+	add = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case *Node:
+			if v.Type != "" {
+				h := hashSubtree(v, hashes)
+				writeChildHash(shape, path, h.shape)
+				writeChildHash(exact, path, h.exact)
+				mass += h.mass
+				return
+			}
+			for _, f := range v.Fields {
+				add(path+"."+f.Name, f.Value)
+			}
+		case []interface{}:
+			for i, element := range v {
+				add(fmt.Sprintf("%s[%d]", path, i), element)
+			}
+		default:
+			fmt.Fprintf(exact, "%s=%v;", path, v)
+		}
+	}
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" {
+			continue
+		}
+		add(f.Name, f.Value)
+	}
+
+	h := subtreeHash{shape: shape.Sum64(), exact: exact.Sum64(), mass: mass}
+	hashes[n] = h
+	return h
+}
+
+func writeChildHash(h hash.Hash64, path string, sum uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], sum)
+	h.Write([]byte(path))
+	h.Write(buf[:])
+}
+
+// subtreeValues appends the names and literal values in n's subtree, in
+// depth-first order, so copies of the same shape line up value by value.
+func subtreeValues(n *Node, values *[]string) {
+	var add func(value interface{})
+	add = func(value interface{}) {
+		switch v := value.(type) {
+		case *Node:
+			if v.Type != "" {
+				subtreeValues(v, values)
+				return
+			}
+			for _, f := range v.Fields {
+				add(f.Value)
+			}
+		case []interface{}:
+			for _, element := range v {
+				add(element)
+			}
+		default:
+			*values = append(*values, fmt.Sprint(v))
+		}
+	}
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" {
+			continue
+		}
+		add(f.Value)
+	}
+}
@@ -0,0 +1,170 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TextEdit replaces the characters from Start up to End with Text. Offsets
+// count characters, as node locations do.
+type TextEdit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// ApplyEdit returns code with e made, or false if e's range isn't within
+// code.
+func ApplyEdit(code string, e TextEdit) (string, bool) {
+	chars := []rune(code)
+	if e.Start < 0 || e.Start > e.End || e.End > len(chars) {
+		return "", false
+	}
+	return string(chars[:e.Start]) + e.Text + string(chars[e.End:]), true
+}
+
+// scopeTypes are the nodes that start a new local variable scope, so they
+// parse the same on their own as in their file. Anything else can depend on
+// the assignments before it: x is a method call until x = 1 makes it a
+// variable.
+var scopeTypes = map[string]bool{"def": true, "defs": true, "class": true, "module": true, "sclass": true}
+
+// EditScope finds the innermost def, class, module or singleton class in
+// root that the edit falls strictly inside of, and that nothing but
+// indentation comes before on its first line, so it can be parsed again on
+// its own. It returns the node's path and its source after the edit.
+func EditScope(root *Node, code string, e TextEdit) (path, source string, ok bool) {
+	chars := []rune(code)
+	var scope *TreeNode
+	var scopeLoc Location
+	for _, t := range flattenTree(root) {
+		loc, found := t.Node.location()
+		if !found || !scopeTypes[t.Node.Type] || loc.StartChar >= e.Start || e.End >= loc.EndChar || loc.EndChar > len(chars) {
+			continue
+		}
+		if !startsLine(chars, loc.StartChar) || (scope != nil && t.Depth <= scope.Depth) {
+			continue
+		}
+		scope, scopeLoc = t, loc
+	}
+	if scope == nil {
+		return "", "", false
+	}
+	source = string(chars[scopeLoc.StartChar:e.Start]) + e.Text + string(chars[e.End:scopeLoc.EndChar])
+	return scope.Path, source, true
+}
+
+func startsLine(chars []rune, at int) bool {
+	for i := at - 1; i >= 0 && chars[i] != '\n'; i-- {
+		if !unicode.IsSpace(chars[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SpliceEdit updates root, the tree of code before e, to the tree after it,
+// given program, the tree of the source EditScope returned for path parsed
+// on its own. Locations after the edit move by the lines and characters it
+// added or removed. It reports false, leaving root alone, if program isn't
+// a single statement of the same type spanning all of that source, as when
+// the edit unbalanced an end.
+func SpliceEdit(root *Node, code, path string, e TextEdit, program *Node) bool {
+	old, ok := LookupPath(root, path)
+	if !ok {
+		return false
+	}
+	oldLoc, _ := old.location()
+	var replacement *Node
+	if body := nodeField(program, "statements"); body != nil {
+		list, _ := body.field("body")
+		if stmts := typedNodes(list); len(stmts) == 1 {
+			replacement = stmts[0]
+		}
+	}
+	length := oldLoc.EndChar - oldLoc.StartChar + len([]rune(e.Text)) - (e.End - e.Start)
+	if replacement == nil || replacement.Type != old.Type {
+		return false
+	}
+	if loc, ok := replacement.location(); !ok || loc.StartChar != 0 || loc.EndChar != length {
+		return false
+	}
+
+	removed := []rune(code)[e.Start:e.End]
+	lines := strings.Count(e.Text, "\n") - strings.Count(string(removed), "\n")
+	chars := len([]rune(e.Text)) - len(removed)
+	shiftLocations(root, old, func(line, char int) (int, int) {
+		if char >= e.End {
+			return line + lines, char + chars
+		}
+		return line, char
+	})
+	shiftLocations(replacement, nil, func(line, char int) (int, int) {
+		return line + oldLoc.StartLine - 1, char + oldLoc.StartChar
+	})
+	return replaceNode(root, old, replacement)
+}
+
+// shiftLocations moves every location in n's subtree, other than in skip's,
+// by applying move to both ends.
+func shiftLocations(n, skip *Node, move func(line, char int) (int, int)) {
+	if n == skip {
+		return
+	}
+	for i, f := range n.Fields {
+		if f.Name == "location" {
+			if loc, ok := n.location(); ok {
+				startLine, startChar := move(loc.StartLine, loc.StartChar)
+				endLine, endChar := move(loc.EndLine, loc.EndChar)
+				n.Fields[i].Value = []interface{}{
+					json.Number(fmt.Sprint(startLine)), json.Number(fmt.Sprint(startChar)),
+					json.Number(fmt.Sprint(endLine)), json.Number(fmt.Sprint(endChar)),
+				}
+			}
+			continue
+		}
+		shiftValue(f.Value, skip, move)
+	}
+}
+
+func shiftValue(value interface{}, skip *Node, move func(line, char int) (int, int)) {
+	switch v := value.(type) {
+	case *Node:
+		shiftLocations(v, skip, move)
+	case []interface{}:
+		for _, element := range v {
+			shiftValue(element, skip, move)
+		}
+	}
+}
+
+// replaceNode puts replacement where old is in n's subtree.
+func replaceNode(n, old, replacement *Node) bool {
+	var replace func(value interface{}) (interface{}, bool)
+	replace = func(value interface{}) (interface{}, bool) {
+		switch v := value.(type) {
+		case *Node:
+			if v == old {
+				return replacement, true
+			}
+			for i, f := range v.Fields {
+				if updated, ok := replace(f.Value); ok {
+					v.Fields[i].Value = updated
+					return v, true
+				}
+			}
+		case []interface{}:
+			for i, element := range v {
+				if updated, ok := replace(element); ok {
+					v[i] = updated
+					return v, true
+				}
+			}
+		}
+		return value, false
+	}
+	_, ok := replace(n)
+	return ok
+}
@@ -0,0 +1,282 @@
+package analyze
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// EncodingError is source that DecodeSource can't turn into UTF-8. Encoding
+// is the encoding it was declared or detected in; Line and Column, 1-based,
+// are the first bad byte when the source claims to be UTF-8 but isn't.
+type EncodingError struct {
+	Encoding string
+	Line     int
+	Column   int
+	err      string
+}
+
+func (e *EncodingError) Error() string { return e.err }
+
+// magicComment matches Ruby's encoding comment in its plain, Emacs and Vim
+// forms: # encoding: cp1252, # -*- coding: sjis -*-, # vim: fileencoding=...
+var magicComment = regexp.MustCompile(`(?i)^#.*?coding[:=][ \t]*([\w.-]+)`)
+
+// singleByteEncodings maps the bytes 0x80-0xFF to runes for each encoding
+// DecodeSource converts. Bytes Windows-1252 leaves undefined decode to the
+// C1 controls, as browsers do.
+var singleByteEncodings = map[string]func(b byte) rune{
+	"ISO-8859-1":   func(b byte) rune { return rune(b) },
+	"ISO-8859-15":  func(b byte) rune { return latin9[b] },
+	"Windows-1252": func(b byte) rune { return cp1252[b] },
+}
+
+var cp1252 = func() (m [256]rune) {
+	for i := range m {
+		m[i] = rune(i)
+	}
+	high := []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ")
+	copy(m[0x80:], high)
+	return m
+}()
+
+var latin9 = func() (m [256]rune) {
+	for i := range m {
+		m[i] = rune(i)
+	}
+	for b, r := range map[byte]rune{0xA4: '€', 0xA6: 'Š', 0xA8: 'š', 0xB4: 'Ž', 0xB8: 'ž', 0xBC: 'Œ', 0xBD: 'œ', 0xBE: 'Ÿ'} {
+		m[b] = r
+	}
+	return m
+}()
+
+// encodingNames maps the names and aliases Ruby accepts in a magic comment,
+// lower-cased, to a canonical name. Those not in singleByteEncodings are
+// recognized but can't be converted.
+var encodingNames = map[string]string{
+	"utf-8": "UTF-8", "utf8": "UTF-8", "utf-8-mac": "UTF-8", "utf8-mac": "UTF-8",
+	"us-ascii": "US-ASCII", "ascii": "US-ASCII", "ascii-8bit": "ASCII-8BIT", "binary": "ASCII-8BIT",
+	"iso-8859-1": "ISO-8859-1", "iso8859-1": "ISO-8859-1", "latin1": "ISO-8859-1",
+	"iso-8859-15": "ISO-8859-15", "iso8859-15": "ISO-8859-15", "latin9": "ISO-8859-15",
+	"windows-1252": "Windows-1252", "cp1252": "Windows-1252",
+	"shift_jis": "Shift_JIS", "sjis": "Shift_JIS", "windows-31j": "Windows-31J", "cp932": "Windows-31J",
+	"euc-jp": "EUC-JP", "eucjp": "EUC-JP", "euc-kr": "EUC-KR", "gbk": "GBK", "gb2312": "GB2312",
+	"gb18030": "GB18030", "big5": "Big5", "koi8-r": "KOI8-R", "windows-1251": "Windows-1251",
+	"cp1251": "Windows-1251", "utf-16le": "UTF-16LE", "utf-16be": "UTF-16BE",
+}
+
+// DecodeSource returns data as UTF-8 along with the encoding it was in. It
+// goes by a byte order mark, then charset if it isn't "", then a magic
+// comment on the first two lines, and otherwise takes valid UTF-8 as it is.
+// Anything else is guessed at: UTF-16 without a BOM is converted, Shift_JIS
+// and EUC-JP fail with an *EncodingError as multibyte encodings other than
+// UTF-16 can't be converted here, and the rest is read as Windows-1252.
+//
+// A converted magic comment is rewritten to say utf-8, padded to its old
+// length so the locations after it don't move.
+func DecodeSource(data []byte, charset string) (string, string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xEF\xBB\xBF")):
+		return decodeUTF8(data[3:])
+	case bytes.HasPrefix(data, []byte("\xFF\xFE")):
+		return decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte("\xFE\xFF")):
+		return decodeUTF16(data[2:], true)
+	}
+
+	name, declared := charset, charset != ""
+	span := findMagicComment(data)
+	if !declared && span != nil {
+		name, declared = string(data[span[0]:span[1]]), true
+	}
+	if declared {
+		encoding, ok := encodingNames[strings.ToLower(name)]
+		if !ok {
+			return "", "", &EncodingError{Encoding: name, err: fmt.Sprintf("unknown encoding %q", name)}
+		}
+		switch encoding {
+		case "UTF-8", "US-ASCII":
+			return decodeUTF8(data)
+		case "ASCII-8BIT":
+			// Binary source is bytes with no encoding, so go by its contents.
+		case "UTF-16LE", "UTF-16BE":
+			return decodeUTF16(data, encoding == "UTF-16BE")
+		default:
+			decode, ok := singleByteEncodings[encoding]
+			if !ok {
+				return "", "", unsupportedEncoding(encoding)
+			}
+			return transcode(data, decode, span), encoding, nil
+		}
+	}
+
+	if bigEndian, ok := looksUTF16(data); ok {
+		return decodeUTF16(data, bigEndian)
+	}
+	if utf8.Valid(data) {
+		return string(data), "UTF-8", nil
+	}
+	if guess := guessEncoding(data); guess != "" {
+		return "", "", unsupportedEncoding(guess)
+	}
+	return transcode(data, singleByteEncodings["Windows-1252"], span), "Windows-1252", nil
+}
+
+func unsupportedEncoding(encoding string) error {
+	return &EncodingError{Encoding: encoding, err: fmt.Sprintf("%s source can't be converted to UTF-8", encoding)}
+}
+
+// findMagicComment returns the byte span of the encoding name in a magic
+// comment on the first line, or the second if the first is a shebang.
+func findMagicComment(data []byte) []int {
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	for i, line := range lines {
+		if i == 2 {
+			break
+		}
+		if m := magicComment.FindSubmatchIndex(line); m != nil {
+			offset := 0
+			if i == 1 {
+				offset = len(lines[0]) + 1
+			}
+			return []int{offset + m[2], offset + m[3]}
+		}
+		if !bytes.HasPrefix(line, []byte("#!")) {
+			break
+		}
+	}
+	return nil
+}
+
+func decodeUTF8(data []byte) (string, string, error) {
+	if utf8.Valid(data) {
+		return string(data), "UTF-8", nil
+	}
+	e := &EncodingError{Encoding: "UTF-8", Line: 1, Column: 1}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			break
+		}
+		if r == '\n' {
+			e.Line, e.Column = e.Line+1, 1
+		} else {
+			e.Column++
+		}
+		data = data[size:]
+	}
+	e.err = fmt.Sprintf("invalid UTF-8 at line %d, column %d", e.Line, e.Column)
+	return "", "", e
+}
+
+func decodeUTF16(data []byte, bigEndian bool) (string, string, error) {
+	encoding := "UTF-16LE"
+	if bigEndian {
+		encoding = "UTF-16BE"
+	}
+	if len(data)%2 != 0 {
+		return "", "", &EncodingError{Encoding: encoding, err: fmt.Sprintf("%s source has an odd number of bytes", encoding)}
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units)), encoding, nil
+}
+
+// transcode converts single-byte encoded data to UTF-8, blanking the magic
+// comment's encoding name at span to utf-8.
+func transcode(data []byte, decode func(byte) rune, span []int) string {
+	var sb strings.Builder
+	sb.Grow(len(data) + len(data)/8)
+	for i := 0; i < len(data); i++ {
+		if span != nil && i == span[0] {
+			sb.WriteString("utf-8")
+			if pad := span[1] - span[0] - len("utf-8"); pad > 0 {
+				sb.WriteString(strings.Repeat(" ", pad))
+			}
+			i = span[1] - 1
+			continue
+		}
+		if b := data[i]; b < 0x80 {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteRune(decode(b))
+		}
+	}
+	return sb.String()
+}
+
+// looksUTF16 reports whether data is UTF-16 without a BOM, going by the NUL
+// bytes ASCII characters have in it and Ruby source otherwise doesn't.
+func looksUTF16(data []byte) (bigEndian, ok bool) {
+	even, odd := 0, 0
+	for i, b := range data {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	return even > odd, len(data)%2 == 0 && even+odd > len(data)/4
+}
+
+// guessEncoding recognizes invalid UTF-8 that's more likely a Japanese
+// multibyte encoding than a single-byte one, returning "" if it isn't. Every
+// byte of Shift_JIS or EUC-JP text above 0x7F has to pair up for it to count.
+func guessEncoding(data []byte) string {
+	if pairedBytes(data, func(b, next byte) int {
+		switch {
+		case (b >= 0x81 && b <= 0x9F || b >= 0xE0 && b <= 0xFC) && (next >= 0x40 && next <= 0x7E || next >= 0x80 && next <= 0xFC):
+			return 2
+		case b >= 0xA1 && b <= 0xDF:
+			return 1
+		}
+		return 0
+	}) {
+		return "Shift_JIS"
+	}
+	if pairedBytes(data, func(b, next byte) int {
+		if (b >= 0xA1 && b <= 0xFE || b == 0x8E) && next >= 0xA1 && next <= 0xFE {
+			return 2
+		}
+		return 0
+	}) {
+		return "EUC-JP"
+	}
+	return ""
+}
+
+// pairedBytes reports whether every byte above 0x7F in data starts a
+// character of the size char gives, with at least one two-byte character.
+func pairedBytes(data []byte, char func(b, next byte) int) bool {
+	pairs := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 {
+			continue
+		}
+		var next byte
+		if i+1 < len(data) {
+			next = data[i+1]
+		}
+		switch char(b, next) {
+		case 2:
+			pairs++
+			i++
+		case 1:
+		default:
+			return false
+		}
+	}
+	return pairs > 0
+}
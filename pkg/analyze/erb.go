@@ -0,0 +1,77 @@
+package analyze
+
+import (
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// CompileERB turns an ERB template into the Ruby it embeds, laid out so that
+// every character of that Ruby sits at the same line and column as in the
+// template. Template text, comments and tag delimiters are blanked out with
+// spaces rather than dropped, and each tag is closed with a semicolon so
+// that tags on one line still read as separate statements. Node locations
+// and syntax error positions from parsing the result therefore point
+// straight into the template.
+//
+// Both the erb and Erubi flavours of tag are understood: <% %>, <%= %>,
+// <%== %>, <%- -%> and <%# %>, with <%% for a literal <%.
+func CompileERB(template string) (string, error) {
+	var out strings.Builder
+	out.Grow(len(template))
+	blank := func(s string) {
+		for _, r := range s {
+			if r == '\n' || r == '\r' {
+				out.WriteRune(r)
+			} else {
+				out.WriteByte(' ')
+			}
+		}
+	}
+
+	rest := template
+	for {
+		open := strings.Index(rest, "<%")
+		if open < 0 {
+			blank(rest)
+			return out.String(), nil
+		}
+		blank(rest[:open])
+		rest = rest[open:]
+
+		if strings.HasPrefix(rest, "<%%") {
+			blank("<%%")
+			rest = rest[3:]
+			continue
+		}
+		end := strings.Index(rest, "%>")
+		if end < 0 {
+			line, column := templatePosition(template, len(template)-len(rest))
+			return "", &parser.SyntaxError{Message: "unterminated ERB tag", Line: line, Column: column}
+		}
+
+		tag, closing := rest[:end], "%>"
+		if strings.HasPrefix(rest, "<%#") {
+			blank(tag + closing)
+			rest = rest[end+len(closing):]
+			continue
+		}
+		opening := "<%"
+		for _, marker := range []string{"<%==", "<%=", "<%-"} {
+			if strings.HasPrefix(tag, marker) {
+				opening = marker
+				break
+			}
+		}
+		code := tag[len(opening):]
+		if strings.HasSuffix(code, "-") {
+			code, closing = code[:len(code)-1], "-"+closing
+		}
+
+		blank(opening)
+		out.WriteString(code)
+		out.WriteByte(';')
+		blank(closing[1:])
+		rest = rest[end+2:]
+	}
+}
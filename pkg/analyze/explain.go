@@ -0,0 +1,74 @@
+package analyze
+
+import (
+	_ "embed"
+	"encoding/json"
+)
+
+//go:embed data/nodes.json
+var nodesJSON []byte
+
+// NodeExplanation describes one parser's node type for someone reading its
+// tree. See names the types other parsers, or the same one, use for related
+// syntax.
+type NodeExplanation struct {
+	Type        string      `json:"type"`
+	Parsers     []string    `json:"parsers"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Example     string      `json:"example"`
+	Fields      []NodeField `json:"fields"`
+	See         []string    `json:"see"`
+}
+
+// NodeField is a field a node type holds its children or values in.
+type NodeField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// nodeExplanations holds the catalog by type. A name several parsers use
+// for different shapes, like call, has one entry per parser.
+var nodeExplanations = func() map[string][]NodeExplanation {
+	var entries []struct {
+		NodeExplanation
+		Fields [][2]string `json:"fields"`
+	}
+	if err := json.Unmarshal(nodesJSON, &entries); err != nil {
+		panic("analyze: bad node catalog: " + err.Error())
+	}
+	byType := map[string][]NodeExplanation{}
+	for _, e := range entries {
+		ex := e.NodeExplanation
+		ex.Fields = []NodeField{}
+		for _, f := range e.Fields {
+			ex.Fields = append(ex.Fields, NodeField{Name: f[0], Description: f[1]})
+		}
+		if ex.See == nil {
+			ex.See = []string{}
+		}
+		byType[ex.Type] = append(byType[ex.Type], ex)
+	}
+	return byType
+}()
+
+// Explain looks up a node type as the named parser produces it. With no
+// parser it prefers preferred's entry, falling back to any parser's.
+func Explain(nodeType, parser, preferred string) (NodeExplanation, bool) {
+	entries := nodeExplanations[nodeType]
+	want := parser
+	if want == "" {
+		want = preferred
+	}
+	for _, e := range entries {
+		for _, name := range e.Parsers {
+			if name == want {
+				return e, true
+			}
+		}
+	}
+	if parser == "" && len(entries) > 0 {
+		return entries[0], true
+	}
+	return NodeExplanation{}, false
+}
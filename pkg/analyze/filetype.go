@@ -0,0 +1,74 @@
+package analyze
+
+import (
+	"path"
+	"strings"
+)
+
+// File types a request can name with its filetype field. Most Ruby DSL files
+// parse like any other Ruby; what differs is the file name tools such as
+// RuboCop need to see to apply the right rules.
+const (
+	FileTypeRuby     = "ruby"
+	fileTypeGemfile  = "gemfile"
+	fileTypeRakefile = "rakefile"
+	fileTypeRackup   = "rackup"
+	fileTypeGemspec  = "gemspec"
+	FileTypeERB      = "erb"
+)
+
+// FileTypeNames is the file name each type is presented to tools as.
+var FileTypeNames = map[string]string{
+	FileTypeRuby:     "snippet.rb",
+	fileTypeGemfile:  "Gemfile",
+	fileTypeRakefile: "Rakefile",
+	fileTypeRackup:   "config.ru",
+	fileTypeGemspec:  "snippet.gemspec",
+	FileTypeERB:      "snippet.html.erb",
+}
+
+// rubyFileNames are Ruby files recognised by their whole name, since they
+// have no extension.
+var rubyFileNames = map[string]string{
+	"Gemfile":     fileTypeGemfile,
+	"gems.rb":     fileTypeGemfile,
+	"Rakefile":    fileTypeRakefile,
+	"rakefile":    fileTypeRakefile,
+	"Berksfile":   FileTypeRuby,
+	"Brewfile":    FileTypeRuby,
+	"Capfile":     FileTypeRuby,
+	"Dangerfile":  FileTypeRuby,
+	"Guardfile":   FileTypeRuby,
+	"Podfile":     FileTypeRuby,
+	"Steepfile":   FileTypeRuby,
+	"Thorfile":    FileTypeRuby,
+	"Vagrantfile": FileTypeRuby,
+}
+
+var rubyExtensions = map[string]string{
+	".rb":       FileTypeRuby,
+	".rake":     fileTypeRakefile,
+	".ru":       fileTypeRackup,
+	".gemspec":  fileTypeGemspec,
+	".podspec":  FileTypeRuby,
+	".jbuilder": FileTypeRuby,
+	".builder":  FileTypeRuby,
+	".thor":     FileTypeRuby,
+	".erb":      FileTypeERB,
+}
+
+// DetectFileType works out a file's type from its name, or returns "" if it
+// isn't a Ruby file.
+func DetectFileType(name string) string {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if fileType, ok := rubyFileNames[base]; ok {
+		return fileType
+	}
+	return rubyExtensions[path.Ext(base)]
+}
+
+// IsRubySource reports whether path names a file the project endpoints
+// parse: plain Ruby, a Ruby DSL file or an ERB template.
+func IsRubySource(path string) bool {
+	return DetectFileType(path) != ""
+}
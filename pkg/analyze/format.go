@@ -0,0 +1,87 @@
+package analyze
+
+import (
+	"strings"
+)
+
+// matchLines returns the index pairs of lines a and b have in common, in
+// order, using Myers' O(ND) diff algorithm.
+func matchLines(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	steps := 0
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				steps = d
+				break search
+			}
+		}
+	}
+
+	var pairs [][2]int
+	x, y := n, m
+	for d := steps; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[offset+k-1] < prev[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			pairs = append(pairs, [2]int{x - 1, y - 1})
+			x, y = x-1, y-1
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		pairs = append(pairs, [2]int{x - 1, y - 1})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs
+}
+
+// LineMap maps each 1-based line of before to the line of after holding the
+// same code, or nil if formatting rewrote it beyond recognition. Lines are
+// compared with whitespace collapsed, so re-indented lines still match.
+func LineMap(before, after string) []*int {
+	normalize := func(code string) []string {
+		lines := strings.Split(code, "\n")
+		for i, line := range lines {
+			lines[i] = strings.Join(strings.Fields(line), " ")
+		}
+		return lines
+	}
+
+	mapping := make([]*int, strings.Count(before, "\n")+1)
+	for _, pair := range matchLines(normalize(before), normalize(after)) {
+		line := pair[1] + 1
+		mapping[pair[0]] = &line
+	}
+	return mapping
+}
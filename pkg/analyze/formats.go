@@ -0,0 +1,206 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+const DefaultFormat = "json"
+
+// FormatOptions tune the output. MaxDepth and MaxNodes prune the tree (see
+// PruneTree) whatever the format; formats ignore other options that don't
+// apply to them.
+type FormatOptions struct {
+	MaxDepth         int  `json:"max_depth"`
+	MaxNodes         int  `json:"max_nodes"`
+	CollapseLiterals bool `json:"collapse_literals"`
+
+	// Metrics attaches code metrics (see ComputeCodeMetrics) to def,
+	// class and module nodes in JSON output.
+	Metrics bool `json:"metrics"`
+
+	// Scopes marks each node binding or using a local variable with the
+	// variable's ID (see AnalyzeScopes) in JSON output.
+	Scopes bool `json:"scopes"`
+
+	// Filter is a JSONPath expression (see CompileJSONPath) selecting the
+	// fragments of JSON output to return, as an array, instead of the tree.
+	Filter string `json:"filter"`
+
+	// Compact strips locations, comments and other parser detail from
+	// JSON output (see compactTree).
+	Compact bool `json:"compact"`
+
+	// Raw returns JSON output in the parser's own shape rather than
+	// normalized (see NormalNode).
+	Raw bool `json:"raw"`
+
+	// Columns adds each located node's columns on its first and last lines
+	// counted in characters, UTF-16 code units and bytes (see
+	// LocationColumns), worked out from Source, the code parsed.
+	Columns bool   `json:"columns"`
+	Source  string `json:"-"`
+
+	// Index gives each node of JSON output its path and returns the tree
+	// with a NodeIndex of Source alongside it.
+	Index bool `json:"index"`
+
+	// IDs gives each node of JSON output an "id" that stays the same when
+	// the code is parsed again after an edit elsewhere (see NodeIDs).
+	IDs bool `json:"ids"`
+
+	// Layout is LayoutNested, the default, or LayoutFlat to return
+	// normalized JSON output as an array of FlatNodes.
+	Layout string `json:"layout"`
+
+	// Heredocs is HeredocsInline or HeredocsSeparate to give heredocs in
+	// normalized JSON output locations that hold their bodies, found in
+	// Source.
+	Heredocs string `json:"heredocs"`
+
+	// Interpolation gives strings in normalized JSON output text and
+	// interpolation parts (see expandInterpolation).
+	Interpolation bool `json:"interpolation"`
+}
+
+// RewritesJSON reports whether options other than Raw change JSON output.
+// Without Raw it is normalized too, so only raw output with none of them is
+// the parser's own.
+func (opts FormatOptions) RewritesJSON() bool {
+	return opts.MaxDepth > 0 || opts.MaxNodes > 0 || opts.Metrics || opts.Scopes || opts.Filter != "" || opts.Compact || opts.Columns || opts.Index || opts.IDs || opts.Layout == LayoutFlat || opts.Heredocs != "" || opts.Interpolation
+}
+
+// OutputFormat converts parser JSON into another representation of the tree.
+type OutputFormat struct {
+	ContentType string
+	render      func(w io.Writer, root *Node, opts FormatOptions) error
+}
+
+var OutputFormats = map[string]OutputFormat{
+	"dot": {
+		ContentType: "text/vnd.graphviz; charset=utf-8",
+		render: func(w io.Writer, root *Node, _ FormatOptions) error {
+			return WriteDOT(w, root)
+		},
+	},
+	"html": {
+		ContentType: "text/html; charset=utf-8",
+		render: func(w io.Writer, root *Node, _ FormatOptions) error {
+			return WriteHTML(w, root, "")
+		},
+	},
+	"mermaid": {ContentType: "text/plain; charset=utf-8", render: writeMermaid},
+	"sexp":    {ContentType: "text/plain; charset=utf-8", render: writeSexp},
+	"tree":    {ContentType: "text/plain; charset=utf-8", render: writeTree},
+}
+
+func KnownFormat(name string) bool {
+	_, ok := OutputFormats[name]
+	return ok || name == DefaultFormat
+}
+
+// RenderFormat converts output, the raw JSON from a parser, to the named
+// format. JSON is passed through unchanged unless the options rewrite it.
+func RenderFormat(name string, output []byte, opts FormatOptions) (contentType string, body []byte, err error) {
+	if name == DefaultFormat {
+		body, err := renderJSON(output, opts)
+		return "application/json", body, err
+	}
+
+	root, err := DecodeAST(output)
+	if err != nil {
+		return "", nil, err
+	}
+	root = PruneTree(root, "", opts.MaxDepth, opts.MaxNodes)
+	format := OutputFormats[name]
+	var buf bytes.Buffer
+	if err := format.render(&buf, root, opts); err != nil {
+		return "", nil, err
+	}
+	return format.ContentType, buf.Bytes(), nil
+}
+
+// renderJSON applies the options to JSON output. Metrics, scopes, pruning,
+// compaction and columns work on trees of typed nodes and leave ripper's
+// s-expressions alone; normalization, the layout and the filter come last,
+// so the filter selects from what would otherwise be returned.
+func renderJSON(output []byte, opts FormatOptions) ([]byte, error) {
+	if opts.Raw && !opts.RewritesJSON() {
+		return output, nil
+	}
+
+	value, err := decodeJSON(output)
+	if err != nil {
+		return nil, err
+	}
+	var index *NodeIndex
+	if root, ok := value.(*Node); ok {
+		if opts.Metrics {
+			// Measured before pruning, so methods cut short still report
+			// their full size.
+			attachMetrics(root)
+		}
+		if opts.Scopes {
+			attachScopes(root)
+		}
+		if opts.IDs {
+			attachIDs(root)
+		}
+		root = PruneTree(root, "", opts.MaxDepth, opts.MaxNodes)
+		if opts.Index {
+			// Built after pruning, so every path in it is in the tree.
+			index = BuildIndex(root, opts.Source)
+			attachPaths(root)
+		}
+		if opts.Compact {
+			compactTree(root)
+		}
+		if opts.Columns {
+			attachColumns(root, opts.Source)
+		}
+		value = root
+	}
+	if !opts.Raw {
+		normal, err := normalizeTree(value)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Interpolation {
+			expandInterpolation(normal)
+		}
+		if opts.Heredocs != "" {
+			layoutHeredocs(normal, opts.Source, opts.Heredocs)
+		}
+		var tree interface{} = normal
+		if opts.Layout == LayoutFlat {
+			tree = FlatNodes(normal)
+		}
+		if opts.Filter == "" {
+			return marshalIndexed(tree, index)
+		}
+		data, err := json.Marshal(tree)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = decodeJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Filter != "" {
+		path, err := CompileJSONPath(opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return marshalIndexed(path.eval(value), index)
+	}
+	return marshalIndexed(value, index)
+}
+
+func marshalIndexed(value interface{}, index *NodeIndex) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil || index == nil {
+		return data, err
+	}
+	return json.Marshal(indexedTree{Tree: data, Index: index})
+}
@@ -0,0 +1,497 @@
+package analyze
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Generation limits for GenerateOptions.Complexity.
+const (
+	MinComplexity     = 1
+	MaxComplexity     = 10
+	DefaultComplexity = 3
+)
+
+// GenerateOptions control Generate. The same seed and complexity always
+// give the same program.
+type GenerateOptions struct {
+	Seed int64
+	// Complexity, from MinComplexity to MaxComplexity, sets how many
+	// statements there are and how deeply they and their expressions nest.
+	Complexity int
+}
+
+var (
+	genLocals  = []string{"a", "b", "count", "total", "name", "items", "result", "value", "x", "y", "memo", "flag"}
+	genParams  = []string{"first", "second", "other", "input", "size", "label"}
+	genMethods = []string{"greet", "compute", "helper", "process", "build", "check", "render", "update"}
+	genClasses = []string{"Widget", "Point", "Store", "Report", "Parser", "Queue"}
+	genWords   = []string{"alpha", "beta", "gamma", "hello", "world", "ruby", "tree", "node"}
+	genBinary  = []string{"+", "-", "*", "/", "%", "**", "==", "!=", "<", ">", "<=", ">=", "&&", "||", "<=>", "&", "|", "<<"}
+	genOpWrite = []string{"+=", "-=", "*=", "||=", "&&="}
+	genCallees = []string{"to_s", "inspect", "class", "frozen?", "dup", "hash"}
+)
+
+// generator writes a random program one statement at a time. It tracks the
+// local variables and methods in scope and where it is, so that what it
+// writes is not just well-formed but free of the errors Ruby catches while
+// parsing, such as a break outside a loop or a constant assigned in a
+// method.
+type generator struct {
+	r        *rand.Rand
+	b        strings.Builder
+	indent   int
+	maxNest  int
+	maxDepth int
+	nest     int
+	scopes   [][]string
+	methods  map[string]int
+	classes  []string
+	inDef    bool
+	inLoop   bool
+	inClass  bool
+}
+
+// Generate writes a random but syntactically valid Ruby program covering
+// the common statements and expressions: assignments, calls with blocks,
+// conditionals, loops, method and class definitions, and literals of every
+// kind.
+func Generate(opts GenerateOptions) string {
+	c := opts.Complexity
+	if c < MinComplexity {
+		c = MinComplexity
+	}
+	if c > MaxComplexity {
+		c = MaxComplexity
+	}
+	g := &generator{
+		r:        rand.New(rand.NewSource(opts.Seed)),
+		maxNest:  1 + c/3,
+		maxDepth: 1 + (c+1)/3,
+		scopes:   [][]string{nil},
+		methods:  make(map[string]int),
+	}
+	g.statements(2 + c + g.r.Intn(c+1))
+	return g.b.String()
+}
+
+func (g *generator) pick(list []string) string {
+	return list[g.r.Intn(len(list))]
+}
+
+func (g *generator) chance(percent int) bool {
+	return g.r.Intn(100) < percent
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	g.b.WriteString(strings.Repeat("  ", g.indent))
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteByte('\n')
+}
+
+func (g *generator) locals() []string {
+	return g.scopes[len(g.scopes)-1]
+}
+
+func (g *generator) define(name string) {
+	for _, local := range g.locals() {
+		if local == name {
+			return
+		}
+	}
+	g.scopes[len(g.scopes)-1] = append(g.scopes[len(g.scopes)-1], name)
+}
+
+// scope runs body in a new local variable scope, as under def and class.
+// Blocks see the variables outside them, so they start with a copy.
+func (g *generator) scope(inherit bool, body func()) {
+	var start []string
+	if inherit {
+		start = append(start, g.locals()...)
+	}
+	g.scopes = append(g.scopes, start)
+	g.indent++
+	body()
+	g.indent--
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+func (g *generator) statements(n int) {
+	for i := 0; i < n; i++ {
+		g.statement()
+	}
+}
+
+// body writes the statements of a nested construct, fewer the deeper it is.
+func (g *generator) body() {
+	g.nest++
+	g.statements(1 + g.r.Intn(3))
+	g.nest--
+}
+
+func (g *generator) statement() {
+	type choice struct {
+		weight int
+		ok     bool
+		write  func()
+	}
+	nested := g.nest < g.maxNest
+	choices := []choice{
+		{6, true, g.assign},
+		{2, len(g.locals()) > 0, g.opAssign},
+		{1, true, g.multiAssign},
+		{4, true, g.printCall},
+		{1, true, g.modifier},
+		{2, nested, g.ifStatement},
+		{2, nested, g.eachBlock},
+		{1, nested, g.whileLoop},
+		{1, nested, g.caseStatement},
+		{1, nested, g.beginRescue},
+		{2, nested && !g.inDef, g.def},
+		{1, nested && !g.inDef && !g.inClass, g.class},
+		{1, g.inLoop, g.breakOrNext},
+		{1, g.inDef, g.returnStatement},
+		{1, g.inDef, g.yieldStatement},
+		{1, len(g.methods) > 0, g.callMethod},
+	}
+	total := 0
+	for _, c := range choices {
+		if c.ok {
+			total += c.weight
+		}
+	}
+	n := g.r.Intn(total)
+	for _, c := range choices {
+		if !c.ok {
+			continue
+		}
+		if n < c.weight {
+			c.write()
+			return
+		}
+		n -= c.weight
+	}
+}
+
+func (g *generator) assign() {
+	if !g.inDef && g.chance(10) {
+		g.line("%s_%d = %s", strings.ToUpper(g.pick(genWords)), g.r.Intn(10), g.expr(0))
+		return
+	}
+	name := g.pick(genLocals)
+	value := g.expr(0)
+	g.define(name)
+	g.line("%s = %s", name, value)
+}
+
+func (g *generator) opAssign() {
+	g.line("%s %s %s", g.pick(g.locals()), g.pick(genOpWrite), g.expr(1))
+}
+
+func (g *generator) multiAssign() {
+	first, second := g.pick(genLocals), g.pick(genLocals)
+	if first == second {
+		second += "2"
+	}
+	value := g.expr(1) + ", " + g.expr(1)
+	if g.chance(30) {
+		value = g.array(1)
+	}
+	g.define(first)
+	g.define(second)
+	g.line("%s, %s = %s", first, second, value)
+}
+
+func (g *generator) printCall() {
+	g.line("%s(%s)", g.pick([]string{"puts", "p", "print"}), g.expr(0))
+}
+
+func (g *generator) modifier() {
+	g.line("%s(%s) %s %s", g.pick([]string{"puts", "p"}), g.expr(1), g.pick([]string{"if", "unless"}), g.condition())
+}
+
+func (g *generator) ifStatement() {
+	keyword := "if"
+	if g.chance(25) {
+		keyword = "unless"
+	}
+	g.line("%s %s", keyword, g.condition())
+	g.indented(g.body)
+	if keyword == "if" && g.chance(30) {
+		g.line("elsif %s", g.condition())
+		g.indented(g.body)
+	}
+	if g.chance(50) {
+		g.line("else")
+		g.indented(g.body)
+	}
+	g.line("end")
+}
+
+func (g *generator) indented(body func()) {
+	g.indent++
+	body()
+	g.indent--
+}
+
+// loop writes body as the inside of a loop or block, where break and next
+// are allowed.
+func (g *generator) loop(body func()) {
+	inLoop := g.inLoop
+	g.inLoop = true
+	body()
+	g.inLoop = inLoop
+}
+
+func (g *generator) eachBlock() {
+	receiver := g.pick([]string{g.array(1), g.rangeLiteral(), "(" + g.hash(1) + ")"})
+	method := g.pick([]string{"each", "map", "select", "each_with_index"})
+	params := "item"
+	if method == "each_with_index" {
+		params = "item, index"
+	}
+	g.line("%s.%s do |%s|", receiver, method, params)
+	g.scope(true, func() {
+		for _, param := range strings.Split(params, ", ") {
+			g.define(param)
+		}
+		g.loop(g.body)
+	})
+	g.line("end")
+}
+
+func (g *generator) whileLoop() {
+	g.line("%s %s", g.pick([]string{"while", "until"}), g.condition())
+	g.indented(func() { g.loop(g.body) })
+	g.line("end")
+}
+
+func (g *generator) caseStatement() {
+	g.line("case %s", g.head(1))
+	for i := 0; i < 1+g.r.Intn(3); i++ {
+		g.line("when %s", g.literal())
+		g.indented(g.body)
+	}
+	if g.chance(50) {
+		g.line("else")
+		g.indented(g.body)
+	}
+	g.line("end")
+}
+
+func (g *generator) beginRescue() {
+	g.line("begin")
+	g.indented(g.body)
+	g.define("error")
+	g.line("rescue %s => error", g.pick([]string{"StandardError", "ArgumentError", "ZeroDivisionError", "KeyError"}))
+	g.indented(g.body)
+	if g.chance(40) {
+		g.line("ensure")
+		g.indented(g.body)
+	}
+	g.line("end")
+}
+
+func (g *generator) def() {
+	name := g.pick(genMethods)
+	names := append([]string(nil), genParams[:g.r.Intn(4)]...)
+	params := append([]string(nil), names...)
+	g.methods[name] = len(names)
+	if g.chance(30) {
+		names = append(names, "opt")
+		params = append(params, "opt = "+g.literal())
+	}
+	if g.chance(20) {
+		names = append(names, "rest")
+		params = append(params, "*rest")
+	}
+	if g.chance(20) {
+		names = append(names, "key")
+		params = append(params, "key: "+g.literal())
+	}
+	signature := name
+	if len(params) > 0 {
+		signature += "(" + strings.Join(params, ", ") + ")"
+	}
+	if g.inClass && g.chance(20) {
+		signature = "self." + signature
+	}
+	g.line("def %s", signature)
+	inDef, inLoop := g.inDef, g.inLoop
+	g.inDef, g.inLoop = true, false
+	g.scope(false, func() {
+		for _, p := range names {
+			g.define(p)
+		}
+		g.body()
+	})
+	g.inDef, g.inLoop = inDef, inLoop
+	g.line("end")
+}
+
+func (g *generator) class() {
+	name := g.pick(genClasses)
+	header := name
+	if len(g.classes) > 0 && g.chance(40) {
+		if parent := g.pick(g.classes); parent != name {
+			header += " < " + parent
+		}
+	}
+	g.classes = append(g.classes, name)
+	g.line("class %s", header)
+	inClass, inLoop := g.inClass, g.inLoop
+	g.inClass, g.inLoop = true, false
+	g.scope(false, func() {
+		if g.chance(50) {
+			g.line("attr_reader :%s, :%s", g.pick(genLocals), g.pick(genWords))
+		}
+		for i := 0; i < 1+g.r.Intn(3); i++ {
+			g.def()
+		}
+	})
+	g.inClass, g.inLoop = inClass, inLoop
+	g.line("end")
+}
+
+func (g *generator) breakOrNext() {
+	g.line("%s if %s", g.pick([]string{"break", "next"}), g.condition())
+}
+
+func (g *generator) returnStatement() {
+	g.line("return %s if %s", g.head(1), g.condition())
+}
+
+func (g *generator) yieldStatement() {
+	g.line("yield(%s) if block_given?", g.expr(1))
+}
+
+func (g *generator) callMethod() {
+	call := g.methodCall(1)
+	if g.chance(40) {
+		g.line("%s { |arg| %s(arg) }", call, g.pick([]string{"puts", "p"}))
+		return
+	}
+	g.line("%s", call)
+}
+
+func (g *generator) methodCall(d int) string {
+	names := make([]string, 0, len(g.methods))
+	for _, name := range genMethods {
+		if _, ok := g.methods[name]; ok {
+			names = append(names, name)
+		}
+	}
+	name := g.pick(names)
+	args := make([]string, g.methods[name])
+	for i := range args {
+		args[i] = g.expr(d + 1)
+	}
+	return name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (g *generator) condition() string {
+	if g.chance(50) {
+		return g.comparison()
+	}
+	return g.head(1)
+}
+
+// head is an expression that can follow a keyword such as if or return,
+// where a brace would be taken as the start of a block.
+func (g *generator) head(d int) string {
+	e := g.expr(d)
+	if strings.HasPrefix(e, "{") || strings.HasPrefix(e, "->") {
+		return "(" + e + ")"
+	}
+	return e
+}
+
+func (g *generator) comparison() string {
+	return "(" + g.operand() + " " + g.pick([]string{"==", "!=", "<", ">", "<=", ">="}) + " " + g.operand() + ")"
+}
+
+func (g *generator) operand() string {
+	if locals := g.locals(); len(locals) > 0 && g.chance(60) {
+		return g.pick(locals)
+	}
+	return strconv.Itoa(g.r.Intn(100))
+}
+
+// expr writes an expression nested d levels deep, which can be used
+// anywhere a value can: everything but a literal or a name is wrapped in
+// parentheses.
+func (g *generator) expr(d int) string {
+	if d >= g.maxDepth || g.chance(35) {
+		if locals := g.locals(); len(locals) > 0 && g.chance(40) {
+			return g.pick(locals)
+		}
+		return g.literal()
+	}
+	switch g.r.Intn(9) {
+	case 0, 1:
+		return "(" + g.expr(d+1) + " " + g.pick(genBinary) + " " + g.expr(d+1) + ")"
+	case 2:
+		return g.array(d)
+	case 3:
+		return g.hash(d)
+	case 4:
+		return `"` + g.pick(genWords) + ` #{` + g.expr(d+1) + `}"`
+	case 5:
+		return "(" + g.expr(d+1) + ")." + g.pick(genCallees)
+	case 6:
+		return "(" + g.condition() + " ? " + g.expr(d+1) + " : " + g.expr(d+1) + ")"
+	case 7:
+		return "->(arg) { [arg, " + g.expr(d+1) + "] }"
+	default:
+		if len(g.methods) > 0 {
+			return g.methodCall(d)
+		}
+		return "!" + g.operand()
+	}
+}
+
+func (g *generator) array(d int) string {
+	elements := make([]string, g.r.Intn(4))
+	for i := range elements {
+		elements[i] = g.expr(d + 1)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (g *generator) hash(d int) string {
+	pairs := make([]string, 1+g.r.Intn(3))
+	for i := range pairs {
+		if g.chance(70) {
+			pairs[i] = g.pick(genWords) + strconv.Itoa(i) + ": " + g.expr(d+1)
+		} else {
+			pairs[i] = strconv.Quote(g.pick(genWords)) + " => " + g.expr(d+1)
+		}
+	}
+	return "{ " + strings.Join(pairs, ", ") + " }"
+}
+
+func (g *generator) rangeLiteral() string {
+	start := g.r.Intn(5)
+	return "(" + strconv.Itoa(start) + g.pick([]string{"..", "..."}) + strconv.Itoa(start+1+g.r.Intn(5)) + ")"
+}
+
+func (g *generator) literal() string {
+	switch g.r.Intn(8) {
+	case 0, 1:
+		return strconv.Itoa(g.r.Intn(1000))
+	case 2:
+		return strconv.FormatFloat(float64(g.r.Intn(10000))/100, 'f', 2, 64)
+	case 3:
+		return strconv.Quote(g.pick(genWords))
+	case 4:
+		return "'" + g.pick(genWords) + "'"
+	case 5:
+		return ":" + g.pick(genWords)
+	case 6:
+		return g.pick([]string{"nil", "true", "false"})
+	default:
+		return "%w[" + g.pick(genWords) + " " + g.pick(genWords) + "]"
+	}
+}
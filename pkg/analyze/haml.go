@@ -0,0 +1,131 @@
+package analyze
+
+import "strings"
+
+func isHamlNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == ':'
+}
+
+// hamlOutputMarker returns the length of the output marker at the start of
+// text ("=", "!=", "&=" or "~"), or 0 if there isn't one.
+func hamlOutputMarker(text string) int {
+	for _, marker := range []string{"!=", "&=", "=", "~"} {
+		if strings.HasPrefix(text, marker) {
+			return len(marker)
+		}
+	}
+	return 0
+}
+
+// extractHaml finds the Ruby in a Haml template: "-" lines, "=" lines and
+// their variants, attribute hashes and object references on tags, the body
+// of :ruby filters, and #{} interpolation everywhere else.
+func extractHaml(template string) ([]TemplateExpr, error) {
+	sc := newTemplateScanner(template)
+	for ; sc.i < len(sc.lines); sc.i++ {
+		line := sc.lines[sc.i]
+		if line.blank() {
+			continue
+		}
+		pos := line.Offset + line.Indent
+		text := line.Text[line.Indent:]
+
+		switch {
+		case strings.HasPrefix(text, "-#"):
+			// A silent comment, along with everything nested under it.
+			for sc.nestedNext(line.Indent) {
+				sc.i++
+			}
+		case text[0] == '-':
+			sc.emit(exprCode, pos+1)
+		case strings.HasPrefix(text, "=="):
+			sc.interpolate(pos+2, sc.lineEnd())
+		case hamlOutputMarker(text) > 0:
+			sc.emit(exprOutput, pos+hamlOutputMarker(text))
+		case text[0] == ':':
+			sc.filterBlock(line.Indent, strings.TrimSpace(text[1:]) == "ruby")
+		case text[0] == '%' || text[0] == '.' || text[0] == '#' && !strings.HasPrefix(text, "#{"):
+			if err := sc.hamlTag(pos); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(text, "!!!"), text[0] == '/':
+		case text[0] == '\\', strings.HasPrefix(text, "! "), strings.HasPrefix(text, "& "):
+			sc.interpolate(pos+1, sc.lineEnd())
+		default:
+			sc.interpolate(pos, sc.lineEnd())
+		}
+	}
+	return sc.exprs, nil
+}
+
+// filterBlock handles the lines nested under a filter opened at indent. A
+// :ruby filter's body is one block of code; any other filter's is text.
+func (sc *templateScanner) filterBlock(indent int, ruby bool) {
+	first := sc.i + 1
+	for sc.nestedNext(indent) {
+		sc.i++
+		if !ruby {
+			sc.interpolate(sc.lines[sc.i].Offset, sc.lineEnd())
+		}
+	}
+	if !ruby || first > sc.i {
+		return
+	}
+	start := sc.lines[first].Offset + sc.lines[first].Indent
+	code := strings.TrimRight(sc.template[start:sc.lineEnd()], " \t\r\n")
+	if strings.TrimSpace(code) != "" {
+		sc.exprs = append(sc.exprs, TemplateExpr{Kind: exprCode, Code: code, Offset: start})
+	}
+}
+
+// hamlTag handles a line starting with a tag: its name, classes and ids, then
+// any attributes, then either output or text.
+func (sc *templateScanner) hamlTag(pos int) error {
+	t := sc.template
+	if t[pos] == '%' {
+		pos++
+	}
+	for pos < sc.lineEnd() && (isHamlNameChar(t[pos]) || t[pos] == '.' || t[pos] == '#' && (pos+1 >= len(t) || t[pos+1] != '{')) {
+		pos++
+	}
+
+	for pos < sc.lineEnd() {
+		switch t[pos] {
+		case '{', '[':
+			end, err := sc.bracket(pos)
+			if err != nil {
+				return err
+			}
+			sc.exprs = append(sc.exprs, TemplateExpr{Kind: exprAttributes, Code: t[pos : end+1], Offset: pos})
+			pos = end + 1
+			continue
+		case '(':
+			// HTML-style attributes only hold Ruby in interpolated values.
+			end, err := sc.bracket(pos)
+			if err != nil {
+				return err
+			}
+			sc.interpolate(pos+1, end)
+			pos = end + 1
+			continue
+		case '<', '>', '/':
+			pos++
+			continue
+		}
+		break
+	}
+
+	if pos >= sc.lineEnd() {
+		return nil
+	}
+	rest := t[pos:sc.lineEnd()]
+	switch {
+	case strings.HasPrefix(rest, "=="):
+		sc.interpolate(pos+2, sc.lineEnd())
+	case hamlOutputMarker(rest) > 0:
+		sc.emit(exprOutput, pos+hamlOutputMarker(rest))
+	default:
+		sc.interpolate(pos, sc.lineEnd())
+	}
+	return nil
+}
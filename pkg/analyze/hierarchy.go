@@ -0,0 +1,242 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// mixinMethods are the calls in a class body that mix a module in.
+var mixinMethods = map[string]bool{"include": true, "extend": true, "prepend": true}
+
+type Mixin struct {
+	Kind string
+	Name string
+}
+
+// ClassDecl is one class or module body as written in one file; a class
+// reopened elsewhere has several.
+type ClassDecl struct {
+	Kind       string
+	Name       string
+	Namespace  string
+	Superclass string
+	Mixins     []Mixin
+	Path       string
+	Location   *Location
+}
+
+// constArguments lists the constants passed to a call, in order.
+func constArguments(n *Node) []string {
+	var names []string
+	for _, edge := range n.children() {
+		if edge.Field != "arguments" {
+			continue
+		}
+		Walk(edge.Node, func(arg *Node, _ int) bool {
+			if name := constName(arg); name != "" {
+				names = append(names, name)
+				return false
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// FindClassDecls lists the class and module bodies in a stree tree with what
+// each one inherits from and mixes in. Other parsers use different node
+// types and yield nothing, as with FindDefinitions.
+func FindClassDecls(root *Node, path string) []ClassDecl {
+	var decls []ClassDecl
+	var visit func(n *Node, namespace string, current int)
+	visit = func(n *Node, namespace string, current int) {
+		switch n.Type {
+		case "class", "module":
+			inner := enterNamespace(n, namespace)
+			if inner == namespace {
+				break
+			}
+			decl := ClassDecl{Kind: n.Type, Name: inner, Namespace: namespace, Path: path}
+			if v, ok := n.field("superclass"); ok {
+				if ref, ok := v.(*Node); ok {
+					decl.Superclass = constName(ref)
+				}
+			}
+			if loc, ok := n.location(); ok {
+				decl.Location = &loc
+			}
+			decls = append(decls, decl)
+			for _, edge := range n.children() {
+				visit(edge.Node, inner, len(decls)-1)
+			}
+			return
+		case "def", "defs":
+			// Calls inside methods don't mix anything into the class.
+			return
+		case "command", "fcall":
+			if name := calleeName(n); current >= 0 && mixinMethods[name] {
+				for _, arg := range constArguments(n) {
+					decls[current].Mixins = append(decls[current].Mixins, Mixin{Kind: name, Name: arg})
+				}
+				return
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, namespace, current)
+		}
+	}
+	visit(root, "", -1)
+	return decls
+}
+
+// HierarchyClass is a class or module merged across every body declaring
+// it. Those only referenced, such as a superclass from a gem, are external.
+type HierarchyClass struct {
+	Name        string       `json:"name"`
+	Kind        string       `json:"kind"`
+	Superclass  string       `json:"superclass,omitempty"`
+	Includes    []string     `json:"includes,omitempty"`
+	Extends     []string     `json:"extends,omitempty"`
+	Prepends    []string     `json:"prepends,omitempty"`
+	Definitions []SourceSite `json:"definitions,omitempty"`
+}
+
+type HierarchyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type Hierarchy struct {
+	Classes []*HierarchyClass `json:"classes"`
+	Edges   []HierarchyEdge   `json:"edges"`
+}
+
+// resolveConst finds the class a constant written inside namespace refers to,
+// trying the innermost enclosing namespace first as Ruby does. Constants that
+// match nothing defined are returned as written.
+func resolveConst(name, namespace string, defined map[string]*HierarchyClass) string {
+	if strings.HasPrefix(name, "::") {
+		return name[2:]
+	}
+	for ns := namespace; ns != ""; {
+		if candidate := ns + "::" + name; defined[candidate] != nil {
+			return candidate
+		}
+		i := strings.LastIndex(ns, "::")
+		if i < 0 {
+			break
+		}
+		ns = ns[:i]
+	}
+	return name
+}
+
+func appendUnique(list []string, name string) []string {
+	for _, existing := range list {
+		if existing == name {
+			return list
+		}
+	}
+	return append(list, name)
+}
+
+// MergeHierarchy combines class bodies from any number of files into one
+// graph, resolving the constants each body refers to against every class
+// defined anywhere in them.
+func MergeHierarchy(decls []ClassDecl) *Hierarchy {
+	defined := make(map[string]*HierarchyClass)
+	for _, d := range decls {
+		c := defined[d.Name]
+		if c == nil {
+			c = &HierarchyClass{Name: d.Name, Kind: d.Kind}
+			defined[d.Name] = c
+		}
+		c.Definitions = append(c.Definitions, SourceSite{Path: d.Path, Location: d.Location})
+	}
+
+	classes := make(map[string]*HierarchyClass, len(defined))
+	for name, c := range defined {
+		classes[name] = c
+	}
+	reference := func(name string) {
+		if classes[name] == nil {
+			classes[name] = &HierarchyClass{Name: name, Kind: "external"}
+		}
+	}
+	for _, d := range decls {
+		c := defined[d.Name]
+		if d.Superclass != "" && c.Superclass == "" {
+			c.Superclass = resolveConst(d.Superclass, d.Namespace, defined)
+			reference(c.Superclass)
+		}
+		for _, m := range d.Mixins {
+			name := resolveConst(m.Name, d.Name, defined)
+			reference(name)
+			switch m.Kind {
+			case "include":
+				c.Includes = appendUnique(c.Includes, name)
+			case "extend":
+				c.Extends = appendUnique(c.Extends, name)
+			case "prepend":
+				c.Prepends = appendUnique(c.Prepends, name)
+			}
+		}
+	}
+
+	h := &Hierarchy{Classes: make([]*HierarchyClass, 0, len(classes)), Edges: []HierarchyEdge{}}
+	for _, c := range classes {
+		h.Classes = append(h.Classes, c)
+	}
+	sort.Slice(h.Classes, func(i, j int) bool { return h.Classes[i].Name < h.Classes[j].Name })
+	for _, c := range h.Classes {
+		if c.Superclass != "" {
+			h.Edges = append(h.Edges, HierarchyEdge{From: c.Name, To: c.Superclass, Kind: "inherits"})
+		}
+		for kind, names := range map[string][]string{"include": c.Includes, "extend": c.Extends, "prepend": c.Prepends} {
+			for _, name := range names {
+				h.Edges = append(h.Edges, HierarchyEdge{From: c.Name, To: name, Kind: kind})
+			}
+		}
+	}
+	sort.SliceStable(h.Edges, func(i, j int) bool {
+		a, b := h.Edges[i], h.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Kind < b.Kind
+	})
+	return h
+}
+
+// WriteHierarchyDOT renders the graph for Graphviz, with superclasses above
+// their subclasses. Modules get rounded boxes, classes defined elsewhere
+// dashed ones, and mixin edges are dashed and labelled.
+func WriteHierarchyDOT(w io.Writer, h *Hierarchy) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph hierarchy {")
+	fmt.Fprintln(bw, `  rankdir=BT;`)
+	fmt.Fprintln(bw, `  node [shape=box, fontname="monospace"];`)
+	for _, c := range h.Classes {
+		attrs := ""
+		switch c.Kind {
+		case "module":
+			attrs = `, style=rounded`
+		case "external":
+			attrs = `, style=dashed`
+		}
+		fmt.Fprintf(bw, "  \"%s\" [label=\"%s\"%s];\n", dotEscaper.Replace(c.Name), dotEscaper.Replace(c.Name), attrs)
+	}
+	for _, e := range h.Edges {
+		attrs := `arrowhead=empty`
+		if e.Kind != "inherits" {
+			attrs = fmt.Sprintf(`style=dashed, label="%s"`, e.Kind)
+		}
+		fmt.Fprintf(bw, "  \"%s\" -> \"%s\" [%s];\n", dotEscaper.Replace(e.From), dotEscaper.Replace(e.To), attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
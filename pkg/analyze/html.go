@@ -0,0 +1,108 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// htmlStyle and htmlScript are inlined into every page, so a saved file
+// needs nothing else to display.
+const htmlStyle = `body { font: 13px/1.5 "Fira Code", "Fira Mono", monospace; margin: 0; color: #222; }
+header { padding: 8px 16px; border-bottom: 1px solid #ddd; background: #fafafa; }
+header h1 { display: inline; font-size: 15px; margin-right: 16px; }
+main { display: flex; align-items: flex-start; }
+#source { flex: 0 0 40%; margin: 0; padding: 16px; background: #f5f5f5; overflow: auto; position: sticky; top: 0; max-height: 100vh; box-sizing: border-box; }
+#source span { display: block; min-height: 1.5em; }
+#source span.hl { background: #ffffe0; }
+.tree { flex: 1; padding: 16px; }
+.tree ul { list-style: none; margin: 0; padding-left: 18px; border-left: 1px dotted #ccc; }
+.tree > ul { padding-left: 0; border: none; }
+summary { cursor: pointer; }
+li.leaf { padding-left: 14px; }
+.field { color: #888; }
+.type { color: #0550ae; font-weight: bold; }
+.loc { color: #aaa; font-size: 11px; }
+.more { color: #aaa; font-style: italic; padding-left: 14px; }
+`
+
+const htmlScript = `document.querySelectorAll("button[data-open]").forEach(function (b) {
+  b.addEventListener("click", function () {
+    var open = b.dataset.open === "1";
+    document.querySelectorAll(".tree details").forEach(function (d) { d.open = open; });
+  });
+});
+var lines = document.querySelectorAll("#source span");
+document.querySelectorAll(".tree [data-lines]").forEach(function (el) {
+  el.addEventListener("mouseover", function (e) {
+    e.stopPropagation();
+    var range = el.dataset.lines.split("-");
+    lines.forEach(function (line, i) {
+      line.classList.toggle("hl", i + 1 >= +range[0] && i + 1 <= +range[1]);
+    });
+  });
+});
+`
+
+// htmlOpenDepth is how many levels of the tree start expanded.
+const htmlOpenDepth = 3
+
+// WriteHTML renders the tree as a standalone HTML page: nested collapsible
+// <details> elements, with the source alongside if it is not empty, so
+// hovering over a node highlights the lines it spans. Its styles and script
+// are inline, so the page works saved to disk or sent as an attachment.
+func WriteHTML(w io.Writer, root *Node, source string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Ruby AST</title>\n<style>\n%s</style>\n</head>\n<body>\n", htmlStyle)
+	bw.WriteString("<header><h1>Ruby AST</h1><button data-open=\"1\">Expand all</button> <button data-open=\"0\">Collapse all</button></header>\n<main>\n")
+
+	if source != "" {
+		bw.WriteString("<pre id=\"source\">")
+		for _, line := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+			bw.WriteString("<span>" + html.EscapeString(line) + "</span>")
+		}
+		bw.WriteString("</pre>\n")
+	}
+
+	var visit func(field string, n *Node, depth int)
+	visit = func(field string, n *Node, depth int) {
+		label := ""
+		if field != "" {
+			label = "<span class=\"field\">" + html.EscapeString(field) + ":</span> "
+		}
+		label += "<span class=\"type\">" + html.EscapeString(n.label()) + "</span>"
+		lines := ""
+		if loc, ok := n.location(); ok {
+			label += " <span class=\"loc\">" + loc.String() + "</span>"
+			lines = fmt.Sprintf(" data-lines=\"%d-%d\"", loc.StartLine, loc.EndLine)
+		}
+
+		edges := n.children()
+		hidden := n.hiddenChildren()
+		if len(edges) == 0 && hidden == 0 {
+			fmt.Fprintf(bw, "<li class=\"leaf\"%s>%s</li>\n", lines, label)
+			return
+		}
+		open := ""
+		if depth < htmlOpenDepth {
+			open = " open"
+		}
+		fmt.Fprintf(bw, "<li%s><details%s><summary>%s</summary><ul>\n", lines, open, label)
+		if hidden > 0 {
+			fmt.Fprintf(bw, "<li class=\"more\">%d more</li>\n", hidden)
+		} else {
+			for _, edge := range edges {
+				visit(edge.Field, edge.Node, depth+1)
+			}
+		}
+		bw.WriteString("</ul></details></li>\n")
+	}
+	bw.WriteString("<div class=\"tree\"><ul>\n")
+	visit("", root, 0)
+	bw.WriteString("</ul></div>\n</main>\n")
+
+	fmt.Fprintf(bw, "<script>\n%s</script>\n</body>\n</html>\n", htmlScript)
+	return bw.Flush()
+}
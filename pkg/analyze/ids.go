@@ -0,0 +1,70 @@
+package analyze
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// NodeIDs gives every node in root an ID that survives small edits to the
+// code, unlike its path. A node's ID hashes its parent's with the node's
+// field and type and, for one in a list such as a body of statements, its
+// name and how many before it in the list share its type and name. An
+// edit only changes the IDs of the nodes it adds, removes or renames, later
+// ones in the same list of the same type and name, and everything below
+// those.
+//
+// The name is the node's "name" or "value" if it has one, as a def or a
+// token does, and otherwise the first one found below it, as the method of
+// a command.
+func NodeIDs(root *Node) map[*Node]string {
+	names := make(map[*Node]string)
+	var name func(n *Node) string
+	name = func(n *Node) string {
+		var found string
+		if v, ok := n.field("name"); ok {
+			found, _ = v.(string)
+		}
+		if found == "" {
+			found, _ = n.value()
+		}
+		for _, edge := range n.children() {
+			if inner := name(edge.Node); found == "" {
+				found = inner
+			}
+		}
+		names[n] = found
+		return found
+	}
+	name(root)
+
+	id := func(parent, key string, occurrence int) string {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s\x00%s\x00%d", parent, key, occurrence)
+		return fmt.Sprintf("%016x", h.Sum64())
+	}
+	ids := map[*Node]string{root: id("", root.Type, 0)}
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		seen := make(map[string]int)
+		for _, edge := range n.children() {
+			key := edge.Field + "\x00" + edge.Node.Type
+			if strings.Contains(edge.Path, "[") {
+				key += "\x00" + names[edge.Node]
+			}
+			ids[edge.Node] = id(ids[n], key, seen[key])
+			seen[key]++
+			visit(edge.Node)
+		}
+	}
+	visit(root)
+	return ids
+}
+
+// attachIDs adds each node's "id" from NodeIDs, for JSON output requested
+// with the ids option.
+func attachIDs(root *Node) {
+	for n, id := range NodeIDs(root) {
+		n.Fields = append(n.Fields, ASTField{Name: "id", Value: id})
+	}
+}
@@ -0,0 +1,116 @@
+package analyze
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// NodeIndex relates the nodes of a tree, identified by their paths, to the
+// bytes of the UTF-8 source they span, so a client can match the code and
+// the tree up without walking either.
+type NodeIndex struct {
+	// Ranges holds each located node's start and end byte.
+	Ranges map[string][2]int `json:"ranges"`
+
+	// Segments divide the source into runs, in order, each belonging to the
+	// innermost node that spans it, up to the next run's start. A binary
+	// search on Start finds the node at any byte.
+	Segments []IndexSegment `json:"segments"`
+}
+
+// IndexSegment is a run of source from Start; Path is nil where no node
+// spans it.
+type IndexSegment struct {
+	Start int     `json:"start"`
+	Path  *string `json:"path"`
+}
+
+// indexedTree is JSON output with the index option: the tree as it would
+// otherwise be returned, filtered or not, alongside its index.
+type indexedTree struct {
+	Tree  json.RawMessage `json:"tree"`
+	Index *NodeIndex      `json:"index"`
+}
+
+// BuildIndex indexes the located nodes of root, parsed from code. Nodes
+// without a location are passed over, their children taken as their
+// parent's; ones that stick out of their parent are clipped to it.
+func BuildIndex(root *Node, code string) *NodeIndex {
+	offsets := make([]int, 0, len(code)+1)
+	for i := range code {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(code))
+	toBytes := func(loc Location) (int, int, bool) {
+		if loc.StartChar < 0 || loc.EndChar < loc.StartChar || loc.EndChar >= len(offsets) {
+			return 0, 0, false
+		}
+		return offsets[loc.StartChar], offsets[loc.EndChar], true
+	}
+
+	index := &NodeIndex{Ranges: make(map[string][2]int), Segments: []IndexSegment{}}
+	type span struct {
+		path       string
+		start, end int
+		children   []*span
+	}
+	spans := make(map[*TreeNode]*span)
+	var top []*span
+	for _, t := range flattenTree(root) {
+		loc, ok := t.Node.location()
+		if !ok {
+			continue
+		}
+		start, end, ok := toBytes(loc)
+		if !ok {
+			continue
+		}
+		index.Ranges[t.Path] = [2]int{start, end}
+		s := &span{path: t.Path, start: start, end: end}
+		spans[t] = s
+		parent := t.Parent
+		for parent != nil && spans[parent] == nil {
+			parent = parent.Parent
+		}
+		if parent == nil {
+			top = append(top, s)
+		} else {
+			spans[parent].children = append(spans[parent].children, s)
+		}
+	}
+
+	add := func(start int, path *string) {
+		index.Segments = append(index.Segments, IndexSegment{start, path})
+	}
+	var partition func(spans []*span, start, end int, path *string)
+	partition = func(spans []*span, start, end int, path *string) {
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		at := start
+		for _, s := range spans {
+			from, to := max(s.start, at), min(s.end, end)
+			if from >= to {
+				continue
+			}
+			if from > at {
+				add(at, path)
+			}
+			partition(s.children, from, to, &s.path)
+			at = to
+		}
+		if at < end {
+			add(at, path)
+		}
+	}
+	partition(top, 0, len(code), nil)
+	return index
+}
+
+// attachPaths adds a "path" field to every node in root, so each can be
+// found in the index. Nodes cut short by PruneTree already have one.
+func attachPaths(root *Node) {
+	for _, t := range flattenTree(root) {
+		if _, ok := t.Node.field("path"); !ok {
+			t.Node.Fields = append(t.Node.Fields, ASTField{Name: "path", Value: t.Path})
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type jsonPathSelector int
+
+const (
+	selectField jsonPathSelector = iota
+	selectIndex
+	selectSlice
+	selectWildcard
+	selectFilter
+)
+
+// JSONPathStep is one selector in a path. A recursive step (..) applies its
+// selector to the value and to everything below it.
+type JSONPathStep struct {
+	selector  jsonPathSelector
+	recursive bool
+	name      string
+	index     int
+	start     *int
+	end       *int
+	filter    *jsonPathFilter
+}
+
+// jsonPathFilter is a [?(...)] test on each candidate: that the relative
+// path exists, or compares to a literal.
+type jsonPathFilter struct {
+	path  []JSONPathStep
+	op    string
+	value interface{}
+}
+
+type JSONPath []JSONPathStep
+
+// CompileJSONPath parses a JSONPath expression: $ for the root, then .name
+// or ['name'] for fields, [n] and [start:end] for array elements, * for
+// everything, .. for recursive descent and [?(@.a == 'x')] filters comparing
+// with ==, !=, <, <=, > or >=, or [?(@.a)] for having a field. As with jq,
+// the leading $ can be left off.
+func CompileJSONPath(expr string) (JSONPath, error) {
+	p := &jsonPathParser{src: strings.TrimSpace(expr)}
+	if strings.HasPrefix(p.src, "$") {
+		p.pos++
+	}
+	steps, err := p.steps(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return steps, nil
+}
+
+type jsonPathParser struct {
+	src string
+	pos int
+}
+
+func (p *jsonPathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+func isJSONPathNameChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// steps reads selectors until the end of the expression, or inside a filter
+// until something that isn't one.
+func (p *jsonPathParser) steps(inFilter bool) ([]JSONPathStep, error) {
+	var steps []JSONPathStep
+	for p.pos < len(p.src) {
+		recursive := false
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], ".."):
+			p.pos += 2
+			recursive = true
+		case p.src[p.pos] == '.':
+			p.pos++
+		case p.src[p.pos] == '[':
+		default:
+			if inFilter {
+				return steps, nil
+			}
+			return nil, p.errorf("unexpected %q", p.src[p.pos])
+		}
+
+		var step JSONPathStep
+		var err error
+		switch {
+		case p.pos < len(p.src) && p.src[p.pos] == '[':
+			step, err = p.bracket()
+		case p.pos < len(p.src) && p.src[p.pos] == '*':
+			p.pos++
+			step = JSONPathStep{selector: selectWildcard}
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isJSONPathNameChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a field name")
+			}
+			step = JSONPathStep{selector: selectField, name: p.src[start:p.pos]}
+		}
+		if err != nil {
+			return nil, err
+		}
+		step.recursive = recursive
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (p *jsonPathParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// bracket reads a [...] selector.
+func (p *jsonPathParser) bracket() (JSONPathStep, error) {
+	p.pos++
+	p.skipSpaces()
+	var step JSONPathStep
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "*"):
+		p.pos++
+		step = JSONPathStep{selector: selectWildcard}
+	case strings.HasPrefix(p.src[p.pos:], "'") || strings.HasPrefix(p.src[p.pos:], `"`):
+		name, err := p.quoted()
+		if err != nil {
+			return step, err
+		}
+		step = JSONPathStep{selector: selectField, name: name}
+	case strings.HasPrefix(p.src[p.pos:], "?("):
+		p.pos += 2
+		filter, err := p.filter()
+		if err != nil {
+			return step, err
+		}
+		step = JSONPathStep{selector: selectFilter, filter: filter}
+	default:
+		start, ok := p.integer()
+		p.skipSpaces()
+		if p.pos < len(p.src) && p.src[p.pos] == ':' {
+			p.pos++
+			p.skipSpaces()
+			end, hasEnd := p.integer()
+			step = JSONPathStep{selector: selectSlice}
+			if ok {
+				step.start = &start
+			}
+			if hasEnd {
+				step.end = &end
+			}
+		} else if ok {
+			step = JSONPathStep{selector: selectIndex, index: start}
+		} else {
+			return step, p.errorf("expected an index, field or filter")
+		}
+	}
+	p.skipSpaces()
+	if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+		return step, p.errorf("expected ']'")
+	}
+	p.pos++
+	return step, nil
+}
+
+func (p *jsonPathParser) integer() (int, bool) {
+	start := p.pos
+	if p.pos < len(p.src) && p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *jsonPathParser) quoted() (string, error) {
+	quote := p.src[p.pos]
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.src) && p.src[p.pos] != quote; p.pos++ {
+		if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+			p.pos++
+		}
+		b.WriteByte(p.src[p.pos])
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	return b.String(), nil
+}
+
+// filter reads the inside of [?(...)] up to and including the ")".
+func (p *jsonPathParser) filter() (*jsonPathFilter, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.src) || p.src[p.pos] != '@' {
+		return nil, p.errorf("expected '@'")
+	}
+	p.pos++
+	path, err := p.steps(true)
+	if err != nil {
+		return nil, err
+	}
+	f := &jsonPathFilter{path: path}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			f.op = op
+			break
+		}
+	}
+	if f.op != "" {
+		p.skipSpaces()
+		if f.value, err = p.literal(); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.pos++
+	return f, nil
+}
+
+// literal reads a string, number, true, false or null to compare with.
+func (p *jsonPathParser) literal() (interface{}, error) {
+	if p.pos < len(p.src) && (p.src[p.pos] == '\'' || p.src[p.pos] == '"') {
+		return p.quoted()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && (isJSONPathNameChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.pos++
+	}
+	switch word := p.src[start:p.pos]; word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		if _, err := strconv.ParseFloat(word, 64); err != nil {
+			p.pos = start
+			return nil, p.errorf("expected a string, number, true, false or null")
+		}
+		return json.Number(word), nil
+	}
+}
+
+// descendants lists v and every node and array below it, depth first.
+func descendants(v interface{}, out []interface{}) []interface{} {
+	out = append(out, v)
+	switch v := v.(type) {
+	case *Node:
+		for _, f := range v.Fields {
+			switch f.Value.(type) {
+			case *Node, []interface{}:
+				out = descendants(f.Value, out)
+			}
+		}
+	case []interface{}:
+		for _, element := range v {
+			switch element.(type) {
+			case *Node, []interface{}:
+				out = descendants(element, out)
+			}
+		}
+	}
+	return out
+}
+
+// apply selects from one value, appending what it matches.
+func (step JSONPathStep) apply(v interface{}, out []interface{}) []interface{} {
+	switch step.selector {
+	case selectField:
+		if n, ok := v.(*Node); ok {
+			if value, ok := n.field(step.name); ok {
+				out = append(out, value)
+			}
+		}
+	case selectIndex:
+		if list, ok := v.([]interface{}); ok {
+			i := step.index
+			if i < 0 {
+				i += len(list)
+			}
+			if i >= 0 && i < len(list) {
+				out = append(out, list[i])
+			}
+		}
+	case selectSlice:
+		if list, ok := v.([]interface{}); ok {
+			bound := func(b *int, def int) int {
+				if b == nil {
+					return def
+				}
+				i := *b
+				if i < 0 {
+					i += len(list)
+				}
+				if i < 0 {
+					return 0
+				}
+				if i > len(list) {
+					return len(list)
+				}
+				return i
+			}
+			if start, end := bound(step.start, 0), bound(step.end, len(list)); start < end {
+				out = append(out, list[start:end]...)
+			}
+		}
+	case selectWildcard:
+		switch v := v.(type) {
+		case *Node:
+			for _, f := range v.Fields {
+				out = append(out, f.Value)
+			}
+		case []interface{}:
+			out = append(out, v...)
+		}
+	case selectFilter:
+		// A filter tests the elements of an array, or the fields of an
+		// object, rather than the value itself.
+		var candidates []interface{}
+		switch v := v.(type) {
+		case *Node:
+			for _, f := range v.Fields {
+				candidates = append(candidates, f.Value)
+			}
+		case []interface{}:
+			candidates = v
+		}
+		for _, c := range candidates {
+			if step.filter.test(c) {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+func (f *jsonPathFilter) test(v interface{}) bool {
+	values := JSONPath(f.path).eval(v)
+	if f.op == "" {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if compareJSON(value, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareJSON compares numbers numerically and anything else by equality.
+func compareJSON(a interface{}, op string, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		x, err1 := an.Float64()
+		y, err2 := bn.Float64()
+		if err1 == nil && err2 == nil {
+			switch op {
+			case "==":
+				return x == y
+			case "!=":
+				return x != y
+			case "<":
+				return x < y
+			case "<=":
+				return x <= y
+			case ">":
+				return x > y
+			case ">=":
+				return x >= y
+			}
+		}
+	}
+	switch a.(type) {
+	case *Node, []interface{}:
+		return op == "!="
+	}
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// eval returns every value the path selects from root, in document order.
+func (path JSONPath) eval(root interface{}) []interface{} {
+	current := []interface{}{root}
+	for _, step := range path {
+		var next []interface{}
+		for _, v := range current {
+			if step.recursive {
+				for _, d := range descendants(v, nil) {
+					next = step.apply(d, next)
+				}
+			} else {
+				next = step.apply(v, next)
+			}
+		}
+		current = next
+	}
+	return current
+}
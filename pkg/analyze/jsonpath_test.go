@@ -0,0 +1,81 @@
+package analyze
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompileJSONPathErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"$.", "expected a field name at offset 2"},
+		{"$[1", "expected ']' at offset 3"},
+		{"$[]", "expected an index, field or filter at offset 2"},
+		{"$['a", "unterminated string at offset 4"},
+		{"$[?(a)]", "expected '@' at offset 4"},
+		{"$[?(@.a ==)]", "expected a string, number, true, false or null at offset 10"},
+		{"$[?(@.a == 1]", "expected ')' at offset 12"},
+		{"$.a)", `unexpected ')' at offset 3`},
+		{"statements", `unexpected 's' at offset 0`},
+	}
+	for _, tt := range tests {
+		_, err := CompileJSONPath(tt.expr)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("CompileJSONPath(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestJSONPathEval(t *testing.T) {
+	root := testTree(t)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"$.statements.body[1].message.value", `["puts"]`},
+		// As with jq, the $ can be left off.
+		{".statements.body[0].type", `["call"]`},
+		{"['statements']['body'][1][\"type\"]", `["command"]`},
+		{"$.statements.body[-1].type", `["command"]`},
+		{"$.statements.body[5]", `[]`},
+		{"$.statements.body[0:1].type", `["call"]`},
+		{"$.statements.body[-1:].type", `["command"]`},
+		{"$.statements.body[:].type", `["call","command"]`},
+		{"$.statements.body[1:1]", `[]`},
+		{"$.statements.body[*].message.value", `["bar","puts"]`},
+		{"$.statements.body[0].operator.*", `["period",[1,3,1,4],"."]`},
+		{"$.nope", `[]`},
+		{"$.comments", `[[]]`},
+		{"$..message.value", `["bar","puts"]`},
+		{"$..parts[0].value", `["1","hi"]`},
+		{"$..parts[?(@.value)].type", `["int","symbol_literal","tstring_content"]`},
+		{"$.statements.body[?(@.type == 'command')].message.value", `["puts"]`},
+		{"$.statements.body[?(@.type != 'command')].type", `["call"]`},
+		{"$.statements.body[?(@.location[2] >= 2)].type", `["command"]`},
+		{"$.statements.body[?(@.location[3] > 20.5)].type", `["command"]`},
+		{"$.statements.body[?(@.location[3] == 14)].type", `["call"]`},
+		{"$.statements.body[?(@.block == null)].type", `["command"]`},
+		{"$.statements.body[?(@.message.value == \"bar\")].type", `["call"]`},
+		{"$.statements.body[?( @.receiver )].type", `["call"]`},
+	}
+	for _, tt := range tests {
+		path, err := CompileJSONPath(tt.expr)
+		if err != nil {
+			t.Errorf("CompileJSONPath(%q): %v", tt.expr, err)
+			continue
+		}
+		values := path.eval(root)
+		if values == nil {
+			values = []interface{}{}
+		}
+		got, err := json.Marshal(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
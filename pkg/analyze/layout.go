@@ -0,0 +1,58 @@
+package analyze
+
+// Layouts of normalized JSON output: the nested tree, or a flat array of
+// nodes for clients that can't recurse that deep or render rows lazily.
+const (
+	LayoutNested = "nested"
+	LayoutFlat   = "flat"
+)
+
+func KnownLayout(name string) bool {
+	return name == "" || name == LayoutNested || name == LayoutFlat
+}
+
+// FlatNode is a NormalNode in the flat layout, where the nodes are listed
+// in depth-first order from the root and refer to each other by index.
+// Parent is null for the root.
+type FlatNode struct {
+	Type       string                 `json:"type"`
+	Field      string                 `json:"field,omitempty"`
+	Location   *Location              `json:"location,omitempty"`
+	Value      *string                `json:"value,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Depth      int                    `json:"depth"`
+	Parent     *int                   `json:"parent"`
+	Children   []int                  `json:"children"`
+}
+
+// FlatNodes lists root's nodes in the flat layout. It keeps its own stack
+// rather than recursing, so the depth of the tree doesn't matter.
+func FlatNodes(root *NormalNode) []*FlatNode {
+	type pending struct {
+		node   *NormalNode
+		parent int
+		depth  int
+	}
+	var flat []*FlatNode
+	stack := []pending{{root, -1, 0}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		n := p.node
+		index := len(flat)
+		flat = append(flat, &FlatNode{
+			Type: n.Type, Field: n.Field, Location: n.Location, Value: n.Value, Attributes: n.Attributes,
+			Depth: p.depth, Children: make([]int, 0, len(n.Children)),
+		})
+		if p.parent >= 0 {
+			parent := p.parent
+			flat[index].Parent = &parent
+			flat[parent].Children = append(flat[parent].Children, index)
+		}
+		for i := len(n.Children) - 1; i >= 0; i-- {
+			stack = append(stack, pending{n.Children[i], index, p.depth + 1})
+		}
+	}
+	return flat
+}
@@ -0,0 +1,65 @@
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br/>")
+
+// isLiteral reports whether n is a token node: a leaf carrying a string
+// value, such as ident, int or tstring_content.
+func (n *Node) isLiteral() bool {
+	_, ok := n.value()
+	return ok && len(n.children()) == 0
+}
+
+// writeMermaid renders the tree as a Mermaid flowchart. Children cut by
+// pruning are drawn as a single dashed placeholder; with CollapseLiterals,
+// token children are folded into their parent's label instead of getting
+// nodes of their own.
+func writeMermaid(w io.Writer, root *Node, opts FormatOptions) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph TD")
+
+	next := 0
+	var visit func(n *Node) int
+	visit = func(n *Node) int {
+		id := next
+		next++
+
+		lines := []string{n.label()}
+		if loc, ok := n.location(); ok {
+			lines = append(lines, loc.String())
+		}
+
+		var edges []astEdge
+		for _, edge := range n.children() {
+			if opts.CollapseLiterals && edge.Node.isLiteral() {
+				lines = append(lines, edge.Field+": "+edge.Node.label())
+				continue
+			}
+			edges = append(edges, edge)
+		}
+		fmt.Fprintf(bw, "  n%d[\"%s\"]\n", id, mermaidEscaper.Replace(strings.Join(lines, "\n")))
+
+		if hidden := n.hiddenChildren(); hidden > 0 {
+			placeholder := next
+			next++
+			fmt.Fprintf(bw, "  n%d((\"%d more\"))\n", placeholder, hidden)
+			fmt.Fprintf(bw, "  n%d -.-> n%d\n", id, placeholder)
+			return id
+		}
+
+		for _, edge := range edges {
+			child := visit(edge.Node)
+			fmt.Fprintf(bw, "  n%d -->|%s| n%d\n", id, mermaidEscaper.Replace(edge.Field), child)
+		}
+		return id
+	}
+	visit(root)
+
+	return bw.Flush()
+}
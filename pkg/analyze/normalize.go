@@ -0,0 +1,170 @@
+package analyze
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// NormalNode is the shape /parse returns trees in, whichever parser made
+// them. Type is the parser's own node type. Children are the typed nodes
+// below, in source order, each with the field of its parent it came from.
+// Value is the literal a token node stands for, such as an identifier's
+// name or an integer's digits, and Attributes keep any other plain fields,
+// such as Prism's method names and flags. Null fields and empty lists are
+// dropped, as they would have held children.
+type NormalNode struct {
+	Type       string                 `json:"type"`
+	Field      string                 `json:"field,omitempty"`
+	Location   *Location              `json:"location,omitempty"`
+	Value      *string                `json:"value,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Children   []*NormalNode          `json:"children"`
+}
+
+// NormalJSON is JSON output as RenderFormat returns it, for a response to
+// embed as is. Its schema is NormalNode's, the shape it has without raw.
+type NormalJSON []byte
+
+func (j NormalJSON) MarshalJSON() ([]byte, error) {
+	if j == nil {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// literalFields hold a token's value: stree and Prism's "value", and the
+// contents of a Prism string.
+var literalFields = map[string]bool{"value": true, "unescaped": true}
+
+// hasNodes reports whether a field value has a typed node anywhere in it.
+func hasNodes(v interface{}) bool {
+	switch v := v.(type) {
+	case *Node:
+		if v.Type != "" {
+			return true
+		}
+		for _, f := range v.Fields {
+			if hasNodes(f.Value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, element := range v {
+			if hasNodes(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NormalizeNode converts an stree or Prism node.
+func NormalizeNode(n *Node, field string) *NormalNode {
+	out := &NormalNode{Type: n.Type, Field: field, Children: []*NormalNode{}}
+	if loc, ok := n.location(); ok {
+		out.Location = &loc
+	}
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" || f.Value == nil || hasNodes(f.Value) {
+			continue
+		}
+		if list, ok := f.Value.([]interface{}); ok && len(list) == 0 {
+			// An empty list of children, rather than a plain value.
+			continue
+		}
+		if literalFields[f.Name] && out.Value == nil {
+			switch v := f.Value.(type) {
+			case string:
+				out.Value = &v
+				continue
+			case json.Number:
+				s := v.String()
+				out.Value = &s
+				continue
+			}
+		}
+		if out.Attributes == nil {
+			out.Attributes = make(map[string]interface{})
+		}
+		out.Attributes[f.Name] = f.Value
+	}
+	for _, edge := range n.children() {
+		out.Children = append(out.Children, NormalizeNode(edge.Node, edge.Field))
+	}
+	return out
+}
+
+// sexpType returns the event name heading one of ripper's s-expressions,
+// such as "command" or "@ident".
+func sexpType(v interface{}) (string, []interface{}, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return "", nil, false
+	}
+	name, ok := list[0].(string)
+	return name, list, ok
+}
+
+// normalizeSexp converts ripper's s-expression for a node. Scanner events
+// (@ident and the like) become token nodes with their value and position;
+// ripper gives positions as a line and column rather than offsets into the
+// source, so they go in the attributes, and nodes have no location.
+func normalizeSexp(name string, list []interface{}) *NormalNode {
+	out := &NormalNode{Type: strings.TrimPrefix(name, "@"), Children: []*NormalNode{}}
+	if strings.HasPrefix(name, "@") && len(list) == 3 {
+		if value, ok := list[1].(string); ok {
+			out.Value = &value
+		}
+		if pos, ok := list[2].([]interface{}); ok && len(pos) == 2 {
+			out.Attributes = map[string]interface{}{"line": pos[0], "column": pos[1]}
+		}
+		return out
+	}
+	var values []interface{}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		if name, list, ok := sexpType(v); ok {
+			out.Children = append(out.Children, normalizeSexp(name, list))
+			return
+		}
+		switch v := v.(type) {
+		case []interface{}:
+			for _, element := range v {
+				collect(element)
+			}
+		case string:
+			// Such as a binary expression's operator.
+			values = append(values, v)
+		}
+	}
+	for _, element := range list[1:] {
+		collect(element)
+	}
+	if values != nil {
+		out.Attributes = map[string]interface{}{"values": values}
+	}
+	return out
+}
+
+// normalizeTree converts parser output, decoded by decodeJSON, to the
+// normalized shape.
+func normalizeTree(v interface{}) (*NormalNode, error) {
+	if n, ok := v.(*Node); ok {
+		return NormalizeNode(n, ""), nil
+	}
+	if name, list, ok := sexpType(v); ok {
+		return normalizeSexp(name, list), nil
+	}
+	return nil, errors.New("output is neither a tree nor an s-expression")
+}
+
+// Normalize decodes the JSON output of any of the parsers into the
+// normalized tree /parse returns.
+func Normalize(output []byte) (*NormalNode, error) {
+	value, err := decodeJSON(output)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeTree(value)
+}
@@ -0,0 +1,218 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// CharOffset converts a 1-based line and column, counted in characters, to
+// the 0-based character offset that node locations use. It returns false if
+// the position is outside the source.
+func CharOffset(code string, line, column int) (int, bool) {
+	offset, l, c := 0, 1, 1
+	for _, r := range code {
+		if l == line && c == column {
+			return offset, true
+		}
+		if r == '\n' {
+			if l == line {
+				return 0, false
+			}
+			l, c = l+1, 1
+		} else {
+			c++
+		}
+		offset++
+	}
+	return offset, l == line && c == column
+}
+
+// NodeSummary identifies a node without its subtree.
+type NodeSummary struct {
+	Type     string    `json:"type"`
+	Path     string    `json:"path"`
+	Location *Location `json:"location,omitempty"`
+}
+
+func Summarize(t *TreeNode) NodeSummary {
+	summary := NodeSummary{Type: t.Node.Type, Path: t.Path}
+	if loc, ok := t.Node.location(); ok {
+		summary.Location = &loc
+	}
+	return summary
+}
+
+// DeepestNodeAt returns the most deeply nested node whose range contains
+// offset, or nil if none does.
+func DeepestNodeAt(root *Node, offset int) *TreeNode {
+	var found *TreeNode
+	for _, t := range flattenTree(root) {
+		loc, ok := t.Node.location()
+		if !ok || offset < loc.StartChar || offset >= loc.EndChar {
+			continue
+		}
+		if found == nil || t.Depth > found.Depth {
+			found = t
+		}
+	}
+	return found
+}
+
+// OutermostNodeOn returns the outermost node that starts on line, other than
+// statement lists, for tools that report a line only. It returns nil if no
+// node starts there.
+func OutermostNodeOn(root *Node, line int) *TreeNode {
+	var found *TreeNode
+	for _, t := range flattenTree(root) {
+		if t.Node.Type == "program" || t.Node.Type == "statements" {
+			continue
+		}
+		loc, ok := t.Node.location()
+		if !ok || loc.StartLine != line {
+			continue
+		}
+		if found == nil || t.Depth < found.Depth {
+			found = t
+		}
+	}
+	return found
+}
+
+// SourceLine is one line of the source, numbered from 1.
+type SourceLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// SourceSlice is the text a node covers. Node locations count characters,
+// so the byte offsets into the UTF-8 source and the 0-based character
+// columns within the first and last lines are given too, along with the
+// lines around it.
+type SourceSlice struct {
+	Location    Location     `json:"location"`
+	Text        string       `json:"text"`
+	StartByte   int          `json:"start_byte"`
+	EndByte     int          `json:"end_byte"`
+	StartColumn int          `json:"start_column"`
+	EndColumn   int          `json:"end_column"`
+	Before      []SourceLine `json:"before"`
+	After       []SourceLine `json:"after"`
+}
+
+// SliceSource cuts n out of code, the source it was parsed from, with up to
+// context lines on either side. It returns false if n has no location or
+// the location doesn't fit in code.
+func SliceSource(n *Node, code string, context int) (SourceSlice, bool) {
+	loc, ok := n.location()
+	if !ok || loc.StartChar < 0 || loc.EndChar < loc.StartChar {
+		return SourceSlice{}, false
+	}
+	start, end := -1, -1
+	offset := 0
+	for i := range code {
+		if offset == loc.StartChar {
+			start = i
+		}
+		if offset == loc.EndChar {
+			end = i
+			break
+		}
+		offset++
+	}
+	if offset == loc.StartChar && start < 0 {
+		start = len(code)
+	}
+	if offset == loc.EndChar && end < 0 {
+		end = len(code)
+	}
+	if start < 0 || end < 0 {
+		return SourceSlice{}, false
+	}
+
+	lines := strings.Split(code, "\n")
+	startLine := strings.Count(code[:start], "\n")
+	endLine := strings.Count(code[:end], "\n")
+	slice := SourceSlice{
+		Location:    loc,
+		Text:        code[start:end],
+		StartByte:   start,
+		EndByte:     end,
+		StartColumn: utf8.RuneCountInString(code[strings.LastIndexByte(code[:start], '\n')+1 : start]),
+		EndColumn:   utf8.RuneCountInString(code[strings.LastIndexByte(code[:end], '\n')+1 : end]),
+		Before:      []SourceLine{},
+		After:       []SourceLine{},
+	}
+	for i := startLine - context; i < startLine; i++ {
+		if i >= 0 {
+			slice.Before = append(slice.Before, SourceLine{i + 1, strings.TrimSuffix(lines[i], "\r")})
+		}
+	}
+	for i := endLine + 1; i <= endLine+context && i < len(lines); i++ {
+		slice.After = append(slice.After, SourceLine{i + 1, strings.TrimSuffix(lines[i], "\r")})
+	}
+	return slice, true
+}
+
+// LocationColumns gives a location's columns on its first and last lines,
+// from 0, in the units editors count in: characters, as node locations do,
+// UTF-16 code units, as browsers and the Language Server Protocol do, and
+// UTF-8 bytes.
+type LocationColumns struct {
+	Char  [2]int `json:"char"`
+	UTF16 [2]int `json:"utf16"`
+	Byte  [2]int `json:"byte"`
+}
+
+// columnTable holds the columns of every character offset in some code.
+type columnTable struct {
+	char, utf16, byte []int
+}
+
+func newColumnTable(code string) *columnTable {
+	n := utf8.RuneCountInString(code) + 1
+	t := &columnTable{char: make([]int, 0, n), utf16: make([]int, 0, n), byte: make([]int, 0, n)}
+	var char, units, bytes int
+	for _, r := range code {
+		t.char, t.utf16, t.byte = append(t.char, char), append(t.utf16, units), append(t.byte, bytes)
+		if r == '\n' {
+			char, units, bytes = 0, 0, 0
+			continue
+		}
+		char++
+		units += utf16.RuneLen(r)
+		bytes += utf8.RuneLen(r)
+	}
+	t.char, t.utf16, t.byte = append(t.char, char), append(t.utf16, units), append(t.byte, bytes)
+	return t
+}
+
+func (t *columnTable) columns(loc Location) (LocationColumns, bool) {
+	if loc.StartChar < 0 || loc.StartChar > loc.EndChar || loc.EndChar >= len(t.char) {
+		return LocationColumns{}, false
+	}
+	return LocationColumns{
+		Char:  [2]int{t.char[loc.StartChar], t.char[loc.EndChar]},
+		UTF16: [2]int{t.utf16[loc.StartChar], t.utf16[loc.EndChar]},
+		Byte:  [2]int{t.byte[loc.StartChar], t.byte[loc.EndChar]},
+	}, true
+}
+
+// attachColumns adds a "columns" field to every node with a location in
+// code, for JSON output requested with the columns option.
+func attachColumns(root *Node, code string) {
+	t := newColumnTable(code)
+	Walk(root, func(n *Node, _ int) bool {
+		loc, ok := n.location()
+		if !ok {
+			return true
+		}
+		if c, ok := t.columns(loc); ok {
+			data, _ := json.Marshal(c)
+			node, _ := DecodeAST(data)
+			n.Fields = append(n.Fields, ASTField{Name: "columns", Value: node})
+		}
+		return true
+	})
+}
@@ -0,0 +1,102 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// PruneTree returns a copy of root cut down to at most maxDepth levels below
+// it and roughly maxNodes nodes in total, filled breadth-first so the upper
+// levels are always complete before deeper ones are. Zero means no limit.
+//
+// A node whose children were cut keeps its own fields and gains
+// "truncated": true, "child_count" and its "path" (prefixed with basePath),
+// which the client can pass to /subtree to load the rest of the branch.
+func PruneTree(root *Node, basePath string, maxDepth, maxNodes int) *Node {
+	if maxDepth <= 0 && maxNodes <= 0 {
+		return root
+	}
+
+	type queued struct {
+		node  *Node
+		path  string
+		depth int
+	}
+	truncated := make(map[*Node]queued)
+	queue := []queued{{root, basePath, 0}}
+	count := 1
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+
+		children := q.node.children()
+		if len(children) == 0 {
+			continue
+		}
+		if (maxDepth > 0 && q.depth >= maxDepth) || (maxNodes > 0 && count+len(children) > maxNodes) {
+			truncated[q.node] = q
+			continue
+		}
+		count += len(children)
+		for _, edge := range children {
+			queue = append(queue, queued{edge.Node, joinPath(q.path, edge.Path), q.depth + 1})
+		}
+	}
+
+	var rebuild func(value interface{}) interface{}
+	rebuild = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case *Node:
+			if q, ok := truncated[v]; ok {
+				return truncatedNode(v, q.path)
+			}
+			n := &Node{Type: v.Type, Fields: make([]ASTField, len(v.Fields))}
+			for i, f := range v.Fields {
+				n.Fields[i] = ASTField{Name: f.Name, Value: rebuild(f.Value)}
+			}
+			return n
+		case []interface{}:
+			values := make([]interface{}, len(v))
+			for i, element := range v {
+				values[i] = rebuild(element)
+			}
+			return values
+		default:
+			return v
+		}
+	}
+	return rebuild(root).(*Node)
+}
+
+// truncatedNode is the placeholder left for n once its children are cut:
+// the node's own scalar fields plus how to fetch what was removed.
+func truncatedNode(n *Node, path string) *Node {
+	placeholder := &Node{Type: n.Type}
+	for _, f := range n.Fields {
+		switch f.Value.(type) {
+		case *Node, []interface{}:
+			if f.Name != "location" {
+				continue
+			}
+		}
+		placeholder.Fields = append(placeholder.Fields, f)
+	}
+	placeholder.Fields = append(placeholder.Fields,
+		ASTField{Name: "truncated", Value: true},
+		ASTField{Name: "child_count", Value: json.Number(strconv.Itoa(len(n.children())))},
+		ASTField{Name: "path", Value: path},
+	)
+	return placeholder
+}
+
+// hiddenChildren returns how many children were cut from a placeholder left
+// by PruneTree, or 0 for any other node.
+func (n *Node) hiddenChildren() int {
+	if truncated, _ := n.field("truncated"); truncated != true {
+		return 0
+	}
+	count, _ := n.field("child_count")
+	num, _ := count.(json.Number)
+	v, _ := num.Int64()
+	return int(v)
+}
@@ -0,0 +1,439 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxQueryMatches caps how many matches /query returns.
+const maxQueryMatches = 1000
+
+// maxPatternLength keeps patterns small enough that backtracking over
+// several ... stays cheap.
+const maxPatternLength = 2000
+
+type patternKind int
+
+const (
+	patAny     patternKind = iota // _
+	patRest                       // ...
+	patType                       // a bare node type, such as int
+	patLiteral                    // :sym, "str" or a number
+	patNil                        // nil or nil?
+	patBool                       // true or false
+	patNode                       // (type children...)
+	patUnion                      // {a b}
+	patAll                        // [a b]
+	patNot                        // !a
+	patCapture                    // $a
+	patRepeat                     // a*, a+ or a?
+)
+
+// Pattern is a compiled node pattern, after RuboCop's NodePattern.
+type Pattern struct {
+	kind  patternKind
+	text  string
+	items []*Pattern
+	min   int
+	max   int // -1 for no limit
+}
+
+type patternError struct {
+	Message string
+	Offset  int
+}
+
+func (e *patternError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+type patternParser struct {
+	src string
+	pos int
+}
+
+func isPatternWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// CompilePattern parses a node pattern. Nodes are written (type children...)
+// and match positionally, with _ for any one value, ... for any number of
+// them, :sym, "str" and numbers for literals, nil, true and false, a bare
+// type for any node of that type, {a b} for either, [a b] for both, !a for
+// not, $a to capture, and a suffix of *, + or ? to repeat.
+func CompilePattern(src string) (*Pattern, error) {
+	if len(src) > maxPatternLength {
+		return nil, &patternError{Message: "Pattern too long", Offset: maxPatternLength}
+	}
+	p := &patternParser{src: src}
+	pat, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.src) {
+		return nil, p.errorf("Unexpected %q", p.src[p.pos])
+	}
+	return pat, nil
+}
+
+func (p *patternParser) errorf(format string, args ...interface{}) error {
+	return &patternError{Message: fmt.Sprintf(format, args...), Offset: p.pos}
+}
+
+func (p *patternParser) skipSpaces() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// parse reads one pattern along with any repetition suffix.
+func (p *patternParser) parse() (*Pattern, error) {
+	pat, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) && pat.kind != patRest {
+		switch p.src[p.pos] {
+		case '*':
+			pat = &Pattern{kind: patRepeat, items: []*Pattern{pat}, min: 0, max: -1}
+		case '+':
+			pat = &Pattern{kind: patRepeat, items: []*Pattern{pat}, min: 1, max: -1}
+		case '?':
+			pat = &Pattern{kind: patRepeat, items: []*Pattern{pat}, min: 0, max: 1}
+		default:
+			return pat, nil
+		}
+		p.pos++
+	}
+	return pat, nil
+}
+
+// parseList reads patterns up to the closing bracket.
+func (p *patternParser) parseList(closing byte) ([]*Pattern, error) {
+	open := p.pos
+	p.pos++
+	var items []*Pattern
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.src) {
+			p.pos = open
+			return nil, p.errorf("Unclosed %q", p.src[open])
+		}
+		if p.src[p.pos] == closing {
+			p.pos++
+			return items, nil
+		}
+		item, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *patternParser) parseTerm() (*Pattern, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("Unexpected end of pattern")
+	}
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		items, err := p.parseList(')')
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			p.pos = start
+			return nil, p.errorf("Empty node pattern")
+		}
+		return &Pattern{kind: patNode, items: items}, nil
+	case c == '{' || c == '[':
+		closing, kind := byte('}'), patUnion
+		if c == '[' {
+			closing, kind = ']', patAll
+		}
+		items, err := p.parseList(closing)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			p.pos = start
+			return nil, p.errorf("Empty %q", c)
+		}
+		return &Pattern{kind: kind, items: items}, nil
+	case c == '!' || c == '$':
+		p.pos++
+		inner, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		kind := patNot
+		if c == '$' {
+			kind = patCapture
+		}
+		return &Pattern{kind: kind, items: []*Pattern{inner}}, nil
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		return &Pattern{kind: patRest}, nil
+	case c == ':':
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte(" \t\r\n(){}[]", p.src[p.pos]) < 0 {
+			p.pos++
+		}
+		if p.pos == start+1 {
+			return nil, p.errorf("Empty symbol")
+		}
+		return &Pattern{kind: patLiteral, text: p.src[start+1 : p.pos]}, nil
+	case c == '"':
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+			}
+			b.WriteByte(p.src[p.pos])
+		}
+		if p.pos >= len(p.src) {
+			p.pos = start
+			return nil, p.errorf("Unterminated string")
+		}
+		p.pos++
+		return &Pattern{kind: patLiteral, text: b.String()}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		p.pos++
+		for p.pos < len(p.src) && (isPatternWordChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return &Pattern{kind: patLiteral, text: p.src[start:p.pos]}, nil
+	case isPatternWordChar(c):
+		for p.pos < len(p.src) && isPatternWordChar(p.src[p.pos]) {
+			p.pos++
+		}
+		word := p.src[start:p.pos]
+		if word == "nil" && p.pos < len(p.src) && p.src[p.pos] == '?' {
+			p.pos++
+		}
+		switch word {
+		case "_":
+			return &Pattern{kind: patAny}, nil
+		case "nil":
+			return &Pattern{kind: patNil}, nil
+		case "true", "false":
+			return &Pattern{kind: patBool, text: word}, nil
+		}
+		return &Pattern{kind: patType, text: word}, nil
+	default:
+		return nil, p.errorf("Unexpected %q", c)
+	}
+}
+
+// positionalValues lists a node's fields in order as pattern children.
+// Locations and comments are left out, as are Prism's *_loc fields, and the
+// elements of array fields are spliced in place.
+func positionalValues(n *Node) []interface{} {
+	var values []interface{}
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" || f.Name == "comments" || strings.HasSuffix(f.Name, "_loc") {
+			continue
+		}
+		if list, ok := f.Value.([]interface{}); ok {
+			values = append(values, list...)
+		} else {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// literalText returns a value as a literal pattern compares it: strings and
+// numbers as written, and token nodes such as stree's ident or int by their
+// value, so that :puts matches (ident "puts") as well as Prism's plain name.
+func literalText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case *Node:
+		switch value, _ := v.field("value"); value := value.(type) {
+		case string:
+			return value, true
+		case json.Number:
+			return value.String(), true
+		}
+	}
+	return "", false
+}
+
+// match reports whether v matches p, appending what it captures.
+func (p *Pattern) match(v interface{}, captures *[]interface{}) bool {
+	switch p.kind {
+	case patAny:
+		return true
+	case patType:
+		n, ok := v.(*Node)
+		return ok && n.Type == p.text
+	case patLiteral:
+		text, ok := literalText(v)
+		return ok && text == p.text
+	case patNil:
+		return v == nil
+	case patBool:
+		b, ok := v.(bool)
+		return ok && b == (p.text == "true")
+	case patNode:
+		n, ok := v.(*Node)
+		if !ok || n == nil || !p.items[0].match(n, captures) {
+			return false
+		}
+		return matchSequence(p.items[1:], positionalValues(n), captures)
+	case patUnion:
+		for _, item := range p.items {
+			mark := len(*captures)
+			if item.match(v, captures) {
+				return true
+			}
+			*captures = (*captures)[:mark]
+		}
+		return false
+	case patAll:
+		for _, item := range p.items {
+			if !item.match(v, captures) {
+				return false
+			}
+		}
+		return true
+	case patNot:
+		var discard []interface{}
+		return !p.items[0].match(v, &discard)
+	case patCapture:
+		// Captures are numbered in the order they are written, so one
+		// around others comes first.
+		mark := len(*captures)
+		*captures = append(*captures, v)
+		if !p.items[0].match(v, captures) {
+			*captures = (*captures)[:mark]
+			return false
+		}
+		return true
+	case patRepeat:
+		// Outside a node's children there is only the one value to repeat.
+		return p.items[0].match(v, captures)
+	}
+	return false
+}
+
+// matchSequence matches a node's children against the patterns in a node
+// pattern, backtracking over ... and repetitions.
+func matchSequence(items []*Pattern, values []interface{}, captures *[]interface{}) bool {
+	if len(items) == 0 {
+		return len(values) == 0
+	}
+	mark := len(*captures)
+	switch item := items[0]; {
+	case item.kind == patCapture && item.items[0].kind == patRest:
+		for k := 0; k <= len(values); k++ {
+			*captures = append(*captures, values[:k])
+			if matchSequence(items[1:], values[k:], captures) {
+				return true
+			}
+			*captures = (*captures)[:mark]
+		}
+		return false
+	case item.kind == patRest:
+		for k := 0; k <= len(values); k++ {
+			if matchSequence(items[1:], values[k:], captures) {
+				return true
+			}
+			*captures = (*captures)[:mark]
+		}
+		return false
+	case item.kind == patRepeat:
+		count := 0
+		for count < len(values) && (item.max < 0 || count < item.max) && item.items[0].match(values[count], captures) {
+			count++
+		}
+		for ; count >= item.min; count-- {
+			// Redo the captures of the elements kept at this count.
+			*captures = (*captures)[:mark]
+			for _, v := range values[:count] {
+				item.items[0].match(v, captures)
+			}
+			if matchSequence(items[1:], values[count:], captures) {
+				return true
+			}
+		}
+		*captures = (*captures)[:mark]
+		return false
+	default:
+		if len(values) == 0 || !item.match(values[0], captures) {
+			*captures = (*captures)[:mark]
+			return false
+		}
+		if !matchSequence(items[1:], values[1:], captures) {
+			*captures = (*captures)[:mark]
+			return false
+		}
+		return true
+	}
+}
+
+type QueryMatch struct {
+	Path     string        `json:"path"`
+	Type     string        `json:"type"`
+	Location *Location     `json:"location,omitempty"`
+	Captures []interface{} `json:"captures,omitempty"`
+}
+
+// captureValue describes a captured value: nodes by their type and place,
+// since the match already carries the subtree, a captured ... as a list, and
+// anything else as is.
+func captureValue(v interface{}, paths map[*Node]string) interface{} {
+	if list, ok := v.([]interface{}); ok {
+		values := make([]interface{}, len(list))
+		for i, element := range list {
+			values[i] = captureValue(element, paths)
+		}
+		return values
+	}
+	n, ok := v.(*Node)
+	if !ok || n == nil {
+		return v
+	}
+	c := QueryMatch{Path: paths[n], Type: n.Type}
+	if loc, ok := n.location(); ok {
+		c.Location = &loc
+	}
+	return c
+}
+
+// RunQuery finds every node in the tree matching the pattern, in depth-first
+// order.
+func RunQuery(root *Node, pat *Pattern) (matches []QueryMatch, truncated bool) {
+	nodes := flattenTree(root)
+	paths := make(map[*Node]string, len(nodes))
+	for _, t := range nodes {
+		paths[t.Node] = t.Path
+	}
+
+	matches = []QueryMatch{}
+	for _, t := range nodes {
+		var captures []interface{}
+		if !pat.match(t.Node, &captures) {
+			continue
+		}
+		if len(matches) == maxQueryMatches {
+			return matches, true
+		}
+		m := QueryMatch{Path: t.Path, Type: t.Node.Type}
+		if loc, ok := t.Node.location(); ok {
+			m.Location = &loc
+		}
+		for _, c := range captures {
+			m.Captures = append(m.Captures, captureValue(c, paths))
+		}
+		matches = append(matches, m)
+	}
+	return matches, false
+}
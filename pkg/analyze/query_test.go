@@ -0,0 +1,164 @@
+package analyze
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTreeCode = "foo.bar(1, :x)\nputs 'hi'"
+
+// testTreeJSON is stree's tree for testTreeCode.
+const testTreeJSON = `{"type":"program","location":[1,0,2,24],"statements":{"type":"statements","location":[1,0,2,24],"body":[
+	{"type":"call","location":[1,0,1,14],
+		"receiver":{"type":"vcall","location":[1,0,1,3],"value":{"type":"ident","location":[1,0,1,3],"value":"foo"}},
+		"operator":{"type":"period","location":[1,3,1,4],"value":"."},
+		"message":{"type":"ident","location":[1,4,1,7],"value":"bar"},
+		"arguments":{"type":"arg_paren","location":[1,7,1,14],"arguments":{"type":"args","location":[1,8,1,13],"parts":[
+			{"type":"int","location":[1,8,1,9],"value":"1"},
+			{"type":"symbol_literal","location":[1,11,1,13],"value":{"type":"ident","location":[1,12,1,13],"value":"x"}}]}}},
+	{"type":"command","location":[2,15,2,24],
+		"message":{"type":"ident","location":[2,15,2,19],"value":"puts"},
+		"arguments":{"type":"args","location":[2,20,2,24],"parts":[
+			{"type":"string_literal","location":[2,20,2,24],"parts":[{"type":"tstring_content","location":[2,21,2,23],"value":"hi"}],"quote":"'"}]},
+		"block":null}]},
+	"comments":[]}`
+
+func testTree(t *testing.T) *Node {
+	t.Helper()
+	root, err := DecodeAST([]byte(testTreeJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	tests := []struct {
+		src    string
+		msg    string
+		offset int
+	}{
+		{"", "Unexpected end of pattern", 0},
+		{"(send", `Unclosed '('`, 0},
+		{"(call (ident", `Unclosed '('`, 6},
+		{"()", "Empty node pattern", 0},
+		{"[ ]", `Empty '['`, 0},
+		{`(str "hi)`, "Unterminated string", 5},
+		{": x", "Empty symbol", 1},
+		{"int)", `Unexpected ')'`, 3},
+		{"#int", `Unexpected '#'`, 0},
+		{strings.Repeat("_", maxPatternLength+1), "Pattern too long", maxPatternLength},
+	}
+	for _, tt := range tests {
+		_, err := CompilePattern(tt.src)
+		var perr *patternError
+		if !errors.As(err, &perr) {
+			t.Errorf("CompilePattern(%q) error = %v, want a patternError", tt.src, err)
+			continue
+		}
+		if perr.Message != tt.msg || perr.Offset != tt.offset {
+			t.Errorf("CompilePattern(%q) error = %q at %d, want %q at %d", tt.src, perr.Message, perr.Offset, tt.msg, tt.offset)
+		}
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	root := testTree(t)
+	const (
+		call    = "statements.body[0]"
+		command = "statements.body[1]"
+		args    = "statements.body[0].arguments.arguments"
+	)
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"int", []string{args + ".parts[0]"}},
+		{"(ident :puts)", []string{command + ".message"}},
+		{`(ident "foo")`, []string{call + ".receiver.value"}},
+		{"(int 1)", []string{args + ".parts[0]"}},
+		{"(int 2)", nil},
+		// Token nodes match literals by their value.
+		{"(command :puts ...)", []string{command}},
+		{"(call (vcall :foo) _ :bar _)", []string{call}},
+		{"(call _ _ :bar)", nil},
+		{"(call ...)", []string{call}},
+		{"(args int ...)", []string{args}},
+		{"(args ... symbol_literal)", []string{args}},
+		{"(args int+ symbol_literal)", []string{args}},
+		{"(args int* symbol_literal?)", []string{args}},
+		{"(args int? int symbol_literal)", []string{args}},
+		{"(args symbol_literal*)", nil},
+		{"(command _ _ nil)", []string{command}},
+		{"(command _ _ nil?)", []string{command}},
+		{"(command _ _ !nil)", nil},
+		{"{int symbol_literal}", []string{args + ".parts[0]", args + ".parts[1]"}},
+		{"[ident !(ident :puts) !(ident :bar)]", []string{call + ".receiver.value", args + ".parts[1].value"}},
+		{"(string_literal (tstring_content :hi) \"'\")", []string{command + ".arguments.parts[0]"}},
+		{"(program statements)", []string{""}},
+		{"true", nil},
+	}
+	for _, tt := range tests {
+		pat, err := CompilePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("CompilePattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		matches, truncated := RunQuery(root, pat)
+		if truncated {
+			t.Errorf("%q: truncated", tt.pattern)
+		}
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRunQueryCaptures(t *testing.T) {
+	root := testTree(t)
+	tests := []struct {
+		pattern string
+		want    []interface{}
+	}{
+		{"(call $_ _ $(ident $_) _)", []interface{}{
+			QueryMatch{Path: "statements.body[0].receiver", Type: "vcall", Location: &Location{1, 0, 1, 3}},
+			QueryMatch{Path: "statements.body[0].message", Type: "ident", Location: &Location{1, 4, 1, 7}},
+			"bar",
+		}},
+		// Captures are numbered in the order they are written.
+		{"(args $(int $_) ...)", []interface{}{
+			QueryMatch{Path: "statements.body[0].arguments.arguments.parts[0]", Type: "int", Location: &Location{1, 8, 1, 9}},
+			"1",
+		}},
+		{"(args $int+ symbol_literal)", []interface{}{
+			QueryMatch{Path: "statements.body[0].arguments.arguments.parts[0]", Type: "int", Location: &Location{1, 8, 1, 9}},
+		}},
+		{"(args int $...)", []interface{}{
+			[]interface{}{QueryMatch{Path: "statements.body[0].arguments.arguments.parts[1]", Type: "symbol_literal", Location: &Location{1, 11, 1, 13}}},
+		}},
+		// The failed branch of a union leaves no captures behind.
+		{"(command {(ident $:bar) (ident $_)} ...)", []interface{}{"puts"}},
+		{"(command _ _ $_)", []interface{}{nil}},
+	}
+	for _, tt := range tests {
+		pat, err := CompilePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("CompilePattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		matches, _ := RunQuery(root, pat)
+		if len(matches) != 1 {
+			t.Errorf("%q: %d matches, want 1", tt.pattern, len(matches))
+			continue
+		}
+		if got := matches[0].Captures; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q captured %#v, want %#v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package analyze
+
+// foldableTypes are the stree and prism constructs worth folding in an
+// editor when they span several lines.
+var foldableTypes = map[string]bool{
+	"def": true, "defs": true, "class": true, "module": true, "sclass": true, "singleton_class": true,
+	"block": true, "brace_block": true, "do_block": true, "lambda": true, "begin": true,
+	"if": true, "unless": true, "case": true, "case_match": true, "while": true, "until": true, "for": true,
+	"array": true, "hash": true, "heredoc": true, "string_literal": true, "embdoc": true,
+}
+
+// FoldableLocations returns the locations of the definitions, blocks,
+// conditionals, loops and literals in root that span more than one line,
+// keeping only the outermost of those that start on the same line.
+func FoldableLocations(root *Node) []Location {
+	var found []Location
+	lines := make(map[int]bool)
+	for _, t := range flattenTree(root) {
+		loc, ok := t.Node.location()
+		if !ok || !foldableTypes[t.Node.Type] || loc.EndLine <= loc.StartLine || lines[loc.StartLine] {
+			continue
+		}
+		lines[loc.StartLine] = true
+		found = append(found, loc)
+	}
+	return found
+}
+
+// EnclosingLocations returns the locations of the nodes in root that contain
+// the character at offset, innermost first, leaving out any that are the
+// same as the one inside them.
+func EnclosingLocations(root *Node, offset int) []Location {
+	var found []Location
+	n := root
+	for n != nil {
+		loc, ok := n.location()
+		if ok && loc.StartChar <= offset && offset <= loc.EndChar && (len(found) == 0 || found[len(found)-1] != loc) {
+			found = append(found, loc)
+		}
+		var inner *Node
+		for _, edge := range n.children() {
+			if loc, ok := edge.Node.location(); ok && loc.StartChar <= offset && offset <= loc.EndChar {
+				inner = edge.Node
+				break
+			}
+		}
+		n = inner
+	}
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
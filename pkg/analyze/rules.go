@@ -0,0 +1,222 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxRules caps how many rules one run may have.
+const MaxRules = 200
+
+// Rule is a structural lint rule in the shape of a Semgrep rule: a node
+// pattern, and optionally patterns one of its ancestors must or must not
+// match. $1, $2 and so on in the message are replaced by the source of what
+// the pattern captured. Parser limits the rule to trees from that parser,
+// since node types differ between them.
+type Rule struct {
+	ID               string `json:"id"`
+	Pattern          string `json:"pattern"`
+	PatternInside    string `json:"pattern-inside,omitempty"`
+	PatternNotInside string `json:"pattern-not-inside,omitempty"`
+	Message          string `json:"message"`
+	Severity         string `json:"severity,omitempty"`
+	Parser           string `json:"parser,omitempty"`
+}
+
+// Rule severities. Semgrep's upper-case names are accepted too.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// CompiledRule is a Rule with its patterns compiled by CompileRules.
+type CompiledRule struct {
+	Rule
+	pattern, inside, notInside *Pattern
+}
+
+// RuleFinding is a node a rule matched.
+type RuleFinding struct {
+	RuleID   string    `json:"rule_id"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// ParseRules reads a rule set written as JSON or YAML: either a list of
+// rules or, as Semgrep writes them, an object with a rules list.
+func ParseRules(data []byte) ([]Rule, error) {
+	var doc interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if doc, err = decodeYAML(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		doc = m["rules"]
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of rules")
+	}
+
+	rules := make([]Rule, len(list))
+	for i, item := range list {
+		data, err := json.Marshal(item)
+		if err == nil {
+			err = json.Unmarshal(data, &rules[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// CompileRules checks a rule set and compiles its patterns. Rules without
+// an id are numbered, and the severity defaults to warning.
+func CompileRules(rules []Rule) ([]*CompiledRule, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules given")
+	}
+	if len(rules) > MaxRules {
+		return nil, fmt.Errorf("too many rules; the most is %d", MaxRules)
+	}
+	compiled := make([]*CompiledRule, len(rules))
+	for i, r := range rules {
+		if r.ID == "" {
+			r.ID = "rule-" + strconv.Itoa(i+1)
+		}
+		switch r.Severity = strings.ToLower(r.Severity); r.Severity {
+		case "":
+			r.Severity = SeverityWarning
+		case SeverityError, SeverityWarning, SeverityInfo:
+		default:
+			return nil, fmt.Errorf("rule %s: severity must be error, warning or info", r.ID)
+		}
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("rule %s: no pattern", r.ID)
+		}
+		if r.Message == "" {
+			return nil, fmt.Errorf("rule %s: no message", r.ID)
+		}
+		c := &CompiledRule{Rule: r}
+		for _, p := range []struct {
+			name string
+			src  string
+			dst  **Pattern
+		}{{"pattern", r.Pattern, &c.pattern}, {"pattern-inside", r.PatternInside, &c.inside}, {"pattern-not-inside", r.PatternNotInside, &c.notInside}} {
+			if p.src == "" {
+				continue
+			}
+			pat, err := CompilePattern(p.src)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: invalid %s: %w", r.ID, p.name, err)
+			}
+			*p.dst = pat
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// RunRules evaluates the rules that apply to parserName's trees against
+// root, returning what they found in depth-first order. code is the source
+// root was parsed from, for the captures in messages.
+func RunRules(root *Node, code, parserName string, rules []*CompiledRule) (findings []RuleFinding, truncated bool) {
+	runes := []rune(code)
+	findings = []RuleFinding{}
+	for _, t := range flattenTree(root) {
+		for _, r := range rules {
+			if r.Parser != "" && r.Parser != parserName {
+				continue
+			}
+			var captures []interface{}
+			if !r.pattern.match(t.Node, &captures) || !r.placed(t) {
+				continue
+			}
+			if len(findings) == maxQueryMatches {
+				return findings, true
+			}
+			f := RuleFinding{
+				RuleID:   r.ID,
+				Severity: r.Severity,
+				Message:  expandCaptures(r.Message, captures, runes),
+				Path:     t.Path,
+				Type:     t.Node.Type,
+			}
+			if loc, ok := t.Node.location(); ok {
+				f.Location = &loc
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, false
+}
+
+// placed checks a match's ancestors against the rule's pattern-inside and
+// pattern-not-inside.
+func (r *CompiledRule) placed(t *TreeNode) bool {
+	inside := r.inside == nil
+	for a := t.Parent; a != nil; a = a.Parent {
+		var discard []interface{}
+		if r.notInside != nil && r.notInside.match(a.Node, &discard) {
+			return false
+		}
+		if !inside && r.inside.match(a.Node, &discard) {
+			inside = true
+		}
+	}
+	return inside
+}
+
+// expandCaptures replaces $1 to $9 in a message with the source text of
+// those captures, or their value for literals. References to captures the
+// pattern doesn't have are left alone.
+func expandCaptures(message string, captures []interface{}, runes []rune) string {
+	if !strings.Contains(message, "$") {
+		return message
+	}
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if message[i] == '$' && i+1 < len(message) && message[i+1] >= '1' && message[i+1] <= '9' {
+			if n := int(message[i+1] - '1'); n < len(captures) {
+				b.WriteString(captureText(captures[n], runes))
+				i++
+				continue
+			}
+		}
+		b.WriteByte(message[i])
+	}
+	return b.String()
+}
+
+func captureText(v interface{}, runes []rune) string {
+	switch v := v.(type) {
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, element := range v {
+			parts[i] = captureText(element, runes)
+		}
+		return strings.Join(parts, ", ")
+	case *Node:
+		if loc, ok := v.location(); ok && loc.StartChar <= loc.EndChar && loc.EndChar <= len(runes) {
+			return string(runes[loc.StartChar:loc.EndChar])
+		}
+	}
+	if text, ok := literalText(v); ok {
+		return text
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,204 @@
+package analyze
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	want := []Rule{
+		{ID: "no-puts", Pattern: "(command (ident :puts) ...)", PatternNotInside: "(def ...)", Message: "Avoid $1 here", Severity: "ERROR"},
+		{Pattern: "int", Message: "An int:\nsee $1\n"},
+	}
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"semgrep YAML", `
+rules:
+  - id: no-puts
+    pattern: (command (ident :puts) ...)
+    pattern-not-inside: "(def ...)"
+    message: Avoid $1 here # as Semgrep writes it
+    severity: ERROR
+  - pattern: int
+    message: |
+      An int:
+      see $1
+`},
+		{"YAML list", `
+- id: no-puts
+  pattern: (command (ident :puts) ...)
+  pattern-not-inside: '(def ...)'
+  message: "Avoid $1 here"
+  severity: ERROR
+- pattern: int
+  message: "An int:\nsee $1\n"
+`},
+		{"JSON", `{"rules": [
+			{"id": "no-puts", "pattern": "(command (ident :puts) ...)", "pattern-not-inside": "(def ...)", "message": "Avoid $1 here", "severity": "ERROR"},
+			{"pattern": "int", "message": "An int:\nsee $1\n", "unknown": true}]}`},
+	}
+	for _, tt := range tests {
+		got, err := ParseRules([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ParseRules = %+v, want %+v", tt.name, got, want)
+		}
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "expected a list of rules"},
+		{"rules: none", "expected a list of rules"},
+		{`{"rules": 1}`, "expected a list of rules"},
+		{`[{"id": 5}]`, "rule 1: json: cannot unmarshal"},
+		{"- pattern: int\n- [a, b]", "rule 2: json: cannot unmarshal"},
+		{"rules:\n  - id: a\n    id: b", `line 3: key "id" appears twice`},
+		{`[{"id": "a"`, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		_, err := ParseRules([]byte(tt.src))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("ParseRules(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	compiled, err := CompileRules([]Rule{
+		{Pattern: "int", Message: "m"},
+		{ID: "loud", Pattern: "int", Message: "m", Severity: "INFO"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := compiled[0]; r.ID != "rule-1" || r.Severity != SeverityWarning {
+		t.Errorf("defaults = %q, %q; want rule-1, warning", r.ID, r.Severity)
+	}
+	if r := compiled[1]; r.ID != "loud" || r.Severity != SeverityInfo {
+		t.Errorf("rule 2 = %q, %q; want loud, info", r.ID, r.Severity)
+	}
+
+	tests := []struct {
+		rules []Rule
+		want  string
+	}{
+		{nil, "no rules given"},
+		{make([]Rule, MaxRules+1), "too many rules; the most is 200"},
+		{[]Rule{{ID: "a", Pattern: "int", Message: "m", Severity: "fatal"}}, "rule a: severity must be error, warning or info"},
+		{[]Rule{{Pattern: "int", Message: "m"}, {Message: "m"}}, "rule rule-2: no pattern"},
+		{[]Rule{{ID: "a", Pattern: "int"}}, "rule a: no message"},
+		{[]Rule{{ID: "a", Pattern: "(int", Message: "m"}}, `rule a: invalid pattern: Unclosed '(' at offset 0`},
+		{[]Rule{{ID: "a", Pattern: "int", PatternInside: "()", Message: "m"}}, "rule a: invalid pattern-inside: Empty node pattern at offset 0"},
+		{[]Rule{{ID: "a", Pattern: "int", PatternNotInside: "{", Message: "m"}}, `rule a: invalid pattern-not-inside: Unclosed '{' at offset 0`},
+	}
+	for _, tt := range tests {
+		_, err := CompileRules(tt.rules)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("CompileRules error = %v, want %q", err, tt.want)
+		}
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	root := testTree(t)
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{
+			name: "capture in a message",
+			rule: Rule{ID: "puts", Pattern: "(command (ident $_) ...)", Message: "Avoid $1", Severity: "error"},
+			want: `[{"rule_id":"puts","severity":"error","message":"Avoid puts","path":"statements.body[1]","type":"command","location":[2,15,2,24]}]`,
+		},
+		{
+			// Node captures expand to their source, lists of them to each
+			// one's, and missing captures are left as written.
+			name: "source of captures",
+			rule: Rule{ID: "c", Pattern: "(call $_ _ _ (arg_paren (args $...)))", Message: "$1 gets $2, not $3$"},
+			want: `[{"rule_id":"c","severity":"warning","message":"foo gets 1, :x, not $3$","path":"statements.body[0]","type":"call","location":[1,0,1,14]}]`,
+		},
+		{
+			name: "source on the second line",
+			rule: Rule{ID: "s", Pattern: "$string_literal", Message: "$1"},
+			want: `[{"rule_id":"s","severity":"warning","message":"'hi'","path":"statements.body[1].arguments.parts[0]","type":"string_literal","location":[2,20,2,24]}]`,
+		},
+		{
+			name: "pattern-inside",
+			rule: Rule{ID: "i", Pattern: "ident", PatternInside: "(call ...)", Message: "m"},
+			want: `[{"rule_id":"i","severity":"warning","message":"m","path":"statements.body[0].receiver.value","type":"ident","location":[1,0,1,3]},` +
+				`{"rule_id":"i","severity":"warning","message":"m","path":"statements.body[0].message","type":"ident","location":[1,4,1,7]},` +
+				`{"rule_id":"i","severity":"warning","message":"m","path":"statements.body[0].arguments.arguments.parts[1].value","type":"ident","location":[1,12,1,13]}]`,
+		},
+		{
+			name: "pattern-not-inside",
+			rule: Rule{ID: "n", Pattern: "ident", PatternNotInside: "{call symbol_literal}", Message: "m"},
+			want: `[{"rule_id":"n","severity":"warning","message":"m","path":"statements.body[1].message","type":"ident","location":[2,15,2,19]}]`,
+		},
+		{
+			name: "both",
+			rule: Rule{ID: "b", Pattern: "ident", PatternInside: "call", PatternNotInside: "symbol_literal", Message: "m"},
+			want: `[{"rule_id":"b","severity":"warning","message":"m","path":"statements.body[0].receiver.value","type":"ident","location":[1,0,1,3]},` +
+				`{"rule_id":"b","severity":"warning","message":"m","path":"statements.body[0].message","type":"ident","location":[1,4,1,7]}]`,
+		},
+		{
+			name: "another parser's rule",
+			rule: Rule{ID: "p", Pattern: "int", Message: "m", Parser: "prism"},
+			want: `[]`,
+		},
+		{
+			name: "this parser's rule",
+			rule: Rule{ID: "p", Pattern: "int", Message: "m", Parser: "stree"},
+			want: `[{"rule_id":"p","severity":"warning","message":"m","path":"statements.body[0].arguments.arguments.parts[0]","type":"int","location":[1,8,1,9]}]`,
+		},
+	}
+	for _, tt := range tests {
+		rules, err := CompileRules([]Rule{tt.rule})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		findings, truncated := RunRules(root, testTreeCode, "stree", rules)
+		got, _ := json.Marshal(findings)
+		if string(got) != tt.want || truncated {
+			t.Errorf("%s: RunRules = %s (truncated %v), want %s", tt.name, got, truncated, tt.want)
+		}
+	}
+}
+
+// Findings come in tree order, and for one node in the order of the rules.
+func TestRunRulesOrder(t *testing.T) {
+	rules, err := CompileRules([]Rule{
+		{ID: "ints", Pattern: "int", Message: "m"},
+		{ID: "args", Pattern: "args", Message: "m"},
+		{ID: "also-args", Pattern: "(args ...)", Message: "m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, _ := RunRules(testTree(t), testTreeCode, "stree", rules)
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.RuleID+" "+f.Path)
+	}
+	want := []string{
+		"args statements.body[0].arguments.arguments",
+		"also-args statements.body[0].arguments.arguments",
+		"ints statements.body[0].arguments.arguments.parts[0]",
+		"args statements.body[1].arguments",
+		"also-args statements.body[1].arguments",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings in order %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,182 @@
+package analyze
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaGenerator derives JSON Schemas from Go types through their json
+// tags, so the schemas can't drift from what the handlers encode. Named
+// struct types go in defs and are referred to with $ref, which also lets a
+// type contain itself. refPrefix is where defs will live in the document.
+type SchemaGenerator struct {
+	refPrefix string
+	Defs      map[string]interface{}
+	// descriptions documents struct fields, keyed by "Type.field".
+	descriptions map[string]string
+}
+
+var (
+	locationType   = reflect.TypeOf(Location{})
+	interfaceType  = reflect.TypeOf((*interface{})(nil)).Elem()
+	astNodeType    = reflect.TypeOf(Node{})
+	normalJSONType = reflect.TypeOf(NormalJSON(nil))
+	bytesType      = reflect.TypeOf([]byte(nil))
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func NewSchemaGenerator(refPrefix string, descriptions map[string]string) *SchemaGenerator {
+	return &SchemaGenerator{refPrefix: refPrefix, Defs: make(map[string]interface{}), descriptions: descriptions}
+}
+
+// jsonFieldName returns the name a struct field is encoded under and whether
+// it's omitted when empty, or "" for a field that isn't encoded.
+func jsonFieldName(f reflect.StructField) (name string, omitempty bool) {
+	if f.PkgPath != "" && !f.Anonymous {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// schema returns the schema for t.
+func (g *SchemaGenerator) Schema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		return g.Schema(t.Elem())
+	}
+	switch {
+	case t == locationType:
+		return map[string]interface{}{
+			"type":        "array",
+			"description": "[start_line, start_char, end_line, end_char]: lines from 1, chars as 0-based offsets into the source",
+			"items":       map[string]interface{}{"type": "integer"},
+			"minItems":    4,
+			"maxItems":    4,
+		}
+	case t == astNodeType:
+		return map[string]interface{}{"type": "object", "description": "A node in the parser's own shape"}
+	case t == normalJSONType:
+		return g.Schema(reflect.TypeOf(NormalNode{}))
+	case t == bytesType:
+		return map[string]interface{}{"type": "string", "format": "binary"}
+	case t == interfaceType, t.Implements(marshalerType), reflect.PtrTo(t).Implements(marshalerType):
+		// Such as json.RawMessage, which could hold anything.
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.Schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.Defs[t.Name()]; !ok {
+			// Reserve the name first in case the type refers to itself.
+			g.Defs[t.Name()] = nil
+			g.Defs[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": g.refPrefix + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object builds the schema for a struct's fields, including those of
+// embedded structs as encoding/json flattens them.
+func (g *SchemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				embedded := f.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					addFields(embedded)
+					continue
+				}
+			}
+			name, omitempty := jsonFieldName(f)
+			if name == "" {
+				continue
+			}
+			prop := g.Schema(f.Type)
+			if desc, ok := g.descriptions[t.Name()+"."+name]; ok {
+				prop = withDescription(prop, desc)
+			}
+			properties[name] = prop
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withDescription adds a description to a schema. A $ref can't have
+// siblings in older drafts, so it is wrapped.
+func withDescription(schema map[string]interface{}, desc string) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]interface{}{"allOf": []interface{}{schema}, "description": desc}
+	}
+	out := make(map[string]interface{}, len(schema)+1)
+	for k, v := range schema {
+		out[k] = v
+	}
+	out["description"] = desc
+	return out
+}
+
+var NormalNodeDescriptions = map[string]string{
+	"NormalNode.type":       "The parser's own node type, such as command or call",
+	"NormalNode.field":      "The field of the parent node this node came from",
+	"NormalNode.value":      "The literal a token stands for, such as an identifier's name",
+	"NormalNode.attributes": "The node's other plain fields, such as Prism's flags",
+	"NormalNode.children":   "The typed nodes below this one, in source order",
+}
+
+// ASTSchema is the JSON Schema of normalized /parse output.
+func ASTSchema() map[string]interface{} {
+	g := NewSchemaGenerator("#/$defs/", NormalNodeDescriptions)
+	root := g.Schema(reflect.TypeOf(NormalNode{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "Ruby AST"
+	root["description"] = "A syntax tree as /parse returns it without raw, whichever parser made it"
+	root["$defs"] = g.Defs
+	return root
+}
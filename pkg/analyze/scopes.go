@@ -0,0 +1,218 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// hardScopes start a fresh set of local variables; softScopes (blocks and
+// lambdas) can also see those of the scope around them. Both stree and Prism
+// spellings are listed.
+var (
+	hardScopes = map[string]bool{
+		"program": true, "def": true, "defs": true, "class": true, "module": true,
+		"sclass": true, "singleton_class": true,
+	}
+	softScopes = map[string]bool{
+		"block": true, "brace_block": true, "do_block": true, "lambda": true,
+	}
+)
+
+// parameterLists are the nodes whose children declare parameters.
+var parameterLists = map[string]bool{
+	"params": true, "block_var": true, "lambda_var": true,
+	"parameters": true, "block_parameters": true,
+}
+
+// Prism's local variable writes, which all have a plain string name.
+var localWriteTypes = map[string]bool{
+	"local_variable_write": true, "local_variable_target": true,
+	"local_variable_operator_write": true, "local_variable_and_write": true,
+	"local_variable_or_write": true,
+}
+
+// VariableRef is one place a local variable is bound or used, by the path of
+// its node as /subtree takes it.
+type VariableRef struct {
+	Path     string    `json:"path"`
+	Kind     string    `json:"kind"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// Variable is one local variable: a parameter or the first assignment to a
+// name in its scope, and everything after that refers to it. A block
+// parameter or block-local variable with the name of one outside the block
+// shadows it.
+type Variable struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Scope       string        `json:"scope"`
+	Declaration VariableRef   `json:"declaration"`
+	References  []VariableRef `json:"references"`
+	Shadows     *int          `json:"shadows,omitempty"`
+}
+
+type scope struct {
+	parent *scope
+	hard   bool
+	vars   map[string]*Variable
+}
+
+// lookup finds the variable a name refers to from this scope, looking out
+// through blocks but not past a def, class or module.
+func (sc *scope) lookup(name string) *Variable {
+	for ; sc != nil; sc = sc.parent {
+		if v := sc.vars[name]; v != nil {
+			return v
+		}
+		if sc.hard {
+			break
+		}
+	}
+	return nil
+}
+
+// parameterName returns the name a node in a parameter list declares: stree
+// gives required and block-local names as bare idents, keywords as labels,
+// and wraps the splats around an ident; Prism has a *_parameter node with a
+// string name for each.
+func parameterName(n *Node) (name string, node *Node, ok bool) {
+	switch n.Type {
+	case "ident":
+		name, ok = n.value()
+		return name, n, ok
+	case "label":
+		name, ok = n.value()
+		return strings.TrimSuffix(name, ":"), n, ok
+	case "rest_param", "kwrest_param", "blockarg":
+		v, _ := n.field("name")
+		if ident, isNode := v.(*Node); isNode {
+			return parameterName(ident)
+		}
+		return "", nil, false
+	}
+	if strings.HasSuffix(n.Type, "_parameter") || n.Type == "block_local_variable" {
+		v, _ := n.field("name")
+		name, ok = v.(string)
+		return name, n, ok && name != ""
+	}
+	return "", nil, false
+}
+
+// localName returns the local variable an stree var_field or var_ref node
+// names; instance variables, constants and the like aren't locals.
+func localName(n *Node) (string, bool) {
+	v, _ := n.field("value")
+	ident, ok := v.(*Node)
+	if !ok || ident.Type != "ident" {
+		return "", false
+	}
+	return ident.value()
+}
+
+type ScopeAnalysis struct {
+	Variables []*Variable `json:"variables"`
+
+	// byNode finds the variable a node binds or refers to.
+	byNode map[*Node]*Variable
+}
+
+// AnalyzeScopes links every local variable read and write in a tree to the
+// variable it refers to, following Ruby's rules: the first assignment to a
+// name declares it, blocks see the variables around them, and defs, classes
+// and modules start afresh.
+func AnalyzeScopes(root *Node) *ScopeAnalysis {
+	a := &ScopeAnalysis{Variables: []*Variable{}, byNode: make(map[*Node]*Variable)}
+	ref := func(n *Node, path, kind string) VariableRef {
+		r := VariableRef{Path: path, Kind: kind}
+		if loc, ok := n.location(); ok {
+			r.Location = &loc
+		}
+		return r
+	}
+	declare := func(sc *scope, scopePath, name, kind string, n *Node, path string) {
+		v := &Variable{ID: len(a.Variables), Name: name, Kind: kind, Scope: scopePath, Declaration: ref(n, path, "declaration"), References: []VariableRef{}}
+		if kind != "local" && !sc.hard {
+			if outer := sc.parent.lookup(name); outer != nil {
+				v.Shadows = &outer.ID
+			}
+		}
+		sc.vars[name] = v
+		a.Variables = append(a.Variables, v)
+		a.byNode[n] = v
+	}
+	use := func(v *Variable, n *Node, path, kind string) {
+		v.References = append(v.References, ref(n, path, kind))
+		a.byNode[n] = v
+	}
+
+	var visit func(n *Node, path string, sc *scope, scopePath string)
+	visit = func(n *Node, path string, sc *scope, scopePath string) {
+		switch {
+		case hardScopes[n.Type] || softScopes[n.Type]:
+			sc = &scope{parent: sc, hard: hardScopes[n.Type], vars: make(map[string]*Variable)}
+			scopePath = path
+		case parameterLists[n.Type]:
+			for _, edge := range n.children() {
+				childPath := joinPath(path, edge.Path)
+				if name, node, ok := parameterName(edge.Node); ok {
+					if node != edge.Node {
+						childPath = joinPath(childPath, "name")
+					}
+					kind := "parameter"
+					if edge.Field == "locals" || node.Type == "block_local_variable" {
+						kind = "block_local"
+					}
+					declare(sc, scopePath, name, kind, node, childPath)
+					if node != edge.Node || edge.Node.Type == "ident" || edge.Node.Type == "label" {
+						continue
+					}
+				}
+				visit(edge.Node, childPath, sc, scopePath)
+			}
+			return
+		case n.Type == "var_field" || localWriteTypes[n.Type]:
+			name, ok := localName(n)
+			if localWriteTypes[n.Type] {
+				v, _ := n.field("name")
+				name, ok = v.(string)
+			}
+			if ok {
+				if v := sc.lookup(name); v != nil {
+					use(v, n, path, "write")
+				} else {
+					declare(sc, scopePath, name, "local", n, path)
+				}
+			}
+		case n.Type == "var_ref" || n.Type == "local_variable_read":
+			name, ok := localName(n)
+			if n.Type == "local_variable_read" {
+				v, _ := n.field("name")
+				name, ok = v.(string)
+			}
+			if v := sc.lookup(name); ok && v != nil {
+				use(v, n, path, "read")
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, joinPath(path, edge.Path), sc, scopePath)
+		}
+	}
+	visit(root, "", &scope{hard: true, vars: make(map[string]*Variable)}, "")
+	return a
+}
+
+// attachScopes adds a "variable" field to every node that binds or refers to
+// a local variable, holding the variable's ID, so that all the uses of one
+// can be found from any of them.
+func attachScopes(root *Node) {
+	a := AnalyzeScopes(root)
+	Walk(root, func(n *Node, _ int) bool {
+		if v, ok := a.byNode[n]; ok {
+			n.Fields = append(n.Fields, ASTField{Name: "variable", Value: json.Number(strconv.Itoa(v.ID))})
+		}
+		return true
+	})
+}
@@ -0,0 +1,182 @@
+package analyze
+
+import (
+	"regexp"
+	"strings"
+)
+
+// slimFilter matches a line opening an embedded-language block, such as
+// "javascript:" or "ruby:".
+var slimFilter = regexp.MustCompile(`^([\w-]+):\s*$`)
+
+// slimOutput returns the length of the output marker at the start of text,
+// "=" or "==" followed by any whitespace modifiers, or 0 if there isn't one.
+func slimOutput(text string) int {
+	if !strings.HasPrefix(text, "=") {
+		return 0
+	}
+	n := 1
+	if strings.HasPrefix(text, "==") {
+		n = 2
+	}
+	for n < len(text) && strings.IndexByte("'<>", text[n]) >= 0 {
+		n++
+	}
+	return n
+}
+
+// extractSlim finds the Ruby in a Slim template: "-" lines, "=" lines and
+// tag output, unquoted attribute values, the body of ruby: blocks, and #{}
+// interpolation in text and quoted attributes.
+func extractSlim(template string) ([]TemplateExpr, error) {
+	sc := newTemplateScanner(template)
+	for ; sc.i < len(sc.lines); sc.i++ {
+		line := sc.lines[sc.i]
+		if line.blank() {
+			continue
+		}
+		pos := line.Offset + line.Indent
+		text := line.Text[line.Indent:]
+
+		switch {
+		case text[0] == '/':
+			// A comment, along with everything nested under it.
+			for sc.nestedNext(line.Indent) {
+				sc.i++
+			}
+		case text[0] == '|' || text[0] == '\'':
+			sc.interpolate(pos+1, sc.lineEnd())
+			for sc.nestedNext(line.Indent) {
+				sc.i++
+				sc.interpolate(sc.lines[sc.i].Offset, sc.lineEnd())
+			}
+		case text[0] == '-':
+			sc.emit(exprCode, pos+1)
+		case slimOutput(text) > 0:
+			sc.emit(exprOutput, pos+slimOutput(text))
+		case text[0] == '<':
+			sc.interpolate(pos, sc.lineEnd())
+		case strings.HasPrefix(text, "doctype"):
+		case slimFilter.MatchString(text):
+			sc.filterBlock(line.Indent, slimFilter.FindStringSubmatch(text)[1] == "ruby")
+		default:
+			if err := sc.slimTag(pos); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sc.exprs, nil
+}
+
+func isSlimNameChar(c byte) bool {
+	return isHamlNameChar(c) || c == '@'
+}
+
+// slimTag handles a tag: its name and shorthand classes and ids, then its
+// attributes, bare or wrapped in brackets, then output, an inline nested tag
+// after a colon, or text.
+func (sc *templateScanner) slimTag(pos int) error {
+	t := sc.template
+	for pos < sc.lineEnd() && (isHamlNameChar(t[pos]) || t[pos] == '.' || t[pos] == '#') {
+		// A colon followed by a space starts an inline nested tag.
+		if t[pos] == ':' && (pos+1 >= sc.lineEnd() || t[pos+1] == ' ') {
+			break
+		}
+		pos++
+	}
+
+	var closing byte
+	if pos < sc.lineEnd() && strings.IndexByte("([{", t[pos]) >= 0 {
+		closing = map[byte]byte{'(': ')', '[': ']', '{': '}'}[t[pos]]
+		pos++
+	}
+	for {
+		if closing != 0 {
+			// Wrapped attributes may run over several lines.
+			for pos >= sc.lineEnd() && sc.i+1 < len(sc.lines) {
+				sc.i++
+				pos = sc.lines[sc.i].Offset
+			}
+		}
+		start := sc.skipSpaces(pos)
+		if closing != 0 && start < sc.lineEnd() && t[start] == closing {
+			pos = start + 1
+			break
+		}
+		name := start
+		for name < sc.lineEnd() && isSlimNameChar(t[name]) {
+			name++
+		}
+		if name == start || name >= sc.lineEnd() || t[name] != '=' {
+			if closing != 0 && name > start {
+				// A boolean attribute.
+				pos = name
+				continue
+			}
+			if closing != 0 && name == start {
+				return nil
+			}
+			break
+		}
+		value := name + 1
+		if value < sc.lineEnd() && t[value] == '=' {
+			value++
+		}
+		end, err := sc.slimAttributeValue(value, closing)
+		if err != nil {
+			return err
+		}
+		pos = end
+	}
+
+	pos = sc.skipSpaces(pos)
+	if pos >= sc.lineEnd() {
+		return nil
+	}
+	rest := t[pos:sc.lineEnd()]
+	switch {
+	case slimOutput(rest) > 0:
+		sc.emit(exprOutput, pos+slimOutput(rest))
+	case rest[0] == ':':
+		return sc.slimTag(sc.skipSpaces(pos + 1))
+	case rest[0] == '/':
+	default:
+		sc.interpolate(pos, sc.lineEnd())
+	}
+	return nil
+}
+
+// slimAttributeValue records the attribute value starting at pos and returns
+// the offset just past it. Quoted values are text that may interpolate; any
+// other value is a Ruby expression running to the next space outside
+// brackets, or to the closing bracket of wrapped attributes.
+func (sc *templateScanner) slimAttributeValue(pos int, closing byte) (int, error) {
+	t := sc.template
+	if pos < sc.lineEnd() && (t[pos] == '"' || t[pos] == '\'') {
+		end := pos + 1
+		for end < sc.lineEnd() && t[end] != t[pos] {
+			if t[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		sc.interpolate(pos+1, end)
+		return end + 1, nil
+	}
+
+	end := pos
+	for end < sc.lineEnd() && t[end] != ' ' && t[end] != '\t' && t[end] != closing {
+		if strings.IndexByte("([{", t[end]) >= 0 {
+			close, err := sc.bracket(end)
+			if err != nil {
+				return 0, err
+			}
+			end = close
+		}
+		end++
+	}
+	if end > pos {
+		sc.exprs = append(sc.exprs, TemplateExpr{Kind: exprAttribute, Code: t[pos:end], Offset: pos})
+	}
+	return end, nil
+}
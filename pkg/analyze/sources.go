@@ -0,0 +1,12 @@
+package analyze
+
+// SourceSite is where something is defined, in one of the files analysed.
+type SourceSite struct {
+	Path     string    `json:"path,omitempty"`
+	Location *Location `json:"location,omitempty"`
+}
+
+type ParsedFile struct {
+	Path string
+	Root *Node
+}
@@ -0,0 +1,111 @@
+package analyze
+
+// MethodStats describes one method definition. Its depth is how deep its
+// body nests below the def itself.
+type MethodStats struct {
+	Name     string    `json:"name"`
+	Nodes    int       `json:"nodes"`
+	MaxDepth int       `json:"max_depth"`
+	Location *Location `json:"location,omitempty"`
+}
+
+type TreeStats struct {
+	Nodes    int            `json:"nodes"`
+	MaxDepth int            `json:"max_depth"`
+	Types    map[string]int `json:"types"`
+	Methods  []MethodStats  `json:"methods"`
+}
+
+// methodName returns the name a def node defines, as stree (an ident node)
+// and Prism (a plain string) spell it, and whether it is a singleton method.
+func methodName(n *Node) (name string, singleton bool) {
+	switch v, _ := n.field("name"); v := v.(type) {
+	case *Node:
+		name, _ = v.value()
+	case string:
+		name = v
+	}
+	for _, field := range []string{"target", "receiver"} {
+		if v, ok := n.field(field); ok && v != nil {
+			singleton = true
+		}
+	}
+	return name, singleton
+}
+
+// enterNamespace returns the namespace inside a class or module node n
+// declared within namespace, or namespace itself if n's name can't be read.
+func enterNamespace(n *Node, namespace string) string {
+	c, _ := n.field("constant")
+	ref, ok := c.(*Node)
+	if !ok {
+		return namespace
+	}
+	name := constName(ref)
+	switch {
+	case name == "":
+		return namespace
+	case name[0] == ':':
+		return name[2:]
+	case namespace != "":
+		return namespace + "::" + name
+	}
+	return name
+}
+
+// qualifiedMethodName names the method a def node defines within namespace,
+// as in Foo#bar for an instance method and Foo.bar for a singleton one.
+func qualifiedMethodName(n *Node, namespace string) string {
+	name, singleton := methodName(n)
+	switch {
+	case namespace != "" && singleton:
+		return namespace + "." + name
+	case namespace != "":
+		return namespace + "#" + name
+	case singleton:
+		return "self." + name
+	}
+	return name
+}
+
+// ComputeStats counts a tree's nodes by type and measures each method. Method
+// names are qualified by the classes and modules around them where the
+// parser's constants can be read, as with FindDefinitions. A method defined
+// inside another counts towards both.
+func ComputeStats(root *Node) TreeStats {
+	stats := TreeStats{Types: make(map[string]int), Methods: []MethodStats{}}
+	var defDepths []int
+	var visit func(n *Node, depth int, namespace string, open []int)
+	visit = func(n *Node, depth int, namespace string, open []int) {
+		stats.Nodes++
+		stats.Types[n.Type]++
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+
+		switch n.Type {
+		case "class", "module":
+			namespace = enterNamespace(n, namespace)
+		case "def", "defs":
+			method := MethodStats{Name: qualifiedMethodName(n, namespace)}
+			if loc, ok := n.location(); ok {
+				method.Location = &loc
+			}
+			stats.Methods = append(stats.Methods, method)
+			defDepths = append(defDepths, depth)
+			open = append(open[:len(open):len(open)], len(stats.Methods)-1)
+		}
+
+		for _, i := range open {
+			stats.Methods[i].Nodes++
+			if d := depth - defDepths[i]; d > stats.Methods[i].MaxDepth {
+				stats.Methods[i].MaxDepth = d
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, depth+1, namespace, open)
+		}
+	}
+	visit(root, 0, "", nil)
+	return stats
+}
@@ -0,0 +1,170 @@
+package analyze
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Heredoc layouts for normalized JSON output. Both parsers give a heredoc's
+// node a location that doesn't hold its body: stree's runs from <<ID to the
+// end of the terminator, over whatever else is on the opening line, and
+// prism's covers only <<ID, with the parts below it outside.
+const (
+	// HeredocsInline moves the node to its body, from the line after <<ID
+	// to the terminator, with <<ID as its "opening" attribute.
+	HeredocsInline = "inline"
+
+	// HeredocsSeparate leaves the node at <<ID and moves its parts to a
+	// heredoc_body node spanning the body, listed in the root's
+	// "heredoc_bodies" field. The heredoc's "body" attribute is the index of
+	// its body there.
+	HeredocsSeparate = "separate"
+)
+
+func KnownHeredocs(name string) bool {
+	return name == "" || name == HeredocsInline || name == HeredocsSeparate
+}
+
+// heredocTypes are the nodes a heredoc can be: stree's, and prism's strings
+// and commands when their source starts with <<.
+var heredocTypes = map[string]bool{
+	"heredoc": true, "string": true, "interpolated_string": true, "x_string": true, "interpolated_x_string": true,
+}
+
+var heredocOpening = regexp.MustCompile("^<<([~-]?)([\"'`]?)([A-Za-z_][A-Za-z0-9_]*)[\"'`]?")
+
+type heredoc struct {
+	node       *NormalNode
+	opening    Location
+	indented   bool
+	terminator string
+}
+
+// layoutHeredocs puts the heredocs in root, parsed from code, in the given
+// layout. Heredocs whose bodies can't be found in code are left alone.
+func layoutHeredocs(root *NormalNode, code, layout string) {
+	chars := []rune(code)
+	lines := []int{0}
+	for i, r := range chars {
+		if r == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	lineEnd := func(line int) int {
+		if line < len(lines) {
+			return lines[line] - 1
+		}
+		return len(chars)
+	}
+	loc := func(start, end int) Location {
+		startLine := sort.SearchInts(lines, start+1)
+		endLine := sort.SearchInts(lines, end+1)
+		return Location{startLine, start, endLine, end}
+	}
+
+	var found []*heredoc
+	var visit func(n *NormalNode)
+	visit = func(n *NormalNode) {
+		if n.Location != nil && heredocTypes[n.Type] && n.Location.StartChar < len(chars) {
+			if m := heredocOpening.FindStringSubmatch(string(chars[n.Location.StartChar:min(len(chars), n.Location.StartChar+256)])); m != nil {
+				start := n.Location.StartChar
+				found = append(found, &heredoc{
+					node:       n,
+					opening:    loc(start, start+len([]rune(m[0]))),
+					indented:   m[1] != "",
+					terminator: m[3],
+				})
+			}
+		}
+		for _, child := range n.Children {
+			visit(child)
+		}
+	}
+	visit(root)
+	sort.SliceStable(found, func(i, j int) bool { return found[i].opening.StartChar < found[j].opening.StartChar })
+
+	// A heredoc's body starts on the line after <<ID, or after the body of
+	// the one before it if both open on the same line.
+	next := make(map[int]int)
+	bodies := 0
+	for _, h := range found {
+		line := h.opening.StartLine + 1
+		if after, ok := next[h.opening.StartLine]; ok {
+			line = after
+		}
+		end := -1
+		for l := line; l <= len(lines); l++ {
+			text := strings.TrimSuffix(string(chars[lines[l-1]:lineEnd(l)]), "\r")
+			if h.indented {
+				text = strings.TrimLeft(text, " \t")
+			}
+			if text == h.terminator {
+				end = l
+				break
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		next[h.opening.StartLine] = end + 1
+		body := Location{line, lines[line-1], end, lineEnd(end)}
+		if body.EndChar > lines[end-1] && chars[body.EndChar-1] == '\r' {
+			body.EndChar--
+		}
+
+		n := h.node
+		if n.Attributes == nil {
+			n.Attributes = make(map[string]interface{})
+		}
+		switch layout {
+		case HeredocsInline:
+			n.Attributes["opening"] = h.opening
+			n.Location = &body
+		case HeredocsSeparate:
+			n.Attributes["body"] = bodies
+			bodies++
+			opening := h.opening
+			n.Location = &opening
+			root.Children = append(root.Children, &NormalNode{
+				Type:     "heredoc_body",
+				Field:    "heredoc_bodies",
+				Location: &body,
+				Children: n.Children,
+			})
+			n.Children = []*NormalNode{}
+		}
+	}
+}
+
+// interpolatedTypes are prism's strings, symbols, regexps and commands with
+// interpolation, whose text parts are string nodes.
+var interpolatedTypes = map[string]bool{
+	"interpolated_string": true, "interpolated_symbol": true, "interpolated_regular_expression": true,
+	"interpolated_match_last_line": true, "interpolated_x_string": true,
+}
+
+// expandInterpolation gives the strings, symbols, regexps, commands and
+// heredocs below n the same parts whichever parser read them: "text" nodes
+// for the literal text, and "interpolation" nodes for each #{} or #@var,
+// with the code in it as their children.
+func expandInterpolation(n *NormalNode) {
+	for _, child := range n.Children {
+		switch child.Type {
+		case "tstring_content":
+			child.Type = "text"
+		case "string":
+			if interpolatedTypes[n.Type] {
+				child.Type = "text"
+			}
+		case "string_embexpr", "embedded_statements":
+			child.Type = "interpolation"
+			if len(child.Children) == 1 && child.Children[0].Type == "statements" {
+				child.Children = child.Children[0].Children
+			}
+		case "string_dvar", "embedded_variable":
+			child.Type = "interpolation"
+		}
+		expandInterpolation(child)
+	}
+}
@@ -0,0 +1,131 @@
+package analyze
+
+// Symbol is a class, module, constant or method definition, with those
+// defined inside it as its children.
+type Symbol struct {
+	Kind          string    `json:"kind"`
+	Name          string    `json:"name"`
+	QualifiedName string    `json:"qualified_name"`
+	Location      *Location `json:"location,omitempty"`
+	Children      []*Symbol `json:"children,omitempty"`
+}
+
+// assignedConst returns the constant an assignment target names, for the
+// stree var_field of FOO = 1, the const_path_field of Foo::BAR = 1 and the
+// top_const_field of ::FOO = 1.
+func assignedConst(target *Node) string {
+	switch target.Type {
+	case "var_field":
+		if v, _ := target.field("value"); v != nil {
+			if c, ok := v.(*Node); ok && c.Type == "const" {
+				return constName(c)
+			}
+		}
+	case "const_path_field":
+		parent, _ := target.field("parent")
+		constant, _ := target.field("constant")
+		p, ok1 := parent.(*Node)
+		c, ok2 := constant.(*Node)
+		if ok1 && ok2 {
+			if left, right := constName(p), constName(c); left != "" && right != "" {
+				return left + "::" + right
+			}
+		}
+	case "top_const_field":
+		if c, _ := target.field("constant"); c != nil {
+			if ref, ok := c.(*Node); ok {
+				if name := constName(ref); name != "" {
+					return "::" + name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// qualifyConst names a constant written inside namespace.
+func qualifyConst(name, namespace string) string {
+	switch {
+	case name != "" && name[0] == ':':
+		return name[2:]
+	case namespace != "":
+		return namespace + "::" + name
+	}
+	return name
+}
+
+// FindSymbols lists what a tree defines, nested as in the source. Methods in
+// a class << self body are singleton methods of the class. Like
+// FindDefinitions it reads stree's node types; with other parsers only the
+// methods are found.
+func FindSymbols(root *Node) []*Symbol {
+	symbols := []*Symbol{}
+	var visit func(n *Node, namespace string, singleton bool, parent *Symbol)
+	visit = func(n *Node, namespace string, singleton bool, parent *Symbol) {
+		add := func(sym *Symbol) {
+			if loc, ok := n.location(); ok {
+				sym.Location = &loc
+			}
+			if parent == nil {
+				symbols = append(symbols, sym)
+			} else {
+				parent.Children = append(parent.Children, sym)
+			}
+		}
+
+		switch n.Type {
+		case "class", "module":
+			if inner := enterNamespace(n, namespace); inner != namespace {
+				sym := &Symbol{Kind: n.Type, Name: lastSegment(inner), QualifiedName: inner}
+				add(sym)
+				namespace, singleton, parent = inner, false, sym
+			}
+		case "sclass":
+			target, _ := n.field("target")
+			if ref, ok := target.(*Node); ok && ref.Type == "var_ref" {
+				if kw, ok := ref.field("value"); ok {
+					if v, ok := kw.(*Node); ok {
+						name, _ := v.value()
+						singleton = name == "self"
+					}
+				}
+			}
+		case "def", "defs":
+			name, isSingleton := methodName(n)
+			sym := &Symbol{Kind: "method", Name: name, QualifiedName: qualifiedMethodName(n, namespace)}
+			if singleton && !isSingleton {
+				sym.QualifiedName = "self." + name
+				if namespace != "" {
+					sym.QualifiedName = namespace + "." + name
+				}
+			}
+			if singleton || isSingleton {
+				sym.Kind = "singleton_method"
+			}
+			add(sym)
+			// A method body starts afresh: defs in it are instance methods.
+			singleton, parent = false, sym
+		case "assign", "opassign":
+			target, _ := n.field("target")
+			if ref, ok := target.(*Node); ok {
+				if name := assignedConst(ref); name != "" {
+					add(&Symbol{Kind: "constant", Name: lastSegment(name), QualifiedName: qualifyConst(name, namespace)})
+				}
+			}
+		}
+		for _, edge := range n.children() {
+			visit(edge.Node, namespace, singleton, parent)
+		}
+	}
+	visit(root, "", false, nil)
+	return symbols
+}
+
+func lastSegment(name string) string {
+	for i := len(name) - 1; i > 0; i-- {
+		if name[i] == ':' && name[i-1] == ':' {
+			return name[i+1:]
+		}
+	}
+	return name
+}
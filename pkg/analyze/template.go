@@ -0,0 +1,322 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// MaxTemplateExpressions caps how many embedded expressions one template
+// request parses.
+const MaxTemplateExpressions = 1000
+
+// Kinds of embedded Ruby an extractor finds.
+const (
+	exprCode          = "code"          // run for its effect, like Haml's "- x"
+	exprOutput        = "output"        // rendered into the page, like "= x"
+	exprAttributes    = "attributes"    // a Haml attribute hash or object reference
+	exprAttribute     = "attribute"     // a single Slim attribute value
+	exprInterpolation = "interpolation" // #{} inside template text
+)
+
+// TemplateExpr is a piece of Ruby found in a template, starting Offset bytes
+// into it.
+type TemplateExpr struct {
+	Kind   string
+	Code   string
+	Offset int
+}
+
+// TemplateExtractors pull the embedded Ruby out of templates whose Ruby
+// can't be parsed as one program. ERB can be, and is handled by /parse.
+var TemplateExtractors = map[string]func(template string) ([]TemplateExpr, error){
+	"haml": extractHaml,
+	"slim": extractSlim,
+}
+
+// templatePosition converts a byte offset into template into the 1-based line
+// and 0-based column syntax errors are reported with.
+func templatePosition(template string, offset int) (line, column int) {
+	before := template[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:])
+	return line, column
+}
+
+// templateLine is one line of a template, without its newline.
+type templateLine struct {
+	Text   string
+	Offset int
+	Indent int
+}
+
+func splitTemplateLines(template string) []templateLine {
+	var lines []templateLine
+	offset := 0
+	for _, text := range strings.SplitAfter(template, "\n") {
+		if text == "" {
+			continue
+		}
+		line := strings.TrimRight(text, "\r\n")
+		trimmed := strings.TrimLeft(line, " \t")
+		lines = append(lines, templateLine{Text: line, Offset: offset, Indent: len(line) - len(trimmed)})
+		offset += len(text)
+	}
+	return lines
+}
+
+// blank reports whether the line has nothing but whitespace.
+func (l templateLine) blank() bool {
+	return l.Indent == len(l.Text)
+}
+
+// matchBracket returns the index in s of the bracket closing the one at
+// open, skipping over quoted strings, or -1 if it is never closed.
+func matchBracket(s string, open int) int {
+	closing := map[byte]byte{'{': '}', '(': ')', '[': ']'}[s[open]]
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\'':
+			for i++; i < len(s) && s[i] != c; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case s[open]:
+			depth++
+		case closing:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// interpolations finds the #{} expressions in a stretch of template text
+// starting offset bytes into the template.
+func interpolations(text string, offset int) []TemplateExpr {
+	var exprs []TemplateExpr
+	for i := 0; i+1 < len(text); i++ {
+		if text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] != '#' || text[i+1] != '{' {
+			continue
+		}
+		end := matchBracket(text, i+1)
+		if end < 0 {
+			break
+		}
+		exprs = append(exprs, TemplateExpr{Kind: exprInterpolation, Code: text[i+2 : end], Offset: offset + i + 2})
+		i = end
+	}
+	return exprs
+}
+
+// templateScanner walks a template line by line on behalf of an extractor,
+// collecting the expressions it finds.
+type templateScanner struct {
+	template string
+	lines    []templateLine
+	i        int
+	exprs    []TemplateExpr
+}
+
+func newTemplateScanner(template string) *templateScanner {
+	return &templateScanner{template: template, lines: splitTemplateLines(template)}
+}
+
+// lineEnd is the offset of the end of the current line, before its newline.
+func (sc *templateScanner) lineEnd() int {
+	line := sc.lines[sc.i]
+	return line.Offset + len(line.Text)
+}
+
+// skipSpaces returns the offset of the first character at or after pos on
+// the current line that isn't a space or tab.
+func (sc *templateScanner) skipSpaces(pos int) int {
+	for end := sc.lineEnd(); pos < end && (sc.template[pos] == ' ' || sc.template[pos] == '\t'); pos++ {
+	}
+	return pos
+}
+
+// emit records the Ruby from pos to the end of the line as an expression.
+// Code ending in a comma or backslash carries on over the following lines,
+// as both Haml and Slim allow.
+func (sc *templateScanner) emit(kind string, pos int) {
+	pos = sc.skipSpaces(pos)
+	for sc.i+1 < len(sc.lines) {
+		trimmed := strings.TrimRight(sc.lines[sc.i].Text, " \t")
+		if !strings.HasSuffix(trimmed, ",") && !strings.HasSuffix(trimmed, `\`) {
+			break
+		}
+		sc.i++
+	}
+	code := strings.TrimRight(sc.template[pos:sc.lineEnd()], " \t")
+	if code != "" {
+		sc.exprs = append(sc.exprs, TemplateExpr{Kind: kind, Code: code, Offset: pos})
+	}
+}
+
+// interpolate records the #{} expressions in the text from pos to end.
+func (sc *templateScanner) interpolate(pos, end int) {
+	sc.exprs = append(sc.exprs, interpolations(sc.template[pos:end], pos)...)
+}
+
+// bracket returns the offset of the bracket closing the one at open, which
+// may be on a later line; the scanner moves on to that line.
+func (sc *templateScanner) bracket(open int) (int, error) {
+	end := matchBracket(sc.template, open)
+	if end < 0 {
+		line, column := templatePosition(sc.template, open)
+		return 0, &parser.SyntaxError{Message: fmt.Sprintf("unclosed %q", sc.template[open]), Line: line, Column: column}
+	}
+	for sc.i+1 < len(sc.lines) && sc.lines[sc.i+1].Offset <= end {
+		sc.i++
+	}
+	return end, nil
+}
+
+// nestedNext reports whether the line after the current one is blank or
+// indented deeper than indent, and so belongs to a block opened there.
+func (sc *templateScanner) nestedNext(indent int) bool {
+	if sc.i+1 >= len(sc.lines) {
+		return false
+	}
+	next := sc.lines[sc.i+1]
+	return next.blank() || next.Indent > indent
+}
+
+var (
+	blockOpener = regexp.MustCompile(`(?:^|[\s;)])do\s*(?:\|[^|]*\|)?\s*$|^\s*(?:if|unless|while|until|for|begin|def|class|module)\b`)
+	caseOpener  = regexp.MustCompile(`^\s*case\b`)
+	midBlock    = regexp.MustCompile(`^\s*(elsif|else|when|in|rescue|ensure|end)\b`)
+)
+
+// WrapExpr returns what has to go around a code or output expression for it
+// to parse on its own. Templates open blocks with a line like "- if x" and
+// close them by indentation, so an opener gets an end added, and a line such
+// as "- else" gets the start of the statement it belongs to.
+func WrapExpr(e TemplateExpr) (prefix, suffix string) {
+	if e.Kind != exprCode && e.Kind != exprOutput {
+		return "", ""
+	}
+	if m := midBlock.FindStringSubmatch(e.Code); m != nil {
+		switch m[1] {
+		case "elsif", "else":
+			return "if nil\n", "\nend"
+		case "when", "in":
+			return "case nil\n", "\nend"
+		case "rescue", "ensure":
+			return "begin\n", "\nend"
+		default:
+			return "begin\n", ""
+		}
+	}
+	switch {
+	case caseOpener.MatchString(e.Code):
+		return "", "\nwhen nil\nend"
+	case blockOpener.MatchString(e.Code):
+		return "", "\nend"
+	}
+	return "", ""
+}
+
+// ExprMapping maps character offsets in a wrapped expression back to the
+// template it came from.
+type ExprMapping struct {
+	PrefixChars int
+	CodeChars   int
+	newlines    []int // char offsets of the newlines in the expression
+
+	startLine, startColumn, startChar int
+}
+
+func NewExprMapping(template string, e TemplateExpr, prefix string) *ExprMapping {
+	m := &ExprMapping{
+		PrefixChars: utf8.RuneCountInString(prefix),
+		CodeChars:   utf8.RuneCountInString(e.Code),
+		startChar:   utf8.RuneCountInString(template[:e.Offset]),
+	}
+	m.startLine, m.startColumn = templatePosition(template, e.Offset)
+	char := 0
+	for _, r := range e.Code {
+		if r == '\n' {
+			m.newlines = append(m.newlines, char)
+		}
+		char++
+	}
+	return m
+}
+
+// point maps a char offset in the wrapped expression to the template. Offsets
+// in the added prefix or suffix are clamped to the expression's ends.
+func (m *ExprMapping) Point(char int) (line, column, templateChar int) {
+	rel := char - m.PrefixChars
+	if rel < 0 {
+		rel = 0
+	} else if rel > m.CodeChars {
+		rel = m.CodeChars
+	}
+	line, column = m.startLine, m.startColumn+rel
+	for _, nl := range m.newlines {
+		if nl >= rel {
+			break
+		}
+		line++
+		column = rel - nl - 1
+	}
+	return line, column, m.startChar + rel
+}
+
+// remap rewrites every location in the tree to point into the template.
+func (m *ExprMapping) Remap(n *Node) {
+	for i, f := range n.Fields {
+		if f.Name == "location" {
+			if loc, ok := n.location(); ok {
+				startLine, _, startChar := m.Point(loc.StartChar)
+				endLine, _, endChar := m.Point(loc.EndChar)
+				n.Fields[i].Value = []interface{}{
+					json.Number(fmt.Sprint(startLine)), json.Number(fmt.Sprint(startChar)),
+					json.Number(fmt.Sprint(endLine)), json.Number(fmt.Sprint(endChar)),
+				}
+			}
+			continue
+		}
+		m.remapValue(f.Value)
+	}
+}
+
+func (m *ExprMapping) remapValue(value interface{}) {
+	switch v := value.(type) {
+	case *Node:
+		m.Remap(v)
+	case []interface{}:
+		for _, element := range v {
+			m.remapValue(element)
+		}
+	}
+}
+
+// remapError moves a syntax error's position from the wrapped expression
+// into the template.
+func (m *ExprMapping) RemapError(se *parser.SyntaxError, snippet string) *parser.SyntaxError {
+	char := 0
+	if se.Line > 0 {
+		lines := strings.SplitAfter(snippet, "\n")
+		for i := 0; i < se.Line-1 && i < len(lines); i++ {
+			char += utf8.RuneCountInString(lines[i])
+		}
+		char += se.Column
+	}
+	mapped := *se
+	mapped.Line, mapped.Column, _ = m.Point(char)
+	return &mapped
+}
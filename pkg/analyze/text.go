@@ -0,0 +1,90 @@
+package analyze
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeSexp renders the tree as Lisp-style s-expressions in the layout
+// ruby-parse uses: a node's scalar fields stay on its line and each child
+// node starts a new, further indented one.
+//
+//	(command
+//	  (ident "puts")
+//	  (args
+//	    (int "1")) nil)
+func writeSexp(w io.Writer, root *Node, _ FormatOptions) error {
+	bw := bufio.NewWriter(w)
+	writeSexpNode(bw, root, 0)
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+func writeSexpNode(w *bufio.Writer, n *Node, depth int) {
+	w.WriteString("(" + n.Type)
+	for _, f := range n.Fields {
+		if f.Name == "type" || f.Name == "location" {
+			continue
+		}
+		writeSexpValue(w, f.Value, depth)
+	}
+	w.WriteByte(')')
+}
+
+func writeSexpValue(w *bufio.Writer, value interface{}, depth int) {
+	switch v := value.(type) {
+	case *Node:
+		if v.Type == "" {
+			for _, f := range v.Fields {
+				writeSexpValue(w, f.Value, depth)
+			}
+			return
+		}
+		w.WriteString("\n" + strings.Repeat("  ", depth+1))
+		writeSexpNode(w, v, depth+1)
+	case []interface{}:
+		for _, element := range v {
+			writeSexpValue(w, element, depth)
+		}
+	case string:
+		w.WriteString(" " + strconv.Quote(v))
+	case json.Number:
+		w.WriteString(" " + v.String())
+	case bool:
+		w.WriteString(" " + strconv.FormatBool(v))
+	case nil:
+		w.WriteString(" nil")
+	}
+}
+
+// writeTree renders the tree as indented plain text, one node per line with
+// the field it came from and its source range.
+//
+//	program 1:0-1:6
+//	  statements: statements 1:0-1:6
+//	    body: command 1:0-1:6
+func writeTree(w io.Writer, root *Node, _ FormatOptions) error {
+	bw := bufio.NewWriter(w)
+	var visit func(field string, n *Node, depth int)
+	visit = func(field string, n *Node, depth int) {
+		bw.WriteString(strings.Repeat("  ", depth))
+		if field != "" {
+			bw.WriteString(field + ": ")
+		}
+		bw.WriteString(n.label())
+		if loc, ok := n.location(); ok {
+			fmt.Fprintf(bw, " %s", loc)
+		}
+		bw.WriteByte('\n')
+
+		for _, edge := range n.children() {
+			visit(edge.Field, edge.Node, depth+1)
+		}
+	}
+	visit("", root, 0)
+	return bw.Flush()
+}
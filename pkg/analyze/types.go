@@ -0,0 +1,302 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// MethodParam is one parameter in a method signature, with its type as
+// Annotation; a "type" would read as a node's. Kind is required, optional,
+// rest, keyword, optional_keyword, rest_keyword or block in RBS; a Sorbet
+// sig only names its parameters, so it is empty there.
+type MethodParam struct {
+	Name       string `json:"name,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Annotation string `json:"annotation"`
+}
+
+// MethodOverload is one way to call a method. Types are written as in the
+// signature, such as Array[String] in RBS or T::Array[String] in a sig.
+type MethodOverload struct {
+	Signature string        `json:"signature"`
+	Params    []MethodParam `json:"params"`
+	Returns   string        `json:"returns"`
+}
+
+// MethodSignature is a method declared in RBS. Kind is instance, singleton
+// or singleton_instance, for a module_function.
+type MethodSignature struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	Overloads []MethodOverload `json:"overloads"`
+}
+
+// DecodeMethodSignatures reads the output of the rbs-methods parser.
+func DecodeMethodSignatures(output []byte) ([]MethodSignature, error) {
+	var result struct {
+		Methods []MethodSignature `json:"methods"`
+	}
+	err := json.Unmarshal(output, &result)
+	return result.Methods, err
+}
+
+// qualifiedNames names the methods m declares as FindSymbols names them.
+func (m MethodSignature) qualifiedNames() []string {
+	instance, singleton := m.Name, "self."+m.Name
+	if m.Namespace != "" {
+		instance, singleton = m.Namespace+"#"+m.Name, m.Namespace+"."+m.Name
+	}
+	switch m.Kind {
+	case "singleton":
+		return []string{singleton}
+	case "singleton_instance":
+		return []string{instance, singleton}
+	}
+	return []string{instance}
+}
+
+// TypedMethod is a def AttachTypes gave types to, and where they came from:
+// "sig" or "rbs".
+type TypedMethod struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+// AttachTypes adds a "types" field, with its source and overloads, to each
+// def in root, parsed from code, that has a Sorbet sig just above it or is
+// declared in signatures; a sig wins over RBS. Where the parser's constants
+// can't be read to qualify a method's name, as with prism, it still matches
+// the one RBS method of that name and kind. It returns the defs typed, and
+// the RBS methods that matched none of them.
+func AttachTypes(root *Node, code string, signatures []MethodSignature) (typed []TypedMethod, unmatched []string) {
+	names := make(map[Location]string)
+	var collect func(symbols []*Symbol)
+	collect = func(symbols []*Symbol) {
+		for _, sym := range symbols {
+			if sym.Location != nil && (sym.Kind == "method" || sym.Kind == "singleton_method") {
+				names[*sym.Location] = sym.QualifiedName
+			}
+			collect(sym.Children)
+		}
+	}
+	collect(FindSymbols(root))
+
+	declared := make(map[string]*MethodSignature)
+	bare := make(map[string][]string)
+	for i := range signatures {
+		for _, name := range signatures[i].qualifiedNames() {
+			declared[name] = &signatures[i]
+			bare[bareName(name)] = append(bare[bareName(name)], name)
+		}
+	}
+
+	sigs := findSigs(root, code)
+	used := make(map[string]bool)
+	for _, t := range flattenTree(root) {
+		if t.Node.Type != "def" && t.Node.Type != "defs" {
+			continue
+		}
+		loc, _ := t.Node.location()
+		name := names[loc]
+		if _, ok := sigs[t.Node]; !ok && name == "" {
+			continue
+		}
+		types := struct {
+			Source    string           `json:"source"`
+			Overloads []MethodOverload `json:"overloads"`
+		}{Source: "sig"}
+		if sig, ok := sigs[t.Node]; ok {
+			types.Overloads = []MethodOverload{sig}
+		} else {
+			match := name
+			if _, ok := declared[name]; !ok && !strings.ContainsAny(strings.TrimPrefix(name, "self."), "#.") {
+				if candidates := bare[bareName(name)]; len(candidates) == 1 {
+					match = candidates[0]
+				}
+			}
+			m, ok := declared[match]
+			if !ok {
+				continue
+			}
+			used[match] = true
+			types.Source, types.Overloads = "rbs", m.Overloads
+		}
+		data, _ := json.Marshal(types)
+		node, _ := DecodeAST(data)
+		t.Node.Fields = append(t.Node.Fields, ASTField{Name: "types", Value: node})
+		typed = append(typed, TypedMethod{Name: name, Path: t.Path, Source: types.Source})
+	}
+	for i := range signatures {
+		for _, name := range signatures[i].qualifiedNames() {
+			if !used[name] {
+				unmatched = append(unmatched, name)
+			}
+		}
+	}
+	return typed, unmatched
+}
+
+// bareName drops the namespace from a qualified method name, keeping # or .
+// for an instance or singleton method.
+func bareName(name string) string {
+	i := strings.LastIndexAny(name, "#.")
+	if i < 0 {
+		return "#" + name
+	}
+	return name[i:]
+}
+
+// findSigs reads the sig { ... } call before each def in a list of
+// statements.
+func findSigs(root *Node, code string) map[*Node]MethodOverload {
+	chars := []rune(code)
+	sigs := make(map[*Node]MethodOverload)
+	for _, t := range flattenTree(root) {
+		if t.Node.Type != "statements" {
+			continue
+		}
+		body, _ := t.Node.field("body")
+		stmts := typedNodes(body)
+		for i := 1; i < len(stmts); i++ {
+			if stmts[i].Type != "def" && stmts[i].Type != "defs" {
+				continue
+			}
+			loc, ok := stmts[i-1].location()
+			if !ok || loc.StartChar < 0 || loc.EndChar > len(chars) || loc.StartChar > loc.EndChar {
+				continue
+			}
+			if sig, ok := parseSig(string(chars[loc.StartChar:loc.EndChar])); ok {
+				sigs[stmts[i]] = sig
+			}
+		}
+	}
+	return sigs
+}
+
+// parseSig reads the parameter and return types from the source of a
+// Sorbet sig, as in sig { params(x: Integer).returns(String) }.
+func parseSig(text string) (MethodOverload, bool) {
+	rest, ok := strings.CutPrefix(text, "sig")
+	if !ok || rest == "" || isIdentChar(rune(rest[0])) {
+		return MethodOverload{}, false
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "(") {
+		// sig(:final)
+		rest = strings.TrimSpace(rest[closing(rest, 0):])
+	}
+	switch {
+	case strings.HasPrefix(rest, "{") && strings.HasSuffix(rest, "}"):
+		rest = rest[1 : len(rest)-1]
+	case strings.HasPrefix(rest, "do") && strings.HasSuffix(rest, "end"):
+		rest = rest[2 : len(rest)-3]
+	default:
+		return MethodOverload{}, false
+	}
+
+	sig := MethodOverload{Signature: strings.Join(strings.Fields(rest), " "), Params: []MethodParam{}}
+	for _, call := range topLevelCalls(rest) {
+		switch call.name {
+		case "params":
+			for _, arg := range splitTopLevel(call.args) {
+				name, typ, ok := strings.Cut(arg, ":")
+				if ok {
+					sig.Params = append(sig.Params, MethodParam{Name: strings.TrimSpace(name), Annotation: strings.TrimSpace(typ)})
+				}
+			}
+		case "returns":
+			sig.Returns = strings.TrimSpace(call.args)
+		case "void":
+			sig.Returns = "void"
+		}
+	}
+	return sig, true
+}
+
+type sigCall struct {
+	name, args string
+}
+
+// topLevelCalls lists the method calls in a chain such as
+// params(x: Integer).returns(String), with their arguments, leaving out any
+// inside the arguments.
+func topLevelCalls(text string) []sigCall {
+	var calls []sigCall
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		if !isIdentChar(c) {
+			if c == '(' || c == '[' || c == '{' {
+				i = closing(text, i)
+			} else {
+				i++
+			}
+			continue
+		}
+		start := i
+		for i < len(text) && isIdentChar(rune(text[i])) {
+			i++
+		}
+		call := sigCall{name: text[start:i]}
+		if i < len(text) && text[i] == '(' {
+			end := closing(text, i)
+			call.args = text[i+1 : max(i+1, end-1)]
+			i = end
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// closing returns the offset just past the bracket matching the one at
+// open, or the end of text if it isn't closed.
+func closing(text string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(text)
+}
+
+// splitTopLevel splits arguments at the commas outside brackets.
+func splitTopLevel(args string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '(', '[', '{':
+			i = closing(args, i) - 1
+		case ',':
+			parts = append(parts, args[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(args[start:]) != "" {
+		parts = append(parts, args[start:])
+	}
+	return parts
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
@@ -0,0 +1,354 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// decodeYAML reads the part of YAML that rule files use: block mappings and
+// sequences, plain and quoted scalars, | and > block scalars, flow lists of
+// scalars, and comments. Every scalar comes back as a string, and null, ~
+// and empty values as nil.
+func decodeYAML(src string) (interface{}, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")}
+	p.skipBlank()
+	if p.pos < len(p.lines) && strings.TrimSpace(p.lines[p.pos]) == "---" {
+		p.pos++
+		p.skipBlank()
+	}
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	v, err := p.node(p.indent())
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// skipBlank moves past empty and comment-only lines, and a document end.
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) {
+		line := strings.TrimSpace(p.lines[p.pos])
+		if line != "" && !strings.HasPrefix(line, "#") && line != "..." {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *yamlParser) indent() int {
+	line := p.lines[p.pos]
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// text is the current line without its indentation.
+func (p *yamlParser) text() string {
+	return strings.TrimRight(p.lines[p.pos][p.indent():], " \t")
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// node reads the block node starting on the current line, which is indented
+// by indent.
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	if strings.HasPrefix(p.text(), "\t") {
+		return nil, p.errorf("tabs can't be used for indentation")
+	}
+	if isSequenceItem(p.text()) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(p.text()); ok {
+		return p.mapping(indent)
+	}
+	v, err := yamlScalar(p.text())
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.pos++
+	return v, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.skipBlank(); p.pos < len(p.lines) && p.indent() == indent && isSequenceItem(p.text()); p.skipBlank() {
+		rest := strings.TrimLeft(strings.TrimPrefix(p.text(), "-"), " ")
+		if strings.TrimSpace(rest) == "" {
+			p.pos++
+			if p.skipBlank(); p.pos == len(p.lines) || p.indent() <= indent {
+				list = append(list, nil)
+				continue
+			}
+			v, err := p.node(p.indent())
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		// Read what follows the dash as if it started its own line, so a
+		// mapping's later keys line up with its first.
+		inner := indent + len(p.text()) - len(rest)
+		p.lines[p.pos] = strings.Repeat(" ", inner) + rest
+		v, err := p.node(inner)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.skipBlank(); p.pos < len(p.lines) && p.indent() == indent && !isSequenceItem(p.text()); p.skipBlank() {
+		key, rest, ok := splitYAMLKey(p.text())
+		if !ok {
+			return nil, p.errorf("expected a key")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("key %q appears twice", key)
+		}
+		var v interface{}
+		var err error
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			p.pos++
+			p.skipBlank()
+			switch {
+			case p.pos < len(p.lines) && p.indent() > indent:
+				v, err = p.node(p.indent())
+			case p.pos < len(p.lines) && p.indent() == indent && isSequenceItem(p.text()):
+				v, err = p.sequence(indent)
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			v, err = p.blockScalar(indent, rest)
+		default:
+			v, err = yamlScalar(rest)
+			if err != nil {
+				err = p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// blockScalar reads a | (literal) or > (folded) scalar whose header is on
+// the current line, with its optional - or + chomping indicator.
+func (p *yamlParser) blockScalar(indent int, header string) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := ""
+	if h := strings.TrimSpace(strings.SplitN(header[1:], "#", 2)[0]); h == "-" || h == "+" {
+		chomp = h
+	} else if h != "" {
+		return nil, p.errorf("unsupported block scalar header %q", header)
+	}
+	p.pos++
+
+	var lines []string
+	contentIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if n <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = n
+		}
+		if n < contentIndent {
+			return nil, p.errorf("block scalar line is less indented than the first")
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+	trailing := 0
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	body := lines[:len(lines)-trailing]
+
+	var s string
+	if folded {
+		var b strings.Builder
+		for i, line := range body {
+			switch {
+			case i == 0:
+			case line == "":
+				// Blank lines are the breaks, with the one they follow
+				// folded away.
+				b.WriteByte('\n')
+			case body[i-1] == "":
+			case strings.HasPrefix(line, " ") || strings.HasPrefix(body[i-1], " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		s = b.String()
+	} else {
+		s = strings.Join(body, "\n")
+	}
+	switch {
+	case chomp == "-" || len(body) == 0:
+	case chomp == "+":
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// splitYAMLKey splits "key: value" into its key, unquoted, and the rest.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text == "" || text[0] == '#' || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	end := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end = closingQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		end++
+		if end < len(text) && text[end] != ':' {
+			return "", "", false
+		}
+	} else {
+		for end < len(text) && !(text[end] == ':' && (end+1 == len(text) || text[end+1] == ' ')) {
+			if text[end] == ' ' && end+1 < len(text) && text[end+1] == '#' {
+				return "", "", false
+			}
+			end++
+		}
+	}
+	if end >= len(text) || text[end] != ':' || (end+1 < len(text) && text[end+1] != ' ') {
+		return "", "", false
+	}
+	k, err := yamlScalar(text[:end])
+	if err != nil {
+		return "", "", false
+	}
+	key, _ = k.(string)
+	return key, strings.TrimSpace(text[end+1:]), true
+}
+
+// closingQuote finds the quote ending the quoted scalar text starts with.
+func closingQuote(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case q == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlScalar reads a value on one line: quoted, a flow list, or plain with
+// any trailing comment dropped.
+func yamlScalar(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	switch text[0] {
+	case '"', '\'':
+		end := closingQuote(text)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		if after := strings.TrimSpace(text[end+1:]); after != "" && !strings.HasPrefix(after, "#") {
+			return nil, fmt.Errorf("unexpected %q after a quoted string", after)
+		}
+		if text[0] == '\'' {
+			return strings.ReplaceAll(text[1:end], "''", "'"), nil
+		}
+		var s string
+		if err := json.Unmarshal([]byte(text[:end+1]), &s); err != nil {
+			return nil, fmt.Errorf("invalid double-quoted string")
+		}
+		return s, nil
+	case '[':
+		return yamlFlowList(text)
+	case '{':
+		return nil, fmt.Errorf("flow mappings aren't supported; quote a value starting with {")
+	case '|', '>', '&', '*', '!':
+		return nil, fmt.Errorf("unsupported YAML syntax %q", text[:1])
+	}
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	if text == "~" || text == "null" {
+		return nil, nil
+	}
+	return text, nil
+}
+
+// yamlFlowList reads [a, "b", c] into a list of scalars.
+func yamlFlowList(text string) (interface{}, error) {
+	end := strings.LastIndexByte(text, ']')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated flow list")
+	}
+	if after := strings.TrimSpace(text[end+1:]); after != "" && !strings.HasPrefix(after, "#") {
+		return nil, fmt.Errorf("unexpected %q after a flow list", after)
+	}
+	list := []interface{}{}
+	inner := strings.TrimSpace(text[1:end])
+	for inner != "" {
+		var item string
+		if inner[0] == '"' || inner[0] == '\'' {
+			e := closingQuote(inner)
+			if e < 0 {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+			item, inner = inner[:e+1], strings.TrimSpace(inner[e+1:])
+			if inner != "" && inner[0] != ',' {
+				return nil, fmt.Errorf("expected , in flow list")
+			}
+		} else if i := strings.IndexByte(inner, ','); i >= 0 {
+			item, inner = inner[:i], inner[i:]
+		} else {
+			item, inner = inner, ""
+		}
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+		if strings.ContainsAny(item, "[]{}") {
+			return nil, fmt.Errorf("nested flow collections aren't supported")
+		}
+		v, err := yamlScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
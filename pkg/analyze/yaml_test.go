@@ -0,0 +1,79 @@
+package analyze
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "", `null`},
+		{"comments only", "# nothing\n\n", `null`},
+		{"scalar", "hello", `"hello"`},
+		{"mapping", "a: 1\nb: x y", `{"a":"1","b":"x y"}`},
+		{"nested mapping", "a:\n  b: c\n  d:\n    - e\n    - f\ng: h", `{"a":{"b":"c","d":["e","f"]},"g":"h"}`},
+		{"sequence at the key's indentation", "a:\n- x\n- y\nb: z", `{"a":["x","y"],"b":"z"}`},
+		{"sequence of mappings", "- id: a\n  pattern: b\n-\n  id: c\n-", `[{"id":"a","pattern":"b"},{"id":"c"},null]`},
+		{"nested sequence", "- - a\n  - b\n- c", `[["a","b"],"c"]`},
+		{"nulls", "a:\nb: ~\nc: null\nd: ''", `{"a":null,"b":null,"c":null,"d":""}`},
+		{"double-quoted", `a: "x: \"y\" # z\u00e9"`, `{"a":"x: \"y\" # zé"}`},
+		{"single-quoted", "a: 'it''s # not a comment'", `{"a":"it's # not a comment"}`},
+		{"quoted key", `"a b": c` + "\n'd:e': f", `{"a b":"c","d:e":"f"}`},
+		{"colons in values", "a: b:c\nd: http://x", `{"a":"b:c","d":"http://x"}`},
+		{"comments", "# head\na: b # trailing\n  # indented\nc: d#e", `{"a":"b","c":"d#e"}`},
+		{"flow list", `a: [x, "y, z", 'w', ~]` + "\nb: [] # empty", `{"a":["x","y, z","w",null],"b":[]}`},
+		{"literal block", "a: |\n  one\n   two\n\n  three\nb: x", `{"a":"one\n two\n\nthree\n","b":"x"}`},
+		{"folded block", "a: >\n  one\n  two\n\n  three\n    four\nb: x", `{"a":"one two\nthree\n  four\n","b":"x"}`},
+		{"folded blank lines", "a: >\n  x\n\n\n  y", `{"a":"x\n\ny\n"}`},
+		{"strip", "a: |-\n  x\n\n", `{"a":"x"}`},
+		{"keep", "a: |+\n  x\n\nb: y", `{"a":"x\n\n","b":"y"}`},
+		{"empty block", "a: |\nb: y", `{"a":"","b":"y"}`},
+		{"document markers", "---\na: b\n...\n", `{"a":"b"}`},
+		{"CRLF", "a: b\r\nc:\r\n  - d\r\n", `{"a":"b","c":["d"]}`},
+	}
+	for _, tt := range tests {
+		v, err := decodeYAML(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, _ := json.Marshal(v)
+		if string(got) != tt.want {
+			t.Errorf("%s: decodeYAML = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a: b\na: c", `line 2: key "a" appears twice`},
+		{"a: b\n  c: d", "line 2: unexpected indentation"},
+		{"\ta: b", "line 1: tabs can't be used for indentation"},
+		{"a: [x", "line 1: unterminated flow list"},
+		{"a: [[x]]", "line 1: nested flow collections aren't supported"},
+		{"a: [x, 'y]", "line 1: unterminated quoted string"},
+		{"a: {b: c}", "line 1: flow mappings aren't supported; quote a value starting with {"},
+		{"a: &x b", `line 1: unsupported YAML syntax "&"`},
+		{"a: *x", `line 1: unsupported YAML syntax "*"`},
+		{"a: !!str b", `line 1: unsupported YAML syntax "!"`},
+		{`a: "x`, "line 1: unterminated quoted string"},
+		{`a: "x" y`, `line 1: unexpected "y" after a quoted string`},
+		{`a: "\q"`, "line 1: invalid double-quoted string"},
+		{"a: |2\n  x", `line 1: unsupported block scalar header "|2"`},
+		{"a: |\n    x\n  y", "line 3: block scalar line is less indented than the first"},
+		{"a:\n  - x\n  y", "line 3: unexpected indentation"},
+	}
+	for _, tt := range tests {
+		_, err := decodeYAML(tt.src)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("decodeYAML(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
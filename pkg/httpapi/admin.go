@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var startTime = time.Now()
+
+// adminRoutes returns the diagnostic endpoints: the pprof profiles and
+// /debug/stats.
+func (s *server) adminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/stats", s.handleStats)
+	return mux
+}
+
+// requireAdmin only lets requests through that carry -admin-token as a
+// bearer token.
+func (s *server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "Admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleStats reports runtime, cache and worker pool state for diagnosing
+// slowdowns.
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	entries, cacheBytes := s.cache.stats()
+	hits, misses := cacheLookups.Value("hit"), cacheLookups.Value("miss")
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = hits / (hits + misses)
+	}
+	running, idle := s.pool.Running(), s.pool.IdleCount()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]interface{}{
+		"uptime_seconds": time.Since(startTime).Seconds(),
+		"goroutines":     runtime.NumGoroutine(),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"heap_inuse_bytes": mem.HeapInuse,
+			"sys_bytes":        mem.Sys,
+			"gc_cycles":        mem.NumGC,
+			"gc_pause_total":   time.Duration(mem.PauseTotalNs).String(),
+		},
+		"cache": map[string]interface{}{
+			"entries":  entries,
+			"capacity": s.cfg.CacheSize,
+			"bytes":    cacheBytes,
+			"hits":     hits,
+			"misses":   misses,
+			"hit_rate": hitRate,
+		},
+		"workers": map[string]interface{}{
+			"size":       s.pool.Size,
+			"running":    running,
+			"idle":       idle,
+			"busy":       running - idle,
+			"restarting": s.pool.Size - running,
+		},
+		"requests_in_flight": requestsInFlight.Value.Load(),
+	})
+}
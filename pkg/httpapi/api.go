@@ -0,0 +1,436 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// codeRequest is the body of endpoints that analyze one snippet.
+type codeRequest struct {
+	Code   string `json:"code"`
+	Parser string `json:"parser"`
+}
+
+// projectForm is the multipart form /parse/project takes, described with
+// json tags for the schema generator.
+type projectForm struct {
+	Project []byte `json:"project"`
+	Parser  string `json:"parser,omitempty"`
+}
+
+// queryParam is a query string parameter of a GET endpoint, or a path
+// parameter if the path has it in braces.
+type queryParam struct {
+	Name        string
+	Description string
+	Type        string
+	Required    bool
+}
+
+// endpoint is one route of the API. The mux and the OpenAPI document are
+// both built from the table in endpoints, so a route can't be served
+// without being documented. Request and Response are zero values of the
+// types the handler decodes and encodes. A GET takes Params instead of a
+// body.
+type endpoint struct {
+	Path    string
+	Methods []string
+	Summary string
+	Handler http.HandlerFunc
+
+	Request     interface{}
+	RequestType string
+	Params      []queryParam
+
+	Response interface{}
+	// ResponseTypes are the content types the endpoint answers with other
+	// than JSON, as text.
+	ResponseTypes []string
+	// Status is the success status, if not 200.
+	Status int
+}
+
+var (
+	post = []string{http.MethodPost}
+	get  = []string{http.MethodGet}
+)
+
+// formatTypes lists the content types of the non-JSON /parse formats.
+func formatTypes() []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, f := range analyze.OutputFormats {
+		if !seen[f.ContentType] {
+			seen[f.ContentType] = true
+			types = append(types, f.ContentType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+const (
+	dotType     = "text/vnd.graphviz; charset=utf-8"
+	openAPIType = "application/vnd.oai.openapi+json;version=3.0"
+)
+
+func (s *server) endpoints(wt *watcher) []endpoint {
+	endpoints := []endpoint{
+		{Path: "/parse", Methods: post, Handler: s.handleParse,
+			Summary:  "Parse code into a syntax tree, or render it in another format",
+			Request:  parseRequest{},
+			Response: analyze.NormalNode{}, ResponseTypes: formatTypes()},
+		{Path: "/parse/batch", Methods: post, Handler: s.handleBatch,
+			Summary: "Parse several snippets in one request",
+			Request: batchRequest{}, Response: batchResponse{}},
+		{Path: "/parse/compare", Methods: post, Handler: s.asyncJob("compare", s.handleCompare),
+			Summary: "Parse code with several parsers and compare their trees",
+			Request: compareRequest{}, Response: compareResponse{}},
+		{Path: "/parse/project", Methods: post, Handler: s.asyncJob("project", s.handleProject),
+			Summary: "Parse every Ruby file in an uploaded zip",
+			Request: projectForm{}, RequestType: "multipart/form-data", Response: projectResult{}},
+		{Path: "/parse/url", Methods: post, Handler: s.handleParseURL,
+			Summary: "Parse a Ruby file fetched from GitHub or a gist",
+			Request: parseURLRequest{}, Response: analyze.NormalNode{}},
+		{Path: "/parse/repo", Methods: post, Handler: s.asyncJob("repo", s.handleRepo),
+			Summary: "Clone a git repository and index its Ruby files",
+			Request: repoRequest{}, Response: projectResult{}},
+		{Path: "/parse/rbs", Methods: post, Handler: s.handleRBS,
+			Summary: "Parse RBS type signatures",
+			Request: rbsRequest{}, Response: analyze.NormalNode{}, ResponseTypes: formatTypes()},
+		{Path: "/parse/partial", Methods: post, Handler: s.handlePartial,
+			Summary: "Parse code with syntax errors into what prism can recover",
+			Request: partialRequest{}, Response: partialResponse{}},
+		{Path: "/parse/edit", Methods: post, Handler: s.handleEdit,
+			Summary: "Apply a text edit to a recent parse and return the new tree",
+			Request: editRequest{}, Response: editResponse{}},
+		{Path: "/parse/template", Methods: post, Handler: s.handleTemplate,
+			Summary: "Parse the Ruby embedded in a Haml or Slim template",
+			Request: templateRequest{}, Response: templateResponse{}},
+		{Path: "/ws", Methods: get, Handler: s.handleWebSocket,
+			Summary: "Open a WebSocket for parsing as the code is edited",
+			Status:  http.StatusSwitchingProtocols},
+		{Path: "/render", Methods: []string{http.MethodGet, http.MethodPost}, Handler: s.handleRender,
+			Summary: "Render a tree as an SVG, from code or a recent parse",
+			Request: codeRequest{}, ResponseTypes: []string{"image/svg+xml"},
+			Params: []queryParam{{Name: "id", Description: "A parse ID from X-Parse-ID", Type: "string", Required: true}}},
+		{Path: "/export/html", Methods: []string{http.MethodGet, http.MethodPost}, Handler: s.asyncJob("export", s.handleExportHTML),
+			Summary: "Export the tree as a standalone HTML page",
+			Request: codeRequest{}, ResponseTypes: []string{"text/html"},
+			Params: []queryParam{
+				{Name: "snippet", Description: "A snippet ID from POST /snippets", Type: "string"},
+				{Name: "id", Description: "A parse ID from X-Parse-ID, if no snippet is given", Type: "string"},
+			}},
+		{Path: "/export/png", Methods: []string{http.MethodGet, http.MethodPost}, Handler: s.asyncJob("export", s.handleExportPNG),
+			Summary: "Export the tree as a PNG image, laid out by Graphviz",
+			Request: pngRequest{}, ResponseTypes: []string{"image/png"},
+			Params: []queryParam{
+				{Name: "snippet", Description: "A snippet ID from POST /snippets", Type: "string"},
+				{Name: "id", Description: "A parse ID from X-Parse-ID, if no snippet is given", Type: "string"},
+				{Name: "dpi", Description: "Resolution of the image, up to 600", Type: "integer"},
+				{Name: "max_depth", Description: "Prune the tree below this depth", Type: "integer"},
+			}},
+		{Path: "/jobs/{id}", Methods: []string{http.MethodGet, http.MethodDelete}, Handler: s.handleJob,
+			Summary:  "Report a background job's status and result, or cancel it",
+			Response: jobResponse{},
+			Params:   []queryParam{{Name: "id", Description: "A job ID from an async POST", Type: "string", Required: true}}},
+		{Path: "/jobs/{id}/events", Methods: get, Handler: s.handleJob,
+			Summary:       "Stream a background job's progress and per-file results",
+			Params:        []queryParam{{Name: "id", Description: "A job ID from an async POST", Type: "string", Required: true}},
+			ResponseTypes: []string{"text/event-stream"}},
+		{Path: "/jobs/{id}/result", Methods: get, Handler: s.handleJob,
+			Summary:  "Fetch what a finished job's endpoint answered",
+			Response: json.RawMessage{}, ResponseTypes: []string{"text/html", "image/png"},
+			Params: []queryParam{{Name: "id", Description: "A job ID from an async POST", Type: "string", Required: true}}},
+		{Path: "/snippets", Methods: post, Handler: s.handleCreateSnippet,
+			Summary: "Save code and its tree for sharing",
+			Request: codeRequest{}, Response: snippetCreated{}, Status: http.StatusCreated},
+		{Path: "/snippets/{id}", Methods: get, Handler: s.handleGetSnippet,
+			Summary:  "Fetch a shared snippet and its tree",
+			Response: snippet{},
+			Params: []queryParam{
+				{Name: "id", Description: "A snippet ID from POST /snippets", Type: "string", Required: true},
+				{Name: "raw", Description: "Return the tree in the parser's own shape", Type: "boolean"},
+			}},
+		{Path: "/webhooks/github", Methods: post, Handler: s.handleGitHubWebhook,
+			Summary:  "Parse and store the Ruby files of each commit in a GitHub push",
+			Response: webhookResponse{},
+			Params: []queryParam{
+				{Name: "parser", Description: "The parser to use, the default one if not given", Type: "string"},
+			}},
+		{Path: "/commits/{sha}", Methods: get, Handler: s.handleGetCommit,
+			Summary:  "Fetch the files a GitHub push stored for a commit",
+			Response: commitRecord{},
+			Params: []queryParam{
+				{Name: "sha", Description: "A commit SHA from a push to /webhooks/github", Type: "string", Required: true},
+			}},
+		{Path: "/auth/github", Methods: []string{http.MethodGet, http.MethodDelete}, Handler: s.handleGitHubAuth,
+			Summary:  "Show or end the client's GitHub sign-in",
+			Response: githubAuthStatus{}},
+		{Path: "/auth/github/login", Methods: get, Handler: s.handleGitHubLogin,
+			Summary: "Sign in with GitHub to parse private repositories", Status: http.StatusFound},
+		{Path: "/auth/github/callback", Methods: get, Handler: s.handleGitHubCallback,
+			Summary: "Finish a GitHub sign-in", Status: http.StatusSeeOther,
+			Params: []queryParam{
+				{Name: "code", Description: "The code GitHub gives for the sign-in", Type: "string", Required: true},
+				{Name: "state", Description: "The state the sign-in was started with", Type: "string", Required: true},
+			}},
+		{Path: "/history", Methods: []string{http.MethodGet, http.MethodDelete}, Handler: s.handleHistory,
+			Summary:  "List or forget the client's recent parses",
+			Response: historyResponse{}},
+		{Path: "/graphql", Methods: []string{http.MethodGet, http.MethodPost}, Handler: s.handleGraphQL,
+			Summary: "Query just the fields of the tree wanted, in GraphQL",
+			Request: graphqlRequest{}, ResponseTypes: []string{contentTypeGraphQL},
+			Params: []queryParam{
+				{Name: "query", Description: "The GraphQL document", Type: "string", Required: true},
+				{Name: "variables", Description: "Values of its variables, as a JSON object", Type: "string"},
+				{Name: "operationName", Description: "The operation to run, if there are several", Type: "string"},
+			}},
+		{Path: "/graphql/schema", Methods: get, Handler: s.handleGraphQLSchema,
+			Summary: "Serve the /graphql schema in SDL", ResponseTypes: []string{"text/plain; charset=utf-8"}},
+		{Path: "/diff", Methods: post, Handler: s.handleDiff,
+			Summary: "Report how the trees of two snippets differ",
+			Request: diffRequest{}, Response: analyze.DiffResult{}},
+		{Path: "/node-at", Methods: post, Handler: s.handleNodeAt,
+			Summary: "Find the innermost node at a position",
+			Request: nodeAtRequest{}, Response: nodeAtResponse{}},
+		{Path: "/subtree", Methods: get, Handler: s.handleSubtree,
+			Summary:  "Fetch one branch of a recent parse",
+			Response: analyze.NormalNode{},
+			Params: []queryParam{
+				{Name: "id", Description: "A parse ID from X-Parse-ID", Type: "string", Required: true},
+				{Name: "path", Description: "The path of the node, as in node paths elsewhere", Type: "string"},
+				{Name: "max_depth", Description: "Prune the branch below this depth", Type: "integer"},
+				{Name: "max_nodes", Description: "Prune the branch after this many nodes", Type: "integer"},
+				{Name: "raw", Description: "Return the parser's own shape", Type: "boolean"},
+			}},
+		{Path: "/source-range", Methods: get, Handler: s.handleSourceRange,
+			Summary:  "Fetch the source text of a node from a recent parse",
+			Response: sourceRangeResponse{},
+			Params: []queryParam{
+				{Name: "id", Description: "A parse ID from X-Parse-ID", Type: "string", Required: true},
+				{Name: "path", Description: "The path of the node, as in node paths elsewhere", Type: "string"},
+				{Name: "context", Description: "Lines to include before and after it, 2 by default", Type: "integer"},
+			}},
+		{Path: "/generate", Methods: get, Handler: s.handleGenerate,
+			Summary:  "Generate a random Ruby program and parse it",
+			Response: generateResponse{},
+			Params: []queryParam{
+				{Name: "seed", Description: "Makes the same program again; random if not given", Type: "integer"},
+				{Name: "complexity", Description: "From 1 to 10, how big and deeply nested the program is; 3 if not given", Type: "integer"},
+				{Name: "parser", Description: "The parser to parse it with", Type: "string"},
+				{Name: "parse", Description: "Set to false for just the code", Type: "boolean"},
+				{Name: "raw", Description: "Return the tree in the parser's own shape", Type: "boolean"},
+			}},
+		{Path: "/examples", Methods: get, Handler: s.handleExamples,
+			Summary: "List example snippets, grouped by language feature", Response: examplesResponse{}},
+		{Path: "/explain/{node_type}", Methods: get, Handler: s.handleExplain,
+			Summary:  "Describe a node type, with an example and its fields",
+			Response: analyze.NodeExplanation{},
+			Params: []queryParam{
+				{Name: "node_type", Description: "A type as it appears in trees, such as opassign", Type: "string", Required: true},
+				{Name: "parser", Description: "The parser whose type it is, when several use the name", Type: "string"},
+			}},
+		{Path: "/tokens", Methods: post, Handler: s.handleTokens,
+			Summary: "List the tokens a lexer produces",
+			Request: tokensRequest{}, Response: json.RawMessage{}},
+		{Path: "/comments", Methods: post, Handler: s.handleComments,
+			Summary: "Extract comments and what they're attached to",
+			Request: codeRequest{}, Response: commentsResponse{}},
+		{Path: "/stats", Methods: post, Handler: s.handleTreeStats,
+			Summary: "Count a tree's nodes by type and depth",
+			Request: codeRequest{}, Response: analyze.TreeStats{}},
+		{Path: "/hierarchy", Methods: post, Handler: s.handleHierarchy,
+			Summary: "Extract the class and module hierarchy",
+			Request: sourcesRequest{}, Response: hierarchyResponse{}, ResponseTypes: []string{dotType}},
+		{Path: "/callgraph", Methods: post, Handler: s.handleCallGraph,
+			Summary: "Extract which methods call which",
+			Request: sourcesRequest{}, Response: callGraphResponse{}, ResponseTypes: []string{dotType}},
+		{Path: "/analyze/deadcode", Methods: post, Handler: s.handleDeadCode,
+			Summary: "Find unreachable code and unused private methods",
+			Request: codeRequest{}, Response: deadCodeResponse{}},
+		{Path: "/cfg", Methods: post, Handler: s.handleCFG,
+			Summary: "Build a method's control flow graph",
+			Request: cfgRequest{}, Response: analyze.ControlFlowGraph{}, ResponseTypes: []string{dotType}},
+		{Path: "/analyze/security", Methods: post, Handler: s.asyncJob("security", s.handleSecurity),
+			Summary: "Scan an uploaded Rails app with Brakeman",
+			Request: projectForm{}, RequestType: "multipart/form-data", Response: securityResponse{}},
+		{Path: "/analyze/duplication", Methods: post, Handler: s.handleDuplication,
+			Summary: "Find structurally duplicated code",
+			Request: duplicationRequest{}, Response: duplicationResponse{}},
+		{Path: "/deps", Methods: post, Handler: s.handleDeps,
+			Summary: "Extract the require graph between files",
+			Request: depsRequest{}, Response: depsResponse{}, ResponseTypes: []string{dotType}},
+		{Path: "/scopes", Methods: post, Handler: s.handleScopes,
+			Summary: "Resolve local variables to their declarations",
+			Request: codeRequest{}, Response: analyze.ScopeAnalysis{}},
+		{Path: "/symbols", Methods: post, Handler: s.handleSymbols,
+			Summary: "Outline the classes, modules, methods and constants",
+			Request: codeRequest{}, Response: symbolsResponse{}},
+		{Path: "/types", Methods: post, Handler: s.handleTypes,
+			Summary: "Attach the types from RBS files and sigs to the methods",
+			Request: typesRequest{}, Response: typesResponse{}},
+		{Path: "/query", Methods: post, Handler: s.handleQuery,
+			Summary: "Search a tree with a node pattern",
+			Request: queryRequest{}, Response: queryResponse{}},
+		{Path: "/rules/run", Methods: post, Handler: s.handleRunRules,
+			Summary: "Check code against structural lint rules",
+			Request: rulesRequest{}, Response: rulesResponse{}},
+		{Path: "/format", Methods: post, Handler: s.handleFormat,
+			Summary: "Format code with Syntax Tree",
+			Request: formatRequest{}, Response: formatResponse{}},
+		{Path: "/unparse", Methods: post, Handler: s.handleUnparse,
+			Summary: "Turn a tree back into source code",
+			Request: unparseRequest{}, Response: unparseResponse{}},
+		{Path: "/lint", Methods: post, Handler: s.handleLint,
+			Summary: "Run RuboCop and return its offenses",
+			Request: lintRequest{}, Response: lintResponse{}},
+		{Path: "/healthz", Methods: []string{http.MethodGet, http.MethodHead}, Handler: s.handleHealthz,
+			Summary: "Report that the server is up", Response: map[string]string{}},
+		{Path: "/readyz", Methods: []string{http.MethodGet, http.MethodHead}, Handler: s.handleReadyz,
+			Summary: "Report whether code can be parsed", Response: readinessResponse{}},
+		{Path: "/version", Methods: get, Handler: s.handleVersion,
+			Summary: "Report the versions of the server, Ruby and parsers", Response: versionResponse{}},
+		{Path: "/schema/ast.json", Methods: get, Handler: s.handleASTSchema,
+			Summary: "Serve the JSON Schema of normalized trees", ResponseTypes: []string{"application/schema+json"}},
+		{Path: "/openapi.json", Methods: get, Handler: s.handleOpenAPI(wt),
+			Summary: "Serve this document", ResponseTypes: []string{openAPIType}},
+		{Path: "/metrics", Methods: []string{http.MethodGet, http.MethodHead}, Handler: s.handleMetrics,
+			Summary: "Serve Prometheus metrics", ResponseTypes: []string{"text/plain; version=0.0.4; charset=utf-8"}},
+		{Path: "/metrics/code", Methods: post, Handler: s.handleCodeMetrics,
+			Summary: "Measure the complexity of methods and classes",
+			Request: codeRequest{}, Response: analyze.CodeMetrics{}},
+	}
+	if wt != nil {
+		endpoints = append(endpoints, endpoint{Path: "/watch", Methods: get, Handler: wt.handleWatch,
+			Summary: "Stream trees of watched files as they change", ResponseTypes: []string{"text/event-stream"}})
+	}
+	return endpoints
+}
+
+// operationID names an operation for generated clients, such as
+// post_parse_batch.
+func operationID(method, path string) string {
+	id := strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(path)
+	return strings.ToLower(method) + id
+}
+
+// openAPIDocument describes the endpoints as served under /api/v1/: JSON
+// responses in their envelope, and every error as an errorEnvelope.
+func openAPIDocument(endpoints []endpoint) map[string]interface{} {
+	g := analyze.NewSchemaGenerator("#/components/schemas/", analyze.NormalNodeDescriptions)
+	errorContent := map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": g.Schema(reflect.TypeOf(errorEnvelope{}))},
+	}
+	meta := g.Schema(reflect.TypeOf(responseMeta{}))
+
+	paths := make(map[string]interface{})
+	for _, e := range endpoints {
+		item := make(map[string]interface{})
+		for _, method := range e.Methods {
+			op := map[string]interface{}{
+				"operationId": operationID(method, e.Path),
+				"summary":     e.Summary,
+			}
+			// Every method has the path parameters; only reads take the rest.
+			var params []interface{}
+			for _, p := range e.Params {
+				in := "query"
+				if strings.Contains(e.Path, "{"+p.Name+"}") {
+					in = "path"
+				} else if method != http.MethodGet && method != http.MethodHead {
+					continue
+				}
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          in,
+					"description": p.Description,
+					"required":    p.Required,
+					"schema":      map[string]interface{}{"type": p.Type},
+				})
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+			if method != http.MethodGet && method != http.MethodHead && e.Request != nil {
+				contentType := e.RequestType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				op["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						contentType: map[string]interface{}{"schema": g.Schema(reflect.TypeOf(e.Request))},
+					},
+				}
+			}
+
+			// A DELETE answers 204 with no body, whatever the path's other
+			// methods return.
+			response := map[string]interface{}{"description": "Success"}
+			content := make(map[string]interface{})
+			if e.Response != nil && method != http.MethodDelete {
+				content["application/json"] = map[string]interface{}{"schema": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"data": g.Schema(reflect.TypeOf(e.Response)), "meta": meta},
+					"required":   []string{"data", "meta"},
+				}}
+			}
+			for _, t := range e.ResponseTypes {
+				content[t] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			}
+			if len(content) > 0 {
+				response["content"] = content
+			}
+			status := e.Status
+			switch {
+			case method == http.MethodDelete:
+				status = http.StatusNoContent
+			case status == 0:
+				status = http.StatusOK
+			}
+			op["responses"] = map[string]interface{}{
+				strconv.Itoa(status): response,
+				"default":            map[string]interface{}{"description": "Error", "content": errorContent},
+			}
+			item[strings.ToLower(method)] = op
+		}
+		paths[e.Path] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Ruby AST Visualizer",
+			"version": version,
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/api/" + apiVersion}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.Defs},
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document for the routes, built on first
+// request from the same table they're registered from.
+func (s *server) handleOpenAPI(wt *watcher) http.HandlerFunc {
+	var once sync.Once
+	var doc map[string]interface{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		once.Do(func() { doc = openAPIDocument(s.endpoints(wt)) })
+		w.Header().Set("Content-Type", openAPIType)
+		if err := json.NewEncoder(w).Encode(doc); err != nil {
+			slog.Error("Error writing response", "err", err)
+		}
+	}
+}
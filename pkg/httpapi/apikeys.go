@@ -0,0 +1,173 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiKeyExempt routes stay open when API keys are required, so probes don't
+// need one, nor GitHub, whose webhook deliveries are signed. /metrics is not
+// among them, since it names every key's client.
+var apiKeyExempt = map[string]bool{
+	"/healthz":         true,
+	"/readyz":          true,
+	"/webhooks/github": true,
+}
+
+// apiKey is a client allowed in when keys are required. Its name, never the
+// key itself, is what logs and metrics show.
+type apiKey struct {
+	name    string
+	limiter *rateLimiter
+}
+
+// apiKeys maps the SHA-256 of each key to its client. Looking up the hash
+// rather than the key keeps the comparison from leaking the key's prefix
+// through timing.
+type apiKeys map[[sha256.Size]byte]*apiKey
+
+func (k apiKeys) lookup(secret string) *apiKey {
+	return k[sha256.Sum256([]byte(secret))]
+}
+
+// add registers a key. rate and burst are its own limit; a rate of 0 leaves
+// it unlimited.
+func (k apiKeys) add(name, secret string, rate float64, burst int) error {
+	if name == "" || secret == "" {
+		return fmt.Errorf("API key needs a name and a key")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if _, ok := k[sum]; ok {
+		return fmt.Errorf("API key for %q is a duplicate", name)
+	}
+	key := &apiKey{name: name}
+	if rate > 0 {
+		key.limiter = newRateLimiter(rate, burst)
+	}
+	k[sum] = key
+	return nil
+}
+
+// loadAPIKeys collects the keys given as name:key pairs in -api-keys and
+// those in -api-keys-file. Each line of the file is
+//
+//	name key [rate [burst]]
+//
+// with blank lines and # comments ignored. Keys without their own rate get
+// -rate-limit and -rate-burst; a rate without a burst allows a second's worth
+// at once. It returns nil if no keys are configured.
+func loadAPIKeys(cfg *config) (apiKeys, error) {
+	keys := make(apiKeys)
+	for _, entry := range cfg.APIKeys {
+		name, secret, _ := strings.Cut(entry, ":")
+		if err := keys.add(name, secret, cfg.RateLimit, cfg.RateBurst); err != nil {
+			return nil, fmt.Errorf("-api-keys: %w", err)
+		}
+	}
+
+	if cfg.APIKeysFile != "" {
+		f, err := os.Open(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for line := 1; sc.Scan(); line++ {
+			fields := strings.Fields(sc.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if len(fields) < 2 || len(fields) > 4 {
+				return nil, fmt.Errorf("%s:%d: want name key [rate [burst]]", cfg.APIKeysFile, line)
+			}
+			rate, burst := cfg.RateLimit, cfg.RateBurst
+			if len(fields) > 2 {
+				if rate, err = strconv.ParseFloat(fields[2], 64); err != nil || rate < 0 {
+					return nil, fmt.Errorf("%s:%d: invalid rate %q", cfg.APIKeysFile, line, fields[2])
+				}
+				burst = int(math.Max(1, math.Ceil(rate)))
+			}
+			if len(fields) > 3 {
+				if burst, err = strconv.Atoi(fields[3]); err != nil || burst < 1 {
+					return nil, fmt.Errorf("%s:%d: invalid burst %q", cfg.APIKeysFile, line, fields[3])
+				}
+			}
+			if err := keys.add(fields[0], fields[1], rate, burst); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", cfg.APIKeysFile, line, err)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+type apiKeyCtxKey struct{}
+
+func apiKeyFrom(ctx context.Context) *apiKey {
+	key, _ := ctx.Value(apiKeyCtxKey{}).(*apiKey)
+	return key
+}
+
+// requestAPIKey returns the key a request carries: as a bearer token, in
+// X-API-Key, or, for EventSource and WebSocket clients that can't set
+// headers, in the api_key query parameter.
+func requestAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// requireAPIKey turns away API requests without a known key with 401, and
+// those over their key's rate with 429. Routes outside the API, such as the
+// frontend and /debug/, which has its own token, are left alone.
+func (s *server) requireAPIKey(next http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := route(r)
+		if name == "other" || apiKeyExempt[name] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := s.apiKeys.lookup(requestAPIKey(r))
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, "A valid API key is required")
+			return
+		}
+		apiKeyRequests.Inc(key.name)
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.mu.Lock()
+			info.apiKey = key.name
+			info.mu.Unlock()
+		}
+
+		if key.limiter != nil {
+			if ok, wait := key.limiter.allow(key.name, time.Now()); !ok {
+				apiKeyRateLimited.Inc(key.name)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "Rate limit exceeded for this API key")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, key)))
+	})
+}
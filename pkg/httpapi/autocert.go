@@ -0,0 +1,26 @@
+//go:build autocert
+
+package httpapi
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const autocertAvailable = true
+
+// newAutocert obtains and renews certificates for -autocert-domain from
+// Let's Encrypt, keeping them in -autocert-cache across restarts.
+func newAutocert(cfg *config) (*tls.Config, http.Handler, error) {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+		Cache:      autocert.DirCache(cfg.AutocertCache),
+		Email:      cfg.AutocertEmail,
+	}
+	tlsCfg := m.TLSConfig()
+	tlsCfg.MinVersion = tls.VersionTLS12
+	return tlsCfg, m.HTTPHandler(nil), nil
+}
@@ -0,0 +1,17 @@
+//go:build !autocert
+
+package httpapi
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+const autocertAvailable = false
+
+// Without the autocert tag the binary doesn't depend on golang.org/x/crypto,
+// and only -tls-cert and -tls-key are supported.
+func newAutocert(cfg *config) (*tls.Config, http.Handler, error) {
+	return nil, nil, errors.New("-autocert-domain needs a binary built with -tags autocert")
+}
@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+const maxBatchSize = 100
+
+type batchResult struct {
+	AST   analyze.NormalJSON `json:"ast,omitempty"`
+	Error *errorResponse     `json:"error,omitempty"`
+}
+
+// batchRequest's format options apply to every snippet.
+type batchRequest struct {
+	Parser   string `json:"parser"`
+	Snippets []struct {
+		ID   string `json:"id"`
+		Code string `json:"code"`
+	} `json:"snippets"`
+	analyze.FormatOptions
+}
+
+type batchResponse struct {
+	Results map[string]batchResult `json:"results"`
+}
+
+// handleBatch parses several snippets in one request, at most cfg.Workers at
+// a time, and returns their results keyed by the caller's IDs. A snippet
+// failing to parse doesn't fail the others.
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req batchRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if len(req.Snippets) > maxBatchSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d snippets per batch", maxBatchSize))
+		return
+	}
+	seen := make(map[string]bool, len(req.Snippets))
+	for _, snippet := range req.Snippets {
+		if snippet.ID == "" || seen[snippet.ID] {
+			writeError(w, http.StatusBadRequest, "Every snippet needs a unique id")
+			return
+		}
+		seen[snippet.ID] = true
+	}
+	if !checkFormatOptions(w, analyze.DefaultFormat, req.FormatOptions) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]batchResult, len(req.Snippets))
+	sem := make(chan struct{}, s.cfg.Workers)
+	for _, snippet := range req.Snippets {
+		wg.Add(1)
+		go func(id, code string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var result batchResult
+			output, _, err := s.parse(r.Context(), parser, code)
+			if err != nil {
+				_, resp := parseErrorResponse(r.Context(), parser, err)
+				result.Error = &resp
+			} else {
+				opts := req.FormatOptions
+				opts.Source = code
+				if _, result.AST, err = analyze.RenderFormat(analyze.DefaultFormat, output, opts); err != nil {
+					slog.ErrorContext(r.Context(), "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+					result.Error = &errorResponse{Error: "Failed to render json output"}
+				}
+			}
+
+			mu.Lock()
+			results[id] = result
+			mu.Unlock()
+		}(snippet.ID, snippet.Code)
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return
+	}
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, batchResponse{results})
+}
@@ -0,0 +1,376 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeMsgpack = "application/msgpack"
+	contentTypeCBOR    = "application/cbor"
+)
+
+// acceptedBinaryType picks MessagePack or CBOR from an Accept header if the
+// client prefers it to JSON, or returns "". A type listed only through a
+// wildcard never wins, so browsers sending */* keep getting JSON.
+func acceptedBinaryType(header string) string {
+	best, bestQ := "", 0.0
+	jsonQ := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "application/json":
+			jsonQ = q
+		case contentTypeMsgpack, "application/x-msgpack", "application/vnd.msgpack":
+			if q > bestQ {
+				best, bestQ = contentTypeMsgpack, q
+			}
+		case contentTypeCBOR:
+			if q > bestQ {
+				best, bestQ = contentTypeCBOR, q
+			}
+		}
+	}
+	if best == "" || bestQ < jsonQ {
+		return ""
+	}
+	return best
+}
+
+// transcodeWriter holds back a JSON response and sends it re-encoded as
+// MessagePack or CBOR once the handler is done. A streamed tree arrives all
+// at once as a result. Other content types pass straight through, and so
+// does JSON that fails to decode, unchanged.
+type transcodeWriter struct {
+	http.ResponseWriter
+	contentType string
+
+	status  int
+	started bool
+	holding bool
+	buf     bytes.Buffer
+}
+
+func (tw *transcodeWriter) WriteHeader(status int) {
+	if tw.started {
+		return
+	}
+	tw.started = true
+	tw.status = status
+	mediaType, _, _ := mime.ParseMediaType(tw.Header().Get("Content-Type"))
+	if mediaType == "application/json" && status != http.StatusNoContent && status != http.StatusNotModified {
+		tw.holding = true
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *transcodeWriter) Write(b []byte) (int, error) {
+	if !tw.started {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.holding {
+		return tw.buf.Write(b)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush passes through only once the response is known not to be held.
+func (tw *transcodeWriter) Flush() {
+	if tw.started && !tw.holding {
+		http.NewResponseController(tw.ResponseWriter).Flush()
+	}
+}
+
+func (tw *transcodeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(tw.ResponseWriter).Hijack()
+}
+
+func (tw *transcodeWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *transcodeWriter) finish() {
+	if !tw.holding {
+		return
+	}
+	h := tw.Header()
+	body, err := transcodeJSON(tw.buf.Bytes(), tw.contentType)
+	if err != nil {
+		tw.ResponseWriter.WriteHeader(tw.status)
+		tw.ResponseWriter.Write(tw.buf.Bytes())
+		return
+	}
+	h.Set("Content-Type", tw.contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	// As with compression, another representation can't keep a strong ETag.
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	tw.ResponseWriter.WriteHeader(tw.status)
+	tw.ResponseWriter.Write(body)
+}
+
+// transcode re-encodes JSON responses as MessagePack or CBOR for clients
+// whose Accept header asks for one, which for a large tree is about half the
+// size and much quicker to decode.
+func transcode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		contentType := acceptedBinaryType(r.Header.Get("Accept"))
+		if contentType == "" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tw := &transcodeWriter{ResponseWriter: w, contentType: contentType}
+		next.ServeHTTP(tw, r)
+		tw.finish()
+	})
+}
+
+// jsonMember is a member of a JSON object, kept in order so re-encoded
+// maps list their keys as the JSON did.
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// transcodeJSON re-encodes one JSON value, with any trailing newline, as
+// contentType.
+func transcodeJSON(data []byte, contentType string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("more than one JSON value")
+	}
+	var enc binaryEncoder = &msgpackEncoder{}
+	if contentType == contentTypeCBOR {
+		enc = &cborEncoder{}
+	}
+	encodeBinary(enc, value)
+	return enc.bytes(), nil
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		members := []jsonMember{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, jsonMember{key.(string), value})
+		}
+		_, err := dec.Token()
+		return members, err
+	case json.Delim('['):
+		elements := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, value)
+		}
+		_, err := dec.Token()
+		return elements, err
+	}
+	return tok, nil
+}
+
+// binaryEncoder writes the JSON data model in a binary format. array and
+// mapHeader start a container of n elements or members, which follow.
+type binaryEncoder interface {
+	null()
+	bool(v bool)
+	int(v int64)
+	float(v float64)
+	string(v string)
+	array(n int)
+	mapHeader(n int)
+	bytes() []byte
+}
+
+func encodeBinary(enc binaryEncoder, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		enc.null()
+	case bool:
+		enc.bool(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			enc.int(n)
+		} else {
+			f, _ := v.Float64()
+			enc.float(f)
+		}
+	case string:
+		enc.string(v)
+	case []interface{}:
+		enc.array(len(v))
+		for _, element := range v {
+			encodeBinary(enc, element)
+		}
+	case []jsonMember:
+		enc.mapHeader(len(v))
+		for _, m := range v {
+			enc.string(m.key)
+			encodeBinary(enc, m.value)
+		}
+	}
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) null() { e.buf = append(e.buf, 0xc0) }
+
+func (e *msgpackEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) int(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f, v < 0 && v >= -32:
+		e.buf = append(e.buf, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(v))
+	case v >= 0 && v <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(v))
+	case v >= 0:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), uint64(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) float(v float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(v))
+}
+
+func (e *msgpackEncoder) string(v string) {
+	e.header(len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) array(n int) { e.header(n, 0x90, 16, 0, 0xdc, 0xdd) }
+
+func (e *msgpackEncoder) mapHeader(n int) { e.header(n, 0x80, 16, 0, 0xde, 0xdf) }
+
+// header writes a length: in the low bits of fix below fixMax, else after
+// the 8-, 16- or 32-bit marker, where a zero marker8 means there is none.
+func (e *msgpackEncoder) header(n int, fix byte, fixMax int, marker8, marker16, marker32 byte) {
+	switch {
+	case n < fixMax:
+		e.buf = append(e.buf, fix|byte(n))
+	case marker8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, marker8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, marker16), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, marker32), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) bytes() []byte { return e.buf }
+
+type cborEncoder struct {
+	buf []byte
+}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+)
+
+func (e *cborEncoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, major<<5|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, major<<5|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, major<<5|27), n)
+	}
+}
+
+func (e *cborEncoder) null() { e.buf = append(e.buf, 0xf6) }
+
+func (e *cborEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xf5)
+	} else {
+		e.buf = append(e.buf, 0xf4)
+	}
+}
+
+func (e *cborEncoder) int(v int64) {
+	if v >= 0 {
+		e.head(cborUint, uint64(v))
+	} else {
+		e.head(cborNegInt, uint64(-1-v))
+	}
+}
+
+func (e *cborEncoder) float(v float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xfb), math.Float64bits(v))
+}
+
+func (e *cborEncoder) string(v string) {
+	e.head(cborText, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *cborEncoder) array(n int) { e.head(cborArray, uint64(n)) }
+
+func (e *cborEncoder) mapHeader(n int) { e.head(cborMap, uint64(n)) }
+
+func (e *cborEncoder) bytes() []byte { return e.buf }
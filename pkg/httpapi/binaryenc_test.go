@@ -0,0 +1,224 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func jsonArrayOf(n int) string {
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = fmt.Sprint(i + 1)
+	}
+	return "[" + strings.Join(elements, ",") + "]"
+}
+
+func TestTranscodeCBOR(t *testing.T) {
+	// The examples of RFC 8949 appendix A that JSON can write, as this
+	// encoder writes them: floats always in 64 bits, where the RFC's
+	// preferred serialization would shorten some.
+	tests := []struct {
+		json string
+		want string
+	}{
+		{"0", "00"},
+		{"1", "01"},
+		{"10", "0a"},
+		{"23", "17"},
+		{"24", "1818"},
+		{"25", "1819"},
+		{"100", "1864"},
+		{"1000", "1903e8"},
+		{"1000000", "1a000f4240"},
+		{"1000000000000", "1b000000e8d4a51000"},
+		{"-1", "20"},
+		{"-10", "29"},
+		{"-100", "3863"},
+		{"-1000", "3903e7"},
+		{"1.1", "fb3ff199999999999a"},
+		{"1.0e+300", "fb7e37e43c8800759c"},
+		{"-4.1", "fbc010666666666666"},
+		{"false", "f4"},
+		{"true", "f5"},
+		{"null", "f6"},
+		{`""`, "60"},
+		{`"a"`, "6161"},
+		{`"IETF"`, "6449455446"},
+		{`"\"\\"`, "62225c"},
+		{`"ü"`, "62c3bc"},
+		{`"水"`, "63e6b0b4"},
+		{`"𐅑"`, "64f0908591"},
+		{"[]", "80"},
+		{"[1,2,3]", "83010203"},
+		{"[1,[2,3],[4,5]]", "8301820203820405"},
+		{jsonArrayOf(25), "98190102030405060708090a0b0c0d0e0f101112131415161718181819"},
+		{"{}", "a0"},
+		{`{"a":1,"b":[2,3]}`, "a26161016162820203"},
+		{`["a",{"b":"c"}]`, "826161a161626163"},
+		{`{"a":"A","b":"B","c":"C","d":"D","e":"E"}`, "a56161614161626142616361436164614461656145"},
+	}
+	for _, tt := range tests {
+		got, err := transcodeJSON([]byte(tt.json), contentTypeCBOR)
+		if err != nil {
+			t.Errorf("transcodeJSON(%s): %v", tt.json, err)
+			continue
+		}
+		if want := mustHex(tt.want); !bytes.Equal(got, want) {
+			t.Errorf("CBOR of %s = %x, want %x", tt.json, got, want)
+		}
+	}
+}
+
+func TestTranscodeMsgpack(t *testing.T) {
+	// One example of each format the MessagePack spec defines that JSON
+	// can need, at the edges between them.
+	tests := []struct {
+		json string
+		want string
+	}{
+		{"null", "c0"},
+		{"false", "c2"},
+		{"true", "c3"},
+		{"0", "00"},
+		{"127", "7f"},
+		{"128", "cc80"},
+		{"255", "ccff"},
+		{"256", "cd0100"},
+		{"65536", "ce00010000"},
+		{"4294967296", "cf0000000100000000"},
+		{"-1", "ff"},
+		{"-32", "e0"},
+		{"-33", "d0df"},
+		{"-128", "d080"},
+		{"-129", "d1ff7f"},
+		{"-32769", "d2ffff7fff"},
+		{"-2147483649", "d3ffffffff7fffffff"},
+		{"1.5", "cb3ff8000000000000"},
+		{`""`, "a0"},
+		{`"a"`, "a161"},
+		{`"` + strings.Repeat("x", 31) + `"`, "bf" + strings.Repeat("78", 31)},
+		{`"` + strings.Repeat("x", 32) + `"`, "d920" + strings.Repeat("78", 32)},
+		{`"` + strings.Repeat("x", 256) + `"`, "da0100" + strings.Repeat("78", 256)},
+		{"[]", "90"},
+		{jsonArrayOf(15), "9f0102030405060708090a0b0c0d0e0f"},
+		{jsonArrayOf(16), "dc00100102030405060708090a0b0c0d0e0f10"},
+		{"{}", "80"},
+		// The example on msgpack.org.
+		{`{"compact":true,"schema":0}`, "82a7636f6d70616374c3a6736368656d6100"},
+	}
+	for _, tt := range tests {
+		got, err := transcodeJSON([]byte(tt.json), contentTypeMsgpack)
+		if err != nil {
+			t.Errorf("transcodeJSON(%.40s): %v", tt.json, err)
+			continue
+		}
+		if want := mustHex(tt.want); !bytes.Equal(got, want) {
+			t.Errorf("MessagePack of %.40s = %x, want %x", tt.json, got, want)
+		}
+	}
+
+	var members []string
+	for i := 0; i < 16; i++ {
+		members = append(members, fmt.Sprintf(`"%x":null`, i))
+	}
+	got, err := transcodeJSON([]byte("{"+strings.Join(members, ",")+"}"), contentTypeMsgpack)
+	if err != nil || !bytes.HasPrefix(got, mustHex("de0010a130c0")) {
+		t.Errorf("MessagePack of a 16-member map = %x, %v; want it to start de0010a130c0", got, err)
+	}
+}
+
+func TestTranscodeJSONErrors(t *testing.T) {
+	for _, data := range []string{"", "{", `{"a":}`, "1 2"} {
+		if _, err := transcodeJSON([]byte(data), contentTypeCBOR); err == nil {
+			t.Errorf("transcodeJSON(%q) succeeded", data)
+		}
+	}
+	if _, err := transcodeJSON([]byte("[1]\n"), contentTypeCBOR); err != nil {
+		t.Errorf("transcodeJSON with a trailing newline: %v", err)
+	}
+}
+
+func TestAcceptedBinaryType(t *testing.T) {
+	tests := []struct{ header, want string }{
+		{"", ""},
+		{"*/*", ""},
+		{"application/json", ""},
+		{"application/msgpack", contentTypeMsgpack},
+		{"application/x-msgpack", contentTypeMsgpack},
+		{"application/vnd.msgpack", contentTypeMsgpack},
+		{"Application/CBOR", contentTypeCBOR},
+		{"application/cbor, application/json;q=0.5", contentTypeCBOR},
+		{"application/cbor;q=0.5, application/json", ""},
+		{"application/json, application/cbor", contentTypeCBOR},
+		{"application/msgpack;q=0.4, application/cbor;q=0.6", contentTypeCBOR},
+		{"application/cbor;q=0", ""},
+		{"text/html, */*;q=0.8", ""},
+	}
+	for _, tt := range tests {
+		if got := acceptedBinaryType(tt.header); got != tt.want {
+			t.Errorf("acceptedBinaryType(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	handler := transcode(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"a":`))
+			w.Write([]byte("[true]}\n"))
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hi"))
+		case "/bad":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{"))
+		}
+	}))
+
+	tests := []struct {
+		path, accept string
+		wantType     string
+		wantBody     []byte
+		wantETag     string
+		wantStatus   int
+	}{
+		{"/json", "application/cbor", contentTypeCBOR, mustHex("a1616181f5"), `W/"abc"`, http.StatusCreated},
+		{"/json", "application/msgpack", contentTypeMsgpack, mustHex("81a16191c3"), `W/"abc"`, http.StatusCreated},
+		{"/json", "application/json", "application/json; charset=utf-8", []byte("{\"a\":[true]}\n"), `"abc"`, http.StatusCreated},
+		{"/text", "application/cbor", "text/plain", []byte("hi"), "", http.StatusOK},
+		{"/bad", "application/cbor", "application/json", []byte("{"), "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus || rec.Header().Get("Content-Type") != tt.wantType || !bytes.Equal(rec.Body.Bytes(), tt.wantBody) {
+			t.Errorf("%s as %s: %d %s %x, want %d %s %x", tt.path, tt.accept,
+				rec.Code, rec.Header().Get("Content-Type"), rec.Body.Bytes(), tt.wantStatus, tt.wantType, tt.wantBody)
+		}
+		if got := rec.Header().Get("ETag"); got != tt.wantETag {
+			t.Errorf("%s as %s: ETag = %s, want %s", tt.path, tt.accept, got, tt.wantETag)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("%s as %s: Vary = %q, want Accept", tt.path, tt.accept, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+var errCircuitOpen = errors.New("backend is failing; circuit open")
+
+// fallbackOrder is the chain requests that don't name a parser go down when
+// a backend is failing. Each speaks the same JSON; only the node names
+// differ, so the response says which one served it.
+var fallbackOrder = []string{"stree", "prism", "ripper"}
+
+// breaker is a circuit breaker for one backend. After threshold failures in
+// a row it opens and calls fail fast with errCircuitOpen instead of each
+// waiting on a broken backend; once cooldown has passed, one call is let
+// through to probe whether it has recovered.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record notes the outcome of a call allow let through. Invalid source is a
+// success as far as the backend is concerned, and a cancelled call says
+// nothing either way.
+func (b *breaker) record(err error, now time.Time) (tripped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.probing
+	b.probing = false
+	switch {
+	case err == nil || parser.IsSyntaxError(err):
+		b.failures = 0
+	case errors.Is(err, context.Canceled):
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			tripped = b.failures == b.threshold || probe
+			b.openUntil = now.Add(b.cooldown)
+		}
+	}
+	return tripped
+}
+
+// breakers holds a breaker per backend. They are keyed by the Parser itself
+// rather than its name, since the formatter and the stree parser share one.
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu sync.Mutex
+	m  map[parser.Parser]*breaker
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{threshold: threshold, cooldown: cooldown, m: make(map[parser.Parser]*breaker)}
+}
+
+// get returns parser's breaker, or nil if breakers are disabled.
+func (bs *breakers) get(p parser.Parser) *breaker {
+	if bs.threshold <= 0 {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.m[p]
+	if !ok {
+		b = &breaker{threshold: bs.threshold, cooldown: bs.cooldown}
+		bs.m[p] = b
+	}
+	return b
+}
+
+// shouldFallBack reports whether a parse that failed with err is worth
+// retrying on the next backend in the chain: the backend broke or is known
+// to be broken, rather than the source being invalid or the server busy.
+func shouldFallBack(err error) bool {
+	if errors.Is(err, errOverloaded) {
+		return false
+	}
+	result := parseResult(err)
+	return result == "error" || result == "timeout"
+}
+
+// fallbackChain returns the backends to try, in order, for a request that
+// asked for parser by name, or for the default if name is empty. Only the
+// default goes down the chain; a parser asked for by name is used alone.
+func (s *server) fallbackChain(name string) []parser.Parser {
+	if name != "" {
+		return []parser.Parser{s.parsers[name]}
+	}
+	var chain []parser.Parser
+	for _, name := range fallbackOrder {
+		if p, ok := s.parsers[name]; ok {
+			chain = append(chain, p)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// lruCache is a size-bounded cache of parse output, each entry with the
+// source it was parsed from, when that is known, so the two are evicted
+// together. Entries older than ttl are treated as missing; a zero ttl keeps
+// entries until they are evicted.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	source  []byte
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// cacheKey hashes parts into a fixed-size key. Parts are length-prefixed so
+// ("ab", "c") and ("a", "bc") don't collide.
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(part)))
+		h.Write(n[:])
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *lruCache) get(key string) ([]byte, bool) {
+	if entry := c.lookup(key); entry != nil {
+		return entry.value, true
+	}
+	return nil, false
+}
+
+// source returns the source kept with key's entry.
+func (c *lruCache) source(key string) ([]byte, bool) {
+	if entry := c.lookup(key); entry != nil && entry.source != nil {
+		return entry.source, true
+	}
+	return nil, false
+}
+
+func (c *lruCache) lookup(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return entry
+}
+
+// add caches value, and source unless it is nil. Adding an entry again
+// without a source keeps the one it had.
+func (c *lruCache) add(key string, value, source []byte) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.value = value
+		if source != nil {
+			entry.source = source
+		}
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, source: source, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// stats returns the number of cached entries and the bytes they hold.
+func (c *lruCache) stats() (entries, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		bytes += len(entry.value) + len(entry.source)
+	}
+	return c.order.Len(), bytes
+}
@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type sourcesRequest struct {
+	Code   string       `json:"code"`
+	Parser string       `json:"parser"`
+	Format string       `json:"format"`
+	Files  []sourceFile `json:"files"`
+}
+
+type callGraphResponse struct {
+	*analyze.CallGraph
+	Errors []fileError `json:"errors"`
+}
+
+// handleCallGraph reports which of the methods defined in a snippet, or
+// across the files of a project, call which others.
+func (s *server) handleCallGraph(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req sourcesRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Format != "" && req.Format != analyze.DefaultFormat && req.Format != "dot" {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	files, fileErrors, ok := s.parseSourceFiles(w, r, parser, req.Code, req.Files)
+	if !ok {
+		return
+	}
+
+	g := analyze.BuildCallGraph(files)
+	w.Header().Set("X-Parser", parser.Name())
+	if req.Format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		analyze.WriteCallGraphDOT(w, g)
+		return
+	}
+	writeJSON(w, callGraphResponse{g, fileErrors})
+}
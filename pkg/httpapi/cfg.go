@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// cfgRequest picks the method to graph by its qualified or bare name, or
+// the first one in the code without one.
+type cfgRequest struct {
+	Code   string `json:"code"`
+	Parser string `json:"parser"`
+	Method string `json:"method"`
+	Format string `json:"format"`
+}
+
+// handleCFG builds the control flow graph of one method, as JSON or DOT.
+func (s *server) handleCFG(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req cfgRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Format != "" && req.Format != analyze.DefaultFormat && req.Format != "dot" {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	g, ok := analyze.BuildCFG(root, req.Code, req.Method)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown method")
+		return
+	}
+	w.Header().Set("X-Parser", parser.Name())
+	if req.Format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		analyze.WriteCFGDOT(w, g)
+		return
+	}
+	writeJSON(w, g)
+}
@@ -0,0 +1,31 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// handleCodeMetrics reports cyclomatic complexity, ABC score and size for
+// each method, and size and total complexity for each class and module.
+func (s *server) handleCodeMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req codeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, analyze.ComputeCodeMetrics(root))
+}
@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type commentsResponse struct {
+	Comments []analyze.CommentInfo `json:"comments"`
+}
+
+// handleComments returns every comment in the posted code with its range
+// and the node it belongs to. Only backends that keep comments in the tree
+// (stree does) report any.
+func (s *server) handleComments(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req codeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, commentsResponse{analyze.ExtractComments(root, req.Code)})
+}
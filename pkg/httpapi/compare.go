@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+type compareRequest struct {
+	Code    string   `json:"code"`
+	Parsers []string `json:"parsers"`
+}
+
+type compareResponse struct {
+	Results map[string]batchResult `json:"results"`
+	Summary analyze.CompareSummary `json:"summary"`
+}
+
+// handleCompare runs the same code through several backends and returns
+// their output side by side, along with a summary of where their trees
+// disagree. Trees are compared by the source ranges their nodes cover, since
+// that is what the backends have in common.
+func (s *server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req compareRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	names := req.Parsers
+	if len(names) == 0 {
+		for _, name := range fallbackOrder {
+			if _, ok := s.parsers[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	seen := make(map[string]bool, len(names))
+	parsers := make([]parser.Parser, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Parser %q is listed twice", name))
+			return
+		}
+		seen[name] = true
+		p, ok := s.parsers[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Unknown parser")
+			return
+		}
+		parsers = append(parsers, p)
+	}
+	if len(parsers) < 2 {
+		writeError(w, http.StatusBadRequest, "Comparing needs at least two parsers")
+		return
+	}
+
+	results := make([]batchResult, len(parsers))
+	invalid := make([]bool, len(parsers))
+	var wg sync.WaitGroup
+	for i, p := range parsers {
+		wg.Add(1)
+		go func(i int, p parser.Parser) {
+			defer wg.Done()
+			output, _, err := s.parse(r.Context(), p, req.Code)
+			if err != nil {
+				_, resp := parseErrorResponse(r.Context(), p, err)
+				results[i].Error = &resp
+				invalid[i] = parser.IsSyntaxError(err)
+				return
+			}
+			results[i].AST = output
+		}(i, p)
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return
+	}
+
+	summary := analyze.CompareSummary{ValidityAgrees: true, Backends: make(map[string]*analyze.BackendStats, len(parsers))}
+	ranges := make([]map[analyze.Location][]string, len(parsers))
+	for i, result := range results {
+		name := names[i]
+		summary.Backends[name] = nil
+		if result.Error != nil {
+			continue
+		}
+		// Some backends, ripper among them, don't produce a tree of typed
+		// nodes; they are still shown, just left out of the comparison.
+		root, err := analyze.DecodeAST(result.AST)
+		if err != nil {
+			continue
+		}
+		var stats analyze.BackendStats
+		ranges[i], stats = analyze.NodeRanges(root)
+		summary.Backends[name] = &stats
+	}
+	// A backend that failed outright has no say in whether the code is
+	// valid.
+	var verdicts []bool
+	for i, result := range results {
+		if result.Error == nil || invalid[i] {
+			verdicts = append(verdicts, invalid[i])
+		}
+	}
+	for _, v := range verdicts {
+		if v != verdicts[0] {
+			summary.ValidityAgrees = false
+		}
+	}
+	for i := 0; i < len(parsers); i++ {
+		for j := i + 1; j < len(parsers); j++ {
+			pair := [2]string{names[i], names[j]}
+			if ranges[i] == nil || ranges[j] == nil {
+				summary.Pairs = append(summary.Pairs, analyze.PairComparison{Parsers: pair})
+				continue
+			}
+			summary.Pairs = append(summary.Pairs, analyze.CompareRanges(pair, ranges[i], ranges[j]))
+		}
+	}
+
+	byName := make(map[string]batchResult, len(parsers))
+	for i, result := range results {
+		byName[names[i]] = result
+	}
+	writeJSON(w, compareResponse{byName, summary})
+}
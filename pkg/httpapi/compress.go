@@ -0,0 +1,197 @@
+package httpapi
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinBytes is the smallest response worth compressing; below it the
+// encoding overhead outweighs the saving.
+const compressMinBytes = 1024
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() interface{} { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if the client accepts neither.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[enc]; ok || !listed && accepted["*"] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressible reports whether a response of this type is text that
+// compresses well. Event streams are left alone, since each event has to
+// reach the client as soon as it is written.
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/x-ndjson",
+		mediaType == "application/javascript",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it: only compressible types of at least compressMinBytes are.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < compressMinBytes {
+			return len(b), nil
+		}
+		cw.decide(true)
+		buf := cw.buf
+		cw.buf = nil
+		if _, err := cw.write(buf); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return cw.write(b)
+}
+
+func (cw *compressWriter) write(b []byte) (int, error) {
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressing from here on if the response is
+// large enough and its type and status allow it.
+func (cw *compressWriter) decide(large bool) {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if large && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// The compressed body is a different representation, so a strong
+		// ETag would no longer be accurate.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		} else {
+			fw := flateWriters.Get().(*flate.Writer)
+			fw.Reset(cw.ResponseWriter)
+			cw.enc = fw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Flush sends what has been written so far, so streamed responses still
+// arrive incrementally when compressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) > 0)
+		buf := cw.buf
+		cw.buf = nil
+		cw.write(buf)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close writes out whatever is still buffered and finishes the compressed
+// stream.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written; leave the default response to net/http.
+			return
+		}
+		cw.decide(len(cw.buf) >= compressMinBytes)
+		cw.write(cw.buf)
+	}
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		enc.Close()
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		enc.Close()
+		flateWriters.Put(enc)
+	}
+}
+
+// compress gzips or deflates text responses, JSON above all, for clients
+// that accept it. AST JSON for a medium-sized file runs to megabytes and
+// shrinks about tenfold.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
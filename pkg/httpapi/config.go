@@ -0,0 +1,369 @@
+package httpapi
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the upper-cased flag name to get the environment
+// variable that sets its default, e.g. -ruby-bin is RUBY_AST_RUBY_BIN.
+const envPrefix = "RUBY_AST_"
+
+type config struct {
+	Port                  int
+	Listen                string
+	SocketMode            fs.FileMode
+	TLSCert               string
+	TLSKey                string
+	AutocertDomains       []string
+	AutocertCache         string
+	AutocertEmail         string
+	HTTPAddr              string
+	RubyBin               string
+	Rubies                []string
+	DiscoverRubies        bool
+	Strict                bool
+	Workers               int
+	Sandbox               bool
+	SandboxCPU            time.Duration
+	SandboxMemoryMB       int64
+	SandboxFileSizeMB     int64
+	SandboxIsolateNetwork bool
+	SandboxWrapper        string
+	MaxConcurrent         int
+	MaxQueued             int
+	BreakerThreshold      int
+	BreakerCooldown       time.Duration
+	DotBin                string
+	RubocopBin            string
+	RubocopConfig         string
+	BrakemanBin           string
+	BrakemanTimeout       time.Duration
+	AllowedOrigins        []string
+	RateLimit             float64
+	RateBurst             int
+	TrustedProxies        []netip.Prefix
+	APIKeys               []string
+	APIKeysFile           string
+	CORSCredentials       bool
+	FetchHosts            []string
+	CloneHosts            []string
+	GitBin                string
+	CloneTimeout          time.Duration
+	GitHubWebhookSecret   string
+	GitHubToken           string
+	GitHubClientID        string
+	GitHubClientSecret    string
+	GitHubTokenKey        string
+	GitHubLoginTTL        time.Duration
+	Compress              bool
+	MaxBodyBytes          int64
+	MaxUploadBytes        int64
+	Timeout               time.Duration
+	CacheSize             int
+	CacheTTL              time.Duration
+	LiveDebounce          time.Duration
+	Storage               string
+	StorageMemory         int
+	StorageSessions       int
+	History               int
+	HistoryTTL            time.Duration
+	Jobs                  int
+	JobQueue              int
+	JobTTL                time.Duration
+	ExamplesDir           string
+	Watch                 string
+	WatchInterval         time.Duration
+	ShutdownTimeout       time.Duration
+	LogFormat             string
+	LogLevel              slog.Level
+	OTLPEndpoint          string
+	AdminAddr             string
+	GRPCAddr              string
+	LSP                   string
+	AdminToken            string
+	ServiceName           string
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// fileMode is a permission mode written in octal, like chmod's.
+type fileMode fs.FileMode
+
+func (m *fileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("invalid mode %q", value)
+	}
+	*m = fileMode(mode)
+	return nil
+}
+
+// prefixList is a comma-separated list of CIDR ranges or single addresses.
+type prefixList []netip.Prefix
+
+func (l *prefixList) String() string {
+	parts := make([]string, len(*l))
+	for i, prefix := range *l {
+		parts[i] = prefix.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *prefixList) Set(value string) error {
+	var values stringList
+	values.Set(value)
+	*l = nil
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return err
+			}
+			*l = append(*l, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, prefix.Masked())
+	}
+	return nil
+}
+
+// loadConfig reads settings from environment variables, then lets args
+// override them.
+func loadConfig(args []string) (*config, error) {
+	cfg := &config{
+		SocketMode:     0o660,
+		AllowedOrigins: []string{"*"},
+		FetchHosts:     []string{"github.com", "raw.githubusercontent.com", "gist.github.com", "gist.githubusercontent.com"},
+		CloneHosts:     []string{"github.com", "gitlab.com", "bitbucket.org", "codeberg.org"},
+	}
+
+	fs := flag.NewFlagSet("ruby-ast-visualizer", flag.ExitOnError)
+	fs.IntVar(&cfg.Port, "port", 4000, "port to listen on")
+	fs.StringVar(&cfg.Listen, "listen", "", "address to listen on instead of -port: host:port, unix:/path/to.sock, or systemd for socket activation")
+	fs.Var((*fileMode)(&cfg.SocketMode), "socket-mode", "permissions of the socket created by -listen unix:..., in octal")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "certificate file, PEM-encoded with any intermediates, to serve HTTPS with")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "private key file for -tls-cert")
+	fs.Var((*stringList)(&cfg.AutocertDomains), "autocert-domain", "comma-separated domains to get Let's Encrypt certificates for and serve HTTPS; use with -port 443")
+	fs.StringVar(&cfg.AutocertCache, "autocert-cache", "", "directory to keep Let's Encrypt certificates in (default: the user cache directory)")
+	fs.StringVar(&cfg.AutocertEmail, "autocert-email", "", "contact address given to Let's Encrypt for expiry notices")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", ":80", "with -autocert-domain, address answering ACME challenges and redirecting to https, or empty for none")
+	fs.StringVar(&cfg.RubyBin, "ruby-bin", "ruby", "Ruby interpreter used to run the parsers")
+	fs.Var((*stringList)(&cfg.Rubies), "rubies", "comma-separated version=path pairs of other Rubies that ruby_version can pick, e.g. 3.0=/opt/ruby-3.0/bin/ruby")
+	fs.BoolVar(&cfg.DiscoverRubies, "discover-rubies", true, "also offer the Rubies installed by rbenv, asdf, mise, rvm and chruby to ruby_version")
+	fs.BoolVar(&cfg.Strict, "strict", false, "refuse to start if Ruby or syntax_tree can't be found")
+	fs.StringVar(&cfg.DotBin, "dot-bin", "dot", "Graphviz dot binary used to render SVG and PNG")
+	fs.StringVar(&cfg.GitBin, "git-bin", "git", "git binary used by /parse/repo")
+	fs.StringVar(&cfg.RubocopBin, "rubocop-bin", "rubocop", "RuboCop binary used by /lint")
+	fs.StringVar(&cfg.RubocopConfig, "rubocop-config", "", "RuboCop configuration file, instead of its own lookup")
+	fs.StringVar(&cfg.BrakemanBin, "brakeman-bin", "brakeman", "Brakeman binary used by /analyze/security")
+	fs.IntVar(&cfg.Workers, "workers", runtime.NumCPU(), "number of persistent stree worker processes")
+	fs.BoolVar(&cfg.Sandbox, "sandbox", true, "run the Ruby parser processes with resource limits, an empty working directory and a scrubbed environment")
+	fs.DurationVar(&cfg.SandboxCPU, "sandbox-cpu", 10*time.Second, "CPU time a one-shot parser process may use, or 0 for no limit")
+	fs.Int64Var(&cfg.SandboxMemoryMB, "sandbox-memory-mb", 2048, "address space a parser process may use in MiB, or 0 for no limit")
+	fs.Int64Var(&cfg.SandboxFileSizeMB, "sandbox-file-size-mb", 16, "largest file a parser process may write in MiB, or 0 for no limit")
+	fs.BoolVar(&cfg.SandboxIsolateNetwork, "sandbox-isolate-network", false, "cut parser processes off from the network with a network namespace (Linux, needs unprivileged user namespaces)")
+	fs.StringVar(&cfg.SandboxWrapper, "sandbox-wrapper", "", "also run parser processes under bwrap or nsjail")
+	fs.IntVar(&cfg.MaxConcurrent, "max-concurrent", runtime.NumCPU(), "maximum parser and tool processes running at once")
+	fs.IntVar(&cfg.MaxQueued, "max-queued", 64, "maximum parses waiting for a process slot before answering 503")
+	fs.IntVar(&cfg.BreakerThreshold, "breaker-threshold", 5, "consecutive failures after which a parser backend is disabled for -breaker-cooldown, or 0 to never disable")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "how long a failing parser backend stays disabled before it is tried again")
+	fs.Var((*stringList)(&cfg.AllowedOrigins), "allowed-origins", "comma-separated CORS origins, such as https://example.com or https://*.example.com, or * for any")
+	fs.BoolVar(&cfg.CORSCredentials, "cors-credentials", false, "allow credentialed CORS requests (cookies, HTTP auth) from the origins -allowed-origins lists, which then can't include *")
+	fs.Var((*stringList)(&cfg.FetchHosts), "fetch-hosts", "comma-separated hosts /parse/url may fetch from")
+	fs.Var((*stringList)(&cfg.CloneHosts), "clone-hosts", "comma-separated hosts /parse/repo may clone from")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 10, "requests per second allowed per client IP, or 0 for no limit")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", 30, "requests a client IP may make at once before -rate-limit applies")
+	fs.Var((*prefixList)(&cfg.TrustedProxies), "trusted-proxies", "comma-separated proxy addresses or CIDR ranges whose X-Forwarded-For is believed")
+	fs.Var((*stringList)(&cfg.APIKeys), "api-keys", "comma-separated name:key pairs; if any keys are set, API requests must carry one")
+	fs.StringVar(&cfg.APIKeysFile, "api-keys-file", "", "file of API keys, one \"name key [rate [burst]]\" per line")
+	fs.BoolVar(&cfg.Compress, "compress", true, "gzip or deflate JSON, SVG and other text responses for clients that accept it")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", 1<<20, "maximum size of a request body in bytes")
+	fs.Int64Var(&cfg.MaxUploadBytes, "max-upload-bytes", 20<<20, "maximum size of a /parse/project zip upload in bytes")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "maximum time a single parse may take")
+	fs.DurationVar(&cfg.CloneTimeout, "clone-timeout", time.Minute, "maximum time cloning a repository for /parse/repo may take")
+	fs.StringVar(&cfg.GitHubWebhookSecret, "github-webhook-secret", "", "secret GitHub signs push events to /webhooks/github with; the webhook is off if empty")
+	fs.StringVar(&cfg.GitHubToken, "github-token", "", "token /webhooks/github fetches changed files with, for private repositories")
+	fs.StringVar(&cfg.GitHubClientID, "github-client-id", "", "client ID of the GitHub OAuth app users sign in with at /auth/github/login to parse their private repositories")
+	fs.StringVar(&cfg.GitHubClientSecret, "github-client-secret", "", "client secret of the GitHub OAuth app")
+	fs.StringVar(&cfg.GitHubTokenKey, "github-token-key", "", "64 hex digits of AES key that encrypts GitHub sign-ins in -storage (default: a random key, so sign-ins end on restart)")
+	fs.DurationVar(&cfg.GitHubLoginTTL, "github-login-ttl", 24*time.Hour, "how long a GitHub sign-in lasts before the user has to sign in again")
+	fs.DurationVar(&cfg.BrakemanTimeout, "brakeman-timeout", 2*time.Minute, "maximum time a Brakeman scan for /analyze/security may take")
+	fs.IntVar(&cfg.CacheSize, "cache-size", 256, "number of parse results to cache, or 0 to disable caching")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", 10*time.Minute, "how long cached parse results stay valid, or 0 for no expiry")
+	fs.DurationVar(&cfg.LiveDebounce, "live-debounce", 150*time.Millisecond, "quiet period before a /ws code update is parsed")
+	fs.StringVar(&cfg.Storage, "storage", "memory", "where shared snippets, parse history and, for other replicas to use, parse results are kept: memory, sqlite:<path>, file:<dir> or a redis:// URL")
+	fs.IntVar(&cfg.StorageMemory, "storage-memory", 1000, "with -storage memory, number of snippets to keep")
+	fs.IntVar(&cfg.StorageSessions, "storage-sessions", 1000, "with -storage memory, number of session histories to keep, and separately of GitHub sign-ins")
+	fs.IntVar(&cfg.History, "history", 50, "number of recent parses to keep per session for /history, or 0 to disable it")
+	fs.DurationVar(&cfg.HistoryTTL, "history-ttl", 7*24*time.Hour, "how long a session's parse history is kept after its last parse")
+	fs.IntVar(&cfg.Jobs, "jobs", 4, "number of background jobs that may run at once, or 0 to disable them")
+	fs.IntVar(&cfg.JobQueue, "job-queue", 100, "number of background jobs that may wait to run before more are refused")
+	fs.DurationVar(&cfg.JobTTL, "job-ttl", time.Hour, "how long a finished job's result is kept for /jobs")
+	fs.StringVar(&cfg.ExamplesDir, "examples-dir", "", "directory of extra /examples snippets, one subdirectory of .rb files per group")
+	fs.StringVar(&cfg.Watch, "watch", "", "directory of Ruby files to keep parsed and stream from /watch")
+	fs.DurationVar(&cfg.WatchInterval, "watch-interval", 500*time.Millisecond, "how often -watch checks for changed files")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests on shutdown")
+	fs.StringVar(&cfg.LogFormat, "log-format", "json", "log output format: json or text")
+	fs.StringVar(&cfg.AdminAddr, "admin-addr", "", "address for a separate listener serving /debug/pprof and /debug/stats, e.g. 127.0.0.1:6060")
+	fs.StringVar(&cfg.GRPCAddr, "grpc-addr", "", "address for a listener serving the gRPC API in proto/rubyast/v1, e.g. :9090")
+	fs.StringVar(&cfg.LSP, "lsp", "", "serve the Language Server Protocol: stdio to speak it on stdin and stdout instead of serving HTTP, or an address such as 127.0.0.1:2087 to listen for editors as well")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "bearer token that unlocks /debug/pprof and /debug/stats, on the main listener too")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector to send traces to, e.g. http://localhost:4318; tracing is off if empty")
+	fs.StringVar(&cfg.ServiceName, "service-name", envOr("OTEL_SERVICE_NAME", "ruby-ast-visualizer"), "service.name reported with traces")
+	fs.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "minimum level to log: debug, info, warn or error")
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && envErr == nil {
+			if err := f.Value.Set(value); err != nil {
+				envErr = fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	fs.Parse(args)
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("-workers must be at least 1")
+	}
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("-timeout must be positive")
+	}
+	if cfg.SandboxCPU < 0 || cfg.SandboxMemoryMB < 0 || cfg.SandboxFileSizeMB < 0 {
+		return nil, fmt.Errorf("-sandbox limits must not be negative")
+	}
+	for _, entry := range cfg.Rubies {
+		if version, bin, ok := strings.Cut(entry, "="); !ok || version == "" || bin == "" {
+			return nil, fmt.Errorf("-rubies: %q is not version=path", entry)
+		}
+	}
+	if cfg.MaxConcurrent < 1 {
+		return nil, fmt.Errorf("-max-concurrent must be at least 1")
+	}
+	if cfg.MaxQueued < 0 {
+		return nil, fmt.Errorf("-max-queued must not be negative")
+	}
+	if cfg.RateLimit > 0 && cfg.RateBurst < 1 {
+		return nil, fmt.Errorf("-rate-burst must be at least 1")
+	}
+	if cfg.MaxBodyBytes < 1 {
+		return nil, fmt.Errorf("-max-body-bytes must be positive")
+	}
+	if cfg.Watch != "" && cfg.WatchInterval <= 0 {
+		return nil, fmt.Errorf("-watch-interval must be positive")
+	}
+	if cfg.LiveDebounce <= 0 {
+		return nil, fmt.Errorf("-live-debounce must be positive")
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		return nil, fmt.Errorf("-log-format must be json or text")
+	}
+	if cfg.StorageMemory < 1 {
+		return nil, fmt.Errorf("-storage-memory must be at least 1")
+	}
+	if cfg.StorageSessions < 1 {
+		return nil, fmt.Errorf("-storage-sessions must be at least 1")
+	}
+	if cfg.History < 0 {
+		return nil, fmt.Errorf("-history must not be negative")
+	}
+	if cfg.HistoryTTL <= 0 {
+		return nil, fmt.Errorf("-history-ttl must be positive")
+	}
+	if cfg.Jobs < 0 {
+		return nil, fmt.Errorf("-jobs must not be negative")
+	}
+	if cfg.JobQueue < 1 {
+		return nil, fmt.Errorf("-job-queue must be at least 1")
+	}
+	if cfg.JobTTL <= 0 {
+		return nil, fmt.Errorf("-job-ttl must be positive")
+	}
+	if cfg.MaxUploadBytes < 1 {
+		return nil, fmt.Errorf("-max-upload-bytes must be positive")
+	}
+	if cfg.CloneTimeout <= 0 {
+		return nil, fmt.Errorf("-clone-timeout must be positive")
+	}
+	if cfg.BrakemanTimeout <= 0 {
+		return nil, fmt.Errorf("-brakeman-timeout must be positive")
+	}
+	if cfg.CORSCredentials {
+		for _, origin := range cfg.AllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("-cors-credentials needs -allowed-origins to list origins, not *")
+			}
+		}
+	}
+	if (cfg.GitHubClientID == "") != (cfg.GitHubClientSecret == "") {
+		return nil, fmt.Errorf("-github-client-id and -github-client-secret must be given together")
+	}
+	if key, err := hex.DecodeString(cfg.GitHubTokenKey); err != nil || (cfg.GitHubTokenKey != "" && len(key) != 32) {
+		return nil, fmt.Errorf("-github-token-key must be 64 hex digits")
+	}
+	if cfg.GitHubLoginTTL <= 0 {
+		return nil, fmt.Errorf("-github-login-ttl must be positive")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.TLSCert != "" && len(cfg.AutocertDomains) > 0 {
+		return nil, fmt.Errorf("-tls-cert and -autocert-domain can't both be used")
+	}
+	if len(cfg.AutocertDomains) > 0 && cfg.AutocertCache == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("-autocert-cache is needed: %w", err)
+		}
+		cfg.AutocertCache = filepath.Join(dir, "ruby-ast-visualizer", "autocert")
+	}
+	return cfg, nil
+}
+
+// envOr returns the environment variable name, or fallback if it is unset.
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func (c *config) addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-None-Match, X-API-Key, X-Request-ID, traceparent"
+	corsExposeHeaders = "ETag, X-Cache, X-Parser, X-Parse-ID, X-Lexer, X-Request-ID, X-Ruby-Version, X-Source-Encoding"
+	corsMaxAge        = 600
+)
+
+// originMatches reports whether origin matches an -allowed-origins pattern:
+// "*", an exact origin, or one with a wildcard subdomain such as
+// https://*.example.com, which matches any subdomain but not example.com
+// itself. Origins are compared case-insensitively.
+func originMatches(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if origin == "" {
+		return false
+	}
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	if i := strings.Index(pattern, "://*."); i >= 0 {
+		scheme, domain := pattern[:i+3], pattern[i+4:]
+		host := strings.TrimPrefix(origin, scheme)
+		return host != origin && strings.HasSuffix(host, domain) && len(host) > len(domain)
+	}
+	return pattern == origin
+}
+
+func (s *server) originAllowed(origin string) bool {
+	for _, pattern := range s.cfg.AllowedOrigins {
+		if originMatches(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// cors applies the CORS policy to every request and answers preflights
+// itself, so handlers only ever see the actual requests. With credentials
+// enabled the matching origin is echoed back, since browsers reject a
+// wildcard on credentialed requests; loadConfig makes sure that only
+// happens for origins listed explicitly.
+func (s *server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin != "" && s.originAllowed(origin) {
+			if s.cfg.CORSCredentials {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Allow-Credentials", "true")
+			} else if s.originAllowed("") { // only "*" matches no origin
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type deadCodeResponse struct {
+	DeadCode []analyze.DeadCode `json:"dead_code"`
+}
+
+// handleDeadCode reports unreachable statements, branches ruled out by
+// literal conditions, and private methods the snippet never calls.
+func (s *server) handleDeadCode(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req codeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, deadCodeResponse{analyze.FindDeadCode(root)})
+}
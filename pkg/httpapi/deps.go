@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type depsRequest struct {
+	Parser string       `json:"parser"`
+	Format string       `json:"format"`
+	Files  []sourceFile `json:"files"`
+}
+
+type depsResponse struct {
+	*analyze.DepGraph
+	Errors []fileError `json:"errors"`
+}
+
+// handleDeps builds the graph of which files of a project load which, from
+// their require, require_relative and autoload statements.
+func (s *server) handleDeps(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req depsRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if len(req.Files) == 0 {
+		writeError(w, http.StatusBadRequest, "No files given")
+		return
+	}
+	if req.Format != "" && req.Format != analyze.DefaultFormat && req.Format != "dot" {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	files, fileErrors, ok := s.parseSourceFiles(w, r, parser, "", req.Files)
+	if !ok {
+		return
+	}
+
+	paths := make([]string, len(req.Files))
+	for i, f := range req.Files {
+		paths[i] = f.Path
+	}
+	g := analyze.BuildDepGraph(paths, files)
+	w.Header().Set("X-Parser", parser.Name())
+	if req.Format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		analyze.WriteDepsDOT(w, g)
+		return
+	}
+	writeJSON(w, depsResponse{g, fileErrors})
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type diffRequest struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Parser string `json:"parser"`
+}
+
+// handleDiff parses two snippets and reports how their trees differ.
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req diffRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	var roots [2]*analyze.Node
+	for i, input := range []string{"before", "after"} {
+		code := req.Before
+		if input == "after" {
+			code = req.After
+		}
+		output, _, err := s.parse(r.Context(), parser, code)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			status, resp := parseErrorResponse(r.Context(), parser, err)
+			resp.Input = input
+			writeErrorResponse(w, status, resp)
+			return
+		}
+		if roots[i], ok = decodeOutput(w, output); !ok {
+			return
+		}
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, analyze.DiffTrees(roots[0], roots[1]))
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// duplicationRequest takes a snippet or a project's files as a
+// sourcesRequest does, and the smallest subtree, in nodes, worth reporting.
+type duplicationRequest struct {
+	Code    string       `json:"code"`
+	Parser  string       `json:"parser"`
+	Files   []sourceFile `json:"files"`
+	MinMass int          `json:"min_mass"`
+}
+
+type duplicationResponse struct {
+	Duplicates []analyze.Duplicate `json:"duplicates"`
+	Truncated  bool                `json:"truncated"`
+	Errors     []fileError         `json:"errors"`
+}
+
+// handleDuplication reports structurally duplicated code within one snippet
+// or across the files of a project.
+func (s *server) handleDuplication(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req duplicationRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.MinMass == 0 {
+		req.MinMass = analyze.DefaultMinMass
+	}
+	if req.MinMass < 2 {
+		writeError(w, http.StatusBadRequest, "Invalid min_mass")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	files, fileErrors, ok := s.parseSourceFiles(w, r, parser, req.Code, req.Files)
+	if !ok {
+		return
+	}
+
+	duplicates, truncated := analyze.FindDuplicates(files, req.MinMass)
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, duplicationResponse{duplicates, truncated, fileErrors})
+}
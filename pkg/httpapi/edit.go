@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// incrementalParsers are the backends /parse/edit can splice a reparsed
+// scope into. Only stree keeps every position in "location"; prism also has
+// name_loc and the like, which a splice wouldn't move, so its trees are
+// always parsed again in full.
+var incrementalParsers = map[string]bool{"stree": true}
+
+type editRequest struct {
+	ID     string           `json:"id"`
+	Parser string           `json:"parser"`
+	Edit   analyze.TextEdit `json:"edit"`
+	analyze.FormatOptions
+}
+
+// editResponse is the tree after an edit. Reparsed is the path of the node
+// that was parsed again when Incremental is set; otherwise the whole code
+// was, unless it was already in the cache.
+type editResponse struct {
+	ID          string          `json:"id"`
+	Incremental bool            `json:"incremental"`
+	Reparsed    string          `json:"reparsed,omitempty"`
+	AST         json.RawMessage `json:"ast"`
+}
+
+// handleEdit applies a text edit to the code of a recent parse and returns
+// the new tree. An edit inside a method, class or module only has that
+// parsed again, with the rest of the old tree kept, so typing stays quick in
+// large files.
+func (s *server) handleEdit(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req editRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if !checkFormatOptions(w, analyze.DefaultFormat, req.FormatOptions) {
+		return
+	}
+	p, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	output, ok := s.cache.get(req.ID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown or expired parse ID")
+		return
+	}
+	old, ok := s.cache.source(req.ID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "The source of that parse is no longer kept; parse it again")
+		return
+	}
+	code, ok := analyze.ApplyEdit(string(old), req.Edit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Edit range is outside the code")
+		return
+	}
+
+	resp := editResponse{ID: parseID(p, code)}
+	var next []byte
+	if _, cached := s.cache.get(resp.ID); !cached && incrementalParsers[p.Name()] {
+		next, resp.Reparsed = s.reparseScope(r.Context(), p, output, string(old), code, req.Edit)
+		resp.Incremental = next != nil
+	}
+	if next == nil {
+		var err error
+		if next, _, err = s.parse(r.Context(), p, code); err != nil {
+			writeParseError(w, r, p, err)
+			return
+		}
+	}
+
+	req.Source = code
+	_, ast, err := analyze.RenderFormat(analyze.DefaultFormat, next, req.FormatOptions)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render json output")
+		return
+	}
+	resp.AST = ast
+	w.Header().Set("X-Parser", p.Name())
+	w.Header().Set("X-Parse-ID", resp.ID)
+	writeJSON(w, resp)
+}
+
+// reparseScope parses only the scope around the edit again and splices it
+// into output, the tree of old, caching the result as the parse of code. It
+// returns nil if the edit isn't inside a scope that can be parsed on its
+// own, or the scope no longer parses the same way there, leaving it to a
+// full parse to give the tree or the syntax error.
+func (s *server) reparseScope(ctx context.Context, p parser.Parser, output []byte, old, code string, e analyze.TextEdit) ([]byte, string) {
+	root, err := analyze.DecodeAST(output)
+	if err != nil {
+		return nil, ""
+	}
+	path, source, ok := analyze.EditScope(root, old, e)
+	if !ok {
+		return nil, ""
+	}
+	snippet, _, err := s.parse(ctx, p, source)
+	if err != nil {
+		return nil, ""
+	}
+	program, err := analyze.DecodeAST(snippet)
+	if err != nil || !analyze.SpliceEdit(root, old, path, e, program) {
+		return nil, ""
+	}
+	spliced, err := json.Marshal(root)
+	if err != nil {
+		return nil, ""
+	}
+	key := parseID(p, code)
+	s.cache.add(key, spliced, []byte(code))
+	return spliced, path
+}
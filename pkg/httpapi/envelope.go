@@ -0,0 +1,145 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiVersion is the current version of the API, mounted at /api/v1/. A
+// breaking change gets a new version alongside it, built from its own
+// endpoint table, while the old one keeps answering.
+const apiVersion = "v1"
+
+// responseMeta describes how a versioned response was produced.
+type responseMeta struct {
+	APIVersion string  `json:"api_version"`
+	Version    string  `json:"version"`
+	Parser     string  `json:"parser,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// errorEnvelope is a versioned error: the usual errorResponse with the
+// response's metadata.
+type errorEnvelope struct {
+	errorResponse
+	Meta responseMeta `json:"meta"`
+}
+
+// envelopeWriter wraps a handler's JSON response as {"data": ..., "meta":
+// ...}. The body still goes through as it is written, so streamed trees
+// stay streamed, and the metadata follows once the handler has finished.
+// Errors are small, so they're held back and given the metadata alongside
+// their own fields instead. Other content types pass through untouched.
+type envelopeWriter struct {
+	http.ResponseWriter
+	start time.Time
+
+	status  int
+	started bool
+	wrapped bool
+	wrote   bool
+	errBody []byte
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.started {
+		return
+	}
+	ew.started = true
+	ew.status = status
+	h := ew.Header()
+	if h.Get("Content-Type") != "application/json" || status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		ew.ResponseWriter.WriteHeader(status)
+		return
+	}
+	ew.wrapped = true
+	h.Del("Content-Length")
+	ew.ResponseWriter.WriteHeader(status)
+	if status < http.StatusBadRequest {
+		io.WriteString(ew.ResponseWriter, `{"data":`)
+	}
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if !ew.started {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if !ew.wrapped {
+		return ew.ResponseWriter.Write(b)
+	}
+	ew.wrote = true
+	if ew.status >= http.StatusBadRequest {
+		ew.errBody = append(ew.errBody, b...)
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *envelopeWriter) Flush() {
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+func (ew *envelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(ew.ResponseWriter).Hijack()
+}
+
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *envelopeWriter) meta() responseMeta {
+	parser := ew.Header().Get("X-Parser")
+	if parser == "" {
+		parser = ew.Header().Get("X-Lexer")
+	}
+	return responseMeta{
+		APIVersion: apiVersion,
+		Version:    version,
+		Parser:     parser,
+		DurationMS: float64(time.Since(ew.start).Microseconds()) / 1000,
+	}
+}
+
+// finish closes the envelope. It isn't called if the handler panics, as
+// when a stream is cut short, so a broken body isn't made to look whole.
+func (ew *envelopeWriter) finish() {
+	if !ew.wrapped {
+		return
+	}
+	meta, err := json.Marshal(ew.meta())
+	if err != nil {
+		slog.Error("Error encoding response metadata", "err", err)
+		return
+	}
+	if ew.status >= http.StatusBadRequest {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(ew.errBody, &fields); err != nil {
+			// Not an object; send it as the handler wrote it.
+			ew.ResponseWriter.Write(ew.errBody)
+			return
+		}
+		fields["meta"] = meta
+		writeJSON(ew.ResponseWriter, fields)
+		return
+	}
+	if !ew.wrote {
+		io.WriteString(ew.ResponseWriter, "null")
+	}
+	io.WriteString(ew.ResponseWriter, `,"meta":`)
+	ew.ResponseWriter.Write(meta)
+	io.WriteString(ew.ResponseWriter, "}\n")
+}
+
+// withEnvelope wraps a handler's JSON responses in the versioned envelope.
+func withEnvelope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ew := &envelopeWriter{ResponseWriter: w, start: time.Now()}
+		next(ew, r)
+		ew.finish()
+	}
+}
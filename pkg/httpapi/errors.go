@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// errorResponse is the JSON body of every non-2xx response. Line and Column
+// are 1-based and only set for syntax errors with a known position. Input
+// names the offending input on endpoints that parse more than one, and
+// Encoding the encoding of source that couldn't be converted to UTF-8.
+type errorResponse struct {
+	Error    string `json:"error"`
+	Parser   string `json:"parser,omitempty"`
+	Input    string `json:"input,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorResponse(w, status, errorResponse{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, resp errorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+// encodingErrorResponse describes source DecodeSource rejected, prefixing
+// its reason with message.
+func encodingErrorResponse(message string, err error) errorResponse {
+	resp := errorResponse{Error: message}
+	var encErr *analyze.EncodingError
+	if errors.As(err, &encErr) {
+		resp.Error += ": " + encErr.Error()
+		resp.Encoding, resp.Line, resp.Column = encErr.Encoding, encErr.Line, encErr.Column
+	}
+	return resp
+}
+
+// parseErrorResponse maps a failed Parse call to a status and body: 422 with
+// the error position for invalid source, 504 if it ran out of time, and 500
+// if the backend itself failed.
+func parseErrorResponse(ctx context.Context, p parser.Parser, err error) (int, errorResponse) {
+	if errors.Is(err, errOverloaded) {
+		return http.StatusServiceUnavailable, errorResponse{
+			Error:  "Server is busy, try again shortly",
+			Parser: p.Name(),
+		}
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return http.StatusServiceUnavailable, errorResponse{
+			Error:  "The " + p.Name() + " parser is failing and temporarily disabled",
+			Parser: p.Name(),
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, errorResponse{
+			Error:  "Parse timed out",
+			Parser: p.Name(),
+		}
+	}
+
+	var se *parser.SyntaxError
+	if !errors.As(err, &se) {
+		slog.ErrorContext(ctx, "Error running parser", "parser", p.Name(), "err", err)
+		return http.StatusInternalServerError, errorResponse{
+			Error:  "Failed to execute " + p.Name() + " parser",
+			Parser: p.Name(),
+		}
+	}
+
+	resp := errorResponse{Error: se.Message, Parser: p.Name()}
+	if se.Line > 0 {
+		resp.Line = se.Line
+		resp.Column = se.Column + 1
+	}
+	return http.StatusUnprocessableEntity, resp
+}
+
+func writeParseError(w http.ResponseWriter, r *http.Request, p parser.Parser, err error) {
+	// The client has gone away; there is no one to respond to.
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	status, resp := parseErrorResponse(r.Context(), p, err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeErrorResponse(w, status, resp)
+}
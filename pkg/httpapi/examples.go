@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"embed"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed examples
+var embeddedExamples embed.FS
+
+// example is one snippet of the /examples catalog. Its ID is its path
+// without the .rb, e.g. pattern_matching/case_in, and its title the
+// snippet's first comment line.
+type example struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Code   string `json:"code"`
+	Source string `json:"source"`
+}
+
+type exampleGroup struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Examples []example `json:"examples"`
+}
+
+type examplesResponse struct {
+	Groups []exampleGroup `json:"groups"`
+}
+
+// readExamples collects the examples in fsys, one directory per group.
+// Files at its top level go in the "other" group, and files larger than
+// maxBytes, which couldn't be parsed anyway, are skipped.
+func readExamples(fsys fs.FS, source string, maxBytes int64) (map[string]example, error) {
+	examples := make(map[string]example)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != "." && strings.Contains(name, "/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(name) != ".rb" {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxBytes {
+			return err
+		}
+		code, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		id := strings.TrimSuffix(name, ".rb")
+		if !strings.Contains(id, "/") {
+			id = "other/" + id
+		}
+		examples[id] = example{ID: id, Title: exampleTitle(id, string(code)), Code: string(code), Source: source}
+		return nil
+	})
+	return examples, err
+}
+
+// exampleTitle is the text of a snippet's leading comment, or its file name
+// if it has none.
+func exampleTitle(id, code string) string {
+	line, _, _ := strings.Cut(code, "\n")
+	if title, ok := strings.CutPrefix(line, "#"); ok && strings.TrimSpace(title) != "" && !strings.HasPrefix(title, "!") {
+		return strings.TrimSpace(title)
+	}
+	return humanize(path.Base(id))
+}
+
+// humanize turns a file or directory name like pattern_matching into
+// "Pattern matching".
+func humanize(name string) string {
+	name = strings.NewReplacer("_", " ", "-", " ").Replace(name)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// examples returns the catalog: the built-in snippets and, over them, any
+// from -examples-dir, which is read afresh each time so new files show up
+// without a restart.
+func (s *server) examples() (examplesResponse, error) {
+	sub, err := fs.Sub(embeddedExamples, "examples")
+	if err != nil {
+		return examplesResponse{}, err
+	}
+	all, err := readExamples(sub, "builtin", s.cfg.MaxBodyBytes)
+	if err != nil {
+		return examplesResponse{}, err
+	}
+	if s.cfg.ExamplesDir != "" {
+		extra, err := readExamples(os.DirFS(s.cfg.ExamplesDir), "custom", s.cfg.MaxBodyBytes)
+		if err != nil {
+			return examplesResponse{}, err
+		}
+		for id, ex := range extra {
+			all[id] = ex
+		}
+	}
+
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	resp := examplesResponse{Groups: []exampleGroup{}}
+	for _, id := range ids {
+		group, _, _ := strings.Cut(id, "/")
+		if n := len(resp.Groups); n == 0 || resp.Groups[n-1].ID != group {
+			resp.Groups = append(resp.Groups, exampleGroup{ID: group, Title: humanize(group)})
+		}
+		g := &resp.Groups[len(resp.Groups)-1]
+		g.Examples = append(g.Examples, all[id])
+	}
+	return resp, nil
+}
+
+// handleExamples serves /examples, snippets to load into the editor grouped
+// by the language feature they show.
+func (s *server) handleExamples(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	resp, err := s.examples()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading examples", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load examples")
+		return
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,4 @@
+# Numbered and it parameters
+[1, 2, 3].map { _1 * 2 }
+[[1, 2], [3, 4]].map { _1 + _2 }
+%w[x y].each { puts it }
@@ -0,0 +1,7 @@
+# Procs, lambdas and &
+square = ->(x) { x * x }
+add = proc { |a, b| (a || 0) + (b || 0) }
+
+[1, 2, 3].map(&square)
+%w[a b].map(&:upcase)
+add.call(1)
@@ -0,0 +1,7 @@
+# Yielding to a block
+def twice
+  yield 1
+  yield 2
+end
+
+twice { |n| puts n * 10 }
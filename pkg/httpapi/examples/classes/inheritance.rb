@@ -0,0 +1,21 @@
+# Classes, modules and inheritance
+module Greeting
+  def greet
+    "Hello, #{name}"
+  end
+end
+
+class Person
+  include Greeting
+  attr_reader :name
+
+  def initialize(name)
+    @name = name
+  end
+end
+
+class Admin < Person
+  def greet
+    super + " (admin)"
+  end
+end
@@ -0,0 +1,14 @@
+# class << self and class methods
+class Config
+  @settings = {}
+
+  class << self
+    attr_reader :settings
+
+    def set(key, value)
+      settings[key] = value
+    end
+  end
+
+  def self.get(key) = settings[key]
+end
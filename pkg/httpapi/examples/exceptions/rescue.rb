@@ -0,0 +1,16 @@
+# begin, rescue, retry and ensure
+attempts = 0
+begin
+  attempts += 1
+  raise IOError, "flaky" if attempts < 3
+  puts "worked after #{attempts}"
+rescue IOError => e
+  retry if attempts < 3
+  warn e.message
+else
+  puts "no errors"
+ensure
+  puts "done"
+end
+
+value = Integer("x") rescue 0
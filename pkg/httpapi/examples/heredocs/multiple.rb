@@ -0,0 +1,6 @@
+# Two heredocs on one line, and a raw one
+pair = [<<~ONE, <<~'TWO']
+  first #{1 + 1}
+ONE
+  second #{not interpolated}
+TWO
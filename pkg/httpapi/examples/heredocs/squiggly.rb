@@ -0,0 +1,7 @@
+# Squiggly heredocs with interpolation
+name = "world"
+message = <<~TEXT
+  Hello, #{name}!
+    Indented more.
+TEXT
+puts message
@@ -0,0 +1,19 @@
+# define_method and method_missing
+class Record
+  %w[name email].each do |field|
+    define_method(field) { @attrs[field] }
+    define_method("#{field}=") { |value| @attrs[field] = value }
+  end
+
+  def initialize
+    @attrs = {}
+  end
+
+  def method_missing(name, *args)
+    name.end_with?("?") ? !!@attrs[name.to_s.chomp("?")] : super
+  end
+
+  def respond_to_missing?(name, include_private = false)
+    name.end_with?("?") || super
+  end
+end
@@ -0,0 +1,6 @@
+# Every kind of parameter
+def everything(a, b = 2, *rest, c, key:, opt: 4, **opts, &block)
+  block&.call(a, b, rest, c, key, opt, opts)
+end
+
+everything(1, 3, key: :k) { |*args| p args }
@@ -0,0 +1,8 @@
+# Endless methods and argument forwarding
+def square(x) = x * x
+
+def log(...)
+  puts(...)
+end
+
+log "squared:", square(4)
@@ -0,0 +1,9 @@
+# case/in with arrays and hashes
+case { status: "ok", data: { items: [1, 2] } }
+in { status: "ok", data: { items: [first, *rest] } }
+  puts first, rest.inspect
+in { status: "error", message: String => message }
+  warn message
+else
+  puts "unknown"
+end
@@ -0,0 +1,9 @@
+# Find patterns, pins and guards
+expected = 42
+
+case [1, 42, 3]
+in [*, ^expected => found, *]
+  puts "found #{found}"
+in [Integer => n, *] if n.negative?
+  puts "starts negative"
+end
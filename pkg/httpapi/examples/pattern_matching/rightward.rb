@@ -0,0 +1,6 @@
+# Standalone pattern matching
+config = { host: "localhost", port: 8080 }
+config => { host:, port: }
+puts "#{host}:#{port}"
+
+puts "has port" if config in { port: Integer }
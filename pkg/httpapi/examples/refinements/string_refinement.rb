@@ -0,0 +1,11 @@
+# Refining String in a single file
+module Shout
+  refine String do
+    def shout
+      upcase + "!"
+    end
+  end
+end
+
+using Shout
+puts "hello".shout
@@ -0,0 +1,9 @@
+# String, symbol and regexp literals
+name = "Ada"
+greeting = "Hello, #{name}"
+raw = 'no #{interpolation}'
+words = %w[alpha beta gamma]
+symbols = %i[read write]
+key = :"content-type"
+pattern = /\A(?<user>\w+)@example\.com\z/i
+command = `date`
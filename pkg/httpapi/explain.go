@@ -0,0 +1,32 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// handleExplain serves /explain/{node_type}, what a node type means and
+// what Ruby produces it. Types several parsers share are told apart with
+// ?parser=.
+func (s *server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	name := r.URL.Query().Get("parser")
+	if name != "" {
+		if _, ok := s.lookupParser(w, name); !ok {
+			return
+		}
+	}
+
+	ex, ok := analyze.Explain(strings.TrimPrefix(r.URL.Path, "/explain/"), name, parser.DefaultParser)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown node type")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeJSON(w, ex)
+}
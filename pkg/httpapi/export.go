@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// handleExportHTML returns the tree as a standalone HTML page to save or
+// send on, offered as a download.
+func (s *server) handleExportHTML(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	var req codeRequest
+	if r.Method == http.MethodPost && !s.decodeRequest(w, r, &req) {
+		return
+	}
+	root, code, ok := s.exportTree(w, r, &req)
+	if !ok {
+		return
+	}
+
+	var page bytes.Buffer
+	if err := analyze.WriteHTML(&page, root, code); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering HTML", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render HTML")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="ast.html"`)
+	if _, err := w.Write(page.Bytes()); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
+
+// exportTree finds the tree an export is of: for POST, parsed from req;
+// otherwise from a shared snippet (?snippet=) or a recent parse still in
+// the cache (?id=). It returns the code too where it is known; a parse ID
+// alone doesn't carry it. It returns false if the request has been fully
+// handled.
+func (s *server) exportTree(w http.ResponseWriter, r *http.Request, req *codeRequest) (*analyze.Node, string, bool) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost:
+		p, ok := s.lookupParser(w, req.Parser)
+		if !ok {
+			return nil, "", false
+		}
+		root, ok := s.parseTree(w, r, p, req.Code)
+		if !ok {
+			return nil, "", false
+		}
+		w.Header().Set("X-Parser", p.Name())
+		w.Header().Set("X-Parse-ID", parseID(p, req.Code))
+		return root, req.Code, true
+	case query.Get("snippet") != "":
+		sn, ok := s.loadSnippet(w, r, query.Get("snippet"))
+		if !ok {
+			return nil, "", false
+		}
+		root, ok := decodeOutput(w, sn.AST)
+		return root, sn.Code, ok
+	}
+	output, ok := s.cache.get(query.Get("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown or expired parse ID")
+		return nil, "", false
+	}
+	root, ok := decodeOutput(w, output)
+	return root, "", ok
+}
+
+// pngMaxDPI bounds the resolution of /export/png, which with a large tree
+// would otherwise let one request ask Graphviz for an enormous image.
+const pngMaxDPI = 600
+
+type pngRequest struct {
+	codeRequest
+	// DPI is the resolution to rasterize at; Graphviz's default is 96.
+	DPI int `json:"dpi"`
+	// MaxDepth prunes the tree below this depth, as in /parse.
+	MaxDepth int `json:"max_depth"`
+}
+
+// handleExportPNG returns a PNG of the tree as /render draws it, at the
+// requested DPI and depth, for slides and documents that can't show SVG.
+// For GET, dpi and max_depth are query parameters.
+func (s *server) handleExportPNG(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	var req pngRequest
+	if r.Method == http.MethodPost {
+		if !s.decodeRequest(w, r, &req) {
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		for _, param := range []struct {
+			name string
+			n    *int
+		}{{"dpi", &req.DPI}, {"max_depth", &req.MaxDepth}} {
+			if value := query.Get(param.name); value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "Invalid "+param.name)
+					return
+				}
+				*param.n = n
+			}
+		}
+	}
+	if req.DPI < 0 || req.DPI > pngMaxDPI {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid dpi; the most is %d", pngMaxDPI))
+		return
+	}
+	if req.MaxDepth < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid max_depth")
+		return
+	}
+
+	root, _, ok := s.exportTree(w, r, &req.codeRequest)
+	if !ok {
+		return
+	}
+	root = analyze.PruneTree(root, "", req.MaxDepth, 0)
+
+	var dot bytes.Buffer
+	if err := analyze.WriteDOT(&dot, root); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering DOT", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render DOT")
+		return
+	}
+	var args []string
+	if req.DPI > 0 {
+		args = append(args, "-Gdpi="+strconv.Itoa(req.DPI))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+	defer cancel()
+	png, err := s.runDot(ctx, "png", dot.Bytes(), args...)
+	if err != nil {
+		writeDotError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", `attachment; filename="ast.png"`)
+	if _, err := w.Write(png); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+var (
+	errHostNotAllowed = errors.New("host not allowed")
+	errSourceTooLarge = errors.New("source too large")
+)
+
+// rawSourceURL rewrites GitHub page URLs to the raw file they show:
+// github.com/<owner>/<repo>/blob/<ref>/<path> and gist.github.com/<user>/<id>.
+// Other URLs are returned unchanged.
+func rawSourceURL(u *url.URL) *url.URL {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	raw := *u
+	switch {
+	case u.Host == "github.com" && len(parts) > 4 && parts[2] == "blob":
+		raw.Host = "raw.githubusercontent.com"
+		raw.Path = "/" + strings.Join(append(parts[:2:2], parts[3:]...), "/")
+	case u.Host == "gist.github.com" && len(parts) == 2:
+		raw.Host = "gist.githubusercontent.com"
+		raw.Path = "/" + strings.Join(parts, "/") + "/raw"
+	default:
+		return u
+	}
+	raw.RawQuery = ""
+	raw.Fragment = ""
+	return &raw
+}
+
+func hostAllowed(host string, hosts []string) bool {
+	for _, allowed := range hosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchSource downloads Ruby source over HTTPS from one of cfg.FetchHosts.
+func (s *server) fetchSource(r *http.Request, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil || !hostAllowed(u.Hostname(), s.cfg.FetchHosts) {
+		return "", errHostNotAllowed
+	}
+	u = rawSourceURL(u)
+	// A signed-in user's token only goes to GitHub's own file host.
+	token := ""
+	if u.Hostname() == "raw.githubusercontent.com" {
+		token = s.githubToken(r)
+	}
+	return s.download(r.Context(), u, token)
+}
+
+// download fetches u, following redirects only to hosts in cfg.FetchHosts,
+// and reads at most cfg.MaxBodyBytes of it, converted to UTF-8. A token is
+// sent as a bearer token, which redirects elsewhere drop.
+func (s *server) download(ctx context.Context, u *url.URL, token string) (string, error) {
+	client := &http.Client{
+		Timeout: s.cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "https" || !hostAllowed(req.URL.Hostname(), s.cfg.FetchHosts) {
+				return errHostNotAllowed
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > s.cfg.MaxBodyBytes {
+		return "", errSourceTooLarge
+	}
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	code, _, err := analyze.DecodeSource(body, params["charset"])
+	return code, err
+}
+
+type parseURLRequest struct {
+	URL    string `json:"url"`
+	Parser string `json:"parser"`
+	analyze.FormatOptions
+}
+
+// handleParseURL parses a Ruby file fetched server-side from a GitHub or gist
+// URL, so large files don't need to be pasted in.
+func (s *server) handleParseURL(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req parseURLRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if !checkFormatOptions(w, analyze.DefaultFormat, req.FormatOptions) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	code, err := s.fetchSource(r, req.URL)
+	if err != nil {
+		var urlErr *url.Error
+		var encErr *analyze.EncodingError
+		switch {
+		case r.Context().Err() != nil:
+		case errors.As(err, &encErr):
+			writeErrorResponse(w, http.StatusBadRequest, encodingErrorResponse("Fetched file is not valid UTF-8", err))
+		case errors.Is(err, errHostNotAllowed):
+			writeError(w, http.StatusBadRequest, "Only https URLs on these hosts are allowed: "+strings.Join(s.cfg.FetchHosts, ", "))
+		case errors.Is(err, errSourceTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Fetched file exceeds %d bytes", s.cfg.MaxBodyBytes))
+		case errors.As(err, &urlErr) && urlErr.Timeout():
+			writeError(w, http.StatusGatewayTimeout, "Fetching the URL timed out")
+		default:
+			slog.ErrorContext(r.Context(), "Error fetching source", "url", req.URL, "err", err)
+			writeError(w, http.StatusBadGateway, "Failed to fetch the URL")
+		}
+		return
+	}
+	output, _, err := s.parse(r.Context(), parser, code)
+	if err != nil {
+		writeParseError(w, r, parser, err)
+		return
+	}
+	req.Source = code
+	contentType, body, err := analyze.RenderFormat(analyze.DefaultFormat, output, req.FormatOptions)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render json output")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Parser", parser.Name())
+	w.Header().Set("X-Parse-ID", parseID(parser, code))
+	if _, err := w.Write(body); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,24 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// resolveFileType picks the type of posted code from an explicit filetype,
+// or failing that from a filename, defaulting to plain Ruby. It writes the
+// error response and returns false if the filetype is unknown.
+func resolveFileType(w http.ResponseWriter, fileType, filename string) (string, bool) {
+	if fileType != "" {
+		if _, ok := analyze.FileTypeNames[fileType]; !ok {
+			writeError(w, http.StatusBadRequest, "Unknown filetype")
+			return "", false
+		}
+		return fileType, true
+	}
+	if fileType = analyze.DetectFileType(filename); fileType != "" {
+		return fileType, true
+	}
+	return analyze.FileTypeRuby, true
+}
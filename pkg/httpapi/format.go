@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type formatRequest struct {
+	Code string `json:"code"`
+}
+
+type formatResponse struct {
+	Formatted string `json:"formatted"`
+	LineMap   []*int `json:"line_map"`
+}
+
+// handleFormat returns the posted code formatted by syntax_tree, along with
+// where each original line ended up.
+func (s *server) handleFormat(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req formatRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+
+	output, err := s.run(r.Context(), s.formatter, req.Code)
+	if err != nil {
+		writeParseError(w, r, s.formatter, err)
+		return
+	}
+
+	formatted := string(output)
+	writeJSON(w, formatResponse{formatted, analyze.LineMap(req.Code, formatted)})
+}
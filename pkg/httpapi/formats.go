@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// checkFormatOptions rejects an unknown layout or heredoc layout, and a
+// filter that doesn't compile or that comes with a format other than JSON.
+func checkFormatOptions(w http.ResponseWriter, format string, opts analyze.FormatOptions) bool {
+	if msg := formatOptionsError(format, opts); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return false
+	}
+	return true
+}
+
+// formatOptionsError is checkFormatOptions' message for opts, or "" if they
+// are fine.
+func formatOptionsError(format string, opts analyze.FormatOptions) string {
+	if !analyze.KnownLayout(opts.Layout) {
+		return "Unknown layout"
+	}
+	if !analyze.KnownHeredocs(opts.Heredocs) {
+		return "Unknown heredoc layout"
+	}
+	if opts.Filter == "" {
+		return ""
+	}
+	if format != analyze.DefaultFormat {
+		return "Filter only applies to JSON output"
+	}
+	if _, err := analyze.CompileJSONPath(opts.Filter); err != nil {
+		return "Invalid filter: " + err.Error()
+	}
+	return ""
+}
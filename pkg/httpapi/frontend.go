@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	visualizer "github.com/ghousemohamed/ruby-ast-visualizer"
+)
+
+// withFrontend serves the embedded frontend, if the binary was built with
+// one, and otherwise hands over to api. API paths at the root always go to
+// api so that existing clients keep working.
+func withFrontend(api *http.ServeMux) http.Handler {
+	assets, ok := visualizer.Frontend()
+	if !ok {
+		return api
+	}
+	files := http.FileServer(http.FS(assets))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := api.Handler(r); pattern != "" {
+			api.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Hashed bundles under static/ can be cached forever; anything else
+		// that isn't a file is a client-side route, so gets index.html.
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if strings.HasPrefix(name, "static/") {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else if _, err := fs.Stat(assets, name); name != "" && err != nil {
+			r.URL.Path = "/"
+		}
+		files.ServeHTTP(w, r)
+	})
+}
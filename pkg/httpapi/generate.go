@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// generateResponse is a program from /generate, with the seed that makes it
+// again and, unless parse=false was asked for, its tree.
+type generateResponse struct {
+	Seed       int64           `json:"seed"`
+	Complexity int             `json:"complexity"`
+	Code       string          `json:"code"`
+	Parser     string          `json:"parser,omitempty"`
+	AST        json.RawMessage `json:"ast,omitempty"`
+}
+
+// handleGenerate serves /generate, a random Ruby program and its tree.
+// Without a seed one is picked, and sent back for reproducing the program.
+func (s *server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	seed := rand.Int63()
+	if value := query.Get("seed"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid seed")
+			return
+		}
+		seed = n
+	}
+	complexity := analyze.DefaultComplexity
+	if value := query.Get("complexity"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < analyze.MinComplexity || n > analyze.MaxComplexity {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid complexity; it goes from %d to %d",
+				analyze.MinComplexity, analyze.MaxComplexity))
+			return
+		}
+		complexity = n
+	}
+
+	resp := generateResponse{
+		Seed:       seed,
+		Complexity: complexity,
+		Code:       analyze.Generate(analyze.GenerateOptions{Seed: seed, Complexity: complexity}),
+	}
+	w.Header().Set("X-Generate-Seed", strconv.FormatInt(seed, 10))
+	if parse, err := strconv.ParseBool(query.Get("parse")); err == nil && !parse {
+		writeJSON(w, resp)
+		return
+	}
+
+	p, ok := s.lookupParser(w, query.Get("parser"))
+	if !ok {
+		return
+	}
+	output, _, err := s.parse(r.Context(), p, resp.Code)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		writeParseError(w, r, p, err)
+		return
+	}
+	raw, _ := strconv.ParseBool(query.Get("raw"))
+	_, body, err := analyze.RenderFormat(analyze.DefaultFormat, output, analyze.FormatOptions{Raw: raw})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering tree", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render tree")
+		return
+	}
+	resp.Parser, resp.AST = p.Name(), body
+	w.Header().Set("X-Parser", p.Name())
+	w.Header().Set("X-Parse-ID", parseID(p, resp.Code))
+	writeJSON(w, resp)
+}
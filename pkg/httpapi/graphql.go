@@ -0,0 +1,615 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// contentTypeGraphQL is the media type of GraphQL responses. Not being
+// application/json, it is left out of the /api/v1 envelope, which would
+// hide a response's data behind a second "data".
+const contentTypeGraphQL = "application/graphql-response+json"
+
+// gqlType is an object type of the /graphql schema.
+type gqlType struct {
+	Name        string
+	Description string
+	Fields      []gqlFieldDef
+}
+
+// gqlFieldDef is a field of an object type. Type is written as in SDL, such
+// as "[Node!]!". Resolve gets the Go value the parent object resolved to
+// and the field's arguments, coerced to their types.
+type gqlFieldDef struct {
+	Name        string
+	Type        string
+	Description string
+	Args        []gqlArgDef
+	Resolve     func(ctx context.Context, parent interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+type gqlArgDef struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+func (t *gqlType) field(name string) *gqlFieldDef {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+// gqlScalars are the leaf types of the schema. JSON is any JSON value.
+var gqlScalars = map[string]string{
+	"String":  "",
+	"Int":     "",
+	"Float":   "",
+	"Boolean": "",
+	"ID":      "",
+	"JSON":    "Any JSON value.",
+}
+
+// gqlSchema is the whole schema, with Query as its root type.
+type gqlSchema struct {
+	types map[string]*gqlType
+	order []*gqlType
+}
+
+func newGQLSchema(types ...*gqlType) *gqlSchema {
+	s := &gqlSchema{types: make(map[string]*gqlType), order: types}
+	for _, t := range types {
+		s.types[t.Name] = t
+	}
+	return s
+}
+
+// namedType strips the list and non-null wrappers from a type reference.
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// sdl writes the schema in GraphQL's schema definition language, as
+// GET /graphql/schema serves it.
+func (s *gqlSchema) sdl() string {
+	var b strings.Builder
+	description := func(indent, text string) {
+		if text != "" {
+			fmt.Fprintf(&b, "%s%q\n", indent, text)
+		}
+	}
+	for _, name := range []string{"JSON"} {
+		description("", gqlScalars[name])
+		fmt.Fprintf(&b, "scalar %s\n\n", name)
+	}
+	for _, t := range s.order {
+		description("", t.Description)
+		fmt.Fprintf(&b, "type %s {\n", t.Name)
+		for _, f := range t.Fields {
+			description("  ", f.Description)
+			b.WriteString("  " + f.Name)
+			if len(f.Args) > 0 {
+				var args []string
+				for _, a := range f.Args {
+					arg := a.Name + ": " + a.Type
+					if a.Description != "" {
+						arg = fmt.Sprintf("%q %s", a.Description, arg)
+					}
+					args = append(args, arg)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.Type + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// gqlError is an entry of a response's "errors".
+type gqlError struct {
+	Message    string                 `json:"message"`
+	Locations  []gqlPos               `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// gqlFieldError is an error a resolver returns with extensions to report.
+type gqlFieldError struct {
+	msg        string
+	extensions map[string]interface{}
+}
+
+func (e *gqlFieldError) Error() string {
+	return e.msg
+}
+
+// gqlObject is a selection set's result, with its fields in the order they
+// were asked for.
+type gqlObject []jsonMember
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// gqlExecution runs one operation of a document.
+type gqlExecution struct {
+	schema *gqlSchema
+	doc    *gqlDocument
+	vars   map[string]interface{}
+	errors []gqlError
+
+	// varDefs are the operation's variables while it is validated, when
+	// argValue checks how they are used rather than resolving them.
+	varDefs    []gqlVarDef
+	validating bool
+}
+
+func (e *gqlExecution) fail(msg string, pos gqlPos, path []interface{}) {
+	e.errors = append(e.errors, gqlError{Message: msg, Locations: []gqlPos{pos}, Path: path})
+}
+
+// errGQLInvalid reports a document that fails validation; the messages are
+// in gqlExecution.errors.
+var errGQLInvalid = errors.New("invalid GraphQL document")
+
+// executeGraphQL parses and runs a query, returning the response and whether
+// the document was valid enough to run at all.
+func executeGraphQL(ctx context.Context, schema *gqlSchema, root interface{}, query, operationName string, variables map[string]interface{}) (*gqlResponse, bool) {
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		var se *gqlSyntaxError
+		errors.As(err, &se)
+		return &gqlResponse{Errors: []gqlError{{Message: "Syntax error: " + se.msg, Locations: []gqlPos{se.pos}}}}, false
+	}
+
+	var op *gqlOperation
+	for _, candidate := range doc.operations {
+		if operationName == "" && len(doc.operations) > 1 {
+			return &gqlResponse{Errors: []gqlError{{Message: "The document has several operations, so operationName is required"}}}, false
+		}
+		if operationName == "" || candidate.name == operationName {
+			op = candidate
+			break
+		}
+	}
+	if op == nil {
+		return &gqlResponse{Errors: []gqlError{{Message: fmt.Sprintf("Unknown operation %q", operationName)}}}, false
+	}
+	if op.kind != "query" {
+		return &gqlResponse{Errors: []gqlError{{Message: "Only queries are supported", Locations: []gqlPos{op.pos}}}}, false
+	}
+
+	e := &gqlExecution{schema: schema, doc: doc, vars: make(map[string]interface{})}
+	for _, v := range op.vars {
+		value, given := variables[v.name]
+		if !given {
+			value = v.def
+		}
+		if !given && v.def == nil && strings.HasSuffix(v.typ, "!") {
+			e.fail(fmt.Sprintf("Variable $%s of type %s is required", v.name, v.typ), v.pos, nil)
+			continue
+		}
+		if value == nil && !strings.HasSuffix(v.typ, "!") {
+			e.vars[v.name] = nil
+			continue
+		}
+		coerced, err := coerceGQLValue(value, v.typ, nil)
+		if err != nil {
+			e.fail(fmt.Sprintf("Variable $%s: %v", v.name, err), v.pos, nil)
+			continue
+		}
+		e.vars[v.name] = coerced
+	}
+	if len(e.errors) == 0 {
+		e.varDefs, e.validating = op.vars, true
+		e.validate(op.selections, schema.types["Query"], nil)
+		e.validating = false
+	}
+	if len(e.errors) > 0 {
+		return &gqlResponse{Errors: e.errors}, false
+	}
+
+	data, ok := e.executeSelections(ctx, schema.types["Query"], root, op.selections, nil)
+	resp := &gqlResponse{Errors: e.errors}
+	if ok {
+		resp.Data = data
+	} else {
+		resp.Data = json.RawMessage("null")
+	}
+	return resp, true
+}
+
+// validate checks a selection set against its type before anything runs,
+// so a query with a typo fails as a whole rather than partly.
+func (e *gqlExecution) validate(selections []*gqlSelection, t *gqlType, spreading []string) {
+	for _, sel := range selections {
+		for _, d := range sel.directives {
+			if d.name != "skip" && d.name != "include" {
+				e.fail(fmt.Sprintf("Unknown directive @%s", d.name), sel.pos, nil)
+				continue
+			}
+			e.validateArgs(d.args, []gqlArgDef{{Name: "if", Type: "Boolean!"}}, sel.pos)
+		}
+		switch {
+		case sel.spread != "":
+			f := e.doc.fragments[sel.spread]
+			if f == nil {
+				e.fail(fmt.Sprintf("Unknown fragment %q", sel.spread), sel.pos, nil)
+				continue
+			}
+			for _, name := range spreading {
+				if name == f.name {
+					e.fail(fmt.Sprintf("Fragment %q spreads itself", f.name), sel.pos, nil)
+					return
+				}
+			}
+			e.validateFragment(f.typeCond, f.selections, t, append(spreading, f.name), sel.pos)
+		case sel.inline:
+			e.validateFragment(sel.typeCond, sel.selections, t, spreading, sel.pos)
+		case sel.name == "__typename":
+		default:
+			f := t.field(sel.name)
+			if f == nil {
+				e.fail(fmt.Sprintf("Type %s has no field %q", t.Name, sel.name), sel.pos, nil)
+				continue
+			}
+			e.validateArgs(sel.args, f.Args, sel.pos)
+			child := e.schema.types[namedType(f.Type)]
+			switch {
+			case child == nil && sel.selections != nil:
+				e.fail(fmt.Sprintf("Field %q is a %s and has no fields to select", sel.name, f.Type), sel.pos, nil)
+			case child != nil && sel.selections == nil:
+				e.fail(fmt.Sprintf("Field %q of type %s needs a selection of its fields", sel.name, f.Type), sel.pos, nil)
+			case child != nil:
+				e.validate(sel.selections, child, spreading)
+			}
+		}
+	}
+}
+
+func (e *gqlExecution) validateFragment(typeCond string, selections []*gqlSelection, t *gqlType, spreading []string, pos gqlPos) {
+	if typeCond != "" && typeCond != t.Name {
+		if e.schema.types[typeCond] == nil {
+			e.fail(fmt.Sprintf("Unknown type %q", typeCond), pos, nil)
+		} else {
+			e.fail(fmt.Sprintf("A fragment on %s can't be used on %s", typeCond, t.Name), pos, nil)
+		}
+		return
+	}
+	e.validate(selections, t, spreading)
+}
+
+func (e *gqlExecution) validateArgs(args []gqlArg, defs []gqlArgDef, pos gqlPos) {
+	given := make(map[string]bool)
+	for _, arg := range args {
+		given[arg.name] = true
+		var def *gqlArgDef
+		for i := range defs {
+			if defs[i].Name == arg.name {
+				def = &defs[i]
+			}
+		}
+		if def == nil {
+			e.fail(fmt.Sprintf("Unknown argument %q", arg.name), arg.pos, nil)
+			continue
+		}
+		if _, err := e.argValue(arg.value, def.Type); err != nil {
+			e.fail(fmt.Sprintf("Argument %q: %v", arg.name, err), arg.pos, nil)
+		}
+	}
+	for _, def := range defs {
+		if strings.HasSuffix(def.Type, "!") && !given[def.Name] {
+			e.fail(fmt.Sprintf("Argument %q of type %s is required", def.Name, def.Type), pos, nil)
+		}
+	}
+}
+
+// argValue resolves variables in an argument and coerces it to typ.
+func (e *gqlExecution) argValue(value interface{}, typ string) (interface{}, error) {
+	if name, ok := value.(gqlVariable); ok {
+		if e.validating {
+			for _, v := range e.varDefs {
+				if v.name == string(name) {
+					if namedType(v.typ) != namedType(typ) || strings.HasSuffix(typ, "!") && !strings.HasSuffix(v.typ, "!") && v.def == nil {
+						return nil, fmt.Errorf("variable $%s of type %s can't be used as %s", name, v.typ, typ)
+					}
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("variable $%s is not defined", name)
+		}
+		return e.vars[string(name)], nil
+	}
+	return coerceGQLValue(value, typ, e)
+}
+
+// coerceGQLValue converts a literal or variable value to typ, as decoded
+// from JSON or parsed from the document. e resolves variables nested in
+// lists; it is nil for variable values themselves.
+func coerceGQLValue(value interface{}, typ string, e *gqlExecution) (interface{}, error) {
+	if name, ok := value.(gqlVariable); ok && e != nil {
+		value = e.vars[string(name)]
+	}
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("expected %s!, found null", typ)
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		out := make([]interface{}, len(list))
+		for i, element := range list {
+			v, err := coerceGQLValue(element, inner, e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+
+	switch typ {
+	case "String", "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if n, ok := value.(int64); ok && typ == "ID" {
+			return fmt.Sprint(n), nil
+		}
+	case "Int":
+		switch n := value.(type) {
+		case int64:
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int(n), nil
+			}
+		case float64:
+			// Variables arrive as JSON numbers.
+			if n == math.Trunc(n) && n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int(n), nil
+			}
+		}
+	case "Float":
+		switch n := value.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "JSON":
+		return value, nil
+	}
+	return nil, fmt.Errorf("expected %s", typ)
+}
+
+// collect gathers the fields a selection set asks of t, expanding fragments
+// and applying @skip and @include. Fields with the same response key are
+// merged, in the order they first appear.
+func (e *gqlExecution) collect(t *gqlType, selections []*gqlSelection, keys *[]string, fields map[string][]*gqlSelection) {
+	for _, sel := range selections {
+		if !e.included(sel) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			f := e.doc.fragments[sel.spread]
+			e.collect(t, f.selections, keys, fields)
+		case sel.inline:
+			e.collect(t, sel.selections, keys, fields)
+		default:
+			key := sel.responseKey()
+			if fields[key] == nil {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		}
+	}
+}
+
+func (e *gqlExecution) included(sel *gqlSelection) bool {
+	for _, d := range sel.directives {
+		for _, arg := range d.args {
+			v, _ := e.argValue(arg.value, "Boolean!")
+			if b, _ := v.(bool); b == (d.name == "skip") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// executeSelections resolves the fields selected on parent, an object of
+// type t. It returns false if a non-null field came back null, which makes
+// the object itself null.
+func (e *gqlExecution) executeSelections(ctx context.Context, t *gqlType, parent interface{}, selections []*gqlSelection, path []interface{}) (gqlObject, bool) {
+	var keys []string
+	fields := make(map[string][]*gqlSelection)
+	e.collect(t, selections, &keys, fields)
+
+	out := make(gqlObject, 0, len(keys))
+	for _, key := range keys {
+		sel := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if sel.name == "__typename" {
+			out = append(out, jsonMember{key, t.Name})
+			continue
+		}
+
+		def := t.field(sel.name)
+		args := make(map[string]interface{})
+		for _, arg := range sel.args {
+			for _, a := range def.Args {
+				if a.Name == arg.name {
+					args[arg.name], _ = e.argValue(arg.value, a.Type)
+				}
+			}
+		}
+		var subSelections []*gqlSelection
+		for _, s := range fields[key] {
+			subSelections = append(subSelections, s.selections...)
+		}
+
+		value, err := def.Resolve(ctx, parent, args)
+		if err != nil {
+			ge := gqlError{Message: err.Error(), Locations: []gqlPos{sel.pos}, Path: fieldPath}
+			var fe *gqlFieldError
+			if errors.As(err, &fe) {
+				ge.Extensions = fe.extensions
+			}
+			e.errors = append(e.errors, ge)
+			value = nil
+			if strings.HasSuffix(def.Type, "!") {
+				return nil, false
+			}
+		}
+		completed, ok := e.complete(ctx, def.Type, value, subSelections, sel.pos, fieldPath, err != nil)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, jsonMember{key, completed})
+	}
+	return out, true
+}
+
+// complete turns a resolved value into its result for typ, running any
+// sub-selections on objects. failed says an error has already been
+// reported for a null value.
+func (e *gqlExecution) complete(ctx context.Context, typ string, value interface{}, selections []*gqlSelection, pos gqlPos, path []interface{}, failed bool) (interface{}, bool) {
+	if inner, nonNull := strings.CutSuffix(typ, "!"); nonNull {
+		reported := len(e.errors)
+		result, ok := e.complete(ctx, inner, value, selections, pos, path, failed)
+		if ok && result == nil {
+			// A null from an error below, such as in a non-null list
+			// element, has been reported already.
+			if !failed && len(e.errors) == reported {
+				e.fail("Cannot return null for non-nullable field", pos, path)
+			}
+			return nil, false
+		}
+		return result, ok
+	}
+	if value == nil {
+		return nil, true
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		list := value.([]interface{})
+		out := make([]interface{}, len(list))
+		for i, element := range list {
+			result, ok := e.complete(ctx, inner, element, selections, pos, append(append([]interface{}{}, path...), i), false)
+			if !ok {
+				return nil, true
+			}
+			out[i] = result
+		}
+		return out, true
+	}
+
+	t := e.schema.types[typ]
+	if t == nil {
+		return value, true
+	}
+	result, ok := e.executeSelections(ctx, t, value, selections, path)
+	if !ok {
+		return nil, true
+	}
+	return result, true
+}
+
+// graphqlRequest is the body of POST /graphql, and for GET the query
+// parameters of the same names, with variables as JSON.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// handleGraphQL answers GraphQL queries over the schema graphqlSchema
+// builds. Documents that fail to parse or validate get a 400.
+func (s *server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	var req graphqlRequest
+	if r.Method == http.MethodPost {
+		if !s.decodeRequest(w, r, &req) {
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid variables")
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, "Missing query")
+		return
+	}
+
+	resp, valid := executeGraphQL(r.Context(), s.graphqlSchema(), s, req.Query, req.OperationName, req.Variables)
+	w.Header().Set("Content-Type", contentTypeGraphQL)
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
+
+// handleGraphQLSchema serves the /graphql schema in SDL.
+func (s *server) handleGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, s.graphqlSchema().sdl())
+}
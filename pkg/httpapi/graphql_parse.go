@@ -0,0 +1,620 @@
+package httpapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The query language of /graphql: GraphQL executable documents with
+// operations, variables, aliases, fragments and directives, parsed by hand
+// like the other small languages the server reads.
+
+// gqlMaxDepth bounds how deeply selection sets and values may nest.
+const gqlMaxDepth = 100
+
+type gqlPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	vars       []gqlVarDef
+	selections []*gqlSelection
+	pos        gqlPos
+}
+
+type gqlVarDef struct {
+	name string
+	typ  string
+	def  interface{}
+	pos  gqlPos
+}
+
+type gqlFragment struct {
+	name       string
+	typeCond   string
+	selections []*gqlSelection
+	pos        gqlPos
+}
+
+// gqlSelection is a field, a fragment spread (spread is the fragment's
+// name) or an inline fragment (inline is set).
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       []gqlArg
+	directives []gqlDirective
+	selections []*gqlSelection
+
+	spread   string
+	inline   bool
+	typeCond string
+
+	pos gqlPos
+}
+
+func (sel *gqlSelection) responseKey() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+type gqlArg struct {
+	name  string
+	value interface{}
+	pos   gqlPos
+}
+
+type gqlDirective struct {
+	name string
+	args []gqlArg
+}
+
+// Values in a document are gqlVariable, gqlEnum, []gqlArg for an object,
+// []interface{} for a list, or the Go value of a literal: int64, float64,
+// string, bool or nil.
+type gqlVariable string
+
+type gqlEnum string
+
+// gqlSyntaxError is a document that doesn't parse.
+type gqlSyntaxError struct {
+	msg string
+	pos gqlPos
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return e.msg
+}
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	text  string
+	value string
+	pos   gqlPos
+}
+
+type gqlParser struct {
+	src       string
+	off       int
+	line      int
+	lineStart int
+	tok       gqlToken
+	depth     int
+}
+
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+	p := &gqlParser{src: src, line: 1}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*gqlSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+	p.next()
+	doc = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.tok.kind != gqlEOF {
+		switch {
+		case p.is(gqlPunct, "{"):
+			op := &gqlOperation{kind: "query", pos: p.tok.pos}
+			op.selections = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+		case p.is(gqlName, "fragment"):
+			f := p.fragment()
+			if doc.fragments[f.name] != nil {
+				p.failAt(f.pos, "There can be only one fragment named %q", f.name)
+			}
+			doc.fragments[f.name] = f
+		case p.is(gqlName, "query"), p.is(gqlName, "mutation"), p.is(gqlName, "subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		default:
+			p.fail("Unexpected %s", p.describe())
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("The document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) fail(format string, args ...interface{}) {
+	p.failAt(p.tok.pos, format, args...)
+}
+
+func (p *gqlParser) failAt(pos gqlPos, format string, args ...interface{}) {
+	panic(&gqlSyntaxError{fmt.Sprintf(format, args...), pos})
+}
+
+func (p *gqlParser) describe() string {
+	switch p.tok.kind {
+	case gqlEOF:
+		return "end of document"
+	case gqlString:
+		return "string"
+	}
+	return strconv.Quote(p.tok.text)
+}
+
+func (p *gqlParser) is(kind gqlTokenKind, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *gqlParser) expect(text string) {
+	if !p.is(gqlPunct, text) {
+		p.fail("Expected %q, found %s", text, p.describe())
+	}
+	p.next()
+}
+
+func (p *gqlParser) name() string {
+	if p.tok.kind != gqlName {
+		p.fail("Expected a name, found %s", p.describe())
+	}
+	name := p.tok.text
+	p.next()
+	return name
+}
+
+func (p *gqlParser) nest() {
+	if p.depth++; p.depth > gqlMaxDepth {
+		p.fail("The document nests more than %d levels deep", gqlMaxDepth)
+	}
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	op := &gqlOperation{kind: p.tok.text, pos: p.tok.pos}
+	p.next()
+	if p.tok.kind == gqlName {
+		op.name = p.name()
+	}
+	if p.is(gqlPunct, "(") {
+		p.next()
+		for !p.is(gqlPunct, ")") {
+			v := gqlVarDef{pos: p.tok.pos}
+			p.expect("$")
+			v.name = p.name()
+			p.expect(":")
+			v.typ = p.typeRef()
+			if p.is(gqlPunct, "=") {
+				p.next()
+				v.def = p.value(true)
+			}
+			p.directives()
+			op.vars = append(op.vars, v)
+		}
+		p.next()
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *gqlParser) typeRef() string {
+	var t string
+	if p.is(gqlPunct, "[") {
+		p.next()
+		t = "[" + p.typeRef() + "]"
+		p.expect("]")
+	} else {
+		t = p.name()
+	}
+	if p.is(gqlPunct, "!") {
+		p.next()
+		t += "!"
+	}
+	return t
+}
+
+func (p *gqlParser) fragment() *gqlFragment {
+	f := &gqlFragment{pos: p.tok.pos}
+	p.next()
+	if f.name = p.name(); f.name == "on" {
+		p.failAt(f.pos, "A fragment can't be named \"on\"")
+	}
+	if !p.is(gqlName, "on") {
+		p.fail("Expected \"on\", found %s", p.describe())
+	}
+	p.next()
+	f.typeCond = p.name()
+	p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	p.nest()
+	defer func() { p.depth-- }()
+	p.expect("{")
+	var selections []*gqlSelection
+	for !p.is(gqlPunct, "}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail("A selection set can't be empty")
+	}
+	p.next()
+	return selections
+}
+
+func (p *gqlParser) selection() *gqlSelection {
+	sel := &gqlSelection{pos: p.tok.pos}
+	if p.is(gqlPunct, "...") {
+		p.next()
+		switch {
+		case p.is(gqlName, "on"):
+			p.next()
+			sel.inline, sel.typeCond = true, p.name()
+		case p.tok.kind == gqlName:
+			sel.spread = p.name()
+			sel.directives = p.directives()
+			return sel
+		default:
+			sel.inline = true
+		}
+		sel.directives = p.directives()
+		sel.selections = p.selectionSet()
+		return sel
+	}
+
+	sel.name = p.name()
+	if p.is(gqlPunct, ":") {
+		p.next()
+		sel.alias, sel.name = sel.name, p.name()
+	}
+	sel.args = p.arguments(false)
+	sel.directives = p.directives()
+	if p.is(gqlPunct, "{") {
+		sel.selections = p.selectionSet()
+	}
+	return sel
+}
+
+func (p *gqlParser) arguments(constant bool) []gqlArg {
+	if !p.is(gqlPunct, "(") {
+		return nil
+	}
+	p.next()
+	var args []gqlArg
+	for !p.is(gqlPunct, ")") {
+		// The position first, as name moves past the token.
+		arg := gqlArg{pos: p.tok.pos}
+		arg.name = p.name()
+		p.expect(":")
+		arg.value = p.value(constant)
+		args = append(args, arg)
+	}
+	p.next()
+	return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var directives []gqlDirective
+	for p.is(gqlPunct, "@") {
+		p.next()
+		d := gqlDirective{name: p.name()}
+		d.args = p.arguments(false)
+		directives = append(directives, d)
+	}
+	return directives
+}
+
+func (p *gqlParser) value(constant bool) interface{} {
+	p.nest()
+	defer func() { p.depth-- }()
+	tok := p.tok
+	switch tok.kind {
+	case gqlInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			p.failAt(tok.pos, "Integer %s is out of range", tok.text)
+		}
+		return n
+	case gqlFloat:
+		p.next()
+		f, _ := strconv.ParseFloat(tok.text, 64)
+		return f
+	case gqlString:
+		p.next()
+		return tok.value
+	case gqlName:
+		p.next()
+		switch tok.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(tok.text)
+	}
+	switch {
+	case p.is(gqlPunct, "$"):
+		if constant {
+			p.fail("A variable can't be used here")
+		}
+		p.next()
+		return gqlVariable(p.name())
+	case p.is(gqlPunct, "["):
+		p.next()
+		list := []interface{}{}
+		for !p.is(gqlPunct, "]") {
+			list = append(list, p.value(constant))
+		}
+		p.next()
+		return list
+	case p.is(gqlPunct, "{"):
+		p.next()
+		fields := []gqlArg{}
+		for !p.is(gqlPunct, "}") {
+			field := gqlArg{pos: p.tok.pos}
+			field.name = p.name()
+			p.expect(":")
+			field.value = p.value(constant)
+			fields = append(fields, field)
+		}
+		p.next()
+		return fields
+	}
+	p.fail("Expected a value, found %s", p.describe())
+	return nil
+}
+
+// next reads the following token into p.tok, skipping whitespace, commas
+// and comments.
+func (p *gqlParser) next() {
+skip:
+	for p.off < len(p.src) {
+		switch c := p.src[p.off]; {
+		case c == '\n':
+			p.off++
+			p.line, p.lineStart = p.line+1, p.off
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.off++
+		case c == '#':
+			for p.off < len(p.src) && p.src[p.off] != '\n' {
+				p.off++
+			}
+		case strings.HasPrefix(p.src[p.off:], "\ufeff"):
+			p.off += len("\ufeff")
+		default:
+			break skip
+		}
+	}
+
+	start := p.off
+	p.tok = gqlToken{pos: gqlPos{p.line, utf8.RuneCountInString(p.src[p.lineStart:start]) + 1}}
+	if start == len(p.src) {
+		p.tok.kind = gqlEOF
+		return
+	}
+	c := p.src[start]
+	switch {
+	case strings.HasPrefix(p.src[start:], "..."):
+		p.off += 3
+		p.tok.kind = gqlPunct
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		p.off++
+		p.tok.kind = gqlPunct
+	case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		for p.off < len(p.src) && isNameByte(p.src[p.off]) {
+			p.off++
+		}
+		p.tok.kind = gqlName
+	case c == '-' || c >= '0' && c <= '9':
+		p.number()
+	case strings.HasPrefix(p.src[start:], `"""`):
+		p.blockString()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[start:])
+		p.fail("Unexpected character %q", r)
+	}
+	p.tok.text = p.src[start:p.off]
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) number() {
+	p.tok.kind = gqlInt
+	start := p.off
+	if p.src[p.off] == '-' {
+		p.off++
+	}
+	digits := func() int {
+		n := 0
+		for p.off < len(p.src) && p.src[p.off] >= '0' && p.src[p.off] <= '9' {
+			p.off++
+			n++
+		}
+		return n
+	}
+	intStart := p.off
+	if digits() == 0 || p.src[intStart] == '0' && p.off-intStart > 1 {
+		p.fail("Invalid number %q", p.src[start:p.off])
+	}
+	if p.off < len(p.src) && p.src[p.off] == '.' {
+		p.off++
+		p.tok.kind = gqlFloat
+		if digits() == 0 {
+			p.fail("Invalid number %q", p.src[start:p.off])
+		}
+	}
+	if p.off < len(p.src) && (p.src[p.off] == 'e' || p.src[p.off] == 'E') {
+		p.off++
+		p.tok.kind = gqlFloat
+		if p.off < len(p.src) && (p.src[p.off] == '+' || p.src[p.off] == '-') {
+			p.off++
+		}
+		if digits() == 0 {
+			p.fail("Invalid number %q", p.src[start:p.off])
+		}
+	}
+	if p.off < len(p.src) && (isNameByte(p.src[p.off]) || p.src[p.off] == '.') {
+		p.fail("Invalid number %q", p.src[start:p.off+1])
+	}
+}
+
+func (p *gqlParser) string() {
+	p.tok.kind = gqlString
+	p.off++
+	var b strings.Builder
+	for {
+		if p.off >= len(p.src) || p.src[p.off] == '\n' {
+			p.fail("Unterminated string")
+		}
+		c := p.src[p.off]
+		if c == '"' {
+			p.off++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.off++
+			continue
+		}
+		if p.off+1 >= len(p.src) {
+			p.fail("Unterminated string")
+		}
+		esc := p.src[p.off+1]
+		p.off += 2
+		switch esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.off+4 > len(p.src) {
+				p.fail("Invalid unicode escape")
+			}
+			n, err := strconv.ParseUint(p.src[p.off:p.off+4], 16, 32)
+			if err != nil {
+				p.fail("Invalid unicode escape")
+			}
+			p.off += 4
+			r := rune(n)
+			if utf16Surrogate(r) && strings.HasPrefix(p.src[p.off:], `\u`) && p.off+6 <= len(p.src) {
+				if low, err := strconv.ParseUint(p.src[p.off+2:p.off+6], 16, 32); err == nil {
+					r = (r-0xd800)<<10 + (rune(low) - 0xdc00) + 0x10000
+					p.off += 6
+				}
+			}
+			b.WriteRune(r)
+		default:
+			p.fail("Invalid escape \\%c", esc)
+		}
+	}
+	p.tok.value = b.String()
+}
+
+func utf16Surrogate(r rune) bool {
+	return r >= 0xd800 && r < 0xdc00
+}
+
+// blockString reads a """...""" string, removing the indentation its lines
+// share and any blank first and last lines, as GraphQL specifies.
+func (p *gqlParser) blockString() {
+	p.tok.kind = gqlString
+	p.off += 3
+	var raw strings.Builder
+	for {
+		if p.off >= len(p.src) {
+			p.fail("Unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.off:], `"""`) {
+			p.off += 3
+			break
+		}
+		if strings.HasPrefix(p.src[p.off:], `\"""`) {
+			raw.WriteString(`"""`)
+			p.off += 4
+			continue
+		}
+		if p.src[p.off] == '\n' {
+			p.line, p.lineStart = p.line+1, p.off+1
+		}
+		raw.WriteByte(p.src[p.off])
+		p.off++
+	}
+
+	lines := strings.Split(strings.ReplaceAll(raw.String(), "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	p.tok.value = strings.Join(lines, "\n")
+}
@@ -0,0 +1,232 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// gqlNode is a node of a tree as /graphql returns it, with its depth below
+// the root.
+type gqlNode struct {
+	*analyze.NormalNode
+	depth int
+}
+
+type gqlParseResult struct {
+	parser  string
+	parseID string
+	cached  bool
+	root    *analyze.NormalNode
+}
+
+// gqlMaxDescendants caps the nodes one descendants field returns, however
+// large first is.
+const gqlMaxDescendants = 10000
+
+// graphqlSchema describes the trees /graphql serves. Nodes are resolved from
+// a normalized tree, so every parser's output has the same fields.
+func (s *server) graphqlSchema() *gqlSchema {
+	str := func(f func(parent interface{}) string) func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+		return func(_ context.Context, parent interface{}, _ map[string]interface{}) (interface{}, error) {
+			return f(parent), nil
+		}
+	}
+	optional := func(v string) interface{} {
+		if v == "" {
+			return nil
+		}
+		return v
+	}
+	node := func(parent interface{}) gqlNode { return parent.(gqlNode) }
+	loc := func(parent interface{}) *analyze.Location { return parent.(*analyze.Location) }
+
+	query := &gqlType{Name: "Query", Fields: []gqlFieldDef{
+		{Name: "parse", Type: "ParseResult!", Description: "Parse code and return its tree.",
+			Args: []gqlArgDef{
+				{Name: "code", Type: "String!"},
+				{Name: "parser", Type: "String", Description: "Defaults to " + parser.DefaultParser + "."},
+			},
+			Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				code, _ := args["code"].(string)
+				name, _ := args["parser"].(string)
+				if name == "" {
+					name = parser.DefaultParser
+				}
+				p, ok := s.parsers[name]
+				if !ok {
+					return nil, &gqlFieldError{msg: "Unknown parser"}
+				}
+				output, hit, err := s.parse(ctx, p, code)
+				if err != nil {
+					status, resp := parseErrorResponse(ctx, p, err)
+					ext := map[string]interface{}{"status": status, "parser": resp.Parser}
+					if resp.Line > 0 {
+						ext["line"], ext["column"] = resp.Line, resp.Column
+					}
+					return nil, &gqlFieldError{msg: resp.Error, extensions: ext}
+				}
+				root, err := analyze.Normalize(output)
+				if err != nil {
+					return nil, err
+				}
+				return &gqlParseResult{parser: p.Name(), parseID: parseID(p, code), cached: hit, root: root}, nil
+			}},
+		{Name: "snippet", Type: "Snippet", Description: "A shared snippet, or null if there is none by that ID.",
+			Args: []gqlArgDef{{Name: "id", Type: "ID!"}},
+			Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				sn, err := s.getSnippet(ctx, args["id"].(string))
+				if errors.Is(err, errNotStored) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, errors.New("Failed to load snippet")
+				}
+				return sn, nil
+			}},
+		{Name: "parsers", Type: "[String!]!", Description: "The names parse accepts.",
+			Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				var names []interface{}
+				for _, name := range parserNames(s.parsers) {
+					names = append(names, name)
+				}
+				return names, nil
+			}},
+	}}
+
+	parseResult := &gqlType{Name: "ParseResult", Fields: []gqlFieldDef{
+		{Name: "parser", Type: "String!", Resolve: str(func(v interface{}) string { return v.(*gqlParseResult).parser })},
+		{Name: "parseId", Type: "String!", Description: "The X-Parse-ID /parse would send.",
+			Resolve: str(func(v interface{}) string { return v.(*gqlParseResult).parseID })},
+		{Name: "cached", Type: "Boolean!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return v.(*gqlParseResult).cached, nil
+		}},
+		{Name: "root", Type: "Node!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return gqlNode{v.(*gqlParseResult).root, 0}, nil
+		}},
+	}}
+
+	snippetType := &gqlType{Name: "Snippet", Fields: []gqlFieldDef{
+		{Name: "id", Type: "ID!", Resolve: str(func(v interface{}) string { return v.(*snippet).ID })},
+		{Name: "code", Type: "String!", Resolve: str(func(v interface{}) string { return v.(*snippet).Code })},
+		{Name: "parser", Type: "String!", Resolve: str(func(v interface{}) string { return v.(*snippet).Parser })},
+		{Name: "createdAt", Type: "String!", Description: "When it was saved, in RFC 3339.",
+			Resolve: str(func(v interface{}) string { return v.(*snippet).CreatedAt.Format(time.RFC3339) })},
+		{Name: "root", Type: "Node!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			root, err := analyze.Normalize(v.(*snippet).AST)
+			if err != nil {
+				return nil, err
+			}
+			return gqlNode{root, 0}, nil
+		}},
+	}}
+
+	nodeFilter := []gqlArgDef{
+		{Name: "type", Type: "String", Description: "Only nodes of this type."},
+		{Name: "field", Type: "String", Description: "Only nodes held in this field of their parent."},
+	}
+	matches := func(n *analyze.NormalNode, args map[string]interface{}) bool {
+		typ, _ := args["type"].(string)
+		field, _ := args["field"].(string)
+		return (typ == "" || n.Type == typ) && (field == "" || n.Field == field)
+	}
+	nodeType := &gqlType{Name: "Node", Description: "A node of a syntax tree.", Fields: []gqlFieldDef{
+		{Name: "type", Type: "String!", Resolve: str(func(v interface{}) string { return node(v).Type })},
+		{Name: "field", Type: "String", Description: "The field of its parent the node is held in.",
+			Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+				return optional(node(v).Field), nil
+			}},
+		{Name: "location", Type: "Location", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			if l := node(v).Location; l != nil {
+				return l, nil
+			}
+			return nil, nil
+		}},
+		{Name: "value", Type: "String", Description: "A token's text.",
+			Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+				if value := node(v).Value; value != nil {
+					return *value, nil
+				}
+				return nil, nil
+			}},
+		{Name: "attributes", Type: "JSON", Description: "Scalar fields of the node other than its value.",
+			Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+				if attrs := node(v).Attributes; len(attrs) > 0 {
+					return attrs, nil
+				}
+				return nil, nil
+			}},
+		{Name: "depth", Type: "Int!", Description: "How far below the root the node is; the root is 0.",
+			Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+				return node(v).depth, nil
+			}},
+		{Name: "childCount", Type: "Int!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return len(node(v).Children), nil
+		}},
+		{Name: "children", Type: "[Node!]!", Args: nodeFilter,
+			Resolve: func(_ context.Context, v interface{}, args map[string]interface{}) (interface{}, error) {
+				n := node(v)
+				children := []interface{}{}
+				for _, child := range n.Children {
+					if matches(child, args) {
+						children = append(children, gqlNode{child, n.depth + 1})
+					}
+				}
+				return children, nil
+			}},
+		{Name: "descendants", Type: "[Node!]!", Description: "The nodes below this one, depth first.",
+			Args: append([]gqlArgDef{
+				{Name: "maxDepth", Type: "Int", Description: "Only nodes this many levels down or fewer."},
+				{Name: "first", Type: "Int", Description: "At most this many nodes."},
+			}, nodeFilter...),
+			Resolve: func(_ context.Context, v interface{}, args map[string]interface{}) (interface{}, error) {
+				n := node(v)
+				maxDepth, hasMaxDepth := args["maxDepth"].(int)
+				first, hasFirst := args["first"].(int)
+				if !hasFirst || first > gqlMaxDescendants {
+					first = gqlMaxDescendants
+				}
+				if hasMaxDepth && maxDepth < 0 || first < 0 {
+					return nil, errors.New("maxDepth and first can't be negative")
+				}
+				out := []interface{}{}
+				var visit func(children []*analyze.NormalNode, level int)
+				visit = func(children []*analyze.NormalNode, level int) {
+					if hasMaxDepth && level > maxDepth {
+						return
+					}
+					for _, child := range children {
+						if len(out) >= first {
+							return
+						}
+						if matches(child, args) {
+							out = append(out, gqlNode{child, n.depth + level})
+						}
+						visit(child.Children, level+1)
+					}
+				}
+				visit(n.Children, 1)
+				return out, nil
+			}},
+	}}
+
+	locationType := &gqlType{Name: "Location", Description: "Lines count from 1 and characters from 0.", Fields: []gqlFieldDef{
+		{Name: "startLine", Type: "Int!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return loc(v).StartLine, nil
+		}},
+		{Name: "startChar", Type: "Int!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return loc(v).StartChar, nil
+		}},
+		{Name: "endLine", Type: "Int!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return loc(v).EndLine, nil
+		}},
+		{Name: "endChar", Type: "Int!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return loc(v).EndChar, nil
+		}},
+	}}
+
+	return newGQLSchema(query, parseResult, snippetType, nodeType, locationType)
+}
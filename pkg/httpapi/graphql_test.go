@@ -0,0 +1,355 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# A comment, and commas, which are whitespace.
+		query Q($a: [Int!]! = [1, 2], $b: String) @x {
+			first: f(s: "tab\tq\"é😀", n: -12, x: 1.5e3, e: RED, o: {k: [true, null]}, v: $b)
+			... on Query { g }
+			...F @skip(if: false)
+		}
+		fragment F on Query { h { i } }
+		{ j(d: """
+			  one
+			    two
+			""") }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 2 || doc.fragments["F"] == nil {
+		t.Fatalf("parsed %d operations and fragments %v", len(doc.operations), doc.fragments)
+	}
+
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Q" || op.pos != (gqlPos{3, 3}) {
+		t.Errorf("operation = %s %q at %v", op.kind, op.name, op.pos)
+	}
+	if len(op.vars) != 2 || op.vars[0].typ != "[Int!]!" || !reflect.DeepEqual(op.vars[0].def, []interface{}{int64(1), int64(2)}) || op.vars[1].typ != "String" {
+		t.Errorf("variables = %+v", op.vars)
+	}
+
+	f := op.selections[0]
+	if f.alias != "first" || f.name != "f" || f.responseKey() != "first" || f.pos != (gqlPos{4, 4}) {
+		t.Errorf("field = %q: %q at %v", f.alias, f.name, f.pos)
+	}
+	want := []interface{}{
+		"tab\tq\"é😀",
+		int64(-12),
+		1500.0,
+		gqlEnum("RED"),
+		[]gqlArg{{name: "k", value: []interface{}{true, nil}, pos: gqlPos{4, 60}}},
+		gqlVariable("b"),
+	}
+	for i, arg := range f.args {
+		if !reflect.DeepEqual(arg.value, want[i]) {
+			t.Errorf("argument %s = %#v, want %#v", arg.name, arg.value, want[i])
+		}
+	}
+	if inline := op.selections[1]; !inline.inline || inline.typeCond != "Query" || inline.selections[0].name != "g" {
+		t.Errorf("inline fragment = %+v", inline)
+	}
+	if spread := op.selections[2]; spread.spread != "F" || len(spread.directives) != 1 || spread.directives[0].name != "skip" {
+		t.Errorf("fragment spread = %+v", spread)
+	}
+
+	anon := doc.operations[1]
+	if anon.kind != "query" || anon.name != "" || anon.pos != (gqlPos{9, 3}) {
+		t.Errorf("shorthand operation = %s %q at %v", anon.kind, anon.name, anon.pos)
+	}
+	if got := anon.selections[0].args[0].value; got != "one\n  two" {
+		t.Errorf("block string = %q, want %q", got, "one\n  two")
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+		pos gqlPos
+	}{
+		{"", "The document has no operation", gqlPos{1, 1}},
+		{"fragment F on Q { a }", "The document has no operation", gqlPos{1, 22}},
+		{"{ }", "A selection set can't be empty", gqlPos{1, 3}},
+		{"{ a", `Expected a name, found end of document`, gqlPos{1, 4}},
+		{"{ a(b 1) }", `Expected ":", found "1"`, gqlPos{1, 7}},
+		{"{ a(b: ) }", `Expected a value, found ")"`, gqlPos{1, 8}},
+		{"{ a(b: 01) }", `Invalid number "01"`, gqlPos{1, 8}},
+		{"{ a(b: 1.) }", `Invalid number "1."`, gqlPos{1, 8}},
+		{"{ a(b: 1x) }", `Invalid number "1x"`, gqlPos{1, 8}},
+		{"{ a(b: 99999999999999999999) }", "Integer 99999999999999999999 is out of range", gqlPos{1, 8}},
+		{"{ a(b: \"x\n\") }", "Unterminated string", gqlPos{1, 8}},
+		{`{ a(b: "\q") }`, `Invalid escape \q`, gqlPos{1, 8}},
+		{`{ a(b: "\u12") }`, "Invalid unicode escape", gqlPos{1, 8}},
+		{`{ a(b: """x) }`, "Unterminated string", gqlPos{1, 8}},
+		{"{ a }\n  %", `Unexpected character '%'`, gqlPos{2, 3}},
+		{"query ($a: Int = $b) { a }", "A variable can't be used here", gqlPos{1, 18}},
+		{"fragment on on Q { a } { a }", `A fragment can't be named "on"`, gqlPos{1, 1}},
+		{"fragment F Q { a } { a }", `Expected "on", found "Q"`, gqlPos{1, 12}},
+		{"fragment F on Q { a } fragment F on Q { b } { a }", `There can be only one fragment named "F"`, gqlPos{1, 23}},
+		{"type Q { a }", `Unexpected "type"`, gqlPos{1, 1}},
+		{strings.Repeat("{ a ", 101) + strings.Repeat("}", 101), "The document nests more than 100 levels deep", gqlPos{1, 401}},
+	}
+	for _, tt := range tests {
+		_, err := parseGraphQL(tt.src)
+		var se *gqlSyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("parseGraphQL(%.30q) error = %v, want a syntax error", tt.src, err)
+			continue
+		}
+		if se.msg != tt.msg || se.pos != tt.pos {
+			t.Errorf("parseGraphQL(%.30q) error = %q at %v, want %q at %v", tt.src, se.msg, se.pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+type gqlTestNode struct {
+	typ      string
+	children []interface{}
+}
+
+// gqlTestSchema is a small schema with fields that fail, and fail where
+// they can't be null, to execute queries against.
+func gqlTestSchema() *gqlSchema {
+	node := &gqlType{Name: "Node", Fields: []gqlFieldDef{
+		{Name: "type", Type: "String!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return v.(*gqlTestNode).typ, nil
+		}},
+		{Name: "children", Type: "[Node!]!", Resolve: func(_ context.Context, v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return v.(*gqlTestNode).children, nil
+		}},
+	}}
+	query := &gqlType{Name: "Query", Fields: []gqlFieldDef{
+		{Name: "greet", Type: "String!", Args: []gqlArgDef{{Name: "name", Type: "String!"}, {Name: "times", Type: "Int"}},
+			Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				times, ok := args["times"].(int)
+				if !ok {
+					times = 1
+				}
+				return strings.Repeat("Hello, "+args["name"].(string), times), nil
+			}},
+		{Name: "tree", Type: "Node", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return &gqlTestNode{"program", []interface{}{&gqlTestNode{"int", []interface{}{}}, nil}}, nil
+		}},
+		{Name: "leaf", Type: "Node", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return &gqlTestNode{"int", []interface{}{}}, nil
+		}},
+		{Name: "broken", Type: "String", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, &gqlFieldError{"Broken", map[string]interface{}{"code": "BROKEN"}}
+		}},
+		{Name: "brokenRequired", Type: "String!", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("Broken")
+		}},
+		{Name: "nothing", Type: "String!", Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		}},
+	}}
+	return newGQLSchema(query, node)
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	schema := gqlTestSchema()
+	tests := []struct {
+		name      string
+		query     string
+		operation string
+		vars      string
+		want      string
+		wantValid bool
+	}{
+		{
+			name:      "field",
+			query:     `{ greet(name: "Ann") }`,
+			want:      `{"data":{"greet":"Hello, Ann"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "aliases and __typename",
+			query:     `{ b: greet(name: "B", times: 2) a: greet(name: "A") __typename }`,
+			want:      `{"data":{"b":"Hello, BHello, B","a":"Hello, A","__typename":"Query"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "variables",
+			query:     `query ($n: String!, $t: Int) { greet(name: $n, times: $t) }`,
+			vars:      `{"n": "Bo", "t": 2}`,
+			want:      `{"data":{"greet":"Hello, BoHello, Bo"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "variable default",
+			query:     `query ($n: String! = "X") { greet(name: $n) }`,
+			want:      `{"data":{"greet":"Hello, X"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "named operation",
+			query:     `query A { a: greet(name: "A") } query B { b: greet(name: "B") }`,
+			operation: "B",
+			want:      `{"data":{"b":"Hello, B"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "fragments",
+			query:     `{ ...F ... on Query { g: greet(name: "G") } } fragment F on Query { greet(name: "F") }`,
+			want:      `{"data":{"greet":"Hello, F","g":"Hello, G"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "skip and include",
+			query:     `query ($s: Boolean!) { a: greet(name: "A") @skip(if: $s) b: greet(name: "B") @include(if: false) ... @include(if: true) { c: greet(name: "C") } }`,
+			vars:      `{"s": true}`,
+			want:      `{"data":{"c":"Hello, C"}}`,
+			wantValid: true,
+		},
+		{
+			name:      "merged selections",
+			query:     `{ leaf { type } leaf { children { type } } }`,
+			want:      `{"data":{"leaf":{"type":"int","children":[]}}}`,
+			wantValid: true,
+		},
+		{
+			name:      "error in a nullable field",
+			query:     `{ broken greet(name: "A") }`,
+			want:      `{"data":{"broken":null,"greet":"Hello, A"},"errors":[{"message":"Broken","locations":[{"line":1,"column":3}],"path":["broken"],"extensions":{"code":"BROKEN"}}]}`,
+			wantValid: true,
+		},
+		{
+			name:      "error in a non-null field",
+			query:     `{ greet(name: "A") brokenRequired }`,
+			want:      `{"data":null,"errors":[{"message":"Broken","locations":[{"line":1,"column":20}],"path":["brokenRequired"]}]}`,
+			wantValid: true,
+		},
+		{
+			name:      "null in a non-null field",
+			query:     `{ nothing }`,
+			want:      `{"data":null,"errors":[{"message":"Cannot return null for non-nullable field","locations":[{"line":1,"column":3}],"path":["nothing"]}]}`,
+			wantValid: true,
+		},
+		{
+			// A null in [Node!] nulls the list, and tree with it, as
+			// tree is nullable.
+			name:      "null in a non-null list element",
+			query:     `{ tree { type children { type } } }`,
+			want:      `{"data":{"tree":null},"errors":[{"message":"Cannot return null for non-nullable field","locations":[{"line":1,"column":15}],"path":["tree","children",1]}]}`,
+			wantValid: true,
+		},
+		{
+			name:  "syntax error",
+			query: `{ greet(`,
+			want:  `{"errors":[{"message":"Syntax error: Expected a name, found end of document","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			name:  "mutation",
+			query: `mutation { greet(name: "A") }`,
+			want:  `{"errors":[{"message":"Only queries are supported","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:  "several operations",
+			query: `query A { greet(name: "A") } query B { greet(name: "B") }`,
+			want:  `{"errors":[{"message":"The document has several operations, so operationName is required"}]}`,
+		},
+		{
+			name:      "unknown operation",
+			query:     `query A { greet(name: "A") }`,
+			operation: "B",
+			want:      `{"errors":[{"message":"Unknown operation \"B\""}]}`,
+		},
+		{
+			name:  "missing variable",
+			query: `query ($n: String!) { greet(name: $n) }`,
+			want:  `{"errors":[{"message":"Variable $n of type String! is required","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name:  "variable of the wrong type",
+			query: `query ($t: Int) { greet(name: "A", times: $t) }`,
+			vars:  `{"t": 1.5}`,
+			want:  `{"errors":[{"message":"Variable $t: expected Int","locations":[{"line":1,"column":8}]}]}`,
+		},
+		{
+			name:  "variable used as the wrong type",
+			query: `query ($n: Int) { greet(name: $n) }`,
+			want:  `{"errors":[{"message":"Argument \"name\": variable $n of type Int can't be used as String!","locations":[{"line":1,"column":25}]}]}`,
+		},
+		{
+			name:  "undefined variable",
+			query: `{ greet(name: $n) }`,
+			want:  `{"errors":[{"message":"Argument \"name\": variable $n is not defined","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ greet(name: "A") { x } nope }`,
+			want: `{"errors":[{"message":"Field \"greet\" is a String! and has no fields to select","locations":[{"line":1,"column":3}]},` +
+				`{"message":"Type Query has no field \"nope\"","locations":[{"line":1,"column":26}]}]}`,
+		},
+		{
+			name:  "missing selection",
+			query: `{ tree }`,
+			want:  `{"errors":[{"message":"Field \"tree\" of type Node needs a selection of its fields","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "arguments",
+			query: `{ greet(times: "2", x: 1) }`,
+			want: `{"errors":[{"message":"Argument \"times\": expected Int","locations":[{"line":1,"column":9}]},` +
+				`{"message":"Unknown argument \"x\"","locations":[{"line":1,"column":21}]},` +
+				`{"message":"Argument \"name\" of type String! is required","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "fragment errors",
+			query: `{ ...Missing ...Loop tree { ...OnQuery } } fragment Loop on Query { ...Loop } fragment OnQuery on Query { __typename }`,
+			want: `{"errors":[{"message":"Unknown fragment \"Missing\"","locations":[{"line":1,"column":3}]},` +
+				`{"message":"Fragment \"Loop\" spreads itself","locations":[{"line":1,"column":69}]},` +
+				`{"message":"A fragment on Query can't be used on Node","locations":[{"line":1,"column":29}]}]}`,
+		},
+		{
+			name:  "unknown directive",
+			query: `{ greet(name: "A") @defer }`,
+			want:  `{"errors":[{"message":"Unknown directive @defer","locations":[{"line":1,"column":3}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		var vars map[string]interface{}
+		if tt.vars != "" {
+			if err := json.Unmarshal([]byte(tt.vars), &vars); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, valid := executeGraphQL(context.Background(), schema, nil, tt.query, tt.operation, vars)
+		got, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want || valid != tt.wantValid {
+			t.Errorf("%s: got %v\n%s\nwant %v\n%s", tt.name, valid, got, tt.wantValid, tt.want)
+		}
+	}
+}
+
+func TestGraphQLSDL(t *testing.T) {
+	schema := newGQLSchema(&gqlType{Name: "Query", Description: "The root.", Fields: []gqlFieldDef{
+		{Name: "greet", Type: "String!", Description: "A greeting.", Args: []gqlArgDef{
+			{Name: "name", Type: "String!", Description: "Who to greet."},
+			{Name: "times", Type: "Int"},
+		}},
+	}})
+	want := `"Any JSON value."
+scalar JSON
+
+"The root."
+type Query {
+  "A greeting."
+  greet("Who to greet." name: String!, times: Int): String!
+}
+`
+	if got := schema.sdl(); got != want {
+		t.Errorf("sdl() =\n%s\nwant\n%s", got, want)
+	}
+}
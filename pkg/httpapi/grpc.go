@@ -0,0 +1,334 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// grpcServicePath prefixes the paths of the methods of the Visualizer
+// service in proto/rubyast/v1/visualizer.proto.
+const grpcServicePath = "/rubyast.v1.Visualizer/"
+
+// gRPC status codes.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcCodes maps the statuses the HTTP handlers share with the gRPC methods
+// to status codes; anything else is internal.
+var grpcCodes = map[int]int{
+	http.StatusBadRequest:            grpcInvalidArgument,
+	http.StatusUnauthorized:          grpcUnauthenticated,
+	http.StatusNotFound:              grpcNotFound,
+	http.StatusRequestEntityTooLarge: grpcResourceExhausted,
+	http.StatusUnprocessableEntity:   grpcInvalidArgument,
+	http.StatusTooManyRequests:       grpcResourceExhausted,
+	http.StatusServiceUnavailable:    grpcUnavailable,
+	http.StatusGatewayTimeout:        grpcDeadlineExceeded,
+}
+
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// grpcMethod handles one unary call, from request message to response.
+type grpcMethod func(ctx context.Context, req []byte) (protoBuf, error)
+
+func (s *server) grpcMethods() map[string]grpcMethod {
+	return map[string]grpcMethod{
+		"Parse":   s.grpcParse,
+		"Analyze": s.grpcAnalyze,
+		"Format":  s.grpcFormat,
+	}
+}
+
+// grpcRoute names gRPC calls by method path in logs and metrics.
+func (s *server) grpcRoute(r *http.Request) string {
+	if _, ok := s.grpcMethods()[strings.TrimPrefix(r.URL.Path, grpcServicePath)]; ok {
+		return r.URL.Path
+	}
+	return "other"
+}
+
+// grpcHandler serves the Visualizer service's unary calls over HTTP/2, with
+// each message framed as gRPC does and the status sent in trailers.
+// Compressed messages aren't supported, and none are sent.
+func (s *server) grpcHandler() http.Handler {
+	methods := s.grpcMethods()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			writeError(w, http.StatusUnsupportedMediaType, "Only gRPC is served here")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		resp, err := s.grpcCall(w, r, methods)
+		if err == nil {
+			frame := make([]byte, 5, 5+len(resp))
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+			if _, err := w.Write(append(frame, resp...)); err != nil {
+				slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+			}
+			w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+			return
+		}
+		var ge *grpcError
+		if !errors.As(err, &ge) {
+			slog.ErrorContext(r.Context(), "Error handling gRPC call", "method", r.URL.Path, "err", err)
+			ge = &grpcError{grpcInternal, "Internal error"}
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(ge.code))
+		w.Header().Set("Grpc-Message", grpcEncodeMessage(ge.message))
+	})
+}
+
+// grpcCall reads the request message and runs the method for it under any
+// grpc-timeout the client set.
+func (s *server) grpcCall(w http.ResponseWriter, r *http.Request, methods map[string]grpcMethod) (protoBuf, error) {
+	method, ok := methods[strings.TrimPrefix(r.URL.Path, grpcServicePath)]
+	if !ok {
+		return nil, &grpcError{grpcUnimplemented, "Unknown method " + r.URL.Path}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes+5))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("Request exceeds %d bytes", tooLarge.Limit-5)}
+		}
+		return nil, &grpcError{grpcInternal, "Failed to read request"}
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return nil, &grpcError{grpcInvalidArgument, "Expected exactly one request message"}
+	}
+	if body[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "Compressed messages are not supported"}
+	}
+
+	ctx := r.Context()
+	if timeout, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return method(ctx, body[5:])
+}
+
+// parseGRPCTimeout parses a grpc-timeout header, such as "100m" for 100
+// milliseconds.
+func parseGRPCTimeout(header string) (time.Duration, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[header[len(header)-1]]
+	n, err := strconv.ParseInt(header[:len(header)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// grpcEncodeMessage percent-encodes a status message as grpc-message
+// requires.
+func grpcEncodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcLookupParser finds the named parser, or the default.
+func (s *server) grpcLookupParser(name string) (parser.Parser, error) {
+	if name == "" {
+		name = parser.DefaultParser
+	}
+	p, ok := s.parsers[name]
+	if !ok {
+		return nil, &grpcError{grpcInvalidArgument, "Unknown parser"}
+	}
+	return p, nil
+}
+
+// grpcParseError converts a failed parse to a status as /parse would
+// answer it, with a syntax error's position in the message.
+func grpcParseError(ctx context.Context, p parser.Parser, err error) error {
+	status, resp := parseErrorResponse(ctx, p, err)
+	code, ok := grpcCodes[status]
+	if !ok {
+		code = grpcInternal
+	}
+	if resp.Line > 0 {
+		resp.Error = fmt.Sprintf("%s (line %d, column %d)", resp.Error, resp.Line, resp.Column)
+	}
+	return &grpcError{code, resp.Error}
+}
+
+// grpcCodeRequest decodes the code = 1 and parser = 2 fields the requests
+// share, passing any others to extra.
+func grpcCodeRequest(msg []byte, extra func(field int, num uint64, data []byte)) (codeRequest, error) {
+	var req codeRequest
+	err := protoFields(msg, func(field, wireType int, num uint64, data []byte) error {
+		switch {
+		case field == 1 && wireType == wireBytes:
+			req.Code = string(data)
+		case field == 2 && wireType == wireBytes:
+			req.Parser = string(data)
+		case extra != nil:
+			extra(field, num, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return req, &grpcError{grpcInvalidArgument, "Malformed request message"}
+	}
+	if !utf8.ValidString(req.Code) {
+		return req, &grpcError{grpcInvalidArgument, "Code is not valid UTF-8"}
+	}
+	return req, nil
+}
+
+func (s *server) grpcParse(ctx context.Context, msg []byte) (protoBuf, error) {
+	var maxDepth, maxNodes int
+	req, err := grpcCodeRequest(msg, func(field int, num uint64, _ []byte) {
+		switch field {
+		case 3:
+			maxDepth = int(int32(num))
+		case 4:
+			maxNodes = int(int32(num))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.grpcLookupParser(req.Parser)
+	if err != nil {
+		return nil, err
+	}
+	output, hit, err := s.parse(ctx, p, req.Code)
+	if err != nil {
+		return nil, grpcParseError(ctx, p, err)
+	}
+
+	// ripper's s-expressions can't be pruned, as with /parse.
+	var tree *analyze.NormalNode
+	if root, err := analyze.DecodeAST(output); err == nil {
+		tree = analyze.NormalizeNode(analyze.PruneTree(root, "", maxDepth, maxNodes), "")
+	} else if tree, err = analyze.Normalize(output); err != nil {
+		return nil, fmt.Errorf("normalizing the tree: %w", err)
+	}
+
+	var b protoBuf
+	b.message(1, func(m *protoBuf) { encodeNode(m, tree) })
+	b.string(2, p.Name())
+	b.string(3, parseID(p, req.Code))
+	b.bool(4, hit)
+	return b, nil
+}
+
+// grpcAnalyses are the analyses Analyze runs, each giving what its HTTP
+// endpoint would.
+var grpcAnalyses = map[string]func(root *analyze.Node, code string) interface{}{
+	"comments": func(root *analyze.Node, code string) interface{} {
+		return commentsResponse{analyze.ExtractComments(root, code)}
+	},
+	"metrics": func(root *analyze.Node, _ string) interface{} { return analyze.ComputeCodeMetrics(root) },
+	"scopes":  func(root *analyze.Node, _ string) interface{} { return analyze.AnalyzeScopes(root) },
+	"stats":   func(root *analyze.Node, _ string) interface{} { return analyze.ComputeStats(root) },
+	"symbols": func(root *analyze.Node, _ string) interface{} {
+		return symbolsResponse{analyze.FindSymbols(root)}
+	},
+}
+
+func (s *server) grpcAnalyze(ctx context.Context, msg []byte) (protoBuf, error) {
+	var analysis string
+	req, err := grpcCodeRequest(msg, func(field int, _ uint64, data []byte) {
+		if field == 3 {
+			analysis = string(data)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	analyzeFn, ok := grpcAnalyses[analysis]
+	if !ok {
+		return nil, &grpcError{grpcInvalidArgument, "Unknown analysis"}
+	}
+	p, err := s.grpcLookupParser(req.Parser)
+	if err != nil {
+		return nil, err
+	}
+	output, _, err := s.parse(ctx, p, req.Code)
+	if err != nil {
+		return nil, grpcParseError(ctx, p, err)
+	}
+	root, err := analyze.DecodeAST(output)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "The " + p.Name() + " parser's output can't be analyzed"}
+	}
+
+	// Through JSON, so the result has the same shape as over HTTP.
+	data, err := json.Marshal(analyzeFn(root, req.Code))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var result interface{}
+	if err := dec.Decode(&result); err != nil {
+		return nil, err
+	}
+	var b protoBuf
+	b.message(1, func(m *protoBuf) { encodeValue(m, result) })
+	return b, nil
+}
+
+func (s *server) grpcFormat(ctx context.Context, msg []byte) (protoBuf, error) {
+	req, err := grpcCodeRequest(msg, nil)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.run(ctx, s.formatter, req.Code)
+	if err != nil {
+		return nil, grpcParseError(ctx, s.formatter, err)
+	}
+	var b protoBuf
+	b.string(1, string(output))
+	return b, nil
+}
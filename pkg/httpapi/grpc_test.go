@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func grpcFrame(flag byte, msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func TestGRPCHandlerErrors(t *testing.T) {
+	s := &server{cfg: &config{MaxBodyBytes: 16}}
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        []byte
+		wantHTTP    int
+		wantStatus  int
+	}{
+		{"not grpc", grpcServicePath + "Parse", "application/json", nil, http.StatusUnsupportedMediaType, -1},
+		{"unknown method", grpcServicePath + "Explain", "application/grpc", grpcFrame(0, nil), http.StatusOK, grpcUnimplemented},
+		{"no frame", grpcServicePath + "Parse", "application/grpc", nil, http.StatusOK, grpcInvalidArgument},
+		{"short frame", grpcServicePath + "Parse", "application/grpc+proto", grpcFrame(0, []byte("abc"))[:6], http.StatusOK, grpcInvalidArgument},
+		{"two messages", grpcServicePath + "Parse", "application/grpc", append(grpcFrame(0, nil), grpcFrame(0, nil)...), http.StatusOK, grpcInvalidArgument},
+		{"compressed", grpcServicePath + "Parse", "application/grpc", grpcFrame(1, []byte{0x0a, 0}), http.StatusOK, grpcUnimplemented},
+		{"too large", grpcServicePath + "Parse", "application/grpc", grpcFrame(0, make([]byte, 17)), http.StatusOK, grpcResourceExhausted},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		rec := httptest.NewRecorder()
+		s.grpcHandler().ServeHTTP(rec, req)
+		if rec.Code != tt.wantHTTP {
+			t.Errorf("%s: HTTP status = %d, want %d", tt.name, rec.Code, tt.wantHTTP)
+			continue
+		}
+		if tt.wantStatus < 0 {
+			continue
+		}
+		if got := rec.Header().Get("Grpc-Status"); got != strconv.Itoa(tt.wantStatus) {
+			t.Errorf("%s: grpc-status = %s (%s), want %d", tt.name, got, rec.Header().Get("Grpc-Message"), tt.wantStatus)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("%s: wrote a message with an error status: % x", tt.name, rec.Body.Bytes())
+		}
+	}
+}
+
+func TestGRPCCall(t *testing.T) {
+	s := &server{cfg: &config{MaxBodyBytes: 1 << 20}}
+	var deadline time.Duration
+	methods := map[string]grpcMethod{
+		"Echo": func(ctx context.Context, req []byte) (protoBuf, error) {
+			if d, ok := ctx.Deadline(); ok {
+				deadline = time.Until(d)
+			}
+			return protoBuf(req), nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, grpcServicePath+"Echo", bytes.NewReader(grpcFrame(0, []byte{0x0a, 1, 'a'})))
+	req.Header.Set("Grpc-Timeout", "2S")
+	resp, err := s.grpcCall(httptest.NewRecorder(), req, methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, []byte{0x0a, 1, 'a'}) {
+		t.Errorf("method got % x", []byte(resp))
+	}
+	if deadline <= time.Second || deadline > 2*time.Second {
+		t.Errorf("deadline in %v, want about 2s", deadline)
+	}
+
+	var ge *grpcError
+	req = httptest.NewRequest(http.MethodPost, grpcServicePath+"Parse", bytes.NewReader(grpcFrame(0, nil)))
+	if _, err := s.grpcCall(httptest.NewRecorder(), req, methods); !errors.As(err, &ge) || ge.code != grpcUnimplemented {
+		t.Errorf("unknown method: error = %v, want UNIMPLEMENTED", err)
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"100m", 100 * time.Millisecond, true},
+		{"1H", time.Hour, true},
+		{"2M", 2 * time.Minute, true},
+		{"3S", 3 * time.Second, true},
+		{"4u", 4 * time.Microsecond, true},
+		{"5n", 5 * time.Nanosecond, true},
+		{"0S", 0, true},
+		{"", 0, false},
+		{"S", 0, false},
+		{"10", 0, false},
+		{"10s", 0, false},
+		{"-1S", 0, false},
+		{"1.5S", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseGRPCTimeout(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseGRPCTimeout(%q) = %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	tests := []struct{ message, want string }{
+		{"Unknown parser", "Unknown parser"},
+		{"100% done", "100%25 done"},
+		{"line\nbreak", "line%0Abreak"},
+		{"café", "caf%C3%A9"},
+	}
+	for _, tt := range tests {
+		if got := grpcEncodeMessage(tt.message); got != tt.want {
+			t.Errorf("grpcEncodeMessage(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
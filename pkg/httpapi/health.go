@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+const (
+	toolchainCheckInterval = 30 * time.Second
+	toolchainCheckTimeout  = 5 * time.Second
+)
+
+const toolchainScript = `require "syntax_tree"; puts SyntaxTree::VERSION`
+
+// toolchainCheck runs Ruby and loads syntax_tree, remembering the outcome for
+// toolchainCheckInterval so frequent probes don't each spawn a process.
+type toolchainCheck struct {
+	rubyBin string
+
+	mu      sync.Mutex
+	checked time.Time
+	version string
+	err     error
+}
+
+func (c *toolchainCheck) run(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < toolchainCheckInterval {
+		return c.version, c.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, toolchainCheckTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := parser.NewCommand(ctx, c.rubyBin, "-e", toolchainScript)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	parser.CountSpawnFailure(cmd, err)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		if ctx.Err() != nil {
+			// Don't cache a probe that was cut short.
+			return "", err
+		}
+	}
+
+	c.checked = time.Now()
+	c.version = strings.TrimSpace(string(output))
+	c.err = err
+	return c.version, c.err
+}
+
+type readinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// handleHealthz reports that the process is up and serving requests.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type readinessResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+// handleReadyz reports whether requests can actually be parsed: Ruby and
+// syntax_tree load, and at least one stree worker is running. It answers
+// 503 otherwise, so a load balancer stops routing here.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	checks := make(map[string]readinessCheck)
+	ready := true
+
+	if version, err := s.toolchain.run(r.Context()); err != nil {
+		checks["syntax_tree"] = readinessCheck{Detail: err.Error()}
+		ready = false
+	} else {
+		checks["syntax_tree"] = readinessCheck{OK: true, Detail: version}
+	}
+
+	running := s.pool.Running()
+	checks["workers"] = readinessCheck{OK: running > 0, Detail: fmt.Sprintf("%d of %d running", running, s.pool.Size)}
+	ready = ready && running > 0
+
+	status := "ready"
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		status = "unavailable"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, readinessResponse{status, checks})
+}
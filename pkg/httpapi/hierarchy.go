@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type hierarchyResponse struct {
+	*analyze.Hierarchy
+	Errors []fileError `json:"errors"`
+}
+
+// handleHierarchy extracts the inheritance and mixin graph from one snippet,
+// or merged across the files of a project.
+func (s *server) handleHierarchy(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req sourcesRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Format != "" && req.Format != analyze.DefaultFormat && req.Format != "dot" {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	files, fileErrors, ok := s.parseSourceFiles(w, r, parser, req.Code, req.Files)
+	if !ok {
+		return
+	}
+
+	var decls []analyze.ClassDecl
+	for _, f := range files {
+		decls = append(decls, analyze.FindClassDecls(f.Root, f.Path)...)
+	}
+
+	h := analyze.MergeHierarchy(decls)
+	w.Header().Set("X-Parser", parser.Name())
+	if req.Format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		analyze.WriteHierarchyDOT(w, h)
+		return
+	}
+	writeJSON(w, hierarchyResponse{h, fileErrors})
+}
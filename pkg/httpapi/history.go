@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// historyMaxCode is the largest snippet kept in parse history; bigger ones
+// are parsed as usual but not remembered.
+const historyMaxCode = 64 << 10
+
+// historyEntry is one parse in a session's history. The code can be posted
+// to /parse again, and ParseID used with /subtree and /render while the
+// parse is still cached.
+type historyEntry struct {
+	ParseID   string    `json:"parse_id"`
+	Code      string    `json:"code"`
+	Parser    string    `json:"parser"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type historyResponse struct {
+	Entries []historyEntry `json:"entries"`
+}
+
+func historyKey(session string) string {
+	return "history/" + session
+}
+
+func (s *server) loadHistory(ctx context.Context, session string) ([]historyEntry, error) {
+	data, err := s.history.get(ctx, historyKey(session))
+	if errors.Is(err, errNotStored) {
+		return []historyEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *server) saveHistory(ctx context.Context, session string, entries []historyEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.history.put(ctx, historyKey(session), data, s.cfg.HistoryTTL)
+}
+
+// recordHistory adds a successful parse to the front of the session's
+// history in storage, unless it repeats the latest entry. Failing to is
+// logged rather than failing the parse. historyLocks serializes updates to a
+// session within this replica; across replicas, two parses landing at once
+// can lose an entry, which history can live with. Without a session there
+// is nothing to record.
+func (s *server) recordHistory(ctx context.Context, session string, p parser.Parser, code string) {
+	if session == "" || s.cfg.History == 0 || len(code) > historyMaxCode {
+		return
+	}
+	defer s.historyLocks.lock(session)()
+
+	entries, err := s.loadHistory(ctx, session)
+	if err == nil {
+		if len(entries) > 0 && entries[0].Code == code && entries[0].Parser == p.Name() {
+			return
+		}
+		entry := historyEntry{ParseID: parseID(p, code), Code: code, Parser: p.Name(), CreatedAt: time.Now().UTC()}
+		entries = append([]historyEntry{entry}, entries...)
+		if len(entries) > s.cfg.History {
+			entries = entries[:s.cfg.History]
+		}
+		err = s.saveHistory(ctx, session, entries)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Error recording parse history", "err", err)
+	}
+}
+
+// moveHistory moves a session's history to another, for when a sign-in
+// gives the client a new session. Failing to is logged; only the history is
+// lost.
+func (s *server) moveHistory(ctx context.Context, from, to string) {
+	defer s.historyLocks.lock(from)()
+	entries, err := s.loadHistory(ctx, from)
+	if err == nil && len(entries) > 0 {
+		if err = s.saveHistory(ctx, to, entries); err == nil {
+			err = s.saveHistory(ctx, from, []historyEntry{})
+		}
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Error moving parse history", "err", err)
+	}
+}
+
+// handleHistory lists the parses the client has made, newest first, or
+// with DELETE forgets them. A client without a session is given one here,
+// which starts its history.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	if s.cfg.History == 0 {
+		writeError(w, http.StatusNotFound, "Parse history is disabled")
+		return
+	}
+
+	session := s.session(w, r)
+	if r.Method == http.MethodDelete {
+		unlock := s.historyLocks.lock(session)
+		err := s.saveHistory(r.Context(), session, []historyEntry{})
+		unlock()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error clearing parse history", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to clear history")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	entries, err := s.loadHistory(r.Context(), session)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading parse history", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load history")
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, historyResponse{entries})
+}
+
+// sessionLocks hands out a mutex per session, kept only while someone holds
+// or waits for it.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks session's mutex and returns the function that unlocks it.
+func (l *sessionLocks) lock(session string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+	sl := l.locks[session]
+	if sl == nil {
+		sl = &sessionLock{}
+		l.locks[session] = sl
+	}
+	sl.refs++
+	l.mu.Unlock()
+
+	sl.Lock()
+	return func() {
+		sl.Unlock()
+		l.mu.Lock()
+		if sl.refs--; sl.refs == 0 {
+			delete(l.locks, session)
+		}
+		l.mu.Unlock()
+	}
+}
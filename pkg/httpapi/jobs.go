@@ -0,0 +1,675 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job statuses.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// jobPollInterval is how often a job shared through storage is saved while
+// it runs, and how often other replicas look at it.
+const jobPollInterval = time.Second
+
+// jobResponse is what GET /jobs/{id} answers: where the job has got and,
+// once it has finished, how it went. A JSON result is inline; any result is
+// at ResultURL.
+type jobResponse struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"`
+	Phase      string          `json:"phase,omitempty"`
+	Done       int             `json:"done"`
+	Total      int             `json:"total"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      *errorResponse  `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	ResultURL  string          `json:"result_url,omitempty"`
+}
+
+func (resp *jobResponse) finished() bool {
+	return resp.Status != jobQueued && resp.Status != jobRunning
+}
+
+// jobRecord is a job as kept in shared storage, where any replica can
+// report on it.
+type jobRecord struct {
+	jobResponse
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// jobCreated answers a request that was queued as a job.
+type jobCreated struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	StatusURL string `json:"status_url"`
+	EventsURL string `json:"events_url"`
+}
+
+type jobProgress struct {
+	Phase string `json:"phase,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+type jobFileEvent struct {
+	Done  int         `json:"done"`
+	Total int         `json:"total"`
+	File  projectFile `json:"file"`
+}
+
+// jobResultHeaders are the response headers a job's result is served with.
+var jobResultHeaders = []string{"Content-Type", "Content-Disposition", "X-Parser", "X-Parse-ID", "X-Ruby-Version"}
+
+// job is a request running in the background. Everything it reports is kept
+// as a log of events, so a client connecting late, or reconnecting with
+// Last-Event-ID, is sent what it missed before the live events.
+type job struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	run       func(ctx context.Context, w http.ResponseWriter)
+	resultURL string
+
+	mu      sync.Mutex
+	resp    jobResponse
+	header  http.Header
+	body    []byte
+	events  []string
+	changed chan struct{}
+}
+
+type jobContextKey struct{}
+
+// jobFromContext returns the job a handler is running as, if any, for it to
+// report progress to.
+func jobFromContext(ctx context.Context) *job {
+	j, _ := ctx.Value(jobContextKey{}).(*job)
+	return j
+}
+
+// emit appends an event to the log and wakes the streams following it.
+// j.mu must be held.
+func (j *job) emit(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding job event", "err", err)
+		return
+	}
+	j.events = append(j.events, fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", len(j.events)+1, name, data))
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// phase says what a running job is busy with, such as cloning.
+func (j *job) phase(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.resp.Phase = name
+	j.emit("progress", jobProgress{j.resp.Phase, j.resp.Done, j.resp.Total})
+}
+
+// started and parsed make a job the observer of a project parse.
+func (j *job) started(total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.resp.Phase, j.resp.Total = "parsing", total
+	j.emit("progress", jobProgress{j.resp.Phase, j.resp.Done, j.resp.Total})
+}
+
+func (j *job) parsed(file projectFile) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.resp.Done++
+	j.emit("file", jobFileEvent{j.resp.Done, j.resp.Total, file})
+}
+
+// finish records how the job ended, unless it already has. rec is nil for
+// a canceled job.
+func (j *job) finish(status string, rec *jobRecorder) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.resp.finished() {
+		return
+	}
+	now := time.Now().UTC()
+	j.resp.Status, j.resp.FinishedAt = status, &now
+	switch {
+	case status == jobCanceled:
+		j.resp.Error = &errorResponse{Error: "The job was canceled"}
+	case status == jobFailed:
+		j.resp.StatusCode = rec.status
+		j.resp.Error = &errorResponse{Error: http.StatusText(rec.status)}
+		json.Unmarshal(rec.body.Bytes(), j.resp.Error)
+	default:
+		j.resp.StatusCode = rec.status
+		j.header = make(http.Header)
+		for _, name := range jobResultHeaders {
+			if v := rec.header.Get(name); v != "" {
+				j.header.Set(name, v)
+			}
+		}
+		j.body = rec.body.Bytes()
+		j.resp.ResultURL = j.resultURL
+		if mediaType, _, _ := mime.ParseMediaType(j.header.Get("Content-Type")); mediaType == "application/json" && json.Valid(j.body) {
+			j.resp.Result = bytes.TrimSpace(j.body)
+		}
+	}
+	summary := j.resp
+	summary.Result = nil
+	j.emit(status, summary)
+}
+
+func (j *job) record() *jobRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &jobRecord{jobResponse: j.resp, Header: j.header, Body: j.body}
+}
+
+// jobRecorder is the ResponseWriter a job's handler writes to.
+type jobRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *jobRecorder) Header() http.Header { return rec.header }
+
+func (rec *jobRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *jobRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+func (rec *jobRecorder) Flush() {}
+
+// jobs queues requests to run in the background, -jobs at a time. Each job
+// is kept for -job-ttl after it finishes so its result can be fetched. With
+// shared storage, jobs are saved there too, so any replica can report on
+// one or cancel it, though only the one that took it runs it.
+type jobs struct {
+	ctx    context.Context
+	ttl    time.Duration
+	shared storage
+	queue  chan *job
+
+	mu   sync.Mutex
+	byID map[string]*job
+}
+
+func newJobs(ctx context.Context, cfg *config, st storage) *jobs {
+	js := &jobs{
+		ctx:   ctx,
+		ttl:   cfg.JobTTL,
+		queue: make(chan *job, cfg.JobQueue),
+		byID:  make(map[string]*job),
+	}
+	if _, ok := st.(*memoryStorage); !ok {
+		js.shared = st
+	}
+	for i := 0; i < cfg.Jobs; i++ {
+		go js.work()
+	}
+	return js
+}
+
+var errJobQueueFull = errors.New("job queue is full")
+
+// enqueue queues run as a new job, failing if the queue is full. The job
+// keeps ctx's values, such as its trace, but is canceled with the server
+// rather than the request.
+func (js *jobs) enqueue(ctx context.Context, kind, base string, run func(ctx context.Context, w http.ResponseWriter)) (*job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	j := &job{
+		run:       run,
+		resultURL: base + "/jobs/" + id + "/result",
+		resp:      jobResponse{ID: id, Kind: kind, Status: jobQueued, CreatedAt: time.Now().UTC()},
+		changed:   make(chan struct{}),
+	}
+	j.ctx, j.cancel = context.WithCancel(context.WithValue(context.WithoutCancel(ctx), jobContextKey{}, j))
+	context.AfterFunc(js.ctx, j.cancel)
+
+	js.mu.Lock()
+	js.prune()
+	js.byID[id] = j
+	js.mu.Unlock()
+	js.save(j)
+	select {
+	case js.queue <- j:
+		return j, nil
+	default:
+		js.mu.Lock()
+		delete(js.byID, id)
+		js.mu.Unlock()
+		j.cancel()
+		return nil, errJobQueueFull
+	}
+}
+
+func (js *jobs) work() {
+	for {
+		select {
+		case <-js.ctx.Done():
+			return
+		case j := <-js.queue:
+			js.runJob(j)
+		}
+	}
+}
+
+func (js *jobs) runJob(j *job) {
+	defer j.cancel()
+	if rec, err := js.load(j.ctx, j.resp.ID); err == nil && rec.Status == jobCanceled {
+		j.cancel()
+	}
+	j.mu.Lock()
+	if j.resp.Status != jobQueued || j.ctx.Err() != nil {
+		j.mu.Unlock()
+		j.finish(jobCanceled, nil)
+		return
+	}
+	now := time.Now().UTC()
+	j.resp.Status, j.resp.StartedAt = jobRunning, &now
+	j.emit(jobRunning, jobProgress{j.resp.Phase, j.resp.Done, j.resp.Total})
+	j.mu.Unlock()
+	js.save(j)
+
+	done := make(chan struct{})
+	if js.shared != nil {
+		go js.share(j, done)
+	}
+	rec := &jobRecorder{header: make(http.Header)}
+	j.run(j.ctx, rec)
+	close(done)
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	switch {
+	case j.ctx.Err() != nil:
+		j.finish(jobCanceled, nil)
+	case rec.status >= 300:
+		j.finish(jobFailed, rec)
+	default:
+		j.finish(jobDone, rec)
+	}
+	js.save(j)
+}
+
+// share keeps a running job's progress in shared storage until done is
+// closed, and cancels it if another replica has marked it canceled there.
+func (js *jobs) share(j *job, done chan struct{}) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if rec, err := js.load(j.ctx, j.resp.ID); err == nil && rec.Status == jobCanceled {
+			j.cancel()
+			return
+		}
+		js.save(j)
+	}
+}
+
+// save puts a job in shared storage, if there is any.
+func (js *jobs) save(j *job) {
+	if js.shared == nil {
+		return
+	}
+	js.saveRecord(j.record())
+}
+
+func (js *jobs) saveRecord(rec *jobRecord) {
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = js.shared.put(js.ctx, "jobs/"+rec.ID, data, js.ttl+jobPollInterval)
+	}
+	if err != nil {
+		slog.Warn("Error saving job", "id", rec.ID, "err", err)
+	}
+}
+
+func (js *jobs) load(ctx context.Context, id string) (*jobRecord, error) {
+	if js.shared == nil {
+		return nil, errNotStored
+	}
+	data, err := js.shared.get(ctx, "jobs/"+id)
+	if err != nil {
+		return nil, err
+	}
+	var rec jobRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// prune forgets jobs that finished more than ttl ago. js.mu must be held.
+func (js *jobs) prune() {
+	for id, j := range js.byID {
+		j.mu.Lock()
+		expired := j.resp.FinishedAt != nil && time.Since(*j.resp.FinishedAt) > js.ttl
+		j.mu.Unlock()
+		if expired {
+			delete(js.byID, id)
+		}
+	}
+}
+
+func (js *jobs) get(id string) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune()
+	return js.byID[id]
+}
+
+// cancelJob cancels a queued or running job. One another replica runs is
+// marked canceled in shared storage, and stops when that replica notices.
+func (js *jobs) cancelJob(j *job, rec *jobRecord) {
+	if j != nil {
+		j.cancel()
+		j.finish(jobCanceled, nil)
+		js.save(j)
+		return
+	}
+	if !rec.finished() {
+		now := time.Now().UTC()
+		rec.Status, rec.FinishedAt = jobCanceled, &now
+		rec.Error = &errorResponse{Error: "The job was canceled"}
+		js.saveRecord(rec)
+	}
+}
+
+// wantsAsync reports whether a request asks to be run as a job, with
+// Prefer: respond-async or ?async=true.
+func wantsAsync(r *http.Request) bool {
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+	return async
+}
+
+// asyncJob lets a slow endpoint run as a background job: asked to, it reads
+// the request body, queues the handler to run on it later and answers 202
+// with where to follow the job. Otherwise the handler runs as usual.
+func (s *server) asyncJob(kind string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wantsAsync(r) {
+			handler(w, r)
+			return
+		}
+		if s.jobs == nil {
+			writeError(w, http.StatusNotFound, "Background jobs are disabled")
+			return
+		}
+
+		// The handler applies its own limit when it reads the body again.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max(s.cfg.MaxBodyBytes, s.cfg.MaxUploadBytes)))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+				return
+			}
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+
+		base := jobsBase(r)
+		j, err := s.jobs.enqueue(r.Context(), kind, base, func(ctx context.Context, rw http.ResponseWriter) {
+			req := r.Clone(ctx)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.Header.Del("Prefer")
+			handler(rw, req)
+		})
+		if errors.Is(err, errJobQueueFull) {
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusTooManyRequests, "The job queue is full, try again later")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error queueing job", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to queue job")
+			return
+		}
+
+		w.Header().Set("Preference-Applied", "respond-async")
+		writeJobCreated(w, r, base, j)
+	}
+}
+
+// jobsBase is the path job URLs go under: wherever the API is mounted,
+// which StripPrefix leaves in RequestURI.
+func jobsBase(r *http.Request) string {
+	requestPath, _, _ := strings.Cut(r.RequestURI, "?")
+	return strings.TrimSuffix(requestPath, r.URL.Path)
+}
+
+// writeJobCreated answers 202 with where to follow a job just queued.
+func writeJobCreated(w http.ResponseWriter, r *http.Request, base string, j *job) {
+	statusURL := base + "/jobs/" + j.resp.ID
+	w.Header().Set("Location", statusURL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	resp := jobCreated{ID: j.resp.ID, Status: jobQueued, StatusURL: statusURL, EventsURL: statusURL + "/events"}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
+
+// jobNoResult explains why a job that isn't done has no result.
+var jobNoResult = map[string]string{
+	jobQueued:   "The job hasn't started yet",
+	jobRunning:  "The job is still running",
+	jobFailed:   "The job failed; its status has the error",
+	jobCanceled: "The job was canceled",
+}
+
+// handleJob serves /jobs/{id}, a job's status or with DELETE its
+// cancellation; /jobs/{id}/events, its progress as Server-Sent Events; and
+// /jobs/{id}/result, what its endpoint answered.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	methods := []string{http.MethodGet}
+	if sub == "" {
+		methods = append(methods, http.MethodDelete)
+	}
+	if !s.allowMethods(w, r, methods...) {
+		return
+	}
+	if s.jobs == nil || (sub != "" && sub != "events" && sub != "result") {
+		writeError(w, http.StatusNotFound, "Unknown job")
+		return
+	}
+
+	j := s.jobs.get(id)
+	var rec *jobRecord
+	if j != nil {
+		rec = j.record()
+	} else {
+		var err error
+		rec, err = s.jobs.load(r.Context(), id)
+		if errors.Is(err, errNotStored) {
+			writeError(w, http.StatusNotFound, "Unknown job")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading job", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to load job")
+			return
+		}
+	}
+
+	switch {
+	case sub == "events" && j != nil:
+		s.streamJob(w, r, j)
+	case sub == "events":
+		s.streamSharedJob(w, r, rec)
+	case sub == "result":
+		if message, ok := jobNoResult[rec.Status]; ok {
+			writeError(w, http.StatusConflict, message)
+			return
+		}
+		for name, values := range rec.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.StatusCode)
+		if _, err := w.Write(rec.Body); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+		}
+	case r.Method == http.MethodDelete:
+		s.jobs.cancelJob(j, rec)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, rec.jobResponse)
+	}
+}
+
+// eventStream starts a Server-Sent Events response, returning nil if it
+// can't be streamed, having written the error.
+func eventStream(w http.ResponseWriter) http.Flusher {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher
+}
+
+// streamJob sends a job's events from the one after Last-Event-ID, keeping
+// the stream open until the job ends.
+func (s *server) streamJob(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher := eventStream(w)
+	if flusher == nil {
+		return
+	}
+	next, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	next = max(next, 0)
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		j.mu.Lock()
+		var pending []string
+		if next < len(j.events) {
+			pending = j.events[next:]
+		}
+		next += len(pending)
+		changed, finished := j.changed, j.resp.finished()
+		j.mu.Unlock()
+
+		for _, event := range pending {
+			if _, err := fmt.Fprint(w, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-changed:
+		}
+	}
+}
+
+// streamSharedJob follows a job another replica runs through shared
+// storage. Only its progress can be seen from here, not each file's result.
+func (s *server) streamSharedJob(w http.ResponseWriter, r *http.Request, rec *jobRecord) {
+	flusher := eventStream(w)
+	if flusher == nil {
+		return
+	}
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	var last jobProgress
+	lastStatus := ""
+	for {
+		progress := jobProgress{rec.Phase, rec.Done, rec.Total}
+		var err error
+		switch {
+		case rec.finished():
+			summary := rec.jobResponse
+			summary.Result = nil
+			data, _ := json.Marshal(summary)
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", rec.Status, data)
+		case rec.Status != lastStatus || progress != last:
+			name := "progress"
+			if rec.Status != lastStatus {
+				name = rec.Status
+			}
+			data, _ := json.Marshal(progress)
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		if rec.finished() {
+			return
+		}
+		last, lastStatus = progress, rec.Status
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if next, err := s.jobs.load(r.Context(), rec.ID); err == nil {
+			rec = next
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+)
+
+var errOverloaded = errors.New("too many parses in progress")
+
+// procLimiter caps how many parser and tool processes run at once. Callers
+// beyond the cap wait their turn, up to a bounded queue; past that they are
+// turned away, so a burst of requests can't fork-bomb the host or pile up
+// unboundedly behind the workers.
+type procLimiter struct {
+	slots    chan struct{}
+	maxQueue int32
+	queued   atomic.Int32
+}
+
+func newProcLimiter(concurrency, queue int) *procLimiter {
+	return &procLimiter{slots: make(chan struct{}, concurrency), maxQueue: int32(queue)}
+}
+
+// acquire takes a slot, waiting if need be. It returns errOverloaded at once
+// if the queue is full, or ctx's error if ctx is done first. Each successful
+// acquire must be paired with a release.
+func (l *procLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		loadShed.Inc()
+		return errOverloaded
+	}
+	defer l.queued.Add(-1)
+
+	_, sp := telemetry.StartSpan(ctx, "wait for slot")
+	defer sp.End()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *procLimiter) release() {
+	<-l.slots
+}
+
+// writeOverloaded answers 503 for work shed by the process limiter.
+func writeOverloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, "Server is busy, try again shortly")
+}
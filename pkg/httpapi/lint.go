@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// RuboCop exits 1 when it found offenses, which is not a failure here.
+const rubocopOffensesExitCode = 1
+
+type rubocopReport struct {
+	Files []struct {
+		Offenses []struct {
+			Severity    string `json:"severity"`
+			Message     string `json:"message"`
+			CopName     string `json:"cop_name"`
+			Correctable bool   `json:"correctable"`
+			Location    struct {
+				StartLine   int `json:"start_line"`
+				StartColumn int `json:"start_column"`
+				LastLine    int `json:"last_line"`
+				LastColumn  int `json:"last_column"`
+			} `json:"location"`
+		} `json:"offenses"`
+	} `json:"files"`
+}
+
+// offense is a RuboCop offense with its range converted to node location
+// form and, when the code parses, the deepest node it falls on.
+type offense struct {
+	CopName     string               `json:"cop_name"`
+	Severity    string               `json:"severity"`
+	Message     string               `json:"message"`
+	Correctable bool                 `json:"correctable"`
+	Location    analyze.Location     `json:"location"`
+	Node        *analyze.NodeSummary `json:"node"`
+}
+
+// runRubocop lints code as if it were a file of the given type, so that cops
+// scoped to Gemfiles, gemspecs and the like apply.
+func (s *server) runRubocop(ctx context.Context, code, fileType string) (*rubocopReport, error) {
+	args := []string{"--format", "json", "--stdin", analyze.FileTypeNames[fileType]}
+	if s.cfg.RubocopConfig != "" {
+		args = append(args, "--config", s.cfg.RubocopConfig)
+	}
+
+	if err := s.procs.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.procs.release()
+
+	var stderr bytes.Buffer
+	cmd := parser.NewCommand(ctx, s.cfg.RubocopBin, args...)
+	cmd.Stdin = strings.NewReader(code)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	parser.CountSpawnFailure(cmd, err)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != rubocopOffensesExitCode {
+			if stderr.Len() > 0 {
+				slog.ErrorContext(ctx, "rubocop failed", "stderr", strings.TrimSpace(stderr.String()))
+			}
+			return nil, err
+		}
+	}
+
+	var report rubocopReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+type lintRequest struct {
+	Code     string `json:"code"`
+	Parser   string `json:"parser"`
+	FileType string `json:"filetype"`
+	Filename string `json:"filename"`
+}
+
+type lintResponse struct {
+	Offenses []offense `json:"offenses"`
+}
+
+// handleLint runs RuboCop over the posted code and returns its offenses,
+// each tied to the AST node it covers so the frontend can badge it.
+func (s *server) handleLint(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req lintRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	fileType, ok := resolveFileType(w, req.FileType, req.Filename)
+	if !ok {
+		return
+	}
+	if fileType == analyze.FileTypeERB {
+		writeError(w, http.StatusBadRequest, "ERB templates can't be linted")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+	defer cancel()
+
+	report, err := s.runRubocop(ctx, req.Code, fileType)
+	if err != nil {
+		if errors.Is(err, errOverloaded) {
+			writeOverloaded(w)
+			return
+		}
+		if ctx.Err() != nil {
+			writeError(w, http.StatusGatewayTimeout, "Linting timed out")
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error running rubocop", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to execute rubocop")
+		return
+	}
+
+	// Code RuboCop can lint but the parser rejects still gets its offenses,
+	// just without nodes.
+	var root *analyze.Node
+	if output, _, err := s.parse(r.Context(), parser, req.Code); err == nil {
+		root, _ = analyze.DecodeAST(output)
+	}
+
+	offenses := []offense{}
+	for _, file := range report.Files {
+		for _, o := range file.Offenses {
+			start, ok := analyze.CharOffset(req.Code, o.Location.StartLine, o.Location.StartColumn)
+			if !ok {
+				continue
+			}
+			// last_column is inclusive; offenses past the end of the code
+			// (such as a missing final newline) are zero-width.
+			end, ok := analyze.CharOffset(req.Code, o.Location.LastLine, o.Location.LastColumn)
+			if ok {
+				end++
+			} else {
+				end = start
+			}
+
+			result := offense{
+				CopName:     o.CopName,
+				Severity:    o.Severity,
+				Message:     o.Message,
+				Correctable: o.Correctable,
+				Location:    analyze.Location{StartLine: o.Location.StartLine, StartChar: start, EndLine: o.Location.LastLine, EndChar: end},
+			}
+			if root != nil {
+				if t := analyze.DeepestNodeAt(root, start); t != nil {
+					summary := analyze.Summarize(t)
+					result.Node = &summary
+				}
+			}
+			offenses = append(offenses, result)
+		}
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, lintResponse{offenses})
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor systemd passes to a
+// socket-activated service.
+const systemdFirstFD = 3
+
+// listen opens the main listener -listen describes: a TCP address, which by
+// default is -port on all interfaces, "unix:/path/to.sock", or "systemd" for
+// a socket passed in by systemd. A socket from systemd is also used when
+// -listen is empty and one was passed.
+func listen(cfg *config) (net.Listener, error) {
+	switch {
+	case cfg.Listen == "systemd" || cfg.Listen == "" && systemdActivated():
+		return systemdListener()
+	case strings.HasPrefix(cfg.Listen, "unix:"):
+		return unixListener(strings.TrimPrefix(cfg.Listen, "unix:"), cfg.SocketMode)
+	case cfg.Listen == "":
+		return net.Listen("tcp", cfg.addr())
+	}
+	return net.Listen("tcp", strings.TrimPrefix(cfg.Listen, "tcp:"))
+}
+
+// unixListener listens on a Unix socket at path with the given permissions,
+// replacing a socket left behind by an earlier run. The socket is removed
+// again when the listener is closed.
+func unixListener(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// systemdActivated reports whether systemd passed this process any sockets,
+// following sd_listen_fds(3).
+func systemdActivated() bool {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	return err == nil && pid == os.Getpid() && os.Getenv("LISTEN_FDS") != ""
+}
+
+// systemdListener returns the first socket systemd passed in. The LISTEN_
+// variables are cleared so child processes don't think they were passed it
+// too.
+func systemdListener() (net.Listener, error) {
+	if !systemdActivated() {
+		return nil, errors.New("-listen systemd: no socket was passed in by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("-listen systemd: invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	f := os.NewFile(systemdFirstFD, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
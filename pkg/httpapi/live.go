@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// liveRequest is a code update sent over /ws. Seq is echoed back so the
+// client can tell which update a result belongs to.
+type liveRequest struct {
+	Seq    int    `json:"seq"`
+	Code   string `json:"code"`
+	Parser string `json:"parser"`
+	analyze.FormatOptions
+}
+
+type liveResponse struct {
+	Seq    int             `json:"seq"`
+	Parser string          `json:"parser,omitempty"`
+	AST    json.RawMessage `json:"ast,omitempty"`
+	*errorResponse
+}
+
+// handleWebSocket accepts code updates as the user types and pushes back a
+// parse of the latest one once updates stop arriving for LiveDebounce.
+// Updates that arrive while a parse is running replace each other, so only
+// the newest is parsed next.
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.originAllowed(r.Header.Get("Origin")) {
+		writeError(w, http.StatusForbidden, "Origin not allowed")
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r, s.cfg.MaxBodyBytes)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+
+	updates := make(chan liveRequest, 1)
+	go func() {
+		defer cancel()
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				switch {
+				case errors.Is(err, errWSMessageSize):
+					conn.closeWith(wsCloseMessageLarge)
+				case errors.Is(err, errWSProtocol):
+					conn.closeWith(wsCloseProtocol)
+				case !errors.Is(err, io.EOF):
+					slog.ErrorContext(ctx, "Error reading websocket message", "err", err)
+				}
+				return
+			}
+
+			var req liveRequest
+			if err := json.Unmarshal(msg, &req); err != nil {
+				s.sendLive(conn, liveResponse{errorResponse: &errorResponse{Error: "Invalid message"}})
+				continue
+			}
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- req
+		}
+	}()
+
+	var pending *liveRequest
+	debounce := time.NewTimer(s.cfg.LiveDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.closeWith(wsCloseNormal)
+			return
+		case req := <-updates:
+			pending = &req
+			debounce.Reset(s.cfg.LiveDebounce)
+		case <-debounce.C:
+			if pending != nil {
+				s.sendLive(conn, s.parseLive(ctx, *pending))
+				pending = nil
+			}
+		}
+	}
+}
+
+func (s *server) parseLive(ctx context.Context, req liveRequest) liveResponse {
+	if req.Parser == "" {
+		req.Parser = parser.DefaultParser
+	}
+	p, ok := s.parsers[req.Parser]
+	if !ok {
+		return liveResponse{Seq: req.Seq, errorResponse: &errorResponse{Error: "Unknown parser"}}
+	}
+	if msg := formatOptionsError(analyze.DefaultFormat, req.FormatOptions); msg != "" {
+		return liveResponse{Seq: req.Seq, errorResponse: &errorResponse{Error: msg}}
+	}
+
+	output, _, err := s.parse(ctx, p, req.Code)
+	if err != nil {
+		if ctx.Err() != nil {
+			return liveResponse{Seq: req.Seq}
+		}
+		_, resp := parseErrorResponse(ctx, p, err)
+		return liveResponse{Seq: req.Seq, Parser: p.Name(), errorResponse: &resp}
+	}
+	req.Source = req.Code
+	_, ast, err := analyze.RenderFormat(analyze.DefaultFormat, output, req.FormatOptions)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+		return liveResponse{Seq: req.Seq, Parser: p.Name(), errorResponse: &errorResponse{Error: "Failed to render json output"}}
+	}
+	return liveResponse{Seq: req.Seq, Parser: p.Name(), AST: ast}
+}
+
+func (s *server) sendLive(conn *wsConn, resp liveResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error("Error encoding websocket message", "err", err)
+		return
+	}
+	if err := conn.writeText(msg); err != nil {
+		slog.Error("Error writing websocket message", "err", err)
+	}
+}
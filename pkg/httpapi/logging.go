@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// newLogger returns the process logger, writing to stderr as JSON or, for
+// format "text", as key=value pairs. Records logged with a request's context
+// carry its ID.
+func newLogger(format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if format == "text" {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	return slog.New(requestIDHandler{h})
+}
+
+// fatal logs an error and exits, like log.Fatal.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	if sp := telemetry.SpanFrom(ctx); sp != nil {
+		r.AddAttrs(slog.String("trace_id", sp.TraceID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestInfo collects what the access log reports about a request beyond
+// what the middleware can see itself. Handlers fill it in through the
+// request context; batch parses do so concurrently.
+type requestInfo struct {
+	id string
+
+	mu        sync.Mutex
+	apiKey    string
+	parser    string
+	codeBytes int
+	parseTime time.Duration
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// recordParse notes a parse done for the request in ctx, if any.
+func recordParse(ctx context.Context, p parser.Parser, code string, elapsed time.Duration) {
+	info := requestInfoFrom(ctx)
+	if info == nil {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.parser = p.Name()
+	info.codeBytes += len(code)
+	info.parseTime += elapsed
+}
+
+// newRequestID returns a random ID, or the client's own X-Request-ID if it
+// sent a reasonable one, so IDs can be followed across a proxy.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 && validRequestID(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder captures the status and size of a response. It passes
+// through Flush for /watch and Hijack for /ws.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests gives every request an ID, returned in X-Request-ID, and logs
+// one line per request once it completes. It also keeps the HTTP metrics,
+// labelled by route(r).
+func logRequests(next http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestsInFlight.Add(1)
+		defer requestsInFlight.Add(-1)
+		info := &requestInfo{id: newRequestID(r)}
+		w.Header().Set("X-Request-ID", info.id)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		elapsed := time.Since(start)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		name := route(r)
+		requestsTotal.Inc(name, strconv.Itoa(status))
+		requestDuration.Observe(elapsed.Seconds(), name)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", info.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", name),
+			slog.Int("status", status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("duration_ms", milliseconds(elapsed)),
+		}
+		info.mu.Lock()
+		if info.apiKey != "" {
+			attrs = append(attrs, slog.String("api_key", info.apiKey))
+		}
+		if info.parser != "" {
+			attrs = append(attrs,
+				slog.String("parser", info.parser),
+				slog.Int("code_bytes", info.codeBytes),
+				slog.Float64("parse_duration_ms", milliseconds(info.parseTime)),
+			)
+		}
+		info.mu.Unlock()
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		// Logged without the request context, which would add the ID twice.
+		slog.LogAttrs(context.Background(), level, "request", attrs...)
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,496 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// JSON-RPC and LSP error codes.
+const (
+	lspParseError           = -32700
+	lspInvalidRequest       = -32600
+	lspMethodNotFound       = -32601
+	lspInvalidParams        = -32602
+	lspServerNotInitialized = -32002
+	lspRequestFailed        = -32803
+)
+
+// LSP symbol kinds.
+const (
+	lspSymbolModule   = 2
+	lspSymbolClass    = 5
+	lspSymbolMethod   = 6
+	lspSymbolConstant = 14
+)
+
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+type lspFoldingRange struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+type lspSelectionRange struct {
+	Range  lspRange           `json:"range"`
+	Parent *lspSelectionRange `json:"parent,omitempty"`
+}
+
+// lspDocument is an open file's text, with where each line starts so
+// positions can be converted to and from the character offsets in node
+// locations.
+type lspDocument struct {
+	text       string
+	chars      []rune
+	lineStarts []int
+}
+
+func newLSPDocument(text string) *lspDocument {
+	doc := &lspDocument{text: text, chars: []rune(text), lineStarts: []int{0}}
+	for i, r := range doc.chars {
+		if r == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+	return doc
+}
+
+// lspSession is one client's connection: its open documents and what was
+// agreed on in initialize.
+type lspSession struct {
+	s           *server
+	out         io.Writer
+	parser      parser.Parser
+	encoding    string
+	docs        map[string]*lspDocument
+	initialized bool
+	shutdown    bool
+}
+
+// serveLSP speaks the Language Server Protocol over r and w, one message at
+// a time, until the client exits or closes the connection. It offers
+// document symbols, selection and folding ranges, and a ruby-ast/tree
+// request that returns a document's tree as /parse does.
+func (s *server) serveLSP(ctx context.Context, r io.Reader, w io.Writer) error {
+	sess := &lspSession{s: s, out: w, encoding: "utf-16", docs: make(map[string]*lspDocument)}
+	sess.parser = s.parsers[parser.DefaultParser]
+	in := bufio.NewReader(r)
+	// Document text is escaped in JSON, which can double its size.
+	limit := 2 * s.cfg.MaxBodyBytes
+	for {
+		body, err := readLSPMessage(in, limit)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := sess.reply(json.RawMessage("null"), nil, &lspError{Code: lspParseError, Message: "Invalid JSON"}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := sess.handle(ctx, msg)
+		if msg.ID == nil {
+			if err != nil {
+				slog.DebugContext(ctx, "Error handling LSP notification", "method", msg.Method, "err", err)
+			}
+			continue
+		}
+		if err := sess.reply(*msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// serveLSPListener serves an LSP session on each connection to ln.
+func (s *server) serveLSPListener(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serveLSP(ctx, conn, conn); err != nil {
+				slog.Warn("LSP connection failed", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+		}()
+	}
+}
+
+func readLSPMessage(r *bufio.Reader, limit int64) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("LSP message has no Content-Length")
+	}
+	if int64(length) > limit {
+		return nil, fmt.Errorf("LSP message of %d bytes exceeds %d", length, limit)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (sess *lspSession) reply(id json.RawMessage, result interface{}, err error) error {
+	resp := lspResponse{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var lspErr *lspError
+		if !errors.As(err, &lspErr) {
+			lspErr = &lspError{Code: lspRequestFailed, Message: err.Error()}
+		}
+		resp.Error = lspErr
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		return err
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(sess.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (sess *lspSession) handle(ctx context.Context, msg lspMessage) (interface{}, error) {
+	if msg.Method == "initialize" {
+		return sess.initialize(msg.Params)
+	}
+	if !sess.initialized {
+		return nil, &lspError{Code: lspServerNotInitialized, Message: "initialize must come first"}
+	}
+	if sess.shutdown {
+		return nil, &lspError{Code: lspInvalidRequest, Message: "The server is shutting down"}
+	}
+
+	var params struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Positions []lspPosition `json:"positions"`
+		analyze.FormatOptions
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: "Invalid params"}
+		}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		sess.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		sess.docs[uri] = newLSPDocument(params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		// Changes come whole, as textDocumentSync asks, so the last wins.
+		if n := len(params.ContentChanges); n > 0 {
+			sess.docs[uri] = newLSPDocument(params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		delete(sess.docs, uri)
+		return nil, nil
+	case "textDocument/documentSymbol":
+		doc, root, err := sess.tree(ctx, uri)
+		if err != nil {
+			return nil, sess.treeError(ctx, err)
+		}
+		return sess.symbols(doc, analyze.FindSymbols(root)), nil
+	case "textDocument/foldingRange":
+		_, root, err := sess.tree(ctx, uri)
+		if err != nil {
+			return nil, sess.treeError(ctx, err)
+		}
+		ranges := []lspFoldingRange{}
+		for _, loc := range analyze.FoldableLocations(root) {
+			// Stop short of the closing end or brace, so it stays in view.
+			if end := loc.EndLine - 2; end > loc.StartLine-1 {
+				ranges = append(ranges, lspFoldingRange{StartLine: loc.StartLine - 1, EndLine: end})
+			}
+		}
+		return ranges, nil
+	case "textDocument/selectionRange":
+		doc, root, err := sess.tree(ctx, uri)
+		if err != nil {
+			return nil, sess.treeError(ctx, err)
+		}
+		ranges := make([]*lspSelectionRange, len(params.Positions))
+		for i, pos := range params.Positions {
+			locs := analyze.EnclosingLocations(root, sess.offset(doc, pos))
+			var sel *lspSelectionRange
+			for j := len(locs) - 1; j >= 0; j-- {
+				sel = &lspSelectionRange{Range: sess.lspRange(doc, locs[j]), Parent: sel}
+			}
+			if sel == nil {
+				sel = &lspSelectionRange{Range: lspRange{pos, pos}}
+			}
+			ranges[i] = sel
+		}
+		return ranges, nil
+	case "ruby-ast/tree":
+		doc, ok := sess.docs[uri]
+		if !ok {
+			return nil, &lspError{Code: lspInvalidParams, Message: "Document is not open"}
+		}
+		if params.Filter != "" {
+			if _, err := analyze.CompileJSONPath(params.Filter); err != nil {
+				return nil, &lspError{Code: lspInvalidParams, Message: "Invalid filter: " + err.Error()}
+			}
+		}
+		if !analyze.KnownLayout(params.Layout) {
+			return nil, &lspError{Code: lspInvalidParams, Message: "Unknown layout"}
+		}
+		if !analyze.KnownHeredocs(params.Heredocs) {
+			return nil, &lspError{Code: lspInvalidParams, Message: "Unknown heredoc layout"}
+		}
+		output, _, err := sess.s.parse(ctx, sess.parser, doc.text)
+		if err != nil {
+			return nil, sess.failure(ctx, err)
+		}
+		params.Source = doc.text
+		_, body, err := analyze.RenderFormat(analyze.DefaultFormat, output, params.FormatOptions)
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(body), nil
+	}
+	if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+		return nil, &lspError{Code: lspMethodNotFound, Message: "Unknown method " + msg.Method}
+	}
+	return nil, nil
+}
+
+// initialize picks the position encoding, the first of the client's that's
+// supported, and the parser, which initializationOptions can name.
+func (sess *lspSession) initialize(raw json.RawMessage) (interface{}, error) {
+	var params struct {
+		Capabilities struct {
+			General struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+		InitializationOptions struct {
+			Parser string `json:"parser"`
+		} `json:"initializationOptions"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &lspError{Code: lspInvalidParams, Message: "Invalid params"}
+	}
+	if name := params.InitializationOptions.Parser; name != "" {
+		p, ok := sess.s.parsers[name]
+		if !ok {
+			return nil, &lspError{Code: lspInvalidParams, Message: "Unknown parser " + name}
+		}
+		sess.parser = p
+	}
+	for _, encoding := range params.Capabilities.General.PositionEncodings {
+		if encoding == "utf-8" || encoding == "utf-16" || encoding == "utf-32" {
+			sess.encoding = encoding
+			break
+		}
+	}
+	sess.initialized = true
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"positionEncoding":       sess.encoding,
+			"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1},
+			"documentSymbolProvider": true,
+			"foldingRangeProvider":   true,
+			"selectionRangeProvider": true,
+			"experimental":           map[string]interface{}{"rubyAstTree": true},
+		},
+		"serverInfo": map[string]string{"name": "ruby-ast-visualizer", "version": version},
+	}, nil
+}
+
+// tree parses an open document.
+func (sess *lspSession) tree(ctx context.Context, uri string) (*lspDocument, *analyze.Node, error) {
+	doc, ok := sess.docs[uri]
+	if !ok {
+		return nil, nil, &lspError{Code: lspInvalidParams, Message: "Document is not open"}
+	}
+	output, _, err := sess.s.parse(ctx, sess.parser, doc.text)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := analyze.DecodeAST(output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, root, nil
+}
+
+// failure turns a failed parse into an error response, with the body
+// /parse would have given as its data.
+func (sess *lspSession) failure(ctx context.Context, err error) error {
+	var lspErr *lspError
+	if errors.As(err, &lspErr) {
+		return err
+	}
+	_, resp := parseErrorResponse(ctx, sess.parser, err)
+	return &lspError{Code: lspRequestFailed, Message: resp.Error, Data: resp}
+}
+
+// treeError is failure for the requests that need a tree, except that a
+// syntax error answers null: code often won't parse mid-edit, and editors
+// would show every failure.
+func (sess *lspSession) treeError(ctx context.Context, err error) error {
+	if parser.IsSyntaxError(err) {
+		return nil
+	}
+	return sess.failure(ctx, err)
+}
+
+func (sess *lspSession) symbols(doc *lspDocument, symbols []*analyze.Symbol) []lspDocumentSymbol {
+	kinds := map[string]int{
+		"class": lspSymbolClass, "module": lspSymbolModule, "constant": lspSymbolConstant,
+		"method": lspSymbolMethod, "singleton_method": lspSymbolMethod,
+	}
+	converted := []lspDocumentSymbol{}
+	for _, sym := range symbols {
+		if sym.Location == nil {
+			continue
+		}
+		r := sess.lspRange(doc, *sym.Location)
+		converted = append(converted, lspDocumentSymbol{
+			Name:           sym.Name,
+			Detail:         sym.QualifiedName,
+			Kind:           kinds[sym.Kind],
+			Range:          r,
+			SelectionRange: r,
+			Children:       sess.symbols(doc, sym.Children),
+		})
+	}
+	return converted
+}
+
+func (sess *lspSession) lspRange(doc *lspDocument, loc analyze.Location) lspRange {
+	return lspRange{sess.position(doc, loc.StartChar), sess.position(doc, loc.EndChar)}
+}
+
+// position converts a character offset into a line and a column counted in
+// the session's encoding.
+func (sess *lspSession) position(doc *lspDocument, offset int) lspPosition {
+	offset = min(max(offset, 0), len(doc.chars))
+	line := sort.SearchInts(doc.lineStarts, offset+1) - 1
+	column := 0
+	for _, r := range doc.chars[doc.lineStarts[line]:offset] {
+		column += sess.units(r)
+	}
+	return lspPosition{Line: line, Character: column}
+}
+
+// offset converts a position back to a character offset, clamping it to
+// its line as the protocol asks.
+func (sess *lspSession) offset(doc *lspDocument, pos lspPosition) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(doc.lineStarts) {
+		return len(doc.chars)
+	}
+	offset := doc.lineStarts[pos.Line]
+	for column := 0; offset < len(doc.chars) && doc.chars[offset] != '\n'; offset++ {
+		if column += sess.units(doc.chars[offset]); column > pos.Character {
+			break
+		}
+	}
+	return offset
+}
+
+func (sess *lspSession) units(r rune) int {
+	switch sess.encoding {
+	case "utf-8":
+		return utf8.RuneLen(r)
+	case "utf-32":
+		return 1
+	}
+	return utf16.RuneLen(r)
+}
@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+var (
+	requestsTotal = telemetry.NewCounterVec("ruby_ast_http_requests_total",
+		"HTTP requests by route and status code.", "route", "code")
+	requestDuration = telemetry.NewHistogramVec("ruby_ast_http_request_duration_seconds",
+		"Time to serve HTTP requests, by route.", telemetry.DurationBuckets, "route")
+	requestsInFlight = telemetry.NewGauge("ruby_ast_http_requests_in_flight",
+		"HTTP requests currently being served.")
+	parsesTotal = telemetry.NewCounterVec("ruby_ast_parses_total",
+		"Backend runs by parser and result: ok, syntax_error, timeout, canceled or error.", "parser", "result")
+	parseDuration = telemetry.NewHistogramVec("ruby_ast_parse_duration_seconds",
+		"Time spent in parser backends, by parser.", telemetry.DurationBuckets, "parser")
+	cacheLookups = telemetry.NewCounterVec("ruby_ast_cache_lookups_total",
+		"Parse cache lookups by result: hit or miss.", "result")
+	loadShed = telemetry.NewCounterVec("ruby_ast_load_shed_total",
+		"Parses refused with 503 because the queue for a process slot was full.")
+	rateLimited = telemetry.NewCounterVec("ruby_ast_rate_limited_total",
+		"Requests refused with 429 by the per-IP rate limit.")
+	apiKeyRequests = telemetry.NewCounterVec("ruby_ast_api_key_requests_total",
+		"Authenticated requests by API key name.", "key")
+	apiKeyRateLimited = telemetry.NewCounterVec("ruby_ast_api_key_rate_limited_total",
+		"Requests refused with 429 by an API key's rate limit, by key name.", "key")
+	circuitTrips = telemetry.NewCounterVec("ruby_ast_circuit_trips_total",
+		"Times a backend's circuit breaker opened after repeated failures.", "parser")
+	parseFallbacks = telemetry.NewCounterVec("ruby_ast_parse_fallbacks_total",
+		"Parses handed from a failing backend to the next in the fallback chain.", "from", "to")
+)
+
+// registerMetrics publishes how busy the process limiter is at scrape time.
+func (l *procLimiter) registerMetrics() {
+	telemetry.Register(&telemetry.GaugeFunc{
+		Name: "ruby_ast_processes_running",
+		Help: "Parser and tool processes holding a slot.",
+		Fn:   func() float64 { return float64(len(l.slots)) },
+	})
+	telemetry.Register(&telemetry.GaugeFunc{
+		Name: "ruby_ast_processes_queued",
+		Help: "Parses waiting for a process slot.",
+		Fn:   func() float64 { return float64(l.queued.Load()) },
+	})
+}
+
+// parseResult classifies a backend error for ruby_ast_parses_total.
+func parseResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case parser.IsSyntaxError(err):
+		return "syntax_error"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
+
+// handleMetrics serves every registered metric for Prometheus to scrape.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := telemetry.WriteMetrics(w); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,307 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// githubURL is where users sign in to GitHub, and githubAPIURL where who
+// they are is looked up.
+var (
+	githubURL    = "https://github.com"
+	githubAPIURL = "https://api.github.com"
+)
+
+// oauthStateCookie holds the state a sign-in was started with, so the
+// callback only finishes sign-ins this browser started.
+const oauthStateCookie = "ruby_ast_oauth_state"
+
+// oauthStateTTL is how long a user has to approve the app on GitHub.
+const oauthStateTTL = 10 * time.Minute
+
+// githubLogin is a session's GitHub sign-in. It is kept in storage sealed
+// with the session's ID, so it can't be read back as any other session's.
+type githubLogin struct {
+	Token string `json:"token"`
+	Login string `json:"login"`
+	Scope string `json:"scope"`
+}
+
+type githubAuthStatus struct {
+	Enabled  bool   `json:"enabled"`
+	SignedIn bool   `json:"signed_in"`
+	Login    string `json:"login,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+func githubLoginKey(session string) string {
+	return "github-logins/" + session
+}
+
+// newTokenCipher returns the AEAD GitHub sign-ins are sealed with, keyed by
+// -github-token-key or, without one, a random key.
+func newTokenCipher(cfg *config) (cipher.AEAD, error) {
+	key, err := hex.DecodeString(cfg.GitHubTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// saveGitHubLogin stores login for session, for -github-login-ttl. A nil
+// login signs the session out.
+func (s *server) saveGitHubLogin(ctx context.Context, session string, login *githubLogin) error {
+	var sealed []byte
+	if login != nil {
+		data, err := json.Marshal(login)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, s.tokens.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed = s.tokens.Seal(nonce, nonce, data, []byte(session))
+	}
+	return s.logins.put(ctx, githubLoginKey(session), sealed, s.cfg.GitHubLoginTTL)
+}
+
+// loadGitHubLogin returns session's sign-in, or nil if it has none. One
+// sealed under another key, before a restart without -github-token-key,
+// counts as none.
+func (s *server) loadGitHubLogin(ctx context.Context, session string) (*githubLogin, error) {
+	sealed, err := s.logins.get(ctx, githubLoginKey(session))
+	if errors.Is(err, errNotStored) || (err == nil && len(sealed) < s.tokens.NonceSize()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed := sealed[:s.tokens.NonceSize()], sealed[s.tokens.NonceSize():]
+	data, err := s.tokens.Open(nil, nonce, sealed, []byte(session))
+	if err != nil {
+		return nil, nil
+	}
+	var login githubLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// githubToken returns the token of the GitHub user the request's session
+// is signed in as, or "" if it isn't. Failing to look it up is logged and
+// the request goes on as anyone's would.
+func (s *server) githubToken(r *http.Request) string {
+	if s.cfg.GitHubClientID == "" {
+		return ""
+	}
+	session, ok := requestSession(r)
+	if !ok {
+		return ""
+	}
+	login, err := s.loadGitHubLogin(r.Context(), session)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error loading GitHub sign-in", "err", err)
+		return ""
+	}
+	if login == nil {
+		return ""
+	}
+	return login.Token
+}
+
+// handleGitHubLogin sends the browser to GitHub to approve the OAuth app,
+// asking for the repo scope so private repositories can be read.
+func (s *server) handleGitHubLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if s.cfg.GitHubClientID == "" {
+		writeError(w, http.StatusNotFound, "GitHub sign-in is not configured")
+		return
+	}
+	// Everyone holding an API key shares its session, so a sign-in there
+	// would be theirs too.
+	if apiKeyFrom(r.Context()) != nil {
+		writeError(w, http.StatusBadRequest, "GitHub sign-in is not available with an API key")
+		return
+	}
+
+	var b [16]byte
+	rand.Read(b[:])
+	state := hex.EncodeToString(b[:])
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{"client_id": {s.cfg.GitHubClientID}, "scope": {"repo"}, "state": {state}}
+	http.Redirect(w, r, githubURL+"/login/oauth/authorize?"+query.Encode(), http.StatusFound)
+}
+
+// handleGitHubCallback is where GitHub sends the browser back: it trades
+// the code for a token, stores it under a new session and returns to the
+// visualizer. The client's history moves to the new session with it; the
+// old one, which could have been planted, gets nothing.
+func (s *server) handleGitHubCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if s.cfg.GitHubClientID == "" {
+		writeError(w, http.StatusNotFound, "GitHub sign-in is not configured")
+		return
+	}
+	if apiKeyFrom(r.Context()) != nil {
+		writeError(w, http.StatusBadRequest, "GitHub sign-in is not available with an API key")
+		return
+	}
+
+	query := r.URL.Query()
+	c, err := r.Cookie(oauthStateCookie)
+	if err != nil || query.Get("state") == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(query.Get("state"))) != 1 {
+		writeError(w, http.StatusBadRequest, "Invalid or expired sign-in, start again from /auth/github/login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+	if query.Get("error") != "" {
+		writeError(w, http.StatusForbidden, "GitHub sign-in was not approved")
+		return
+	}
+
+	login, err := s.exchangeGitHubCode(r.Context(), query.Get("code"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error signing in with GitHub", "err", err)
+		writeError(w, http.StatusBadGateway, "Failed to sign in with GitHub")
+		return
+	}
+	// The cookie lasts as long as the sign-in, or as the history it carries
+	// when that is kept longer.
+	old, hadSession := requestSession(r)
+	session := s.newSession(w, r, max(s.cfg.GitHubLoginTTL, s.cfg.HistoryTTL))
+	if err := s.saveGitHubLogin(r.Context(), session, login); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving GitHub sign-in", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to save sign-in")
+		return
+	}
+	if hadSession && s.cfg.History > 0 {
+		s.moveHistory(r.Context(), old, session)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// exchangeGitHubCode trades an OAuth code for a token, and looks up the
+// login it belongs to.
+func (s *server) exchangeGitHubCode(ctx context.Context, code string) (*githubLogin, error) {
+	client := &http.Client{Timeout: s.cfg.Timeout}
+	form := url.Values{"client_id": {s.cfg.GitHubClientID}, "client_secret": {s.cfg.GitHubClientSecret}, "code": {code}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, githubURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+		Error       string `json:"error"`
+	}
+	if err := doGitHubRequest(client, req, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("no token: %s", token.Error)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, githubAPIURL+"/user", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := doGitHubRequest(client, req, &user); err != nil {
+		return nil, err
+	}
+	return &githubLogin{Token: token.AccessToken, Login: user.Login, Scope: token.Scope}, nil
+}
+
+func doGitHubRequest(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// handleGitHubAuth reports whether the session is signed in to GitHub, or
+// with DELETE signs it out.
+func (s *server) handleGitHubAuth(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	if s.cfg.GitHubClientID == "" {
+		writeJSON(w, githubAuthStatus{})
+		return
+	}
+
+	session, ok := requestSession(r)
+	if r.Method == http.MethodDelete {
+		if ok {
+			if err := s.saveGitHubLogin(r.Context(), session, nil); err != nil {
+				slog.ErrorContext(r.Context(), "Error clearing GitHub sign-in", "err", err)
+				writeError(w, http.StatusInternalServerError, "Failed to sign out")
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	status := githubAuthStatus{Enabled: true}
+	if ok {
+		login, err := s.loadGitHubLogin(r.Context(), session)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading GitHub sign-in", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to load sign-in")
+			return
+		}
+		if login != nil {
+			status.SignedIn, status.Login, status.Scope = true, login.Login, login.Scope
+		}
+	}
+	writeJSON(w, status)
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type partialRequest struct {
+	Code string `json:"code"`
+	analyze.FormatOptions
+}
+
+// diagnostic is an error or warning prism reported. Location is in the
+// same [start_line, start_char, end_line, end_char] form as the tree's.
+type diagnostic struct {
+	Level    string `json:"level"`
+	Message  string `json:"message"`
+	Location [4]int `json:"location"`
+}
+
+// partialResponse is the tree prism recovered from the code, rendered as
+// /parse would, with Partial set if the code had errors.
+type partialResponse struct {
+	AST         json.RawMessage `json:"ast"`
+	Partial     bool            `json:"partial"`
+	Diagnostics []diagnostic    `json:"diagnostics"`
+}
+
+// handlePartial parses code that may have syntax errors, returning what
+// prism could make of it along with its diagnostics, so a tree can still be
+// shown while the code is mid-edit.
+func (s *server) handlePartial(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req partialRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if !checkFormatOptions(w, analyze.DefaultFormat, req.FormatOptions) {
+		return
+	}
+
+	output, _, err := s.parse(r.Context(), s.partial, req.Code)
+	if err != nil {
+		writeParseError(w, r, s.partial, err)
+		return
+	}
+	var result struct {
+		AST         json.RawMessage `json:"ast"`
+		Diagnostics []diagnostic    `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		slog.ErrorContext(r.Context(), "Error decoding partial parse", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to decode AST")
+		return
+	}
+
+	req.Source = req.Code
+	_, ast, err := analyze.RenderFormat(analyze.DefaultFormat, result.AST, req.FormatOptions)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render json output")
+		return
+	}
+	resp := partialResponse{AST: ast, Diagnostics: result.Diagnostics}
+	if resp.Diagnostics == nil {
+		resp.Diagnostics = []diagnostic{}
+	}
+	for _, d := range resp.Diagnostics {
+		if d.Level == "error" {
+			resp.Partial = true
+		}
+	}
+	w.Header().Set("X-Parser", "prism")
+	writeJSON(w, resp)
+}
@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type nodeAtRequest struct {
+	Code   string `json:"code"`
+	Parser string `json:"parser"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type nodeAtResponse struct {
+	Path      string                `json:"path"`
+	Node      *analyze.Node         `json:"node"`
+	Ancestors []analyze.NodeSummary `json:"ancestors"`
+}
+
+// handleNodeAt returns the deepest node covering a 1-based line and column,
+// along with its ancestors from the root down.
+func (s *server) handleNodeAt(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req nodeAtRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	offset, ok := analyze.CharOffset(req.Code, req.Line, req.Column)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Position is outside the code")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	found := analyze.DeepestNodeAt(root, offset)
+	if found == nil {
+		writeError(w, http.StatusNotFound, "No node at that position")
+		return
+	}
+
+	ancestors := []analyze.NodeSummary{}
+	for t := found.Parent; t != nil; t = t.Parent {
+		ancestors = append([]analyze.NodeSummary{analyze.Summarize(t)}, ancestors...)
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, nodeAtResponse{found.Path, found.Node, ancestors})
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// rubyFallbacks are where version managers and Homebrew put Ruby, for when
+// the server is started without their shims on PATH, as under systemd or
+// launchd. Paths starting with ~ are relative to the home directory.
+var rubyFallbacks = []string{
+	"~/.rbenv/shims/ruby",
+	"~/.asdf/shims/ruby",
+	"~/.rvm/rubies/default/bin/ruby",
+	"~/.local/share/mise/shims/ruby",
+	"/opt/homebrew/opt/ruby/bin/ruby",
+	"/usr/local/opt/ruby/bin/ruby",
+}
+
+// findRuby resolves -ruby-bin to an executable. A bare name is looked up on
+// PATH and, if it is the default "ruby", in rubyFallbacks.
+func findRuby(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil || name != "ruby" {
+		return path, err
+	}
+
+	home, _ := os.UserHomeDir()
+	candidates := rubyFallbacks
+	if root := os.Getenv("RBENV_ROOT"); root != "" {
+		candidates = append([]string{filepath.Join(root, "shims", "ruby")}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if rest, ok := strings.CutPrefix(candidate, "~/"); ok {
+			if home == "" {
+				continue
+			}
+			candidate = filepath.Join(home, rest)
+		}
+		if path, err := exec.LookPath(candidate); err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+// preflight checks that the parsers can run before the server starts taking
+// requests, and logs what to do about it if they can't. On success
+// cfg.RubyBin is replaced by the resolved path.
+func preflight(cfg *config) error {
+	ruby, err := findRuby(cfg.RubyBin)
+	if err != nil {
+		return fmt.Errorf("cannot find Ruby (-ruby-bin %s): %w; install Ruby or point -ruby-bin or %sRUBY_BIN at it", cfg.RubyBin, err, envPrefix)
+	}
+	cfg.RubyBin = ruby
+
+	check := &toolchainCheck{rubyBin: ruby}
+	version, err := check.run(context.Background())
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s cannot load syntax_tree (%v); run `%s -S gem install syntax_tree`", ruby, err, ruby)
+		}
+		return fmt.Errorf("cannot run %s: %w", ruby, err)
+	}
+	slog.Info("Found parser toolchain", "ruby", ruby, "syntax_tree", version)
+
+	// Graphviz and RuboCop only back /render and /lint, so are optional.
+	if _, err := exec.LookPath(cfg.DotBin); err != nil {
+		slog.Warn("Graphviz not found; /render and /export/png will fail", "dot_bin", cfg.DotBin)
+	}
+	if _, err := exec.LookPath(cfg.RubocopBin); err != nil {
+		slog.Warn("RuboCop not found; /lint will fail", "rubocop_bin", cfg.RubocopBin)
+	}
+	return nil
+}
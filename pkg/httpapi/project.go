@@ -0,0 +1,343 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+const (
+	maxProjectFiles     = 5000
+	maxProjectFileBytes = 4 << 20
+)
+
+var errProjectTooLarge = errors.New("project exceeds size limits")
+
+type projectFile struct {
+	Path        string               `json:"path"`
+	ParseID     string               `json:"parse_id,omitempty"`
+	Lines       int                  `json:"lines"`
+	Nodes       int                  `json:"nodes"`
+	Definitions []analyze.Definition `json:"definitions,omitempty"`
+	Error       *errorResponse       `json:"error,omitempty"`
+}
+
+type projectStats struct {
+	Files   int `json:"files"`
+	Parsed  int `json:"parsed"`
+	Failed  int `json:"failed"`
+	Lines   int `json:"lines"`
+	Nodes   int `json:"nodes"`
+	Classes int `json:"classes"`
+	Modules int `json:"modules"`
+}
+
+type projectResult struct {
+	Files []projectFile `json:"files"`
+	Stats projectStats  `json:"stats"`
+}
+
+func countNodes(root *analyze.Node) int {
+	count := 0
+	analyze.Walk(root, func(*analyze.Node, int) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func countLines(code []byte) int {
+	lines := bytes.Count(code, []byte("\n"))
+	if len(code) > 0 && code[len(code)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// findRubyFiles lists the Ruby files under dir, relative to it and sorted:
+// .rb files, DSL files such as Gemfile and *.gemspec, and ERB templates.
+func findRubyFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() && analyze.IsRubySource(path) {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func readProjectFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxProjectFileBytes {
+		return nil, errProjectTooLarge
+	}
+	return os.ReadFile(path)
+}
+
+// projectObserver follows a project parse: started is called once the files
+// to parse are known, and parsed as each one is done.
+type projectObserver interface {
+	started(total int)
+	parsed(file projectFile)
+}
+
+// parseProject parses every Ruby file under dir, at most cfg.Workers at a
+// time. Each parse lands in the cache, so the returned parse IDs can be used
+// with /subtree and /render to open individual files. obs may be nil.
+func (s *server) parseProject(ctx context.Context, p parser.Parser, dir string, obs projectObserver) (*projectResult, error) {
+	paths, err := findRubyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > maxProjectFiles {
+		return nil, errProjectTooLarge
+	}
+	if obs != nil {
+		obs.started(len(paths))
+	}
+
+	files := make([]projectFile, len(paths))
+	sem := make(chan struct{}, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			file := projectFile{Path: path}
+			defer func() {
+				files[i] = file
+				if obs != nil && ctx.Err() == nil {
+					obs.parsed(file)
+				}
+			}()
+
+			code, err := readProjectFile(filepath.Join(dir, filepath.FromSlash(path)))
+			if errors.Is(err, errProjectTooLarge) {
+				file.Error = &errorResponse{Error: "File is too large"}
+				return
+			}
+			if err != nil {
+				file.Error = &errorResponse{Error: "Failed to read file"}
+				return
+			}
+			file.Lines = countLines(code)
+			source, _, err := analyze.DecodeSource(code, "")
+			if err != nil {
+				resp := encodingErrorResponse("File is not valid UTF-8", err)
+				file.Error = &resp
+				return
+			}
+			if analyze.DetectFileType(path) == analyze.FileTypeERB {
+				if source, err = analyze.CompileERB(source); err != nil {
+					_, resp := parseErrorResponse(ctx, p, err)
+					file.Error = &resp
+					return
+				}
+			}
+			output, _, err := s.parse(ctx, p, source)
+			if err != nil {
+				_, resp := parseErrorResponse(ctx, p, err)
+				file.Error = &resp
+				return
+			}
+			file.ParseID = parseID(p, source)
+			if root, err := analyze.DecodeAST(output); err == nil {
+				file.Nodes = countNodes(root)
+				file.Definitions = analyze.FindDefinitions(root)
+			}
+		}(i, path)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &projectResult{Files: files}
+	for _, f := range files {
+		result.Stats.Files++
+		result.Stats.Lines += f.Lines
+		result.Stats.Nodes += f.Nodes
+		for _, def := range f.Definitions {
+			if def.Kind == "class" {
+				result.Stats.Classes++
+			} else {
+				result.Stats.Modules++
+			}
+		}
+		if f.Error != nil {
+			result.Stats.Failed++
+		} else {
+			result.Stats.Parsed++
+		}
+	}
+	return result, nil
+}
+
+// extractZip unpacks the Ruby files in an archive into dir. Entries that
+// would escape dir, symlinks and other non-Ruby files are skipped, and the
+// total size written is capped at limit bytes.
+func extractZip(archive *zip.Reader, dir string, limit int64) error {
+	written := int64(0)
+	count := 0
+	for _, f := range archive.File {
+		name := filepath.FromSlash(f.Name)
+		if !f.Mode().IsRegular() || !analyze.IsRubySource(name) || !filepath.IsLocal(name) {
+			continue
+		}
+		if count++; count > maxProjectFiles {
+			return errProjectTooLarge
+		}
+		if f.UncompressedSize64 > maxProjectFileBytes || written+int64(f.UncompressedSize64) > limit {
+			return errProjectTooLarge
+		}
+
+		dest := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+			return err
+		}
+		n, err := extractFile(f, dest, limit-written)
+		written += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, dest string, remaining int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	// The sizes in the zip header can lie, so enforce the limit on the
+	// bytes actually decompressed as well.
+	n, err := io.Copy(out, io.LimitReader(rc, remaining+1))
+	if err == nil && n > remaining {
+		err = errProjectTooLarge
+	}
+	return n, err
+}
+
+// handleProject accepts a zip of Ruby files as the "project" field of a
+// multipart upload, extracts it to a private temporary directory and parses
+// every Ruby file in it.
+func (s *server) handleProject(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	dir, ok := s.extractUpload(w, r)
+	if !ok {
+		return
+	}
+	defer os.RemoveAll(dir)
+	parser, ok := s.lookupParser(w, r.FormValue("parser"))
+	if !ok {
+		return
+	}
+
+	s.writeProject(w, r, parser, dir)
+}
+
+// extractUpload extracts the zip in the "project" field of a multipart
+// upload to a new temporary directory, which the caller removes.
+func (s *server) extractUpload(w http.ResponseWriter, r *http.Request) (string, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxUploadBytes)
+	file, header, err := r.FormFile("project")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds %d bytes", tooLarge.Limit))
+			return "", false
+		}
+		writeError(w, http.StatusBadRequest, "Expected a zip file in the project field")
+		return "", false
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid zip file")
+		return "", false
+	}
+
+	dir, err := os.MkdirTemp("", "project-*")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating project directory", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create project directory")
+		return "", false
+	}
+	if err := extractZip(archive, dir, 10*s.cfg.MaxUploadBytes); err != nil {
+		os.RemoveAll(dir)
+		if errors.Is(err, errProjectTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Project is too large")
+			return "", false
+		}
+		slog.WarnContext(r.Context(), "Error extracting project", "err", err)
+		writeError(w, http.StatusBadRequest, "Failed to extract zip file")
+		return "", false
+	}
+	return dir, true
+}
+
+func (s *server) writeProject(w http.ResponseWriter, r *http.Request, p parser.Parser, dir string) {
+	// Run as a job, the parse reports each file as it goes.
+	var obs projectObserver
+	if j := jobFromContext(r.Context()); j != nil {
+		obs = j
+	}
+	result, err := s.parseProject(r.Context(), p, dir, obs)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+		case errors.Is(err, errProjectTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, "Project is too large")
+		default:
+			slog.ErrorContext(r.Context(), "Error parsing project", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to parse project")
+		}
+		return
+	}
+
+	w.Header().Set("X-Parser", p.Name())
+	writeJSON(w, result)
+}
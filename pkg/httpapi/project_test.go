@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type zipEntry struct {
+	name string
+	body string
+	mode fs.FileMode
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			h.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+// extractedFiles lists the files under root, relative to it.
+func extractedFiles(t *testing.T, root string) []string {
+	t.Helper()
+	files := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "ruby files only",
+			entries: []zipEntry{{name: "app/models/user.rb", body: "class User; end"}, {name: "README.md", body: "hi"}, {name: "Gemfile", body: "source 'x'"}},
+			want:    []string{"Gemfile", "app/models/user.rb"},
+		},
+		{
+			name:    "zip slip",
+			entries: []zipEntry{{name: "../evil.rb"}, {name: "lib/../../evil.rb"}, {name: "lib/ok.rb"}},
+			want:    []string{"lib/ok.rb"},
+		},
+		{
+			name:    "absolute",
+			entries: []zipEntry{{name: "/tmp/evil.rb"}, {name: "ok.rb"}},
+			want:    []string{"ok.rb"},
+		},
+		{
+			name:    "symlink",
+			entries: []zipEntry{{name: "link.rb", body: "../../etc/passwd", mode: fs.ModeSymlink | 0o777}, {name: "ok.rb"}},
+			want:    []string{"ok.rb"},
+		},
+		{
+			name:    "duplicate",
+			entries: []zipEntry{{name: "a.rb", body: "1"}, {name: "a.rb", body: "2"}},
+			wantErr: fs.ErrExist,
+		},
+		{
+			name:    "duplicate through a dot segment",
+			entries: []zipEntry{{name: "lib/a.rb", body: "1"}, {name: "lib/./a.rb", body: "2"}},
+			wantErr: fs.ErrExist,
+		},
+		{
+			name:    "too large",
+			entries: []zipEntry{{name: "a.rb", body: string(bytes.Repeat([]byte("x"), 60))}, {name: "b.rb", body: string(bytes.Repeat([]byte("x"), 60))}},
+			wantErr: errProjectTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "project")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			err := extractZip(buildZip(t, tt.entries...), dir, 100)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("extractZip error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := extractedFiles(t, root)
+			want := make([]string, len(tt.want))
+			for i, name := range tt.want {
+				want[i] = "project/" + name
+			}
+			if len(got) != len(want) {
+				t.Fatalf("extracted %q, want %q", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("extracted %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// A header claiming a small file can't get more than it claims written.
+func TestExtractZipLyingSize(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 500)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(body)
+	fw.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "a.rb",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(body),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := extractZip(zr, dir, 100); !errors.Is(err, zip.ErrFormat) && !errors.Is(err, errProjectTooLarge) {
+		t.Fatalf("extractZip error = %v, want zip.ErrFormat or errProjectTooLarge", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "a.rb")); err == nil && info.Size() > 10 {
+		t.Errorf("wrote %d bytes of a file claiming 10", info.Size())
+	}
+}
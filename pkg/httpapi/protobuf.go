@@ -0,0 +1,202 @@
+package httpapi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// Just enough of the protobuf wire format for the messages in
+// proto/rubyast/v1/visualizer.proto: the gRPC service reads and writes them
+// by hand rather than through generated code.
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+type protoBuf []byte
+
+func (b *protoBuf) tag(field, wireType int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *protoBuf) varint(field int, v uint64) {
+	b.tag(field, wireVarint)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+// int32 writes a non-zero value, as proto3 leaves defaults out.
+func (b *protoBuf) int32(field int, v int) {
+	if v != 0 {
+		b.varint(field, uint64(int64(v)))
+	}
+}
+
+func (b *protoBuf) bool(field int, v bool) {
+	if v {
+		b.varint(field, 1)
+	}
+}
+
+func (b *protoBuf) double(field int, v float64) {
+	b.tag(field, wire64)
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *protoBuf) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// string writes a non-empty value; use bytes for an explicitly set one.
+func (b *protoBuf) string(field int, v string) {
+	if v != "" {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// message writes the message encode appends, as a length-delimited field.
+func (b *protoBuf) message(field int, encode func(*protoBuf)) {
+	var m protoBuf
+	encode(&m)
+	b.bytes(field, m)
+}
+
+var errMalformedProto = errors.New("malformed protobuf message")
+
+// protoFields calls fn with each field of a message. Varint and fixed-size
+// values come as num, length-delimited ones as data. Unknown fields are for
+// fn to ignore, as protobuf requires.
+func protoFields(msg []byte, fn func(field, wireType int, num uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformedProto
+		}
+		msg = msg[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		var num uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			if num, n = binary.Uvarint(msg); n <= 0 {
+				return errMalformedProto
+			}
+			msg = msg[n:]
+		case wire64:
+			if len(msg) < 8 {
+				return errMalformedProto
+			}
+			num, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wire32:
+			if len(msg) < 4 {
+				return errMalformedProto
+			}
+			num, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errMalformedProto
+			}
+			data, msg = msg[n:n+int(size)], msg[n+int(size):]
+		default:
+			return errMalformedProto
+		}
+		if err := fn(field, wireType, num, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeNode writes a NormalNode as a rubyast.v1.Node.
+func encodeNode(b *protoBuf, n *analyze.NormalNode) {
+	b.string(1, n.Type)
+	b.string(2, n.Field)
+	if loc := n.Location; loc != nil {
+		b.message(3, func(m *protoBuf) {
+			m.int32(1, loc.StartLine)
+			m.int32(2, loc.StartChar)
+			m.int32(3, loc.EndLine)
+			m.int32(4, loc.EndChar)
+		})
+	}
+	if n.Value != nil {
+		b.bytes(4, []byte(*n.Value))
+	}
+	for _, name := range sortedKeys(n.Attributes) {
+		b.message(5, func(entry *protoBuf) {
+			entry.string(1, name)
+			entry.message(2, func(m *protoBuf) { encodeValue(m, n.Attributes[name]) })
+		})
+	}
+	for _, child := range n.Children {
+		b.message(6, func(m *protoBuf) { encodeNode(m, child) })
+	}
+}
+
+// encodeValue writes a decoded JSON value as a google.protobuf.Value. The
+// objects decoded parser output holds are *analyze.Node with no type.
+func encodeValue(b *protoBuf, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.varint(1, 0)
+	case json.Number:
+		f, _ := v.Float64()
+		b.double(2, f)
+	case float64:
+		b.double(2, v)
+	case string:
+		b.bytes(3, []byte(v))
+	case bool:
+		// Written even when false, being one of a oneof.
+		var n uint64
+		if v {
+			n = 1
+		}
+		b.varint(4, n)
+	case map[string]interface{}:
+		b.message(5, func(m *protoBuf) {
+			for _, name := range sortedKeys(v) {
+				encodeStructField(m, name, v[name])
+			}
+		})
+	case *analyze.Node:
+		b.message(5, func(m *protoBuf) {
+			for _, f := range v.Fields {
+				encodeStructField(m, f.Name, f.Value)
+			}
+		})
+	case []interface{}:
+		b.message(6, func(m *protoBuf) {
+			for _, element := range v {
+				m.message(1, func(e *protoBuf) { encodeValue(e, element) })
+			}
+		})
+	}
+}
+
+func encodeStructField(b *protoBuf, name string, value interface{}) {
+	b.message(1, func(entry *protoBuf) {
+		entry.string(1, name)
+		entry.message(2, func(m *protoBuf) { encodeValue(m, value) })
+	})
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
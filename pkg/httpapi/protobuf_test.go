@@ -0,0 +1,294 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// protoFieldNumbers reads the field numbers of a message in
+// proto/rubyast/v1/visualizer.proto, so the round trip below decodes by the
+// schema rather than by what encodeNode believes it to be.
+func protoFieldNumbers(t *testing.T, message string) map[string]int {
+	t.Helper()
+	src, err := os.ReadFile("../../proto/rubyast/v1/visualizer.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := regexp.MustCompile(`(?s)message ` + message + ` \{(.*?)\n\}`).FindSubmatch(src)
+	if body == nil {
+		t.Fatalf("no message %s in visualizer.proto", message)
+	}
+	fields := map[string]int{}
+	for _, m := range regexp.MustCompile(`(?m)^\s*(?:optional |repeated )?(?:map<[^>]+>|[\w.]+) (\w+) = (\d+);`).FindAllSubmatch(body[1], -1) {
+		fields[string(m[1])], _ = strconv.Atoi(string(m[2]))
+	}
+	return fields
+}
+
+// decodeTestValue reads a google.protobuf.Value, with numbers as
+// json.Number to compare with decoded parser output. Field numbers are
+// struct.proto's.
+func decodeTestValue(t *testing.T, msg []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	err := protoFields(msg, func(field, wireType int, num uint64, data []byte) error {
+		switch field {
+		case 1:
+			v = nil
+		case 2:
+			v = json.Number(strconv.FormatFloat(math.Float64frombits(num), 'g', -1, 64))
+		case 3:
+			v = string(data)
+		case 4:
+			v = num != 0
+		case 5:
+			obj := map[string]interface{}{}
+			protoFields(data, func(_, _ int, _ uint64, entry []byte) error {
+				name, value := decodeTestMapEntry(t, entry)
+				obj[name] = decodeTestValue(t, value)
+				return nil
+			})
+			v = obj
+		case 6:
+			list := []interface{}{}
+			protoFields(data, func(_, _ int, _ uint64, element []byte) error {
+				list = append(list, decodeTestValue(t, element))
+				return nil
+			})
+			v = list
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func decodeTestMapEntry(t *testing.T, entry []byte) (key string, value []byte) {
+	t.Helper()
+	err := protoFields(entry, func(field, _ int, _ uint64, data []byte) error {
+		switch field {
+		case 1:
+			key = string(data)
+		case 2:
+			value = data
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, value
+}
+
+func decodeTestNode(t *testing.T, msg []byte, node, location map[string]int) *analyze.NormalNode {
+	t.Helper()
+	n := &analyze.NormalNode{Children: []*analyze.NormalNode{}}
+	err := protoFields(msg, func(field, _ int, _ uint64, data []byte) error {
+		switch field {
+		case node["type"]:
+			n.Type = string(data)
+		case node["field"]:
+			n.Field = string(data)
+		case node["location"]:
+			loc := &analyze.Location{}
+			protoFields(data, func(field, _ int, num uint64, _ []byte) error {
+				switch field {
+				case location["start_line"]:
+					loc.StartLine = int(int32(num))
+				case location["start_char"]:
+					loc.StartChar = int(int32(num))
+				case location["end_line"]:
+					loc.EndLine = int(int32(num))
+				case location["end_char"]:
+					loc.EndChar = int(int32(num))
+				}
+				return nil
+			})
+			n.Location = loc
+		case node["value"]:
+			value := string(data)
+			n.Value = &value
+		case node["attributes"]:
+			if n.Attributes == nil {
+				n.Attributes = map[string]interface{}{}
+			}
+			name, value := decodeTestMapEntry(t, data)
+			n.Attributes[name] = decodeTestValue(t, value)
+		case node["children"]:
+			n.Children = append(n.Children, decodeTestNode(t, data, node, location))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEncodeNodeRoundTrip(t *testing.T) {
+	node, location := protoFieldNumbers(t, "Node"), protoFieldNumbers(t, "Location")
+	if len(node) != 6 || len(location) != 4 {
+		t.Fatalf("read Node fields %v and Location fields %v from visualizer.proto", node, location)
+	}
+
+	root, err := analyze.DecodeAST([]byte(`{"type":"program","location":[1,0,3,12],"statements":{"type":"statements","location":[1,0,3,12],"body":[
+		{"type":"call","location":[1,0,1,7],
+			"receiver":{"type":"vcall","location":[1,0,1,3],"value":{"type":"ident","location":[1,0,1,3],"value":"foo"}},
+			"operator":{"type":"period","location":[1,3,1,4],"value":"."},
+			"message":{"type":"ident","location":[1,4,1,7],"value":"bar"},
+			"arguments":null},
+		{"type":"tstring_content","location":[2,0,2,0],"value":""},
+		{"type":"int","location":[3,0,3,12],"value":"-1_000","base":-1.5e3,"flags":[true,false,null,"x"],"extra":{"a":{"b":[]}}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := analyze.NormalizeNode(root, "")
+
+	var b protoBuf
+	encodeNode(&b, want)
+	got := decodeTestNode(t, b, node, location)
+
+	// Compared as the HTTP API would have sent the tree, with numbers as
+	// float64 since that is all google.protobuf.Value keeps of them.
+	var gotTree, wantTree interface{}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	json.Unmarshal(gotJSON, &gotTree)
+	json.Unmarshal(wantJSON, &wantTree)
+	if !reflect.DeepEqual(gotTree, wantTree) {
+		t.Errorf("round trip gave\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+	if empty := got.Children[0].Children[1]; empty.Value == nil {
+		t.Errorf("an empty value was left out, though Node.value is optional")
+	}
+}
+
+func TestEncodeNodeWire(t *testing.T) {
+	value := "x"
+	n := &analyze.NormalNode{
+		Type:     "ident",
+		Location: &analyze.Location{StartLine: 1, StartChar: 0, EndLine: 1, EndChar: 150},
+		Value:    &value,
+		Children: []*analyze.NormalNode{{Type: "a", Field: "f"}},
+	}
+	want := []byte{
+		0x0a, 5, 'i', 'd', 'e', 'n', 't', // type = 1
+		0x1a, 7, 0x08, 1, 0x18, 1, 0x20, 0x96, 0x01, // location = 3, without the zero start_char
+		0x22, 1, 'x', // value = 4
+		0x32, 6, 0x0a, 1, 'a', 0x12, 1, 'f', // children = 6
+	}
+	var b protoBuf
+	encodeNode(&b, n)
+	if !bytes.Equal(b, want) {
+		t.Errorf("encodeNode = % x, want % x", []byte(b), want)
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  []byte
+	}{
+		{nil, []byte{0x08, 0}},
+		{json.Number("1.5"), []byte{0x11, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+		{"hi", []byte{0x1a, 2, 'h', 'i'}},
+		{"", []byte{0x1a, 0}},
+		{true, []byte{0x20, 1}},
+		{false, []byte{0x20, 0}},
+		{[]interface{}{true}, []byte{0x32, 4, 0x0a, 2, 0x20, 1}},
+		{[]interface{}{}, []byte{0x32, 0}},
+		{map[string]interface{}{"a": nil}, []byte{0x2a, 9, 0x0a, 7, 0x0a, 1, 'a', 0x12, 2, 0x08, 0}},
+		{&analyze.Node{Fields: []analyze.ASTField{{Name: "a", Value: nil}}}, []byte{0x2a, 9, 0x0a, 7, 0x0a, 1, 'a', 0x12, 2, 0x08, 0}},
+	}
+	for _, tt := range tests {
+		var b protoBuf
+		encodeValue(&b, tt.value)
+		if !bytes.Equal(b, tt.want) {
+			t.Errorf("encodeValue(%#v) = % x, want % x", tt.value, []byte(b), tt.want)
+		}
+	}
+}
+
+func TestProtoFields(t *testing.T) {
+	type field struct {
+		Field, WireType int
+		Num             uint64
+		Data            string
+	}
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    []field
+		wantErr bool
+	}{
+		// The examples of the protobuf encoding guide.
+		{"varint", []byte{0x08, 0x96, 0x01}, []field{{1, wireVarint, 150, ""}}, false},
+		{"string", []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}, []field{{2, wireBytes, 0, "testing"}}, false},
+		{"fixed", []byte{0x0d, 1, 0, 0, 0, 0x11, 2, 0, 0, 0, 0, 0, 0, 0}, []field{{1, wire32, 1, ""}, {2, wire64, 2, ""}}, false},
+		{"empty", nil, nil, false},
+		{"truncated key", []byte{0x80}, nil, true},
+		{"truncated varint", []byte{0x08, 0x96}, nil, true},
+		{"truncated fixed32", []byte{0x0d, 1, 0}, nil, true},
+		{"truncated fixed64", []byte{0x11, 1, 0, 0, 0}, nil, true},
+		{"length past the end", []byte{0x12, 0x08, 't', 'e', 's', 't', 'i', 'n', 'g'}, nil, true},
+		{"huge length", []byte{0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, nil, true},
+		{"group", []byte{0x0b, 0x0c}, nil, true},
+	}
+	for _, tt := range tests {
+		var got []field
+		err := protoFields(tt.msg, func(f, wt int, num uint64, data []byte) error {
+			got = append(got, field{f, wt, num, string(data)})
+			return nil
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: fields = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCCodeRequest(t *testing.T) {
+	var b protoBuf
+	b.string(1, "puts 1")
+	b.string(2, "prism")
+	b.int32(3, 2)
+	b.int32(4, -1)
+	b.string(9, "ignored")
+	var extra []uint64
+	req, err := grpcCodeRequest(b, func(field int, num uint64, _ []byte) {
+		if field == 3 || field == 4 {
+			extra = append(extra, num)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Code != "puts 1" || req.Parser != "prism" {
+		t.Errorf("request = %+v", req)
+	}
+	// A negative int32 is sign-extended to ten bytes on the wire.
+	if len(extra) != 2 || int32(extra[0]) != 2 || int32(extra[1]) != -1 {
+		t.Errorf("extra fields = %v, want 2 and -1", extra)
+	}
+
+	var ge *grpcError
+	if _, err := grpcCodeRequest([]byte{0x0a, 5, 'a'}, nil); !errors.As(err, &ge) || ge.code != grpcInvalidArgument {
+		t.Errorf("truncated request: error = %v, want INVALID_ARGUMENT", err)
+	}
+	if _, err := grpcCodeRequest([]byte{0x0a, 1, 0xff}, nil); !errors.As(err, &ge) || ge.code != grpcInvalidArgument {
+		t.Errorf("invalid UTF-8: error = %v, want INVALID_ARGUMENT", err)
+	}
+}
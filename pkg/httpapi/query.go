@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type queryRequest struct {
+	Code    string `json:"code"`
+	Parser  string `json:"parser"`
+	Pattern string `json:"pattern"`
+}
+
+type queryResponse struct {
+	Matches   []analyze.QueryMatch `json:"matches"`
+	Truncated bool                 `json:"truncated"`
+}
+
+// handleQuery searches a tree structurally with a node pattern.
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req queryRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	pat, err := analyze.CompilePattern(req.Pattern)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid pattern: "+err.Error())
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	matches, truncated := analyze.RunQuery(root, pat)
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, queryResponse{matches, truncated})
+}
@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateLimitIdle = 10 * time.Minute
+
+// rateLimitExempt routes are probed by infrastructure and never limited.
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per key: each key may make burst requests at
+// once, refilled at rate per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token for key. If there is none it returns false and how
+// long until there will be.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// prune forgets keys idle long enough that their bucket is full again.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) pruneLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(rateLimitIdle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			l.prune(now)
+		}
+	}
+}
+
+func (s *server) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.cfg.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client behind any trusted proxies.
+// X-Forwarded-For is only believed when the connection comes from a trusted
+// proxy, and then only as far back as the first hop that isn't one, since
+// anything before that could have been made up by the client.
+func (s *server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	i := len(forwarded) - 1
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		// Over a Unix socket the peer is a local reverse proxy, so the last
+		// hop it reports is believed as if it were a trusted proxy.
+		if addr, err = netip.ParseAddr(strings.TrimSpace(forwarded[i])); err != nil {
+			return host
+		}
+		i--
+	}
+	addr = addr.Unmap()
+
+	for ; i >= 0 && s.trustedProxy(addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+	}
+	return addr.String()
+}
+
+// rateLimit answers 429 with Retry-After to clients over their rate.
+// Requests made with an API key are limited per key instead.
+func (s *server) rateLimit(next http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt[route(r)] || apiKeyFrom(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := s.limiter.allow(s.clientIP(r), time.Now())
+		if !ok {
+			rateLimited.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
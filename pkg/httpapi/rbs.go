@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type rbsRequest struct {
+	Code   string `json:"code"`
+	Format string `json:"format"`
+	analyze.FormatOptions
+}
+
+// handleRBS parses RBS type signatures into a tree of their declarations,
+// so type structure can be shown alongside the code it describes.
+func (s *server) handleRBS(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req rbsRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Format == "" {
+		req.Format = analyze.DefaultFormat
+	}
+	if !analyze.KnownFormat(req.Format) {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	if !checkFormatOptions(w, req.Format, req.FormatOptions) {
+		return
+	}
+
+	output, hit, err := s.parse(r.Context(), s.rbs, req.Code)
+	if err != nil {
+		writeParseError(w, r, s.rbs, err)
+		return
+	}
+	req.Source = req.Code
+	contentType, body, err := analyze.RenderFormat(req.Format, output, req.FormatOptions)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", req.Format, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render "+req.Format+" output")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Parser", s.rbs.Name())
+	w.Header().Set("X-Parse-ID", parseID(s.rbs, req.Code))
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if _, err := w.Write(body); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// handleRender returns an SVG of the tree, laid out by Graphviz. The tree is
+// either parsed from the posted code or, for GET /render?id=..., taken from
+// a recent parse still in the cache, so rendered trees can be linked to.
+func (s *server) handleRender(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	var root *analyze.Node
+	if r.Method == http.MethodGet {
+		output, ok := s.cache.get(r.URL.Query().Get("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "Unknown or expired parse ID")
+			return
+		}
+		if root, ok = decodeOutput(w, output); !ok {
+			return
+		}
+	} else {
+		var req codeRequest
+		if !s.decodeRequest(w, r, &req) {
+			return
+		}
+		parser, ok := s.lookupParser(w, req.Parser)
+		if !ok {
+			return
+		}
+		if root, ok = s.parseTree(w, r, parser, req.Code); !ok {
+			return
+		}
+		w.Header().Set("X-Parse-ID", parseID(parser, req.Code))
+	}
+
+	var dot bytes.Buffer
+	if err := analyze.WriteDOT(&dot, root); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering DOT", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render DOT")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+	defer cancel()
+
+	svg, err := s.runDot(ctx, "svg", dot.Bytes())
+	if err != nil {
+		writeDotError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	if _, err := w.Write(svg); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
+
+// writeDotError answers a request whose runDot under ctx failed.
+func writeDotError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, errOverloaded) {
+		writeOverloaded(w)
+		return
+	}
+	if ctx.Err() != nil {
+		writeError(w, http.StatusGatewayTimeout, "Rendering timed out")
+		return
+	}
+	slog.ErrorContext(ctx, "Error running dot", "err", err)
+	writeError(w, http.StatusInternalServerError, "Failed to execute dot")
+}
+
+// runDot lays out a DOT graph with Graphviz and returns it in the given
+// output format (-T), passing dot any further args.
+func (s *server) runDot(ctx context.Context, format string, graph []byte, args ...string) ([]byte, error) {
+	if err := s.procs.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.procs.release()
+
+	var stderr bytes.Buffer
+	cmd := parser.NewCommand(ctx, s.cfg.DotBin, append([]string{"-T" + format}, args...)...)
+	cmd.Stdin = bytes.NewReader(graph)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	parser.CountSpawnFailure(cmd, err)
+	if err != nil && stderr.Len() > 0 {
+		slog.ErrorContext(ctx, "dot failed", "stderr", strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
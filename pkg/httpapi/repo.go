@@ -0,0 +1,206 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// cloneEnv is the environment git runs in: the PATH, proxy and CA settings
+// it needs to reach the host, and nothing else of the server's, such as its
+// secrets. HOME is set to home, so no user git config applies either.
+func cloneEnv(home string) []string {
+	env := []string{"HOME=" + home, "GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_NOSYSTEM=1", "GIT_LITERAL_PATHSPECS=1", "GIT_NO_LAZY_FETCH=1"}
+	for _, name := range []string{"PATH", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy", "SSL_CERT_FILE", "SSL_CERT_DIR"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// cloneRepo shallow-clones a single ref of an https repository into a
+// checkout directory in dir, which it returns. Only the tip commit is
+// fetched and other transports are disabled, since the URL comes from the
+// client. Blobs over cfg.MaxBodyBytes aren't fetched, and only the Ruby
+// files are checked out, after checking they fit in the same
+// 10*cfg.MaxUploadBytes as an uploaded project. A GitHub token is given to
+// git in its environment, out of sight of ps, and only for github.com.
+func (s *server) cloneRepo(ctx context.Context, repoURL, ref, dir, token string) (string, error) {
+	checkout := filepath.Join(dir, "checkout")
+	args := []string{
+		"-c", "protocol.allow=never",
+		"-c", "protocol.https.allow=always",
+		"clone", "--depth=1", "--single-branch", "--no-tags", "--quiet", "--no-checkout",
+		"--filter=blob:limit=" + strconv.FormatInt(s.cfg.MaxBodyBytes, 10),
+	}
+	if ref != "" {
+		args = append(args, "--branch="+ref)
+	}
+	args = append(args, "--", repoURL, checkout)
+
+	env := cloneEnv(dir)
+	if token != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+		env = append(env, "GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth)
+	}
+	git := func(stdin []byte, args ...string) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := parser.NewCommand(ctx, s.cfg.GitBin, args...)
+		cmd.Env = env
+		cmd.Stdin = bytes.NewReader(stdin)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		parser.CountSpawnFailure(cmd, err)
+		if err != nil && stderr.Len() > 0 {
+			slog.WarnContext(ctx, "git failed", "url", repoURL, "command", args[len(args)-1], "stderr", strings.TrimSpace(stderr.String()))
+		}
+		return output, err
+	}
+
+	if err := s.procs.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer s.procs.release()
+
+	if _, err := git(nil, args...); err != nil {
+		return "", err
+	}
+	tree, err := git(nil, "-C", checkout, "ls-tree", "-r", "-z", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	// <mode> SP <type> SP <object> TAB <path>
+	var objects []byte
+	blobs := make(map[string][]string)
+	for _, entry := range bytes.Split(tree, []byte{0}) {
+		meta, path, ok := strings.Cut(string(entry), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" || !analyze.IsRubySource(path) {
+			continue
+		}
+		blobs[fields[2]] = append(blobs[fields[2]], path)
+	}
+	// Asking for the size of a blob the filter left out would fetch it, so
+	// those are listed first and left out.
+	listed, err := git(nil, "-C", checkout, "rev-list", "--objects", "--missing=print", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(listed), "\n") {
+		if object, ok := strings.CutPrefix(line, "?"); ok {
+			delete(blobs, object)
+		}
+	}
+	for object := range blobs {
+		objects = append(append(objects, object...), '\n')
+	}
+	sizes, err := git(objects, "-C", checkout, "cat-file", "--batch-check=%(objectname) %(objectsize)")
+	if err != nil {
+		return "", err
+	}
+	var paths []byte
+	var files int
+	var size int64
+	for _, line := range strings.Split(string(sizes), "\n") {
+		object, n, _ := strings.Cut(line, " ")
+		bytes, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, path := range blobs[object] {
+			files++
+			size += bytes
+			paths = append(append(paths, path...), 0)
+		}
+	}
+	if files > maxProjectFiles || size > 10*s.cfg.MaxUploadBytes {
+		return "", errProjectTooLarge
+	}
+	if files > 0 {
+		if _, err := git(paths, "-C", checkout, "checkout", "HEAD", "--pathspec-from-file=-", "--pathspec-file-nul"); err != nil {
+			return "", err
+		}
+	}
+	return checkout, nil
+}
+
+type repoRequest struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref"`
+	Parser string `json:"parser"`
+}
+
+// handleRepo clones a public git repository and indexes its Ruby files: node
+// counts, classes and modules per file, and a parse ID to open each one with.
+func (s *server) handleRepo(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req repoRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme != "https" || u.User != nil || !hostAllowed(u.Hostname(), s.cfg.CloneHosts) {
+		writeError(w, http.StatusBadRequest, "Only https repositories on these hosts are allowed: "+strings.Join(s.cfg.CloneHosts, ", "))
+		return
+	}
+	if strings.HasPrefix(req.Ref, "-") {
+		writeError(w, http.StatusBadRequest, "Invalid ref")
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "repo-*")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating repository directory", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create repository directory")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if j := jobFromContext(r.Context()); j != nil {
+		j.phase("cloning")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.CloneTimeout)
+	token := ""
+	if strings.EqualFold(u.Hostname(), "github.com") {
+		token = s.githubToken(r)
+	}
+	checkout, err := s.cloneRepo(ctx, u.String(), req.Ref, dir, token)
+	cancel()
+	if err != nil {
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+		case errors.Is(err, errOverloaded):
+			writeOverloaded(w)
+		case errors.Is(err, errProjectTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, "Repository is too large")
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			writeError(w, http.StatusGatewayTimeout, "Cloning the repository timed out")
+		default:
+			writeError(w, http.StatusBadGateway, "Failed to clone the repository")
+		}
+		return
+	}
+
+	s.writeProject(w, r, parser, checkout)
+}
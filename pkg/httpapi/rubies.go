@@ -0,0 +1,136 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// rubyInstallDirs are where version managers install each Ruby, one
+// directory per version, as globs. Paths starting with ~ are relative to the
+// home directory.
+var rubyInstallDirs = []string{
+	"~/.rbenv/versions/*",
+	"~/.asdf/installs/ruby/*",
+	"~/.local/share/mise/installs/ruby/*",
+	"~/.rvm/rubies/ruby-*",
+	"~/.rubies/ruby-*",
+	"/opt/rubies/ruby-*",
+}
+
+// discoverRubies returns the interpreters ruby_version can pick, by version:
+// those given with -rubies and, unless -discover-rubies is off, those found
+// in rubyInstallDirs. -rubies wins when both have a version.
+func discoverRubies(cfg *config) map[string]string {
+	rubies := make(map[string]string)
+	if cfg.DiscoverRubies {
+		home, _ := os.UserHomeDir()
+		dirs := rubyInstallDirs
+		if root := os.Getenv("RBENV_ROOT"); root != "" {
+			dirs = append([]string{filepath.Join(root, "versions", "*")}, dirs...)
+		}
+		if root := os.Getenv("ASDF_DATA_DIR"); root != "" {
+			dirs = append([]string{filepath.Join(root, "installs", "ruby", "*")}, dirs...)
+		}
+		for _, pattern := range dirs {
+			if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+				if home == "" {
+					continue
+				}
+				pattern = filepath.Join(home, rest)
+			}
+			matches, _ := filepath.Glob(pattern)
+			for _, dir := range matches {
+				version := strings.TrimPrefix(filepath.Base(dir), "ruby-")
+				bin := filepath.Join(dir, "bin", "ruby")
+				if _, seen := rubies[version]; seen || !looksLikeVersion(version) {
+					continue
+				}
+				if info, err := os.Stat(bin); err == nil && info.Mode()&0o111 != 0 {
+					rubies[version] = bin
+				}
+			}
+		}
+	}
+	for _, entry := range cfg.Rubies {
+		version, bin, _ := strings.Cut(entry, "=")
+		rubies[version] = bin
+	}
+	return rubies
+}
+
+// looksLikeVersion reports whether s is a CRuby version such as 3.3.0 or
+// 3.4.0-preview1, as opposed to jruby-9.4 or an alias like "default".
+func looksLikeVersion(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9' && strings.Contains(s, ".")
+}
+
+// versionLess orders versions by their numeric components, so 3.10 comes
+// after 3.9.
+func versionLess(a, b string) bool {
+	as, bs := strings.FieldsFunc(a, isVersionSep), strings.FieldsFunc(b, isVersionSep)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			return an < bn
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func isVersionSep(r rune) bool {
+	return r == '.' || r == '-'
+}
+
+// matchRubyVersion picks the installed version for a requested one: an exact
+// match, or else the newest release in the series, so "3.3" finds 3.3.6.
+func matchRubyVersion(installed []string, want string) (string, bool) {
+	best := ""
+	for _, version := range installed {
+		if version == want {
+			return version, true
+		}
+		if strings.HasPrefix(version, want+".") && (best == "" || versionLess(best, version)) {
+			best = version
+		}
+	}
+	return best, best != ""
+}
+
+func (s *server) rubyVersionNames() []string {
+	versions := make([]string, 0, len(s.rubies))
+	for version := range s.rubies {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	return versions
+}
+
+// lookupRubyVersion returns the named parser running under the requested
+// Ruby. It writes a 400 and returns false if that Ruby isn't installed.
+func (s *server) lookupRubyVersion(w http.ResponseWriter, name, want string) (parser.Parser, bool) {
+	installed := s.rubyVersionNames()
+	version, ok := matchRubyVersion(installed, want)
+	if !ok {
+		if len(installed) == 0 {
+			writeError(w, http.StatusBadRequest, "No other Ruby versions are available")
+		} else {
+			writeError(w, http.StatusBadRequest, "Unknown Ruby version; available: "+strings.Join(installed, ", "))
+		}
+		return nil, false
+	}
+	p, ok := s.rubies[version][name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown parser")
+	}
+	return p, ok
+}
@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// rulesRequest is the body of /rules/run. Rules is a list of rules or a
+// Semgrep-style {"rules": [...]} object, or either as a string of YAML or
+// JSON, as a rule file would be posted.
+type rulesRequest struct {
+	Code   string          `json:"code"`
+	Parser string          `json:"parser"`
+	Rules  json.RawMessage `json:"rules"`
+}
+
+type rulesResponse struct {
+	Findings  []analyze.RuleFinding `json:"findings"`
+	Truncated bool                  `json:"truncated"`
+}
+
+// handleRunRules evaluates structural lint rules against the posted code's
+// tree.
+func (s *server) handleRunRules(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req rulesRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	source := []byte(req.Rules)
+	var text string
+	if err := json.Unmarshal(req.Rules, &text); err == nil {
+		source = []byte(text)
+	}
+	rules, err := analyze.ParseRules(source)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rules: "+err.Error())
+		return
+	}
+	compiled, err := analyze.CompileRules(rules)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rules: "+err.Error())
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	findings, truncated := analyze.RunRules(root, req.Code, parser.Name(), compiled)
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, rulesResponse{findings, truncated})
+}
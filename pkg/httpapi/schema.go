@@ -0,0 +1,20 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// handleASTSchema serves the JSON Schema for normalized trees.
+func (s *server) handleASTSchema(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	if err := json.NewEncoder(w).Encode(analyze.ASTSchema()); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,218 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+type brakemanReport struct {
+	Warnings []struct {
+		WarningType string `json:"warning_type"`
+		Fingerprint string `json:"fingerprint"`
+		CheckName   string `json:"check_name"`
+		Message     string `json:"message"`
+		File        string `json:"file"`
+		Line        *int   `json:"line"`
+		Link        string `json:"link"`
+		Code        string `json:"code"`
+		Confidence  string `json:"confidence"`
+	} `json:"warnings"`
+	Errors []scanError `json:"errors"`
+}
+
+// scanError is a file Brakeman couldn't process.
+type scanError struct {
+	Error    string `json:"error"`
+	Location string `json:"location"`
+}
+
+// securityWarning is a Brakeman warning with the project file it is in and,
+// when that file parsed and the warning has a line, the outermost node
+// starting on that line.
+type securityWarning struct {
+	WarningType string               `json:"warning_type"`
+	CheckName   string               `json:"check_name"`
+	Message     string               `json:"message"`
+	Confidence  string               `json:"confidence"`
+	Fingerprint string               `json:"fingerprint"`
+	Link        string               `json:"link,omitempty"`
+	Code        string               `json:"code,omitempty"`
+	File        string               `json:"file"`
+	Line        int                  `json:"line,omitempty"`
+	ParseID     string               `json:"parse_id,omitempty"`
+	Node        *analyze.NodeSummary `json:"node"`
+}
+
+type securityResponse struct {
+	*projectResult
+	Warnings   []securityWarning `json:"warnings"`
+	ScanErrors []scanError       `json:"scan_errors"`
+}
+
+// runBrakeman scans the Rails app at root. Brakeman's exit codes for
+// warnings and errors are turned off, so any failure is its own.
+func (s *server) runBrakeman(ctx context.Context, root string) (*brakemanReport, error) {
+	args := []string{"--format", "json", "--quiet", "--no-pager", "--no-progress",
+		"--no-exit-on-warn", "--no-exit-on-error", "--force", root}
+
+	if err := s.procs.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.procs.release()
+
+	var stderr bytes.Buffer
+	cmd := parser.NewCommand(ctx, s.cfg.BrakemanBin, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	parser.CountSpawnFailure(cmd, err)
+	if err != nil {
+		if stderr.Len() > 0 {
+			slog.ErrorContext(ctx, "brakeman failed", "stderr", strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+
+	var report brakemanReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// railsRoot finds the app in an extracted project: dir itself, or the one
+// directory in it when the zip was made of the app's folder. It returns that
+// folder's path within the project too, for mapping Brakeman's paths back.
+func railsRoot(dir string) (string, string) {
+	if _, err := os.Stat(filepath.Join(dir, "app")); err == nil {
+		return dir, ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir, ""
+	}
+	return filepath.Join(dir, entries[0].Name()), entries[0].Name()
+}
+
+// handleSecurity runs Brakeman over an uploaded Rails app, as /parse/project
+// takes it, and returns the project parse with each security warning tied
+// to its file and the node on its line.
+func (s *server) handleSecurity(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	dir, ok := s.extractUpload(w, r)
+	if !ok {
+		return
+	}
+	defer os.RemoveAll(dir)
+	p, ok := s.lookupParser(w, r.FormValue("parser"))
+	if !ok {
+		return
+	}
+
+	var obs projectObserver
+	if j := jobFromContext(r.Context()); j != nil {
+		obs = j
+	}
+	result, err := s.parseProject(r.Context(), p, dir, obs)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+		case errors.Is(err, errProjectTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, "Project is too large")
+		default:
+			slog.ErrorContext(r.Context(), "Error parsing project", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to parse project")
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.BrakemanTimeout)
+	defer cancel()
+
+	root, prefix := railsRoot(dir)
+	report, err := s.runBrakeman(ctx, root)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+		case errors.Is(err, errOverloaded):
+			writeOverloaded(w)
+		case errors.Is(err, exec.ErrNotFound):
+			writeError(w, http.StatusServiceUnavailable, "Brakeman is not installed")
+		case ctx.Err() != nil:
+			writeError(w, http.StatusGatewayTimeout, "Security scan timed out")
+		default:
+			slog.ErrorContext(r.Context(), "Error running brakeman", "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to execute brakeman")
+		}
+		return
+	}
+
+	files := make(map[string]projectFile, len(result.Files))
+	for _, f := range result.Files {
+		files[f.Path] = f
+	}
+	// Each file's tree is decoded once, from the parse cache, for however
+	// many warnings it has.
+	trees := make(map[string]*analyze.Node)
+	tree := func(id string) *analyze.Node {
+		if t, ok := trees[id]; ok {
+			return t
+		}
+		var t *analyze.Node
+		if output, ok := s.cache.get(id); ok {
+			t, _ = analyze.DecodeAST(output)
+		}
+		trees[id] = t
+		return t
+	}
+
+	resp := securityResponse{projectResult: result, Warnings: []securityWarning{}, ScanErrors: []scanError{}}
+	for _, found := range report.Warnings {
+		warning := securityWarning{
+			WarningType: found.WarningType,
+			CheckName:   found.CheckName,
+			Message:     found.Message,
+			Confidence:  found.Confidence,
+			Fingerprint: found.Fingerprint,
+			Link:        found.Link,
+			Code:        found.Code,
+			File:        path.Join(prefix, filepath.ToSlash(found.File)),
+		}
+		if found.Line != nil {
+			warning.Line = *found.Line
+		}
+		if f, ok := files[warning.File]; ok && f.ParseID != "" {
+			warning.ParseID = f.ParseID
+			if t := tree(f.ParseID); t != nil && warning.Line > 0 {
+				if n := analyze.OutermostNodeOn(t, warning.Line); n != nil {
+					summary := analyze.Summarize(n)
+					warning.Node = &summary
+				}
+			}
+		}
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+	for _, e := range report.Errors {
+		if rel, err := filepath.Rel(root, e.Location); err == nil && filepath.IsAbs(e.Location) {
+			e.Location = path.Join(prefix, filepath.ToSlash(rel))
+		}
+		resp.ScanErrors = append(resp.ScanErrors, e)
+	}
+
+	w.Header().Set("X-Parser", p.Name())
+	writeJSON(w, resp)
+}
@@ -0,0 +1,664 @@
+// Package httpapi serves the parser and analyze packages over HTTP, as the
+// Ruby AST Visualizer's API.
+package httpapi
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+type server struct {
+	cfg          *config
+	parsers      map[string]parser.Parser
+	lexers       map[string]parser.Parser
+	formatter    parser.Parser
+	unparser     parser.Parser
+	rbs          parser.Parser
+	rbsTypes     parser.Parser
+	partial      parser.Parser
+	cache        parseCache
+	storage      storage
+	history      storage
+	logins       storage
+	tokens       cipher.AEAD
+	pool         *parser.WorkerPool
+	toolchain    *toolchainCheck
+	versions     *rubyVersions
+	limiter      *rateLimiter
+	apiKeys      apiKeys
+	procs        *procLimiter
+	breakers     *breakers
+	rubies       map[string]map[string]parser.Parser
+	historyLocks sessionLocks
+	jobs         *jobs
+}
+
+// allowMethods rejects methods other than those listed. It returns false if
+// the request has been fully handled. CORS preflights never get this far;
+// see cors.
+func (s *server) allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	return false
+}
+
+// lookupParser returns the named backend, or the default one if name is
+// empty. It writes a 400 and returns false if there is no such backend.
+func (s *server) lookupParser(w http.ResponseWriter, name string) (parser.Parser, bool) {
+	if name == "" {
+		name = parser.DefaultParser
+	}
+	p, ok := s.parsers[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown parser")
+	}
+	return p, ok
+}
+
+// decodeRequest decodes the JSON body of r into v, enforcing the configured
+// size limit and converting a body in another encoding to UTF-8. It writes
+// the error response and returns false on failure.
+func (s *server) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	_, sp := telemetry.StartSpan(r.Context(), "decode request")
+	defer sp.End()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes))
+	sp.SetAttrs(telemetry.Attr("http.request.body.size", len(body)))
+	if err != nil {
+		sp.SetError(err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return false
+	}
+
+	// encoding/json silently replaces invalid UTF-8 with U+FFFD, which would
+	// shift every location the parser reports, so convert it up front or
+	// reject it if that can't be done.
+	if !utf8.Valid(body) {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		decoded, encoding, err := analyze.DecodeSource(body, params["charset"])
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, encodingErrorResponse("Request body is not valid UTF-8", err))
+			return false
+		}
+		w.Header().Set("X-Source-Encoding", encoding)
+		body = []byte(decoded)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		sp.SetError(err)
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	return true
+}
+
+// parseID identifies the result of parsing code with parser. It doubles as
+// the cache key, so clients can refer back to a recent parse by ID.
+func parseID(p parser.Parser, code string) string {
+	if v := parser.RubyVersionOf(p); v != "" {
+		return cacheKey(p.Name(), code, "ruby", v)
+	}
+	return cacheKey(p.Name(), code)
+}
+
+// run calls a backend with the configured timeout, bypassing the cache.
+func (s *server) run(ctx context.Context, p parser.Parser, input string) ([]byte, error) {
+	var output []byte
+	err := s.runWith(ctx, p, input, func(ctx context.Context) (err error) {
+		output, err = p.Parse(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+// runWith does the work of run for a backend call made by parse, so that
+// streamed parses are timed, traced and limited the same way.
+func (s *server) runWith(ctx context.Context, p parser.Parser, input string, parse func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	ctx, sp := telemetry.StartSpan(ctx, "run "+p.Name(), telemetry.Attr("parser", p.Name()), telemetry.Attr("code.size", len(input)))
+	defer sp.End()
+
+	if err := s.procs.acquire(ctx); err != nil {
+		sp.SetError(err)
+		return err
+	}
+	defer s.procs.release()
+
+	b := s.breakers.get(p)
+	if b != nil && !b.allow(time.Now()) {
+		sp.SetError(errCircuitOpen)
+		return errCircuitOpen
+	}
+
+	start := time.Now()
+	err := parse(ctx)
+	elapsed := time.Since(start)
+	if b != nil && b.record(err, time.Now()) {
+		circuitTrips.Inc(p.Name())
+		slog.WarnContext(ctx, "Backend keeps failing, opening its circuit", "parser", p.Name(), "cooldown", s.cfg.BreakerCooldown, "err", err)
+	}
+	sp.SetAttrs(telemetry.Attr("parse.result", parseResult(err)))
+	if !parser.IsSyntaxError(err) {
+		sp.SetError(err)
+	}
+	recordParse(ctx, p, input, elapsed)
+	parsesTotal.Inc(p.Name(), parseResult(err))
+	parseDuration.Observe(elapsed.Seconds(), p.Name())
+	return err
+}
+
+// parse runs code through parser, serving repeat parses from the cache.
+func (s *server) parse(ctx context.Context, p parser.Parser, code string) (output []byte, hit bool, err error) {
+	ctx, sp := telemetry.StartSpan(ctx, "parse", telemetry.Attr("parser", p.Name()))
+	defer sp.End()
+
+	key := parseID(p, code)
+	if output, ok := s.cache.get(key); ok {
+		sp.SetAttrs(telemetry.Attr("cache.hit", true))
+		cacheLookups.Inc("hit")
+		recordParse(ctx, p, code, 0)
+		return output, true, nil
+	}
+	sp.SetAttrs(telemetry.Attr("cache.hit", false))
+	cacheLookups.Inc("miss")
+
+	output, err = s.run(ctx, p, code)
+	if err != nil {
+		return nil, false, err
+	}
+	s.cache.add(key, output, []byte(code))
+	return output, false, nil
+}
+
+// parseTree parses code and decodes the result for endpoints that work on
+// the tree rather than the raw JSON. It writes the error response and
+// returns false on failure.
+func (s *server) parseTree(w http.ResponseWriter, r *http.Request, p parser.Parser, code string) (*analyze.Node, bool) {
+	output, _, err := s.parse(r.Context(), p, code)
+	if err != nil {
+		writeParseError(w, r, p, err)
+		return nil, false
+	}
+	return decodeOutput(w, output)
+}
+
+func decodeOutput(w http.ResponseWriter, output []byte) (*analyze.Node, bool) {
+	root, err := analyze.DecodeAST(output)
+	if err != nil {
+		slog.Error("Error decoding AST", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to decode AST")
+		return nil, false
+	}
+	return root, true
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// The response depends only on the code, parser and format, so weak and strong
+// tags are compared the same way.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type parseRequest struct {
+	Code        string `json:"code"`
+	Parser      string `json:"parser"`
+	Format      string `json:"format"`
+	RubyVersion string `json:"ruby_version"`
+	Template    string `json:"template"`
+	FileType    string `json:"filetype"`
+	Filename    string `json:"filename"`
+	analyze.FormatOptions
+}
+
+func (s *server) handleParse(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req parseRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = analyze.DefaultFormat
+	}
+	if !analyze.KnownFormat(req.Format) {
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+	if !checkFormatOptions(w, req.Format, req.FormatOptions) {
+		return
+	}
+
+	p, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	fileType, ok := resolveFileType(w, req.FileType, req.Filename)
+	if !ok {
+		return
+	}
+	// Only clients that already have a session, from GET /history or an
+	// API key, get their parses recorded.
+	session, _ := requestSession(r)
+	if req.Template == "" && fileType == analyze.FileTypeERB {
+		req.Template = "erb"
+	}
+	switch req.Template {
+	case "":
+	case "erb":
+		code, err := analyze.CompileERB(req.Code)
+		if err != nil {
+			writeParseError(w, r, p, err)
+			return
+		}
+		req.Code = code
+	default:
+		writeError(w, http.StatusBadRequest, "Unknown template language")
+		return
+	}
+	chain := s.fallbackChain(req.Parser)
+	if req.RubyVersion != "" {
+		if p, ok = s.lookupRubyVersion(w, p.Name(), req.RubyVersion); !ok {
+			return
+		}
+		chain = []parser.Parser{p}
+		w.Header().Set("X-Ruby-Version", parser.RubyVersionOf(p))
+	}
+
+	etagFor := func(p parser.Parser) string {
+		return `"` + cacheKey(p.Name(), parser.RubyVersionOf(p), req.Format, fmt.Sprint(req.FormatOptions), req.Code) + `"`
+	}
+	etag := etagFor(p)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Without a parser named, a failing backend hands over to the next one
+	// in fallbackOrder, and X-Parser says which one answered.
+	var (
+		output []byte
+		hit    bool
+		err    error
+	)
+	// Only the parser's own JSON is streamed. A normalized node's attributes
+	// come before its children, but the parser can write them after, as
+	// Prism does a call's name after its receiver, so the default shape is
+	// built whole first. Clients of very large trees ask for raw.
+	streamable := req.Format == analyze.DefaultFormat && req.Raw && !req.RewritesJSON()
+	for i, candidate := range chain {
+		if i > 0 {
+			slog.WarnContext(r.Context(), "Falling back to another parser", "from", p.Name(), "to", candidate.Name(), "err", err)
+			parseFallbacks.Inc(p.Name(), candidate.Name())
+			p, etag = candidate, etagFor(candidate)
+		}
+		if streamer, ok := p.(parser.StreamParser); ok && streamable {
+			if err = s.streamParse(w, r, streamer, req.Code, etag); err == nil {
+				s.recordHistory(r.Context(), session, p, req.Code)
+				return
+			}
+		} else if output, hit, err = s.parse(r.Context(), p, req.Code); err == nil {
+			break
+		}
+		if !shouldFallBack(err) {
+			break
+		}
+	}
+	if err != nil {
+		writeParseError(w, r, p, err)
+		return
+	}
+	s.recordHistory(r.Context(), session, p, req.Code)
+
+	req.Source = req.Code
+	_, renderSpan := telemetry.StartSpan(r.Context(), "render "+req.Format)
+	contentType, body, err := analyze.RenderFormat(req.Format, output, req.FormatOptions)
+	renderSpan.SetError(err)
+	renderSpan.End()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", req.Format, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render "+req.Format+" output")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Parser", p.Name())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Parse-ID", parseID(p, req.Code))
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	_, writeSpan := telemetry.StartSpan(r.Context(), "write response", telemetry.Attr("http.response.body.size", len(body)))
+	defer writeSpan.End()
+	if _, err := w.Write(body); err != nil {
+		writeSpan.SetError(err)
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
+
+// routes returns the API. Run mounts it under /api/v1/ with its JSON
+// responses enveloped, and without envelopes under /api/ and at the root for
+// clients from before the API was versioned.
+func (s *server) routes(wt *watcher, envelope bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	registered := make(map[string]bool)
+	for _, e := range s.endpoints(wt) {
+		handler := e.Handler
+		if envelope {
+			handler = withEnvelope(handler)
+		}
+		// A path parameter, as in /snippets/{id}, is the rest of the path.
+		// Paths under the same parameter, like /jobs/{id} and
+		// /jobs/{id}/events, share the first one's handler.
+		pattern, _, _ := strings.Cut(e.Path, "{")
+		if !registered[pattern] {
+			registered[pattern] = true
+			mux.HandleFunc(pattern, handler)
+		}
+	}
+	return mux
+}
+
+// apiRoute returns a function naming the API route a request is for, for
+// metrics and logs: the registered pattern, wherever the API is mounted and
+// whichever version, or "other" for the frontend and unknown paths.
+func apiRoute(api *http.ServeMux) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		u := *r.URL
+		if p := strings.TrimPrefix(u.Path, "/api/"+apiVersion); p != u.Path && strings.HasPrefix(p, "/") {
+			u.Path = p
+		} else {
+			u.Path = strings.TrimPrefix(u.Path, "/api")
+		}
+		if _, pattern := api.Handler(&http.Request{Method: r.Method, URL: &u, Host: r.Host}); pattern != "" {
+			return pattern
+		}
+		return "other"
+	}
+}
+
+// Run starts the server configured by the command-line flags in args and
+// serves until it is interrupted, exiting the process if it can't start.
+func Run(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(newLogger(cfg.LogFormat, cfg.LogLevel))
+	if cfg.OTLPEndpoint != "" {
+		telemetry.ActiveTracer = telemetry.NewTracer(cfg.OTLPEndpoint, cfg.ServiceName, version)
+		slog.Info("Exporting traces", "endpoint", telemetry.ActiveTracer.Endpoint)
+	}
+
+	// Listen first, so a socket passed in by systemd is taken over before
+	// any child process could inherit it.
+	var ln net.Listener
+	if cfg.LSP != "stdio" {
+		if ln, err = listen(cfg); err != nil {
+			fatal("Failed to listen", "err", err)
+		}
+	}
+
+	if err := preflight(cfg); err != nil {
+		if cfg.Strict {
+			fatal("Preflight failed", "err", err)
+		}
+		slog.Error("Preflight failed", "err", err)
+	}
+
+	var sb *parser.Sandbox
+	if cfg.Sandbox {
+		sb, err = parser.NewSandbox(parser.SandboxOptions{
+			CPU:            cfg.SandboxCPU,
+			MemoryMB:       cfg.SandboxMemoryMB,
+			FileSizeMB:     cfg.SandboxFileSizeMB,
+			IsolateNetwork: cfg.SandboxIsolateNetwork,
+			Wrapper:        cfg.SandboxWrapper,
+		})
+	}
+	if err != nil {
+		fatal("Failed to set up the parser sandbox", "err", err)
+	}
+	defer sb.Close()
+
+	pool, err := parser.NewWorkerPool(cfg.RubyBin, sb, cfg.Workers)
+	if err != nil {
+		fatal("Failed to start parser workers", "err", err)
+	}
+	defer pool.Close()
+	pool.RegisterMetrics()
+
+	s := &server{
+		cfg:       cfg,
+		parsers:   parser.NewParsers(cfg.RubyBin, sb, pool),
+		lexers:    parser.NewLexers(cfg.RubyBin, sb),
+		formatter: parser.NewFormatter(cfg.RubyBin, sb),
+		unparser:  parser.NewUnparser(cfg.RubyBin, sb),
+		rbs:       parser.NewRBSParser(cfg.RubyBin, sb),
+		rbsTypes:  parser.NewRBSMethodsParser(cfg.RubyBin, sb),
+		partial:   parser.NewPartialParser(cfg.RubyBin, sb),
+		pool:      pool,
+		procs:     newProcLimiter(cfg.MaxConcurrent, cfg.MaxQueued),
+		breakers:  newBreakers(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		rubies:    parser.NewRubyParsers(discoverRubies(cfg), sb),
+		toolchain: &toolchainCheck{rubyBin: cfg.RubyBin},
+		versions:  &rubyVersions{script: parser.NewVersionsParser(cfg.RubyBin)},
+	}
+	s.procs.registerMetrics()
+	if s.storage, err = openStorage(cfg); err != nil {
+		fatal("Failed to open storage", "err", err)
+	}
+	defer s.storage.close()
+	s.cache = newParseCache(cfg, s.storage)
+	s.history = sessionStorage(cfg, s.storage, cfg.HistoryTTL)
+	s.logins = sessionStorage(cfg, s.storage, cfg.GitHubLoginTTL)
+	if s.tokens, err = newTokenCipher(cfg); err != nil {
+		fatal("Failed to set up the GitHub token key", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Over stdio the editor has stdin and stdout, so nothing else is served.
+	if cfg.LSP == "stdio" {
+		done := make(chan error, 1)
+		go func() { done <- s.serveLSP(ctx, os.Stdin, os.Stdout) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				fatal("LSP session failed", "err", err)
+			}
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	if cfg.Jobs > 0 {
+		s.jobs = newJobs(ctx, cfg, s.storage)
+	}
+
+	var wt *watcher
+	if cfg.Watch != "" {
+		if info, err := os.Stat(cfg.Watch); err != nil || !info.IsDir() {
+			fatal("-watch is not a directory", "dir", cfg.Watch)
+		}
+		wt = newWatcher(s, cfg.Watch, cfg.WatchInterval)
+		go wt.run(ctx)
+		slog.Info("Watching for changes", "dir", cfg.Watch)
+	}
+
+	api := s.routes(wt, false)
+	route := apiRoute(api)
+	mux := http.NewServeMux()
+	mux.Handle("/api/"+apiVersion+"/", http.StripPrefix("/api/"+apiVersion, s.routes(wt, true)))
+	mux.Handle("/api/", http.StripPrefix("/api", api))
+	mux.Handle("/", withFrontend(api))
+
+	var handler http.Handler = mux
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+		go s.limiter.pruneLoop(ctx.Done())
+		handler = s.rateLimit(handler, route)
+	}
+	if s.apiKeys, err = loadAPIKeys(cfg); err != nil {
+		fatal("Failed to load API keys", "err", err)
+	}
+	if s.apiKeys != nil {
+		handler = s.requireAPIKey(handler, route)
+		slog.Info("Requiring API keys", "keys", len(s.apiKeys))
+	}
+	handler = transcode(handler)
+	if cfg.Compress {
+		handler = compress(handler)
+	}
+	handler = logRequests(traceRequests(s.cors(handler), route), route)
+	srv := &http.Server{Handler: handler}
+	tlsCfg, challenge, err := serverTLS(cfg)
+	if err != nil {
+		fatal("Failed to set up TLS", "err", err)
+	}
+	srv.TLSConfig = tlsCfg
+	var httpSrv *http.Server
+	if challenge != nil && cfg.HTTPAddr != "" {
+		httpSrv = &http.Server{Addr: cfg.HTTPAddr, Handler: challenge}
+		go func() {
+			slog.Info("HTTP server starting", "addr", cfg.HTTPAddr)
+			if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("HTTP server failed", "err", err)
+			}
+		}()
+	}
+
+	// The diagnostic endpoints are only served when asked for: on the main
+	// listener behind -admin-token, and/or on a private -admin-addr.
+	var admin http.Handler = s.adminRoutes()
+	if cfg.AdminToken != "" {
+		admin = s.requireAdmin(admin)
+		mux.Handle("/debug/", admin)
+	}
+	var adminSrv *http.Server
+	if cfg.AdminAddr != "" {
+		adminSrv = &http.Server{Addr: cfg.AdminAddr, Handler: admin}
+		go func() {
+			slog.Info("Admin server starting", "addr", cfg.AdminAddr)
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Admin server failed", "err", err)
+			}
+		}()
+	}
+
+	// gRPC gets a listener of its own: without TLS its clients speak HTTP/2
+	// from the first byte, which the main listener doesn't.
+	var grpcSrv *http.Server
+	if cfg.GRPCAddr != "" {
+		var grpcHandler http.Handler = s.grpcHandler()
+		if s.apiKeys != nil {
+			grpcHandler = s.requireAPIKey(grpcHandler, s.grpcRoute)
+		}
+		grpcSrv = &http.Server{
+			Addr:      cfg.GRPCAddr,
+			Handler:   logRequests(traceRequests(grpcHandler, s.grpcRoute), s.grpcRoute),
+			TLSConfig: tlsCfg,
+			Protocols: new(http.Protocols),
+		}
+		grpcSrv.Protocols.SetHTTP2(true)
+		grpcSrv.Protocols.SetUnencryptedHTTP2(true)
+		go func() {
+			slog.Info("gRPC server starting", "addr", cfg.GRPCAddr, "tls", tlsCfg != nil)
+			serve := grpcSrv.ListenAndServe
+			if tlsCfg != nil {
+				serve = func() error { return grpcSrv.ListenAndServeTLS("", "") }
+			}
+			if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("gRPC server failed", "err", err)
+			}
+		}()
+	}
+
+	if cfg.LSP != "" {
+		lspLn, err := net.Listen("tcp", cfg.LSP)
+		if err != nil {
+			fatal("Failed to listen for LSP", "addr", cfg.LSP, "err", err)
+		}
+		defer lspLn.Close()
+		go func() {
+			slog.Info("LSP server starting", "addr", cfg.LSP)
+			if err := s.serveLSPListener(ctx, lspLn); err != nil && !errors.Is(err, net.ErrClosed) {
+				fatal("LSP server failed", "err", err)
+			}
+		}()
+	}
+
+	go func() {
+		slog.Info("Server starting", "addr", ln.Addr().String(), "tls", srv.TLSConfig != nil)
+		serve := func() error { return srv.Serve(ln) }
+		if srv.TLSConfig != nil {
+			serve = func() error { return srv.ServeTLS(ln, "", "") }
+		}
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server failed", "err", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down, draining connections")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error during shutdown", "err", err)
+	}
+	if adminSrv != nil {
+		adminSrv.Shutdown(shutdownCtx)
+	}
+	if grpcSrv != nil {
+		grpcSrv.Shutdown(shutdownCtx)
+	}
+	if httpSrv != nil {
+		httpSrv.Shutdown(shutdownCtx)
+	}
+	telemetry.ActiveTracer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// sessionCookie holds the random ID of a client that didn't send an API key.
+const sessionCookie = "ruby_ast_session"
+
+// session returns the ID of the client a request is from, for state kept
+// per client such as parse history. A client sending an API key is that
+// key's session, wherever it connects from; anyone else gets a random ID in
+// a cookie, set on w the first time, so call this before writing the body.
+func (s *server) session(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := requestSession(r); ok {
+		return id
+	}
+	return s.newSession(w, r, s.cfg.HistoryTTL)
+}
+
+// newSession gives the client a new random session ID in a cookie lasting
+// maxAge, in place of any it had.
+func (s *server) newSession(w http.ResponseWriter, r *http.Request, maxAge time.Duration) string {
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// requestSession returns the session a request already has, if any.
+func requestSession(r *http.Request) (string, bool) {
+	if key := apiKeyFrom(r.Context()); key != nil {
+		return cacheKey("api-key", key.name)[:32], true
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && validSessionID(c.Value) {
+		return c.Value, true
+	}
+	return "", false
+}
+
+// validSessionID reports whether id is a session ID this server could have
+// made, so a forged cookie can't name other keys in storage.
+func validSessionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
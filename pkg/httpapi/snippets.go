@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// snippet is shared code with the tree it parsed to, kept so a link to it
+// shows the same tree later. AST is in the parser's own shape.
+type snippet struct {
+	ID        string          `json:"id"`
+	Code      string          `json:"code"`
+	Parser    string          `json:"parser"`
+	AST       json.RawMessage `json:"ast"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// snippetID names the snippet of code as parsed by parserName: the same
+// code shared twice gets the same short link.
+func snippetID(parserName, code string) string {
+	sum, _ := hex.DecodeString(cacheKey(parserName, code)[:18])
+	return base64.RawURLEncoding.EncodeToString(sum)
+}
+
+// validSnippetID reports whether id could have come from snippetID, so
+// nothing else is looked up in storage.
+func validSnippetID(id string) bool {
+	_, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(id) == base64.RawURLEncoding.EncodedLen(9)
+}
+
+type snippetCreated struct {
+	ID string `json:"id"`
+}
+
+// handleCreateSnippet parses the posted code and stores it with its tree,
+// answering with the ID to fetch both back by.
+func (s *server) handleCreateSnippet(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req codeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	p, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	output, _, err := s.parse(r.Context(), p, req.Code)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		writeParseError(w, r, p, err)
+		return
+	}
+
+	sn, err := s.saveSnippet(r.Context(), p, req.Code, output)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving snippet", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to save snippet")
+		return
+	}
+
+	w.Header().Set("X-Parser", p.Name())
+	w.Header().Set("Location", "snippets/"+sn.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(snippetCreated{sn.ID}); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+// saveSnippet stores code with the tree p parsed it to, as output.
+func (s *server) saveSnippet(ctx context.Context, p parser.Parser, code string, output []byte) (*snippet, error) {
+	sn := &snippet{
+		ID:        snippetID(p.Name(), code),
+		Code:      code,
+		Parser:    p.Name(),
+		AST:       output,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	data, err := json.Marshal(sn)
+	if err != nil {
+		return nil, err
+	}
+	return sn, s.storage.put(ctx, "snippets/"+sn.ID, data, 0)
+}
+
+// handleGetSnippet serves a shared snippet with its tree normalized, as
+// /parse returns it, or in the parser's own shape with raw=1.
+func (s *server) handleGetSnippet(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	sn, ok := s.loadSnippet(w, r, strings.TrimPrefix(r.URL.Path, "/snippets/"))
+	if !ok {
+		return
+	}
+
+	raw, _ := strconv.ParseBool(r.URL.Query().Get("raw"))
+	_, body, err := analyze.RenderFormat(analyze.DefaultFormat, sn.AST, analyze.FormatOptions{Raw: raw})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering snippet", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render snippet")
+		return
+	}
+	sn.AST = body
+	w.Header().Set("X-Parser", sn.Parser)
+	writeJSON(w, sn)
+}
+
+// loadSnippet fetches a shared snippet from storage. It returns false if
+// the request has been fully handled.
+func (s *server) loadSnippet(w http.ResponseWriter, r *http.Request, id string) (*snippet, bool) {
+	sn, err := s.getSnippet(r.Context(), id)
+	if errors.Is(err, errNotStored) {
+		writeError(w, http.StatusNotFound, "Unknown snippet")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading snippet", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load snippet")
+		return nil, false
+	}
+	return sn, true
+}
+
+// getSnippet fetches a shared snippet, or errNotStored if there is none by
+// that ID.
+func (s *server) getSnippet(ctx context.Context, id string) (*snippet, error) {
+	if !validSnippetID(id) {
+		return nil, errNotStored
+	}
+	data, err := s.storage.get(ctx, "snippets/"+id)
+	if err != nil {
+		return nil, err
+	}
+	var sn snippet
+	if err := json.Unmarshal(data, &sn); err != nil {
+		return nil, err
+	}
+	return &sn, nil
+}
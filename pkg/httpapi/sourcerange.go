@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// defaultContextLines is how many lines /source-range gives on either side
+// of a node unless asked.
+const defaultContextLines = 2
+
+const maxContextLines = 100
+
+type sourceRangeResponse struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	analyze.SourceSlice
+}
+
+// handleSourceRange serves GET /source-range?id=...&path=..., the source
+// text of a node from a recent parse with its byte offsets, worked out here
+// from the code as parsed so multibyte characters can't throw them off.
+func (s *server) handleSourceRange(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	context := defaultContextLines
+	if value := query.Get("context"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxContextLines {
+			writeError(w, http.StatusBadRequest, "Invalid context")
+			return
+		}
+		context = n
+	}
+	id := query.Get("id")
+	output, ok := s.cache.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown or expired parse ID")
+		return
+	}
+	code, ok := s.cache.source(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "The source of that parse is no longer kept; parse it again")
+		return
+	}
+	root, ok := decodeOutput(w, output)
+	if !ok {
+		return
+	}
+
+	path := query.Get("path")
+	node, ok := analyze.LookupPath(root, path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No node at that path")
+		return
+	}
+	slice, ok := analyze.SliceSource(node, string(code), context)
+	if !ok {
+		writeError(w, http.StatusNotFound, "That node has no source range")
+		return
+	}
+	writeJSON(w, sourceRangeResponse{path, node.Type, slice})
+}
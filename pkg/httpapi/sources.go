@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// sourceFile is one file of a multi-file analysis, given by its code or by
+// the parse ID /parse/project returned for it.
+type sourceFile struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	ParseID string `json:"parse_id"`
+}
+
+type fileError struct {
+	Path  string        `json:"path"`
+	Error errorResponse `json:"error"`
+}
+
+// parseSourceFiles parses the files of a multi-file request concurrently, or
+// code alone if no files are given. A file that fails is reported in the
+// errors and left out rather than failing the request, but a failure in code
+// alone has already been written to w and ok is false.
+func (s *server) parseSourceFiles(w http.ResponseWriter, r *http.Request, p parser.Parser, code string, files []sourceFile) (parsed []analyze.ParsedFile, errs []fileError, ok bool) {
+	errs = []fileError{}
+	if len(files) == 0 {
+		root, ok := s.parseTree(w, r, p, code)
+		if !ok {
+			return nil, nil, false
+		}
+		return []analyze.ParsedFile{{Root: root}}, errs, true
+	}
+	if len(files) > maxProjectFiles {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d files per request", maxProjectFiles))
+		return nil, nil, false
+	}
+
+	roots := make([]*analyze.Node, len(files))
+	failures := make([]*errorResponse, len(files))
+	sem := make(chan struct{}, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for i, f := range files {
+		wg.Add(1)
+		go func(i int, path, code, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			output, ok := s.cache.get(id)
+			if id != "" && !ok {
+				failures[i] = &errorResponse{Error: "Unknown or expired parse ID"}
+				return
+			}
+			if id == "" {
+				if analyze.DetectFileType(path) == analyze.FileTypeERB {
+					var err error
+					if code, err = analyze.CompileERB(code); err != nil {
+						_, resp := parseErrorResponse(r.Context(), p, err)
+						failures[i] = &resp
+						return
+					}
+				}
+				var err error
+				if output, _, err = s.parse(r.Context(), p, code); err != nil {
+					_, resp := parseErrorResponse(r.Context(), p, err)
+					failures[i] = &resp
+					return
+				}
+			}
+			root, err := analyze.DecodeAST(output)
+			if err != nil {
+				failures[i] = &errorResponse{Error: "Failed to decode AST"}
+				return
+			}
+			roots[i] = root
+		}(i, f.Path, f.Code, f.ParseID)
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		return nil, nil, false
+	}
+	for i, f := range files {
+		if failures[i] != nil {
+			errs = append(errs, fileError{Path: f.Path, Error: *failures[i]})
+		} else {
+			parsed = append(parsed, analyze.ParsedFile{Path: f.Path, Root: roots[i]})
+		}
+	}
+	return parsed, errs, true
+}
@@ -0,0 +1,17 @@
+//go:build sqlite
+
+package httpapi
+
+import (
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteDriver = "sqlite"
+
+// sqliteDSN opens path in WAL mode, so snippets can be read while one is
+// being saved, and has writers wait for each other rather than fail.
+func sqliteDSN(path string) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+}
@@ -0,0 +1,11 @@
+//go:build !sqlite
+
+package httpapi
+
+// Without the sqlite tag the binary doesn't depend on a SQLite driver, and
+// snippets are only kept in memory.
+const sqliteDriver = ""
+
+func sqliteDSN(path string) string {
+	return path
+}
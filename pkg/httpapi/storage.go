@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// storage keeps the state replicas of the server can share: shared
+// snippets, parse history, and parse results behind each replica's own
+// cache. Keys are paths such as snippets/<id>. A value put with a zero ttl is kept for
+// good; get returns errNotStored for a key that is missing or has expired.
+type storage interface {
+	get(ctx context.Context, key string) ([]byte, error)
+	put(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	close() error
+}
+
+var errNotStored = errors.New("not stored")
+
+// openStorage opens the backend -storage names: memory, which keeps the
+// -storage-memory most recently used snippets in this process;
+// sqlite:<path>; file:<dir>; or a redis:// or rediss:// URL.
+func openStorage(cfg *config) (storage, error) {
+	scheme, rest, _ := strings.Cut(cfg.Storage, ":")
+	switch scheme {
+	case "memory":
+		return &memoryStorage{cache: newLRUCache(cfg.StorageMemory, 0)}, nil
+	case "sqlite":
+		return openSQLStorage(rest)
+	case "file":
+		return openFileStorage(rest)
+	case "redis", "rediss":
+		return openRedisStorage(cfg.Storage)
+	}
+	return nil, fmt.Errorf("-storage: unknown backend %q", scheme)
+}
+
+// memoryStorage keeps values in an lruCache, so the least recently used
+// ones go first once it's full. It ignores ttl.
+type memoryStorage struct {
+	cache *lruCache
+}
+
+func (m *memoryStorage) get(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := m.cache.get(key); ok {
+		return value, nil
+	}
+	return nil, errNotStored
+}
+
+func (m *memoryStorage) put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.cache.add(key, value, nil)
+	return nil
+}
+
+func (m *memoryStorage) close() error {
+	return nil
+}
+
+// sessionStorage returns where per-session state such as history is kept.
+// Shared backends hold it alongside everything else, expiring with its ttl;
+// in memory it gets an LRU of its own, of -storage-sessions entries, so
+// sessions don't push out snippets or each other's kinds of state.
+func sessionStorage(cfg *config, st storage, ttl time.Duration) storage {
+	if _, ok := st.(*memoryStorage); ok {
+		return &memoryStorage{cache: newLRUCache(cfg.StorageSessions, ttl)}
+	}
+	return st
+}
+
+// parseCache holds parse output by parseID, with the source for
+// /source-range and /parse/edit. The lruCache is one; with shared storage,
+// a sharedCache is.
+type parseCache interface {
+	get(key string) ([]byte, bool)
+	source(key string) ([]byte, bool)
+	add(key string, value, source []byte)
+	stats() (entries, bytes int)
+}
+
+// sharedStorageTimeout bounds a parse cache lookup in shared storage, so a
+// slow backend costs a miss rather than stalling the parse.
+const sharedStorageTimeout = time.Second
+
+// sharedCache puts shared storage behind this replica's own lruCache, so a
+// parse any replica has done is a hit here, and so are parse IDs it handed
+// out. Storage errors count as misses. stats describes the local cache.
+type sharedCache struct {
+	*lruCache
+	shared storage
+	ttl    time.Duration
+}
+
+func (c *sharedCache) get(key string) ([]byte, bool) {
+	if value, ok := c.lruCache.get(key); ok {
+		return value, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStorageTimeout)
+	defer cancel()
+	value, err := c.shared.get(ctx, "parses/"+key)
+	if err != nil {
+		if !errors.Is(err, errNotStored) {
+			slog.Warn("Error reading the shared parse cache", "err", err)
+		}
+		return nil, false
+	}
+	c.lruCache.add(key, value, nil)
+	return value, true
+}
+
+// source is only looked for in shared storage while the parse is there
+// too, so the two stay together as in the lruCache.
+func (c *sharedCache) source(key string) ([]byte, bool) {
+	if source, ok := c.lruCache.source(key); ok {
+		return source, true
+	}
+	value, ok := c.get(key)
+	if !ok {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStorageTimeout)
+	defer cancel()
+	source, err := c.shared.get(ctx, "sources/"+key)
+	if err != nil {
+		if !errors.Is(err, errNotStored) {
+			slog.Warn("Error reading the shared parse cache", "err", err)
+		}
+		return nil, false
+	}
+	c.lruCache.add(key, value, source)
+	return source, true
+}
+
+func (c *sharedCache) add(key string, value, source []byte) {
+	c.lruCache.add(key, value, source)
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStorageTimeout)
+	defer cancel()
+	err := c.shared.put(ctx, "parses/"+key, value, c.ttl)
+	if err == nil && source != nil {
+		err = c.shared.put(ctx, "sources/"+key, source, c.ttl)
+	}
+	if err != nil {
+		slog.Warn("Error writing the shared parse cache", "err", err)
+	}
+}
+
+// newParseCache returns the parse cache for cfg: an lruCache, backed by st
+// unless that is memory storage, which has nothing to share.
+func newParseCache(cfg *config, st storage) parseCache {
+	local := newLRUCache(cfg.CacheSize, cfg.CacheTTL)
+	if _, ok := st.(*memoryStorage); ok || cfg.CacheSize <= 0 {
+		return local
+	}
+	return &sharedCache{lruCache: local, shared: st, ttl: cfg.CacheTTL}
+}
@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileStorage keeps each value in a file under dir named by its key, which
+// several replicas can share over a network filesystem. A file starts with
+// its expiry in Unix milliseconds as 8 big-endian bytes, 0 for none, and is
+// written to a temporary file and renamed so readers never see half of it.
+type fileStorage struct {
+	dir  string
+	quit chan struct{}
+}
+
+func openFileStorage(dir string) (*fileStorage, error) {
+	if dir == "" {
+		return nil, errors.New("-storage file: needs a directory, as in file:/var/lib/ruby-ast")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &fileStorage{dir: dir, quit: make(chan struct{})}
+	go s.pruneLoop()
+	return s, nil
+}
+
+func (s *fileStorage) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *fileStorage) get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%s: truncated", path)
+	}
+	if expired(data) {
+		return nil, errNotStored
+	}
+	return data[8:], nil
+}
+
+func expired(data []byte) bool {
+	expires := int64(binary.BigEndian.Uint64(data))
+	return expires != 0 && expires < time.Now().UnixMilli()
+}
+
+func (s *fileStorage) put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	var header [8]byte
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(header[:], uint64(time.Now().Add(ttl).UnixMilli()))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(header[:], value...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileStorage) pruneLoop() {
+	ticker := time.NewTicker(storagePruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.prune()
+		}
+	}
+}
+
+// prune deletes expired files. Only the header is read, so large values
+// cost no more than small ones.
+func (s *fileStorage) prune() {
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		var header [8]byte
+		_, err = io.ReadFull(f, header[:])
+		f.Close()
+		if err == nil && expired(header[:]) {
+			os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Error deleting expired values from storage", "err", err)
+	}
+}
+
+func (s *fileStorage) close() error {
+	close(s.quit)
+	return nil
+}
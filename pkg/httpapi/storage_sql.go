@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const storageSchema = `CREATE TABLE IF NOT EXISTS storage (
+	key        TEXT PRIMARY KEY,
+	value      BLOB NOT NULL,
+	expires_at INTEGER NOT NULL
+)`
+
+// storagePruneInterval is how often the SQLite and file backends delete
+// expired values, which are otherwise only skipped.
+const storagePruneInterval = 10 * time.Minute
+
+// sqlStorage keeps values in a SQLite database. expires_at is in Unix
+// milliseconds, or 0 for values kept for good.
+type sqlStorage struct {
+	db   *sql.DB
+	quit chan struct{}
+}
+
+func openSQLStorage(path string) (*sqlStorage, error) {
+	if sqliteDriver == "" {
+		return nil, errors.New("-storage sqlite: needs a binary built with -tags sqlite")
+	}
+	db, err := sql.Open(sqliteDriver, sqliteDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storageSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating the storage table: %w", err)
+	}
+	s := &sqlStorage{db: db, quit: make(chan struct{})}
+	go s.pruneLoop()
+	return s, nil
+}
+
+func (s *sqlStorage) get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	var expires int64
+	err := s.db.QueryRowContext(ctx, `SELECT value, expires_at FROM storage WHERE key = ?`, key).Scan(&value, &expires)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && expires != 0 && expires < time.Now().UnixMilli() {
+		return nil, errNotStored
+	}
+	return value, err
+}
+
+func (s *sqlStorage) put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixMilli()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO storage (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, value, expires)
+	return err
+}
+
+func (s *sqlStorage) pruneLoop() {
+	ticker := time.NewTicker(storagePruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			_, err := s.db.Exec(`DELETE FROM storage WHERE expires_at != 0 AND expires_at < ?`, time.Now().UnixMilli())
+			if err != nil {
+				slog.Warn("Error deleting expired values from storage", "err", err)
+			}
+		}
+	}
+}
+
+func (s *sqlStorage) close() error {
+	close(s.quit)
+	return s.db.Close()
+}
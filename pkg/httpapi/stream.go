@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// streamCacheLimit is the largest streamed AST also kept in the cache.
+// Holding on to bigger ones would give back the memory streaming saves.
+const streamCacheLimit = 4 << 20
+
+// responseStream passes parser output straight through to the client. The
+// headers are held back until the first byte, so a parse that fails before
+// producing any output can still get a proper error response.
+type responseStream struct {
+	w       http.ResponseWriter
+	start   func()
+	started bool
+
+	// buf keeps a copy of the output for the cache until it outgrows
+	// streamCacheLimit.
+	buf      []byte
+	overflow bool
+}
+
+func (rs *responseStream) Write(b []byte) (int, error) {
+	if !rs.started {
+		rs.started = true
+		rs.start()
+	}
+	if !rs.overflow {
+		if len(rs.buf)+len(b) > streamCacheLimit {
+			rs.overflow = true
+			rs.buf = nil
+		} else {
+			rs.buf = append(rs.buf, b...)
+		}
+	}
+	return rs.w.Write(b)
+}
+
+// streamParse answers a plain JSON /parse by copying the backend's output to
+// the response as it arrives, rather than buffering the whole AST first.
+// If the backend fails before any output it returns the error for the caller
+// to report; after output has started, the connection is cut so the client
+// doesn't take a truncated tree for a whole one.
+func (s *server) streamParse(w http.ResponseWriter, r *http.Request, p parser.StreamParser, code, etag string) error {
+	ctx, sp := telemetry.StartSpan(r.Context(), "parse", telemetry.Attr("parser", p.Name()), telemetry.Attr("stream", true))
+	defer sp.End()
+
+	setHeaders := func(hit bool) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Parser", p.Name())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Parse-ID", parseID(p, code))
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	key := parseID(p, code)
+	if output, ok := s.cache.get(key); ok {
+		sp.SetAttrs(telemetry.Attr("cache.hit", true))
+		cacheLookups.Inc("hit")
+		recordParse(ctx, p, code, 0)
+		setHeaders(true)
+		if _, err := w.Write(output); err != nil {
+			slog.ErrorContext(ctx, "Error writing response", "err", err)
+		}
+		return nil
+	}
+	sp.SetAttrs(telemetry.Attr("cache.hit", false))
+	cacheLookups.Inc("miss")
+
+	stream := &responseStream{w: w, start: func() { setHeaders(false) }}
+	err := s.runWith(ctx, p, code, func(ctx context.Context) error {
+		return p.ParseTo(ctx, code, stream)
+	})
+	switch {
+	case err == nil:
+		if !stream.started {
+			setHeaders(false)
+		}
+		if !stream.overflow {
+			s.cache.add(key, stream.buf, []byte(code))
+		}
+	case !stream.started:
+		return err
+	default:
+		if r.Context().Err() == nil {
+			slog.ErrorContext(ctx, "Error streaming AST", "parser", p.Name(), "err", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// handleSubtree serves GET /subtree?id=...&path=..., returning just the node
+// at path from a recent parse, so the frontend can load large trees a
+// branch at a time. The optional max_depth and max_nodes parameters prune
+// the branch the same way they prune /parse output, and raw=1 returns it in
+// the parser's own shape as raw does there.
+func (s *server) handleSubtree(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	output, ok := s.cache.get(query.Get("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown or expired parse ID")
+		return
+	}
+	root, ok := decodeOutput(w, output)
+	if !ok {
+		return
+	}
+
+	path := query.Get("path")
+	node, ok := analyze.LookupPath(root, path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No node at that path")
+		return
+	}
+
+	var limits [2]int
+	for i, name := range []string{"max_depth", "max_nodes"} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "Invalid "+name)
+				return
+			}
+			limits[i] = n
+		}
+	}
+	node = analyze.PruneTree(node, path, limits[0], limits[1])
+	if raw, _ := strconv.ParseBool(query.Get("raw")); raw {
+		writeJSON(w, node)
+		return
+	}
+	writeJSON(w, analyze.NormalizeNode(node, ""))
+}
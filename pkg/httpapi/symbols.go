@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type symbolsResponse struct {
+	Symbols []*analyze.Symbol `json:"symbols"`
+}
+
+// handleSymbols returns the classes, modules, constants and methods a snippet
+// defines, with their fully qualified names and where each is defined.
+func (s *server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req codeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	parser, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+	root, ok := s.parseTree(w, r, parser, req.Code)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("X-Parser", parser.Name())
+	writeJSON(w, symbolsResponse{analyze.FindSymbols(root)})
+}
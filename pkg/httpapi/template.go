@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+type templateResult struct {
+	Kind     string           `json:"kind"`
+	Code     string           `json:"code"`
+	Location analyze.Location `json:"location"`
+	AST      *analyze.Node    `json:"ast,omitempty"`
+	Error    *errorResponse   `json:"error,omitempty"`
+}
+
+type templateRequest struct {
+	Code     string `json:"code"`
+	Language string `json:"language"`
+	Parser   string `json:"parser"`
+}
+
+type templateResponse struct {
+	Language    string           `json:"language"`
+	Expressions []templateResult `json:"expressions"`
+}
+
+// handleTemplate extracts the Ruby embedded in a Haml or Slim template and
+// parses each expression on its own, returning one AST per expression with
+// its locations mapped back into the template.
+func (s *server) handleTemplate(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req templateRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	extract, ok := analyze.TemplateExtractors[req.Language]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown template language")
+		return
+	}
+	p, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	exprs, err := extract(req.Code)
+	if err != nil {
+		writeParseError(w, r, p, err)
+		return
+	}
+	if len(exprs) > analyze.MaxTemplateExpressions {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Templates may embed at most %d Ruby expressions", analyze.MaxTemplateExpressions))
+		return
+	}
+
+	results := make([]templateResult, len(exprs))
+	sem := make(chan struct{}, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for i, e := range exprs {
+		wg.Add(1)
+		go func(i int, e analyze.TemplateExpr) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			prefix, suffix := analyze.WrapExpr(e)
+			m := analyze.NewExprMapping(req.Code, e, prefix)
+			startLine, _, startChar := m.Point(m.PrefixChars)
+			endLine, _, endChar := m.Point(m.PrefixChars + m.CodeChars)
+			result := templateResult{Kind: e.Kind, Code: e.Code, Location: analyze.Location{StartLine: startLine, StartChar: startChar, EndLine: endLine, EndChar: endChar}}
+			defer func() { results[i] = result }()
+
+			snippet := prefix + e.Code + suffix
+			output, _, err := s.parse(r.Context(), p, snippet)
+			var se *parser.SyntaxError
+			if errors.As(err, &se) {
+				err = m.RemapError(se, snippet)
+			}
+			if err != nil {
+				_, resp := parseErrorResponse(r.Context(), p, err)
+				result.Error = &resp
+				return
+			}
+			root, err := analyze.DecodeAST(output)
+			if err != nil {
+				result.Error = &errorResponse{Error: "The " + p.Name() + " parser's output has no locations to map", Parser: p.Name()}
+				return
+			}
+			m.Remap(root)
+			result.AST = root
+		}(i, e)
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return
+	}
+	w.Header().Set("X-Parser", p.Name())
+	writeJSON(w, templateResponse{req.Language, results})
+}
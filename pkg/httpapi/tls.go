@@ -0,0 +1,27 @@
+package httpapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// serverTLS returns the TLS configuration for the main listener, or nil to
+// serve plain HTTP. In -autocert-domain mode it also returns the handler for
+// the -http-addr listener, which answers ACME HTTP-01 challenges and
+// redirects everything else to https.
+func serverTLS(cfg *config) (*tls.Config, http.Handler, error) {
+	switch {
+	case cfg.TLSCert != "":
+		// Load the pair now, so a bad path fails at startup rather than on
+		// the first handshake.
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading -tls-cert and -tls-key: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	case len(cfg.AutocertDomains) > 0:
+		return newAutocert(cfg)
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+const defaultLexer = "ripper"
+
+type tokensRequest struct {
+	Code  string `json:"code"`
+	Lexer string `json:"lexer"`
+}
+
+// handleTokens returns the token stream for the posted code as produced by
+// the requested lexer: each token's type, text, lexer state and location.
+func (s *server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req tokensRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Lexer == "" {
+		req.Lexer = defaultLexer
+	}
+	lexer, ok := s.lexers[req.Lexer]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown lexer")
+		return
+	}
+
+	output, err := s.run(r.Context(), lexer, req.Code)
+	if err != nil {
+		writeParseError(w, r, lexer, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Lexer", lexer.Name())
+	if _, err := w.Write(output); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+)
+
+// traceRequests wraps each request in a server span, continuing the
+// caller's trace if it sent a traceparent header.
+func traceRequests(next http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if telemetry.ActiveTracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		traceID, parentID, _ := telemetry.ParseTraceparent(r.Header.Get("traceparent"))
+		name := route(r)
+		ctx, sp := telemetry.ActiveTracer.Start(r.Context(), r.Method+" "+name, telemetry.SpanKindServer, traceID, parentID, []telemetry.SpanAttr{
+			telemetry.Attr("http.request.method", r.Method),
+			telemetry.Attr("http.route", name),
+			telemetry.Attr("url.path", r.URL.Path),
+		})
+		defer sp.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		sp.SetAttrs(telemetry.Attr("http.response.status_code", status))
+		if status >= 500 {
+			sp.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+		}
+	})
+}
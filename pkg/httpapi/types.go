@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+// typesRequest is code to annotate with types, and RBS files by name to
+// take them from, alongside any sigs in the code.
+type typesRequest struct {
+	Code   string            `json:"code"`
+	Parser string            `json:"parser"`
+	RBS    map[string]string `json:"rbs"`
+	analyze.FormatOptions
+}
+
+// typesResponse is the tree with a "types" field on each def that has
+// them. Unmatched lists the RBS methods no def was found for.
+type typesResponse struct {
+	AST       json.RawMessage       `json:"ast"`
+	Typed     []analyze.TypedMethod `json:"typed"`
+	Unmatched []string              `json:"unmatched"`
+}
+
+// handleTypes overlays declared parameter and return types, from RBS files
+// and Sorbet sigs, on the methods in the tree, so they can be shown on the
+// def nodes.
+func (s *server) handleTypes(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req typesRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if !checkFormatOptions(w, analyze.DefaultFormat, req.FormatOptions) {
+		return
+	}
+	p, ok := s.lookupParser(w, req.Parser)
+	if !ok {
+		return
+	}
+
+	files := make([]string, 0, len(req.RBS))
+	for name := range req.RBS {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	var signatures []analyze.MethodSignature
+	for _, name := range files {
+		output, _, err := s.parse(r.Context(), s.rbsTypes, req.RBS[name])
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			status, resp := parseErrorResponse(r.Context(), s.rbsTypes, err)
+			resp.Input = name
+			writeErrorResponse(w, status, resp)
+			return
+		}
+		methods, err := analyze.DecodeMethodSignatures(output)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error decoding RBS signatures", "file", name, "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to read RBS signatures")
+			return
+		}
+		signatures = append(signatures, methods...)
+	}
+
+	root, ok := s.parseTree(w, r, p, req.Code)
+	if !ok {
+		return
+	}
+	resp := typesResponse{Typed: []analyze.TypedMethod{}, Unmatched: []string{}}
+	typed, unmatched := analyze.AttachTypes(root, req.Code, signatures)
+	resp.Typed = append(resp.Typed, typed...)
+	resp.Unmatched = append(resp.Unmatched, unmatched...)
+
+	output, err := json.Marshal(root)
+	if err == nil {
+		req.Source = req.Code
+		_, resp.AST, err = analyze.RenderFormat(analyze.DefaultFormat, output, req.FormatOptions)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering output", "format", analyze.DefaultFormat, "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to render json output")
+		return
+	}
+	w.Header().Set("X-Parser", p.Name())
+	writeJSON(w, resp)
+}
@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+)
+
+type unparseRequest struct {
+	AST json.RawMessage `json:"ast"`
+}
+
+type unparseResponse struct {
+	Code string `json:"code"`
+}
+
+// handleUnparse turns an (optionally edited) stree AST back into Ruby
+// source, so trees can be round-tripped through /parse.
+func (s *server) handleUnparse(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req unparseRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if _, err := analyze.DecodeAST(req.AST); err != nil {
+		writeError(w, http.StatusBadRequest, "ast must be a JSON object")
+		return
+	}
+
+	output, err := s.run(r.Context(), s.unparser, string(req.AST))
+	if err != nil {
+		writeParseError(w, r, s.unparser, err)
+		return
+	}
+
+	writeJSON(w, unparseResponse{string(output)})
+}
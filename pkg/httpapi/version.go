@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	visualizer "github.com/ghousemohamed/ruby-ast-visualizer"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// version is the release this binary was built from, set at build time with
+// -ldflags "-X github.com/ghousemohamed/ruby-ast-visualizer/pkg/httpapi.version=v1.2.3".
+var version = "dev"
+
+// buildCommit returns the VCS revision Go stamped into the binary, if any.
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// rubyVersions asks Ruby for its version and those of the parser gems. The
+// answer is kept once Ruby has given one, since it only changes when the
+// server is redeployed.
+type rubyVersions struct {
+	script parser.Parser
+
+	mu       sync.Mutex
+	versions map[string]*string
+}
+
+func (v *rubyVersions) get(ctx context.Context) (map[string]*string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.versions != nil {
+		return v.versions, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, toolchainCheckTimeout)
+	defer cancel()
+	output, err := v.script.Parse(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var versions map[string]*string
+	if err := json.Unmarshal(output, &versions); err != nil {
+		return nil, err
+	}
+	v.versions = versions
+	return versions, nil
+}
+
+func parserNames(parsers map[string]parser.Parser) []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type versionResponse struct {
+	Version  string             `json:"version"`
+	Commit   string             `json:"commit,omitempty"`
+	Go       string             `json:"go"`
+	Ruby     map[string]*string `json:"ruby"`
+	Rubies   []string           `json:"rubies"`
+	Parsers  []string           `json:"parsers"`
+	Lexers   []string           `json:"lexers"`
+	Formats  []string           `json:"formats"`
+	Features map[string]bool    `json:"features"`
+}
+
+// handleVersion describes this build and what it supports, so the frontend
+// can hide features the server can't provide. Ruby versions are null when
+// Ruby couldn't be run.
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	ruby, _ := s.versions.get(r.Context())
+	formats := []string{analyze.DefaultFormat}
+	for name := range analyze.OutputFormats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	_, frontend := visualizer.Frontend()
+	writeJSON(w, versionResponse{
+		Version: version,
+		Commit:  buildCommit(),
+		Go:      runtime.Version(),
+		Ruby:    ruby,
+		Rubies:  s.rubyVersionNames(),
+		Parsers: parserNames(s.parsers),
+		Lexers:  parserNames(s.lexers),
+		Formats: formats,
+		Features: map[string]bool{
+			"frontend": frontend,
+			"watch":    s.cfg.Watch != "",
+			"autocert": autocertAvailable,
+			"sqlite":   sqliteDriver != "",
+			"grpc":     s.cfg.GRPCAddr != "",
+			"lsp":      s.cfg.LSP != "",
+		},
+	})
+}
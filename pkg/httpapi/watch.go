@@ -0,0 +1,214 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+const watchKeepAlive = 15 * time.Second
+
+// watchEvent is pushed to /watch clients whenever a file changes. Removed is
+// set instead of AST or Error once a file disappears.
+type watchEvent struct {
+	Path    string          `json:"path"`
+	ParseID string          `json:"parse_id,omitempty"`
+	AST     json.RawMessage `json:"ast,omitempty"`
+	Error   *errorResponse  `json:"error,omitempty"`
+	Removed bool            `json:"removed,omitempty"`
+}
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	event   []byte
+}
+
+// watcher keeps the Ruby files under a directory parsed, re-parsing them as
+// they change, and fans the results out to subscribers. It polls rather than
+// using inotify and friends, which needs no dependencies and also works on
+// network filesystems and in containers with bind mounts.
+type watcher struct {
+	s        *server
+	dir      string
+	interval time.Duration
+
+	mu          sync.Mutex
+	files       map[string]*watchedFile
+	subscribers map[chan []byte]struct{}
+}
+
+func newWatcher(s *server, dir string, interval time.Duration) *watcher {
+	return &watcher{
+		s:           s,
+		dir:         dir,
+		interval:    interval,
+		files:       make(map[string]*watchedFile),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+func (wt *watcher) run(ctx context.Context) {
+	ticker := time.NewTicker(wt.interval)
+	defer ticker.Stop()
+	for {
+		wt.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan re-parses files whose size or modification time changed since the
+// last scan and reports files that have gone.
+func (wt *watcher) scan(ctx context.Context) {
+	paths, err := findRubyFiles(wt.dir)
+	if err != nil {
+		slog.Error("Error scanning watched directory", "dir", wt.dir, "err", err)
+		return
+	}
+	p := wt.s.parsers[parser.DefaultParser]
+
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		seen[path] = true
+		info, err := os.Stat(filepath.Join(wt.dir, filepath.FromSlash(path)))
+		if err != nil {
+			continue
+		}
+		wt.mu.Lock()
+		old := wt.files[path]
+		wt.mu.Unlock()
+		if old != nil && old.modTime.Equal(info.ModTime()) && old.size == info.Size() {
+			continue
+		}
+
+		event := watchEvent{Path: path}
+		raw, err := readProjectFile(filepath.Join(wt.dir, filepath.FromSlash(path)))
+		code, _, decodeErr := analyze.DecodeSource(raw, "")
+		if err != nil {
+			event.Error = &errorResponse{Error: "Failed to read file"}
+		} else if decodeErr != nil {
+			resp := encodingErrorResponse("File is not valid UTF-8", decodeErr)
+			event.Error = &resp
+		} else if output, _, err := wt.s.parse(ctx, p, code); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			_, resp := parseErrorResponse(ctx, p, err)
+			event.Error = &resp
+		} else {
+			event.ParseID = parseID(p, code)
+			event.AST = output
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Error encoding watch event", "err", err)
+			continue
+		}
+
+		wt.mu.Lock()
+		wt.files[path] = &watchedFile{modTime: info.ModTime(), size: info.Size(), event: data}
+		wt.broadcast(data)
+		wt.mu.Unlock()
+	}
+
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	for path := range wt.files {
+		if !seen[path] {
+			delete(wt.files, path)
+			data, _ := json.Marshal(watchEvent{Path: path, Removed: true})
+			wt.broadcast(data)
+		}
+	}
+}
+
+// broadcast sends an event to every subscriber. A subscriber too slow to
+// keep up is dropped; its EventSource reconnects and gets a fresh snapshot.
+// wt.mu must be held.
+func (wt *watcher) broadcast(data []byte) {
+	for ch := range wt.subscribers {
+		select {
+		case ch <- data:
+		default:
+			delete(wt.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of events, primed with the current state of
+// every file.
+func (wt *watcher) subscribe() chan []byte {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	ch := make(chan []byte, len(wt.files)+64)
+	for _, f := range wt.files {
+		ch <- f.event
+	}
+	wt.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (wt *watcher) unsubscribe(ch chan []byte) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	if _, ok := wt.subscribers[ch]; ok {
+		delete(wt.subscribers, ch)
+		close(ch)
+	}
+}
+
+// handleWatch streams the watched files' ASTs as Server-Sent Events: one
+// "file" event per file on connect, then one each time a file changes.
+func (wt *watcher) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if !wt.s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := wt.subscribe()
+	defer wt.unsubscribe(events)
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: file\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
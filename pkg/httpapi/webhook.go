@@ -0,0 +1,317 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/analyze"
+	"github.com/ghousemohamed/ruby-ast-visualizer/pkg/parser"
+)
+
+// maxPushFiles bounds the files fetched for one push event, across all its
+// commits.
+const maxPushFiles = 500
+
+// githubPush is the part of a GitHub push event the webhook reads.
+type githubPush struct {
+	Ref        string `json:"ref"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Commits []struct {
+		ID        string   `json:"id"`
+		Message   string   `json:"message"`
+		Timestamp string   `json:"timestamp"`
+		URL       string   `json:"url"`
+		Added     []string `json:"added"`
+		Modified  []string `json:"modified"`
+	} `json:"commits"`
+}
+
+// commitRecord is what a push stored for one of its commits: each Ruby file
+// it added or changed, saved as a snippet so ?snippet=<id> opens its tree.
+type commitRecord struct {
+	SHA        string       `json:"sha"`
+	Repository string       `json:"repository"`
+	Ref        string       `json:"ref"`
+	Message    string       `json:"message"`
+	Timestamp  string       `json:"timestamp"`
+	URL        string       `json:"url"`
+	Parser     string       `json:"parser"`
+	Files      []commitFile `json:"files"`
+	ReceivedAt time.Time    `json:"received_at"`
+}
+
+type commitFile struct {
+	Path    string         `json:"path"`
+	Snippet string         `json:"snippet,omitempty"`
+	Nodes   int            `json:"nodes"`
+	Error   *errorResponse `json:"error,omitempty"`
+}
+
+type webhookResponse struct {
+	Commits []commitRecord `json:"commits"`
+}
+
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+func commitKey(sha string) string {
+	return "commits/" + sha
+}
+
+// validWebhookSignature checks X-Hub-Signature-256, the hex HMAC-SHA256 of
+// the body under the webhook's secret.
+func validWebhookSignature(header string, body []byte, secret string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// handleGitHubWebhook takes GitHub push events, for the webhook's secret.
+// Each commit's added and changed Ruby files are fetched at that commit,
+// parsed and stored under its SHA for /commits/{sha}. That runs as a job
+// when jobs are on, since GitHub gives up on a delivery after ten seconds.
+// Other events, such as the ping sent when the webhook is added, are
+// acknowledged and ignored.
+func (s *server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	if s.cfg.GitHubWebhookSecret == "" {
+		writeError(w, http.StatusNotFound, "GitHub webhooks are not configured")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if !validWebhookSignature(r.Header.Get("X-Hub-Signature-256"), body, s.cfg.GitHubWebhookSecret) {
+		writeError(w, http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		writeJSON(w, webhookResponse{Commits: []commitRecord{}})
+		return
+	}
+
+	// A webhook can deliver its payload as a form field instead of JSON.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid form body")
+			return
+		}
+		body = []byte(form.Get("payload"))
+	}
+	var push githubPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid push event")
+		return
+	}
+	if strings.Count(push.Repository.FullName, "/") != 1 {
+		writeError(w, http.StatusBadRequest, "Push event has no repository")
+		return
+	}
+	p, ok := s.lookupParser(w, r.URL.Query().Get("parser"))
+	if !ok {
+		return
+	}
+
+	if s.jobs == nil || push.Deleted || len(push.Commits) == 0 {
+		s.storePush(r.Context(), w, p, &push)
+		return
+	}
+	base := jobsBase(r)
+	j, err := s.jobs.enqueue(r.Context(), "webhook", base, func(ctx context.Context, rw http.ResponseWriter) {
+		s.storePush(ctx, rw, p, &push)
+	})
+	if errors.Is(err, errJobQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		writeError(w, http.StatusTooManyRequests, "The job queue is full, try again later")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error queueing job", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to queue job")
+		return
+	}
+	writeJobCreated(w, r, base, j)
+}
+
+// storePush fetches, parses and stores the Ruby files of each commit in
+// push, at most cfg.Workers at a time, and answers with what it stored. A
+// file that can't be fetched or parsed is stored with its error; a commit
+// that can't be stored fails the push, so GitHub shows the delivery failed.
+func (s *server) storePush(ctx context.Context, w http.ResponseWriter, p parser.Parser, push *githubPush) {
+	resp := webhookResponse{Commits: []commitRecord{}}
+	if push.Deleted {
+		writeJSON(w, resp)
+		return
+	}
+
+	total := 0
+	for _, c := range push.Commits {
+		if !commitSHA.MatchString(c.ID) {
+			writeError(w, http.StatusBadRequest, "Invalid commit SHA")
+			return
+		}
+		rec := commitRecord{
+			SHA:        c.ID,
+			Repository: push.Repository.FullName,
+			Ref:        push.Ref,
+			Message:    c.Message,
+			Timestamp:  c.Timestamp,
+			URL:        c.URL,
+			Parser:     p.Name(),
+			Files:      []commitFile{},
+			ReceivedAt: time.Now().UTC().Truncate(time.Millisecond),
+		}
+		// ERB templates are left out: a snippet's code is what was
+		// parsed, and for a template that isn't the file.
+		for _, path := range append(c.Added, c.Modified...) {
+			if analyze.IsRubySource(path) && analyze.DetectFileType(path) != analyze.FileTypeERB {
+				rec.Files = append(rec.Files, commitFile{Path: path})
+			}
+		}
+		sort.Slice(rec.Files, func(i, j int) bool { return rec.Files[i].Path < rec.Files[j].Path })
+		total += len(rec.Files)
+		resp.Commits = append(resp.Commits, rec)
+	}
+	if total > maxPushFiles {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Push changes more than %d Ruby files", maxPushFiles))
+		return
+	}
+
+	sem := make(chan struct{}, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for i := range resp.Commits {
+		rec := &resp.Commits[i]
+		for j := range rec.Files {
+			wg.Add(1)
+			go func(file *commitFile) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				s.storeCommitFile(ctx, p, rec.Repository, rec.SHA, file)
+			}(&rec.Files[j])
+		}
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	for _, rec := range resp.Commits {
+		data, err := json.Marshal(rec)
+		if err == nil {
+			err = s.storage.put(ctx, commitKey(rec.SHA), data, 0)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving commit", "sha", rec.SHA, "err", err)
+			writeError(w, http.StatusInternalServerError, "Failed to save commit")
+			return
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// storeCommitFile fetches file as of sha from raw.githubusercontent.com,
+// parses it and saves it as a snippet.
+func (s *server) storeCommitFile(ctx context.Context, p parser.Parser, repo, sha string, file *commitFile) {
+	segments := strings.Split(file.Path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u := &url.URL{
+		Scheme:  "https",
+		Host:    "raw.githubusercontent.com",
+		Path:    "/" + repo + "/" + sha + "/" + file.Path,
+		RawPath: "/" + repo + "/" + sha + "/" + strings.Join(segments, "/"),
+	}
+	code, err := s.download(ctx, u, s.cfg.GitHubToken)
+	if err != nil {
+		var encErr *analyze.EncodingError
+		switch {
+		case errors.As(err, &encErr):
+			resp := encodingErrorResponse("File is not valid UTF-8", err)
+			file.Error = &resp
+		case errors.Is(err, errSourceTooLarge):
+			file.Error = &errorResponse{Error: "File is too large"}
+		default:
+			slog.WarnContext(ctx, "Error fetching pushed file", "repository", repo, "sha", sha, "path", file.Path, "err", err)
+			file.Error = &errorResponse{Error: "Failed to fetch file"}
+		}
+		return
+	}
+	output, _, err := s.parse(ctx, p, code)
+	if err != nil {
+		_, resp := parseErrorResponse(ctx, p, err)
+		file.Error = &resp
+		return
+	}
+	if root, err := analyze.DecodeAST(output); err == nil {
+		file.Nodes = countNodes(root)
+	}
+	sn, err := s.saveSnippet(ctx, p, code, output)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving snippet", "err", err)
+		file.Error = &errorResponse{Error: "Failed to save snippet"}
+		return
+	}
+	file.Snippet = sn.ID
+}
+
+// handleGetCommit serves what a push stored for a commit.
+func (s *server) handleGetCommit(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	sha := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/commits/"))
+	if !commitSHA.MatchString(sha) {
+		writeError(w, http.StatusNotFound, "Unknown commit")
+		return
+	}
+	data, err := s.storage.get(r.Context(), commitKey(sha))
+	if errors.Is(err, errNotStored) {
+		writeError(w, http.StatusNotFound, "Unknown commit")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading commit", "err", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load commit")
+		return
+	}
+	writeJSON(w, json.RawMessage(data))
+}
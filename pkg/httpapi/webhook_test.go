@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The example from GitHub's guide to validating webhook deliveries.
+const (
+	exampleWebhookSecret    = "It's a Secret to Everybody"
+	exampleWebhookBody      = "Hello, World!"
+	exampleWebhookSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+)
+
+func TestValidWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		secret string
+		want   bool
+	}{
+		{"good", exampleWebhookSignature, exampleWebhookBody, exampleWebhookSecret, true},
+		{"other body", exampleWebhookSignature, exampleWebhookBody + " ", exampleWebhookSecret, false},
+		{"other secret", exampleWebhookSignature, exampleWebhookBody, "It's a Secret to Nobody", false},
+		{"bad digit", strings.Replace(exampleWebhookSignature, "7571", "7572", 1), exampleWebhookBody, exampleWebhookSecret, false},
+		{"missing", "", exampleWebhookBody, exampleWebhookSecret, false},
+		{"no prefix", strings.TrimPrefix(exampleWebhookSignature, "sha256="), exampleWebhookBody, exampleWebhookSecret, false},
+		{"sha1 prefix", "sha1=" + strings.TrimPrefix(exampleWebhookSignature, "sha256="), exampleWebhookBody, exampleWebhookSecret, false},
+		{"empty digest", "sha256=", exampleWebhookBody, exampleWebhookSecret, false},
+		{"non-hex", "sha256=" + strings.Repeat("zz", 32), exampleWebhookBody, exampleWebhookSecret, false},
+		{"odd length", exampleWebhookSignature[:len(exampleWebhookSignature)-1], exampleWebhookBody, exampleWebhookSecret, false},
+		{"truncated", exampleWebhookSignature[:len(exampleWebhookSignature)-2], exampleWebhookBody, exampleWebhookSecret, false},
+	}
+	for _, tt := range tests {
+		if got := validWebhookSignature(tt.header, []byte(tt.body), tt.secret); got != tt.want {
+			t.Errorf("%s: validWebhookSignature(%q) = %v, want %v", tt.name, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubWebhookSignatureRequired(t *testing.T) {
+	s := &server{cfg: &config{GitHubWebhookSecret: exampleWebhookSecret, MaxBodyBytes: 1 << 20}}
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"signed", exampleWebhookSignature, http.StatusOK},
+		{"unsigned", "", http.StatusUnauthorized},
+		{"badly signed", strings.Replace(exampleWebhookSignature, "7571", "7572", 1), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(exampleWebhookBody))
+		req.Header.Set("X-GitHub-Event", "ping")
+		if tt.signature != "" {
+			req.Header.Set("X-Hub-Signature-256", tt.signature)
+		}
+		rec := httptest.NewRecorder()
+		s.handleGitHubWebhook(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pipeConn is the server's side of a connection: what the server writes is
+// kept in out, and reads come from wsConn.br instead.
+type pipeConn struct {
+	net.Conn
+	out    bytes.Buffer
+	closed int
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *pipeConn) Close() error                { c.closed++; return nil }
+
+// clientFrame is a frame as a client sends it, masked with a fixed key.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(n))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(n))
+	}
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func newTestWSConn(input []byte, maxMessage int64) (*wsConn, *pipeConn) {
+	pc := &pipeConn{}
+	return &wsConn{conn: pc, br: bufio.NewReader(bytes.NewReader(input)), maxMessage: maxMessage}, pc
+}
+
+func concat(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func TestReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 300)
+	tests := []struct {
+		name    string
+		input   []byte
+		max     int64
+		want    string
+		wantErr error
+		wantOut []byte
+	}{
+		{
+			// RFC 6455 section 5.7: a single-frame masked text message.
+			name:  "rfc masked hello",
+			input: []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58},
+			want:  "Hello",
+		},
+		{
+			name:    "unmasked",
+			input:   []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'},
+			wantErr: errWSProtocol,
+		},
+		{
+			name:  "fragmented",
+			input: concat(clientFrame(false, wsOpText, []byte("Hel")), clientFrame(true, wsOpContinuation, []byte("lo"))),
+			want:  "Hello",
+		},
+		{
+			name: "ping between fragments",
+			input: concat(
+				clientFrame(false, wsOpText, []byte("Hel")),
+				clientFrame(true, wsOpPing, []byte("hi")),
+				clientFrame(true, wsOpContinuation, []byte("lo")),
+			),
+			want:    "Hello",
+			wantOut: []byte{0x80 | wsOpPong, 2, 'h', 'i'},
+		},
+		{
+			name:  "16-bit length",
+			input: clientFrame(true, wsOpBinary, long),
+			want:  string(long),
+		},
+		{
+			name:    "continuation first",
+			input:   clientFrame(true, wsOpContinuation, []byte("lo")),
+			wantErr: errWSProtocol,
+		},
+		{
+			name:    "text inside fragments",
+			input:   concat(clientFrame(false, wsOpText, []byte("Hel")), clientFrame(true, wsOpText, []byte("lo"))),
+			wantErr: errWSProtocol,
+		},
+		{
+			name:    "fragmented ping",
+			input:   clientFrame(false, wsOpPing, []byte("hi")),
+			wantErr: errWSProtocol,
+		},
+		{
+			name:    "long ping",
+			input:   clientFrame(true, wsOpPing, bytes.Repeat([]byte("p"), 126)),
+			wantErr: errWSProtocol,
+		},
+		{
+			name:    "unknown opcode",
+			input:   clientFrame(true, 0x3, nil),
+			wantErr: errWSProtocol,
+		},
+		{
+			name:    "frame too large",
+			input:   clientFrame(true, wsOpText, long),
+			max:     100,
+			wantErr: errWSMessageSize,
+		},
+		{
+			name:    "fragments too large",
+			input:   concat(clientFrame(false, wsOpText, long[:60]), clientFrame(true, wsOpContinuation, long[:60])),
+			max:     100,
+			wantErr: errWSMessageSize,
+		},
+		{
+			name:    "close",
+			input:   clientFrame(true, wsOpClose, []byte{0x03, 0xe8}),
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated",
+			input:   clientFrame(true, wsOpText, []byte("Hello"))[:8],
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.max == 0 {
+				tt.max = 1 << 20
+			}
+			conn, pc := newTestWSConn(tt.input, tt.max)
+			got, err := conn.readMessage()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readMessage error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("readMessage = %q, want %q", got, tt.want)
+			}
+			if !bytes.Equal(pc.out.Bytes(), tt.wantOut) {
+				t.Errorf("server wrote % x, want % x", pc.out.Bytes(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestWriteFrameLengths(t *testing.T) {
+	tests := []struct {
+		n      int
+		header []byte
+	}{
+		{5, []byte{0x81, 5}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0x00, 0x7e}},
+		{0xffff, []byte{0x81, 126, 0xff, 0xff}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		conn, pc := newTestWSConn(nil, 1<<20)
+		if err := conn.writeText(make([]byte, tt.n)); err != nil {
+			t.Fatal(err)
+		}
+		out := pc.out.Bytes()
+		if !bytes.Equal(out[:len(tt.header)], tt.header) || len(out) != len(tt.header)+tt.n {
+			t.Errorf("writeText(%d bytes) header = % x, length %d; want % x, length %d",
+				tt.n, out[:len(tt.header)], len(out), tt.header, len(tt.header)+tt.n)
+		}
+	}
+}
+
+func TestCloseWithOnce(t *testing.T) {
+	conn, pc := newTestWSConn(nil, 1<<20)
+	conn.closeWith(wsCloseMessageLarge)
+	conn.closeWith(wsCloseNormal)
+	if want := []byte{0x88, 2, 0x03, 0xf1}; !bytes.Equal(pc.out.Bytes(), want) {
+		t.Errorf("close frames = % x, want % x", pc.out.Bytes(), want)
+	}
+	if pc.closed != 1 {
+		t.Errorf("connection closed %d times, want 1", pc.closed)
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r, 1<<20)
+		if err != nil {
+			return
+		}
+		conn.closeWith(wsCloseNormal)
+	}))
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The handshake from RFC 6455 section 1.3.
+	io.WriteString(c, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/ws", strings.NewReader(""))
+	if _, err := upgradeWebSocket(rec, req, 1<<20); err == nil || rec.Code != http.StatusBadRequest {
+		t.Errorf("plain GET: err = %v, status = %d, want an error and 400", err, rec.Code)
+	}
+}
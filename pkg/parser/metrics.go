@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+)
+
+var (
+	spawnFailures = telemetry.NewCounterVec("ruby_ast_process_spawn_failures_total",
+		"Child processes that failed to start, by command.", "command")
+	workerRestarts = telemetry.NewCounterVec("ruby_ast_worker_restarts_total",
+		"stree workers replaced after crashing or being cancelled.")
+)
+
+// CountSpawnFailure records err from running cmd if the process never
+// started, e.g. because the binary is missing.
+func CountSpawnFailure(cmd *exec.Cmd, err error) {
+	if err != nil && cmd.Process == nil {
+		spawnFailures.Inc(filepath.Base(cmd.Path))
+	}
+}
+
+// registerMetrics publishes the pool's worker count at scrape time.
+func (p *WorkerPool) RegisterMetrics() {
+	telemetry.Register(&telemetry.GaugeFunc{
+		Name: "ruby_ast_workers_running",
+		Help: "stree worker processes currently up.",
+		Fn:   func() float64 { return float64(p.Running()) },
+	})
+	telemetry.Register(&telemetry.GaugeFunc{
+		Name: "ruby_ast_workers",
+		Help: "Configured size of the stree worker pool.",
+		Fn:   func() float64 { return float64(p.Size) },
+	})
+}
@@ -0,0 +1,21 @@
+package parser
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const networkIsolationSupported = true
+
+// isolateNetwork starts cmd in new user and network namespaces, so it has
+// only a loopback interface that is down. The user namespace lets this work
+// without root, mapping the process to the server's own user.
+func isolateNetwork(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+}
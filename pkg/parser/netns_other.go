@@ -0,0 +1,9 @@
+//go:build !linux
+
+package parser
+
+import "os/exec"
+
+const networkIsolationSupported = false
+
+func isolateNetwork(cmd *exec.Cmd) {}
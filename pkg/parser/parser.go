@@ -0,0 +1,215 @@
+// Package parser runs Ruby's parsers over source code and hands back the
+// syntax tree each one produces as JSON. The backends are one-shot Ruby
+// processes or a pool of long-lived workers, optionally confined by a
+// Sandbox.
+package parser
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+)
+
+//go:embed ruby/prism.rb
+var prismScript string
+
+//go:embed ruby/ripper.rb
+var ripperScript string
+
+//go:embed ruby/tokens.rb
+var tokensScript string
+
+//go:embed ruby/format.rb
+var formatScript string
+
+//go:embed ruby/unparse.rb
+var unparseScript string
+
+// Exit status the one-shot Ruby scripts use to report invalid input, as
+// opposed to the interpreter itself failing.
+const syntaxErrorExitCode = 65
+
+const DefaultParser = "stree"
+
+// processWaitDelay bounds how long a killed parser may keep its output pipes
+// open before Wait gives up on it.
+const processWaitDelay = time.Second
+
+// Parser turns Ruby source into AST JSON. Invalid source is reported as a
+// *SyntaxError; any other error means the backend itself failed.
+type Parser interface {
+	Name() string
+	Parse(ctx context.Context, code string) ([]byte, error)
+}
+
+// StreamParser is a Parser that can write its JSON out as it is produced
+// instead of handing back the whole AST at once. A syntax error is always
+// reported before anything is written; any other error may come after.
+type StreamParser interface {
+	Parser
+	ParseTo(ctx context.Context, code string, w io.Writer) error
+}
+
+// SyntaxError describes invalid Ruby source as reported by a backend. Line
+// is 1-based and Column 0-based; Line is 0 when the position is unknown.
+type SyntaxError struct {
+	Message string `json:"error"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+func parseSyntaxError(stderr []byte) *SyntaxError {
+	var se SyntaxError
+	if err := json.Unmarshal(stderr, &se); err != nil || se.Message == "" {
+		return &SyntaxError{Message: strings.TrimSpace(string(stderr))}
+	}
+	return &se
+}
+
+func IsSyntaxError(err error) bool {
+	var se *SyntaxError
+	return errors.As(err, &se)
+}
+
+type streeParser struct {
+	pool *WorkerPool
+}
+
+func (p *streeParser) Name() string {
+	return "stree"
+}
+
+func (p *streeParser) Parse(ctx context.Context, code string) ([]byte, error) {
+	return p.pool.parse(ctx, code)
+}
+
+func (p *streeParser) ParseTo(ctx context.Context, code string, w io.Writer) error {
+	return p.pool.parseTo(ctx, code, w)
+}
+
+// scriptParser runs a one-shot Ruby script per parse, with the code piped
+// in on stdin.
+type scriptParser struct {
+	name    string
+	rubyBin string
+	script  string
+	args    []string
+	sandbox *Sandbox
+
+	// rubyVersion is set for backends that run a Ruby chosen with
+	// ruby_version rather than -ruby-bin.
+	rubyVersion string
+}
+
+func (p *scriptParser) Name() string {
+	return p.name
+}
+
+func (p *scriptParser) RubyVersion() string {
+	return p.rubyVersion
+}
+
+func (p *scriptParser) Parse(ctx context.Context, code string) ([]byte, error) {
+	var output bytes.Buffer
+	if err := p.ParseTo(ctx, code, &output); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// ParseTo copies the script's output to w as it is written. The scripts only
+// write to stdout once parsing has succeeded, so syntax errors are reported
+// before anything reaches w.
+func (p *scriptParser) ParseTo(ctx context.Context, code string, w io.Writer) error {
+	cmd := NewCommand(ctx, p.rubyBin, append([]string{"-e", p.script}, p.args...)...)
+	p.sandbox.apply(cmd, true)
+	cmd.Stdin = strings.NewReader(code)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	_, sp := telemetry.StartSpan(ctx, "exec "+p.name+" script", telemetry.Attr("process.executable.path", p.rubyBin))
+	err := cmd.Run()
+	if cmd.ProcessState != nil {
+		sp.SetAttrs(telemetry.Attr("process.exit.code", cmd.ProcessState.ExitCode()))
+	}
+	sp.End()
+	CountSpawnFailure(cmd, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == syntaxErrorExitCode {
+			return parseSyntaxError(stderr.Bytes())
+		}
+		return err
+	}
+	return nil
+}
+
+// NewCommand prepares a child process that is killed, along with anything
+// it spawned, when ctx is done.
+func NewCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = processWaitDelay
+	killProcessGroup(cmd)
+	return cmd
+}
+
+func NewParsers(rubyBin string, sb *Sandbox, pool *WorkerPool) map[string]Parser {
+	parsers := []Parser{
+		&streeParser{pool: pool},
+		&scriptParser{name: "prism", rubyBin: rubyBin, script: prismScript, sandbox: sb},
+		&scriptParser{name: "ripper", rubyBin: rubyBin, script: ripperScript, sandbox: sb},
+	}
+
+	byName := make(map[string]Parser, len(parsers))
+	for _, p := range parsers {
+		byName[p.Name()] = p
+	}
+	return byName
+}
+
+// NewLexers returns the token stream backends. They share the Parser
+// interface since they too turn source into JSON, but are kept apart from
+// the parsers so their output is never mistaken for a tree.
+func NewLexers(rubyBin string, sb *Sandbox) map[string]Parser {
+	lexers := make(map[string]Parser)
+	for _, name := range []string{"ripper", "prism"} {
+		lexers[name] = &scriptParser{name: name, rubyBin: rubyBin, script: tokensScript, args: []string{name}, sandbox: sb}
+	}
+	return lexers
+}
+
+// NewPartialParser returns prism in its error-tolerant mode, which produces
+// a tree even for invalid source, with what it couldn't make sense of left
+// as missing or error nodes. Its JSON wraps that tree as "ast" alongside the
+// "diagnostics" prism reported, so it too is kept apart from the parsers.
+func NewPartialParser(rubyBin string, sb *Sandbox) Parser {
+	return &scriptParser{name: "prism-partial", rubyBin: rubyBin, script: prismScript, args: []string{"--partial"}, sandbox: sb}
+}
+
+// NewFormatter returns the syntax_tree formatter. Its output is Ruby source
+// rather than JSON.
+func NewFormatter(rubyBin string, sb *Sandbox) Parser {
+	return &scriptParser{name: "stree", rubyBin: rubyBin, script: formatScript, sandbox: sb}
+}
+
+// NewUnparser returns the backend that rebuilds Ruby source from stree JSON.
+// It reads JSON and writes source, the reverse of a Parser.
+func NewUnparser(rubyBin string, sb *Sandbox) Parser {
+	return &scriptParser{name: "stree", rubyBin: rubyBin, script: unparseScript, sandbox: sb}
+}
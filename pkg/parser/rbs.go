@@ -0,0 +1,22 @@
+package parser
+
+import (
+	_ "embed"
+)
+
+//go:embed ruby/rbs.rb
+var rbsScript string
+
+// NewRBSParser returns the backend for .rbs signature files. Its trees have
+// the same shape as the Ruby ones, so every output format works on them, but
+// it is kept out of the parsers since it doesn't read Ruby.
+func NewRBSParser(rubyBin string, sb *Sandbox) Parser {
+	return &scriptParser{name: "rbs", rubyBin: rubyBin, script: rbsScript, sandbox: sb}
+}
+
+// NewRBSMethodsParser returns a backend that lists the method signatures in
+// RBS source, with their parameter and return types as text, rather than
+// its tree.
+func NewRBSMethodsParser(rubyBin string, sb *Sandbox) Parser {
+	return &scriptParser{name: "rbs-methods", rubyBin: rubyBin, script: rbsScript, args: []string{"--methods"}, sandbox: sb}
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// streeOnceParser runs the stree worker script for a single parse. The
+// persistent pool only serves -ruby-bin; other Rubies are used rarely enough
+// that starting one per parse is fine.
+type streeOnceParser struct {
+	rubyBin     string
+	rubyVersion string
+	sandbox     *Sandbox
+}
+
+func (p *streeOnceParser) Name() string {
+	return "stree"
+}
+
+func (p *streeOnceParser) RubyVersion() string {
+	return p.rubyVersion
+}
+
+func (p *streeOnceParser) Parse(ctx context.Context, code string) ([]byte, error) {
+	req, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{code})
+	if err != nil {
+		return nil, err
+	}
+	cmd := NewCommand(ctx, p.rubyBin, "-e", workerScript)
+	p.sandbox.apply(cmd, true)
+	cmd.Stdin = bytes.NewReader(append(req, '\n'))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	CountSpawnFailure(cmd, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return decodeWorkerResponse(output)
+}
+
+// RubyVersionOf returns the Ruby version a backend was set up for, or "" if
+// it uses -ruby-bin.
+func RubyVersionOf(parser Parser) string {
+	if v, ok := parser.(interface{ RubyVersion() string }); ok {
+		return v.RubyVersion()
+	}
+	return ""
+}
+
+// NewRubyParsers sets up the parsers for each Ruby in rubies.
+func NewRubyParsers(rubies map[string]string, sb *Sandbox) map[string]map[string]Parser {
+	byVersion := make(map[string]map[string]Parser, len(rubies))
+	for version, bin := range rubies {
+		byVersion[version] = map[string]Parser{
+			"stree":  &streeOnceParser{rubyBin: bin, rubyVersion: version, sandbox: sb},
+			"prism":  &scriptParser{name: "prism", rubyBin: bin, script: prismScript, sandbox: sb, rubyVersion: version},
+			"ripper": &scriptParser{name: "ripper", rubyBin: bin, script: ripperScript, sandbox: sb, rubyVersion: version},
+		}
+	}
+	return byVersion
+}
@@ -0,0 +1,11 @@
+# One-shot formatter. Prints the source on stdin formatted by syntax_tree.
+# Syntax errors are written to stderr as a JSON object and exit with status 65.
+require "json"
+require "syntax_tree"
+
+begin
+  print SyntaxTree.format($stdin.read)
+rescue SyntaxTree::Parser::ParseError => error
+  $stderr.puts(JSON.generate(error: error.message, line: error.lineno, column: error.column))
+  exit 65
+end
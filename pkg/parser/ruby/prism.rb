@@ -0,0 +1,53 @@
+# One-shot Prism parser. Parses the source on stdin (or the file named by
+# ARGV[0]) and prints the AST as JSON, using the same
+# [start_line, start_char, end_line, end_char] location arrays as syntax_tree.
+# Syntax errors are written to stderr as a JSON object and exit with status 65.
+# With --partial, the tree Prism recovers from invalid source is printed
+# anyway, as "ast" in an object with the errors and warnings as "diagnostics".
+require "json"
+require "prism"
+
+def serialize(value)
+  case value
+  when Prism::Node
+    fields = value.deconstruct_keys(nil).except(:location)
+    { type: value.type.to_s.delete_suffix("_node"), location: serialize(value.location) }
+      .merge(fields.transform_values { |field| serialize(field) })
+  when Prism::Location
+    [value.start_line, value.start_character_offset, value.end_line, value.end_character_offset]
+  when Array
+    value.map { |element| serialize(element) }
+  when Symbol
+    value.to_s
+  else
+    value
+  end
+end
+
+partial = ARGV.delete("--partial")
+result = Prism.parse(ARGF.read)
+
+if partial
+  diagnostics = result.errors.map { |d| [d, "error"] } + result.warnings.map { |d| [d, "warning"] }
+  puts JSON.generate(
+    ast: serialize(result.value),
+    diagnostics: diagnostics.map do |diagnostic, level|
+      { level: level, message: diagnostic.message, location: serialize(diagnostic.location) }
+    end
+  )
+  exit
+end
+
+if result.failure?
+  error = result.errors.first
+  $stderr.puts(
+    JSON.generate(
+      error: error.message,
+      line: error.location.start_line,
+      column: error.location.start_column
+    )
+  )
+  exit 65
+end
+
+puts JSON.generate(serialize(result.value))
@@ -0,0 +1,116 @@
+# One-shot RBS parser. Parses the signatures on stdin and prints their
+# declarations as JSON, in the same shape as the Ruby ASTs: each node has a
+# type named after its RBS class and a [start_line, start_char, end_line,
+# end_char] location. Syntax errors are written to stderr as a JSON object
+# and exit with status 65.
+# With --methods, the method signatures are printed instead, as "methods":
+# each with its namespace, name, kind and overloads, and each overload with
+# its parameters and return type written as RBS would write them.
+require "json"
+require "rbs"
+
+def node_type(value)
+  value.class.name.split("::").last
+    .gsub(/([a-z\d])([A-Z])/, '\1_\2')
+    .downcase
+end
+
+def serialize(value)
+  case value
+  when RBS::Location
+    [value.start_line, value.start_pos, value.end_line, value.end_pos]
+  when RBS::TypeName, RBS::Namespace
+    value.to_s
+  when RBS::AST::Comment
+    value.string
+  when Symbol
+    value.to_s
+  when Array
+    value.map { |element| serialize(element) }
+  when Hash
+    value.to_h { |key, element| [key.to_s, serialize(element)] }
+  when String, Numeric, true, false, nil
+    value
+  else
+    fields = value.instance_variables.to_h do |name|
+      [name.to_s.delete_prefix("@"), value.instance_variable_get(name)]
+    end
+    location = fields.delete("location")
+    { type: node_type(value), location: location && serialize(location) }
+      .merge(fields.transform_values { |field| serialize(field) })
+  end
+end
+
+def param(kind, value, name = value&.name)
+  value && { name: name&.to_s, kind: kind, annotation: value.type.to_s }
+end
+
+def overload(method_type)
+  function = method_type.type
+  params =
+    if function.respond_to?(:required_positionals)
+      function.required_positionals.map { |p| param("required", p) } +
+        function.optional_positionals.map { |p| param("optional", p) } +
+        [param("rest", function.rest_positionals)] +
+        function.trailing_positionals.map { |p| param("required", p) } +
+        function.required_keywords.map { |name, p| param("keyword", p, name) } +
+        function.optional_keywords.map { |name, p| param("optional_keyword", p, name) } +
+        [param("rest_keyword", function.rest_keywords)]
+    else
+      [] # (?), rbs 3's untyped parameters
+    end
+  if (block = method_type.block)
+    params << { name: nil, kind: "block", annotation: "(#{block.type.param_to_s}) -> #{block.type.return_to_s}" }
+  end
+  { signature: method_type.to_s, params: params.compact, returns: function.return_type.to_s }
+end
+
+def methods_in(declarations, namespace = "")
+  declarations.flat_map do |decl|
+    case decl
+    when RBS::AST::Declarations::Class, RBS::AST::Declarations::Module, RBS::AST::Declarations::Interface
+      name = decl.name.to_s.delete_prefix("::")
+      inner = namespace.empty? || decl.name.to_s.start_with?("::") ? name : "#{namespace}::#{name}"
+      methods_in(decl.members, inner)
+    when RBS::AST::Members::MethodDefinition
+      types = decl.respond_to?(:overloads) ? decl.overloads.map(&:method_type) : decl.types
+      [{
+        namespace: namespace,
+        name: decl.name.to_s,
+        kind: decl.kind.to_s,
+        overloads: types.map { |method_type| overload(method_type) }
+      }]
+    else
+      []
+    end
+  end
+end
+
+methods = ARGV.delete("--methods")
+source = $stdin.read
+begin
+  result = RBS::Parser.parse_signature(source)
+rescue RBS::ParsingError => error
+  location = error.location
+  $stderr.puts(
+    JSON.generate(
+      error: error.message,
+      line: location&.start_line || 0,
+      column: location&.start_column || 0
+    )
+  )
+  exit 65
+end
+
+# rbs 3 returns the buffer and directives along with the declarations.
+declarations = result.first.is_a?(RBS::Buffer) ? result.last : result
+if methods
+  puts JSON.generate(methods: methods_in(declarations))
+  exit
+end
+
+puts JSON.generate(
+  type: "signature",
+  location: [1, 0, source.count("\n") + 1, source.length],
+  declarations: serialize(declarations)
+)
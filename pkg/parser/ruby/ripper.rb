@@ -0,0 +1,28 @@
+# One-shot Ripper parser. Parses the source on stdin (or the file named by
+# ARGV[0]) and prints the Ripper s-expression as JSON. Syntax errors are
+# written to stderr as a JSON object and exit with status 65.
+require "json"
+require "ripper"
+
+class Parser < Ripper::SexpBuilderPP
+  attr_reader :error
+
+  def on_parse_error(message)
+    @error ||= { error: message, line: lineno, column: column }
+    super
+  end
+
+  def compile_error(message)
+    @error ||= { error: message, line: lineno, column: column }
+  end
+end
+
+parser = Parser.new(ARGF.read, ARGF.filename)
+sexp = parser.parse
+
+if parser.error || parser.error?
+  $stderr.puts(JSON.generate(parser.error || { error: "syntax error" }))
+  exit 65
+end
+
+puts JSON.generate(sexp)
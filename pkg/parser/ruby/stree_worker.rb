@@ -0,0 +1,20 @@
+# Long-lived parser worker. Reads one JSON request per line on stdin and
+# writes one JSON response per line on stdout.
+require "json"
+require "syntax_tree"
+
+$stdout.sync = true
+
+$stdin.each_line do |line|
+  request = JSON.parse(line)
+
+  response =
+    begin
+      program = SyntaxTree.parse(request.fetch("code"))
+      { ast: program.accept(SyntaxTree::JSONVisitor.new) }
+    rescue SyntaxTree::Parser::ParseError => error
+      { error: error.message, line: error.lineno, column: error.column }
+    end
+
+  $stdout.puts(JSON.generate(response))
+end
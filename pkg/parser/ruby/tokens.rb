@@ -0,0 +1,51 @@
+# One-shot lexer. Tokenizes the source on stdin with the lexer named by
+# ARGV[0] ("ripper" or "prism") and prints the tokens as a JSON array, each
+# with a [start_line, start_char, end_line, end_char] location.
+require "json"
+
+lexer = ARGV.shift
+source = $stdin.read
+lines = source.lines
+line_starts = lines.each_with_object([0]) { |line, starts| starts << starts.last + line.length }
+
+tokens =
+  case lexer
+  when "ripper"
+    require "ripper"
+
+    Ripper.lex(source).map do |(line, byte_column), type, value, state|
+      start_char = line_starts[line - 1] + lines[line - 1].byteslice(0, byte_column).length
+      newlines = value.count("\n")
+      end_line = line + newlines
+      end_char = start_char + value.length
+
+      {
+        type: type.to_s.delete_prefix("on_"),
+        value: value,
+        state: state.to_s,
+        location: [line, start_char, end_line, end_char]
+      }
+    end
+  when "prism"
+    require "prism"
+
+    Prism.lex(source).value.map do |token, state|
+      location = token.location
+
+      {
+        type: token.type.to_s.downcase,
+        value: token.value,
+        state: state.to_s,
+        location: [
+          location.start_line,
+          location.start_character_offset,
+          location.end_line,
+          location.end_character_offset
+        ]
+      }
+    end
+  else
+    abort "unknown lexer #{lexer}"
+  end
+
+puts JSON.generate(tokens)
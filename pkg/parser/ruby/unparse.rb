@@ -0,0 +1,65 @@
+# One-shot unparser. Reads syntax_tree JSON (as produced by the stree
+# backend) on stdin, rebuilds the nodes and prints them formatted as Ruby.
+# Trees that can't be rebuilt are reported on stderr as a JSON object and
+# exit with status 65.
+require "json"
+require "syntax_tree"
+
+# Learns the JSON type name of every node class by visiting an empty
+# instance and stopping as soon as the visitor names it.
+class TypeProbe < SyntaxTree::FieldVisitor
+  def node(_node, type)
+    throw(:type, type)
+  end
+end
+
+TYPES =
+  SyntaxTree.constants.each_with_object({}) do |name, types|
+    klass = SyntaxTree.const_get(name)
+    next unless klass.is_a?(Class) && klass < SyntaxTree::Node
+
+    type = catch(:type) { klass.allocate.accept(TypeProbe.new) } rescue nil
+    types[type] = klass if type.is_a?(String)
+  end
+
+def location(value)
+  start_line, start_char, end_line, end_char = value || [1, 0, 1, 0]
+  SyntaxTree::Location.new(
+    start_line: start_line,
+    start_char: start_char,
+    start_column: 0,
+    end_line: end_line,
+    end_char: end_char,
+    end_column: 0
+  )
+end
+
+def rebuild(value, path)
+  case value
+  when Hash
+    klass = TYPES.fetch(value["type"]) { raise ArgumentError, "unknown node type #{value["type"].inspect} at #{path}" }
+    keywords = klass.instance_method(:initialize).parameters.filter_map { |kind, name| name if %i[key keyreq].include?(kind) }
+
+    arguments =
+      keywords.to_h do |name|
+        if name == :location
+          [name, location(value["location"])]
+        else
+          [name, rebuild(value[name.to_s], "#{path}.#{name}")]
+        end
+      end
+    klass.new(**arguments)
+  when Array
+    value.each_with_index.map { |element, index| rebuild(element, "#{path}[#{index}]") }
+  else
+    value
+  end
+end
+
+begin
+  program = rebuild(JSON.parse($stdin.read), "")
+  print SyntaxTree::Formatter.format("", program)
+rescue ArgumentError, NoMethodError, TypeError, KeyError => error
+  $stderr.puts(JSON.generate(error: "Cannot unparse AST: #{error.message}"))
+  exit 65
+end
@@ -0,0 +1,21 @@
+# Prints the Ruby version and those of the parser gems as a JSON object. A
+# gem that isn't installed is reported as null.
+require "json"
+
+loaders = {
+  "syntax_tree" => -> { require "syntax_tree"; SyntaxTree::VERSION },
+  "prism" => -> { require "prism"; Prism::VERSION },
+  "rbs" => -> { require "rbs"; RBS::VERSION }
+}
+
+versions = { "ruby" => RUBY_VERSION }
+loaders.each do |name, loader|
+  versions[name] =
+    begin
+      loader.call
+    rescue LoadError
+      nil
+    end
+end
+
+puts JSON.generate(versions)
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SandboxExecArg, as the first argument, makes the binary act as the
+// sandbox's exec helper rather than start the server; see SandboxExec. A
+// program using NewSandbox has to check for it first thing in main.
+const SandboxExecArg = "-sandbox-exec"
+
+// sandboxEnvKeep lists the environment variables parser processes still see,
+// by name or, ending in _, by prefix: enough to find Ruby and its gems, and
+// nothing like credentials or this server's own settings.
+var sandboxEnvKeep = []string{
+	"PATH", "HOME", "LANG", "LC_", "TZ", "TMPDIR",
+	"GEM_", "BUNDLE_", "RUBYLIB", "RUBYOPT",
+	"RBENV_", "ASDF_", "MISE_", "RVM_", "MY_RUBY_HOME", "rvm_",
+}
+
+// Sandbox confines the Ruby processes that parse user input: resource
+// limits, an empty working directory of their own, a scrubbed environment,
+// optionally no network, and optionally an external wrapper such as
+// bubblewrap or nsjail. A nil *Sandbox runs processes as they are.
+type Sandbox struct {
+	dir      string
+	self     string
+	cpu      time.Duration
+	memory   int64
+	fileSize int64
+
+	isolateNetwork bool
+	wrapper        []string
+}
+
+// SandboxOptions are the limits a sandbox sets. Sizes of 0, like a CPU
+// time of 0, mean no limit.
+type SandboxOptions struct {
+	CPU            time.Duration
+	MemoryMB       int64
+	FileSizeMB     int64
+	IsolateNetwork bool
+	// Wrapper is "bwrap", "nsjail" or "" for none.
+	Wrapper string
+}
+
+func NewSandbox(opts SandboxOptions) (*Sandbox, error) {
+	dir, err := os.MkdirTemp("", "ruby-ast-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	sb := &Sandbox{
+		dir:            dir,
+		cpu:            opts.CPU,
+		memory:         opts.MemoryMB << 20,
+		fileSize:       opts.FileSizeMB << 20,
+		isolateNetwork: opts.IsolateNetwork,
+	}
+	if rlimitsSupported {
+		if sb.self, err = os.Executable(); err != nil {
+			return nil, fmt.Errorf("finding the sandbox helper: %w", err)
+		}
+	}
+	if sb.isolateNetwork && !networkIsolationSupported {
+		return nil, errors.New("-sandbox-isolate-network is only supported on Linux")
+	}
+
+	switch opts.Wrapper {
+	case "":
+	case "bwrap":
+		path, err := exec.LookPath("bwrap")
+		if err != nil {
+			return nil, err
+		}
+		sb.wrapper = []string{path,
+			"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc",
+			"--tmpfs", "/tmp", "--bind", dir, dir, "--chdir", dir,
+			"--unshare-all", "--die-with-parent", "--"}
+	case "nsjail":
+		path, err := exec.LookPath("nsjail")
+		if err != nil {
+			return nil, err
+		}
+		// The limits are left to the helper and the timeouts to the server,
+		// since nsjail's defaults would kill long-lived workers.
+		sb.wrapper = []string{path,
+			"--mode", "o", "--quiet", "--chroot", "/", "--bindmount", dir,
+			"--cwd", dir, "--keep_env", "--disable_rlimits", "--time_limit", "0", "--"}
+	default:
+		return nil, fmt.Errorf("-sandbox-wrapper must be bwrap or nsjail")
+	}
+	return sb, nil
+}
+
+// apply rewrites cmd to run inside the sandbox. The CPU limit is only set for
+// one-shot processes; a long-lived worker would use it up over many parses.
+func (sb *Sandbox) apply(cmd *exec.Cmd, oneShot bool) {
+	if sb == nil || cmd.Err != nil {
+		return
+	}
+	cmd.Dir = sb.dir
+	cmd.Env = sandboxEnv(os.Environ())
+
+	argv := append([]string{cmd.Path}, cmd.Args[1:]...)
+	if sb.self != "" {
+		var cpu time.Duration
+		if oneShot {
+			cpu = sb.cpu
+		}
+		limits := []string{sb.self, SandboxExecArg,
+			strconv.Itoa(int((cpu + time.Second - 1) / time.Second)),
+			strconv.FormatInt(sb.memory, 10),
+			strconv.FormatInt(sb.fileSize, 10),
+			"--"}
+		argv = append(limits, argv...)
+	}
+	if sb.wrapper != nil {
+		argv = append(append([]string(nil), sb.wrapper...), argv...)
+	}
+	cmd.Path, cmd.Args = argv[0], argv
+	if sb.isolateNetwork {
+		isolateNetwork(cmd)
+	}
+}
+
+func (sb *Sandbox) Close() {
+	if sb != nil {
+		os.RemoveAll(sb.dir)
+	}
+}
+
+func sandboxEnv(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, keep := range sandboxEnvKeep {
+			if name == keep || strings.HasSuffix(keep, "_") && strings.HasPrefix(name, keep) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
@@ -0,0 +1,17 @@
+//go:build !unix || openbsd
+
+package parser
+
+import (
+	"fmt"
+	"os"
+)
+
+// Resource limits need setrlimit and exec, so elsewhere the sandbox does
+// without them.
+const rlimitsSupported = false
+
+func SandboxExec(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: resource limits are not supported on this platform")
+	os.Exit(2)
+}
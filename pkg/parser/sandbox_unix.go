@@ -0,0 +1,43 @@
+//go:build unix && !openbsd
+
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const rlimitsSupported = true
+
+// SandboxExec is the sandbox's exec helper. Invoked as
+//
+//	ruby-ast-visualizer -sandbox-exec <cpu seconds> <memory bytes> <file size bytes> -- <command>...
+//
+// it sets the limits on itself, any of which may be 0 for none, and execs
+// the command in its place.
+func SandboxExec(args []string) {
+	if len(args) < 5 || args[3] != "--" {
+		fmt.Fprintln(os.Stderr, "sandbox: usage: -sandbox-exec cpu memory fsize -- command...")
+		os.Exit(2)
+	}
+	for i, resource := range []int{syscall.RLIMIT_CPU, syscall.RLIMIT_AS, syscall.RLIMIT_FSIZE} {
+		limit, err := strconv.ParseUint(args[i], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: invalid limit %q\n", args[i])
+			os.Exit(2)
+		}
+		if limit == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: setrlimit: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	command := args[4:]
+	err := syscall.Exec(command[0], command, os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: exec %s: %v\n", command[0], err)
+	os.Exit(127)
+}
@@ -0,0 +1,14 @@
+package parser
+
+import (
+	_ "embed"
+)
+
+//go:embed ruby/versions.rb
+var versionsScript string
+
+// NewVersionsParser returns the script that reports the versions of Ruby
+// and each parser gem.
+func NewVersionsParser(rubyBin string) Parser {
+	return &scriptParser{name: "versions", rubyBin: rubyBin, script: versionsScript}
+}
@@ -0,0 +1,362 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghousemohamed/ruby-ast-visualizer/internal/telemetry"
+)
+
+//go:embed ruby/stree_worker.rb
+var workerScript string
+
+const workerRestartDelay = time.Second
+
+var errWorkerCrashed = errors.New("parser worker crashed")
+
+type worker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	done   chan struct{}
+}
+
+func startWorker(rubyBin string, sb *Sandbox) (*worker, error) {
+	cmd := exec.Command(rubyBin, "-e", workerScript)
+	cmd.WaitDelay = processWaitDelay
+	sb.apply(cmd, false)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	CountSpawnFailure(cmd, err)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &worker{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(w.done)
+	}()
+	return w, nil
+}
+
+func (w *worker) alive() bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *worker) kill() {
+	w.stdin.Close()
+	w.cmd.Process.Kill()
+}
+
+func (w *worker) parse(code string) ([]byte, error) {
+	req, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{code})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.stdin.Write(append(req, '\n')); err != nil {
+		return nil, errWorkerCrashed
+	}
+
+	line, err := w.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, errWorkerCrashed
+	}
+	return decodeWorkerResponse(line)
+}
+
+// decodeWorkerResponse unwraps a response line from the worker script: the
+// AST, or the syntax error.
+func decodeWorkerResponse(line []byte) ([]byte, error) {
+	var resp struct {
+		AST json.RawMessage `json:"ast"`
+		SyntaxError
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid worker response: %w", err)
+	}
+	if resp.Message != "" {
+		return nil, &resp.SyntaxError
+	}
+	return resp.AST, nil
+}
+
+// parseTo is parse for a successful response, copied to out a chunk at a
+// time rather than read into memory: the {"ast": ...} wrapper is dropped and
+// the tree passed through as is. It returns errWorkerCrashed if the worker
+// dies part way, possibly after writing to out. Errors writing to out are the
+// caller's to track; the response is read to the end regardless, so the
+// worker can be reused.
+func (w *worker) parseTo(code string, out io.Writer) error {
+	req, err := json.Marshal(struct {
+		Code string `json:"code"`
+	}{code})
+	if err != nil {
+		return err
+	}
+	if _, err := w.stdin.Write(append(req, '\n')); err != nil {
+		return errWorkerCrashed
+	}
+
+	key, err := w.stdout.ReadBytes(':')
+	if err != nil {
+		return errWorkerCrashed
+	}
+	if string(bytes.TrimSpace(key)) != `{"ast":` {
+		rest, err := w.stdout.ReadBytes('\n')
+		if err != nil {
+			return errWorkerCrashed
+		}
+		var resp SyntaxError
+		if err := json.Unmarshal(append(key, rest...), &resp); err != nil || resp.Message == "" {
+			return fmt.Errorf("invalid worker response: %s", bytes.TrimSpace(append(key, rest...)))
+		}
+		return &resp
+	}
+	for {
+		if c, err := w.stdout.ReadByte(); err != nil {
+			return errWorkerCrashed
+		} else if c != ' ' {
+			w.stdout.UnreadByte()
+			break
+		}
+	}
+
+	// Each chunk but the last is written one byte short, since the closing
+	// brace of the wrapper can only be told apart at the end of the line.
+	var held []byte
+	for {
+		chunk, err := w.stdout.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return errWorkerCrashed
+		}
+		if err == nil {
+			line := append(held, bytes.TrimRight(chunk, " \r\n")...)
+			if !bytes.HasSuffix(line, []byte("}")) {
+				return errors.New("invalid worker response: unterminated AST")
+			}
+			out.Write(line[:len(line)-1])
+			return nil
+		}
+		out.Write(held)
+		out.Write(chunk[:len(chunk)-1])
+		held = append(held[:0], chunk[len(chunk)-1])
+	}
+}
+
+type WorkerPool struct {
+	rubyBin string
+	sandbox *Sandbox
+	Size    int
+	idle    chan *worker
+	quit    chan struct{}
+
+	// restarting counts workers that have died and not yet come back.
+	restarting atomic.Int32
+}
+
+func NewWorkerPool(rubyBin string, sb *Sandbox, size int) (*WorkerPool, error) {
+	p := &WorkerPool{
+		rubyBin: rubyBin,
+		sandbox: sb,
+		Size:    size,
+		idle:    make(chan *worker, size),
+		quit:    make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		w, err := startWorker(rubyBin, sb)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle <- w
+	}
+	return p, nil
+}
+
+func (p *WorkerPool) acquire(ctx context.Context) (*worker, error) {
+	for {
+		select {
+		case w := <-p.idle:
+			if w.alive() {
+				return w, nil
+			}
+			p.replace(w)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parse runs code through an idle worker. If ctx is cancelled mid-parse the
+// worker is killed and replaced, since its response can no longer be matched
+// to a request.
+func (p *WorkerPool) parse(ctx context.Context, code string) ([]byte, error) {
+	_, sp := telemetry.StartSpan(ctx, "acquire worker")
+	w, err := p.acquire(ctx)
+	sp.SetError(err)
+	sp.End()
+	if err != nil {
+		return nil, err
+	}
+
+	_, sp = telemetry.StartSpan(ctx, "worker round trip")
+	defer sp.End()
+
+	type result struct {
+		output []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := w.parse(code)
+		done <- result{output, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil && !IsSyntaxError(res.err) {
+			p.replace(w)
+			return nil, res.err
+		}
+		p.idle <- w
+		return res.output, res.err
+	case <-ctx.Done():
+		p.replace(w)
+		return nil, ctx.Err()
+	}
+}
+
+// parseTo streams a parse through an idle worker to out, as parse does.
+func (p *WorkerPool) parseTo(ctx context.Context, code string, out io.Writer) error {
+	_, sp := telemetry.StartSpan(ctx, "acquire worker")
+	w, err := p.acquire(ctx)
+	sp.SetError(err)
+	sp.End()
+	if err != nil {
+		return err
+	}
+
+	_, sp = telemetry.StartSpan(ctx, "worker round trip")
+	defer sp.End()
+
+	sw := &stickyWriter{w: out}
+	done := make(chan error, 1)
+	go func() {
+		done <- w.parseTo(code, sw)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && !IsSyntaxError(err) {
+			p.replace(w)
+			return err
+		}
+		p.idle <- w
+		if err != nil {
+			return err
+		}
+		return sw.err
+	case <-ctx.Done():
+		p.replace(w)
+		// Killing the worker ends its output, so this doesn't wait long, and
+		// out is not written to once we return.
+		<-done
+		return ctx.Err()
+	}
+}
+
+// stickyWriter remembers the first error writing to w and drops everything
+// after it, while reporting success to its own caller.
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *stickyWriter) Write(b []byte) (int, error) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+	return len(b), nil
+}
+
+// replace kills w and starts a new worker in its slot, retrying until the
+// Ruby process comes up again or the pool is closed.
+func (p *WorkerPool) replace(w *worker) {
+	w.kill()
+	workerRestarts.Inc()
+	p.restarting.Add(1)
+	go func() {
+		for {
+			nw, err := startWorker(p.rubyBin, p.sandbox)
+			if err == nil {
+				p.restarting.Add(-1)
+				select {
+				case <-p.quit:
+					nw.kill()
+				default:
+					p.idle <- nw
+				}
+				return
+			}
+			slog.Error("Error restarting parser worker", "err", err)
+			select {
+			case <-p.quit:
+				return
+			case <-time.After(workerRestartDelay):
+			}
+		}
+	}()
+}
+
+// Running returns how many workers are up, busy or idle.
+func (p *WorkerPool) Running() int {
+	return p.Size - int(p.restarting.Load())
+}
+
+// IdleCount returns how many workers are waiting for a request.
+func (p *WorkerPool) IdleCount() int {
+	return len(p.idle)
+}
+
+func (p *WorkerPool) Close() {
+	close(p.quit)
+	for {
+		select {
+		case w := <-p.idle:
+			w.kill()
+		default:
+			return
+		}
+	}
+}
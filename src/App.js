@@ -8,6 +8,8 @@ import { highlight, languages } from 'prismjs';
 import 'prismjs/components/prism-ruby';
 import 'prismjs/themes/prism.css';
 
+const API_URL = process.env.REACT_APP_API_URL || '/api/v1';
+
 function createTreeLayout(nodes, edges) {
   const nodeMap = new Map(nodes.map(node => [node.id, { ...node, children: [] }]));
   edges.forEach(edge => {
@@ -65,11 +67,10 @@ function parseAst(ast) {
   function getNodeLabel(node) {
     if (typeof node === 'object' && node !== null) {
       if (node.type) {
-        if (typeof node.value === 'string') {
-          return `${node.type}: "${node.value}"`;
-        } else {
-          return node.type;
-        }
+        const label = typeof node.value === 'string' ? `${node.type}: "${node.value}"` : node.type;
+        // Methods annotated by /types show what they return.
+        const types = node.attributes && node.attributes.types;
+        return types ? `${label} → ${types.overloads.map((o) => o.returns).join(' | ')}` : label;
       } else {
         if (Array.isArray(node)) {
           return "optionals"
@@ -86,7 +87,9 @@ function parseAst(ast) {
       return;
     }
 
-    const nodeId = getUniqueId(node.type || 'unnamed');
+    // Stable IDs from the server let React Flow keep nodes that survive an
+    // edit rather than rebuilding the whole tree.
+    const nodeId = (node.attributes && node.attributes.id) || getUniqueId(node.type || 'unnamed');
     
     if (node.type !== null) {
       nodes.push({
@@ -110,7 +113,7 @@ function parseAst(ast) {
     }
 
     for (let key in node) {
-      if (node.hasOwnProperty(key)) {
+      if (node.hasOwnProperty(key) && key !== 'attributes') {
         if (Array.isArray(node[key])) {
           node[key].forEach((item) => processNode(item, nodeId));
         } else if (typeof node[key] === 'object' && node[key] !== null) {
@@ -131,38 +134,203 @@ function App() {
   const [selectedRange, setSelectedRange] = useState(null);
   const [key, setKey] = useState(0);
   const [selectedNode, setSelectedNode] = useState(null);
+  const [history, setHistory] = useState([]);
+  const [explanation, setExplanation] = useState(null);
+  const [exampleGroups, setExampleGroups] = useState([]);
+  const [diagnostics, setDiagnostics] = useState([]);
   const nodesRef = useRef([]);
+  const explanationsRef = useRef({});
 
   const handleEditorChange = useCallback((code) => {
     setRubyCode(code);
   }, []);
 
-  const handleRenderAst = async () => {
+  const showAst = useCallback((ast) => {
+    let { nodes: parsedNodes, edges: parsedEdges } = parseAst(ast);
+    parsedNodes = createTreeLayout(parsedNodes, parsedEdges);
+    setNodes(parsedNodes);
+    setEdges(parsedEdges);
+    nodesRef.current = parsedNodes;
+  }, [setNodes, setEdges]);
+
+  const loadHistory = useCallback(async () => {
+    try {
+      const response = await fetch(`${API_URL}/history`);
+      if (!response.ok) return;
+      const { data: { entries } } = await response.json();
+      setHistory(entries);
+    } catch (error) {
+      console.error('Failed to load history:', error);
+    }
+  }, []);
+
+  useEffect(() => {
+    loadHistory();
+  }, [loadHistory]);
+
+  useEffect(() => {
+    fetch(`${API_URL}/examples`)
+      .then((response) => (response.ok ? response.json() : { data: { groups: [] } }))
+      .then(({ data: { groups } }) => setExampleGroups(groups))
+      .catch((error) => console.error('Failed to load examples:', error));
+  }, []);
+
+  // renderPartial shows what prism could recover from code with syntax
+  // errors, so the tree stays up while the code is mid-edit.
+  const renderPartial = async (code) => {
+    const response = await fetch(`${API_URL}/parse/partial`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ code, ids: true }),
+    });
+
+    if (!response.ok) {
+      throw new Error(`HTTP error! status: ${response.status}`);
+    }
+
+    const { data: { ast, diagnostics } } = await response.json();
+    showAst(ast);
+    setDiagnostics(diagnostics);
+  };
+
+  const renderCode = async (code) => {
     try {
-      const response = await fetch(process.env.PARSE_URL || 'https://server.ruby-ast-visualizer.net/parse', {
+      const response = await fetch(`${API_URL}/parse`, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
-        body: JSON.stringify({ code: rubyCode }),
+        body: JSON.stringify({ code, ids: true }),
       });
 
+      if (response.status === 422) {
+        await renderPartial(code);
+        return;
+      }
       if (!response.ok) {
         throw new Error(`HTTP error! status: ${response.status}`);
       }
 
-      const ast = await response.json();
-      let { nodes: parsedNodes, edges: parsedEdges } = parseAst(ast);
-      parsedNodes = createTreeLayout(parsedNodes, parsedEdges);
-      setNodes(parsedNodes);
-      setEdges(parsedEdges);
-      nodesRef.current = parsedNodes;
+      const { data: ast } = await response.json();
+      showAst(ast);
+      setDiagnostics([]);
+      loadHistory();
     } catch (error) {
       console.error('Failed to parse AST:', error);
       alert('Failed to parse AST. Please check your input and ensure the server is running.');
     }
   };
 
+  const handleRenderAst = () => renderCode(rubyCode);
+
+  const handleHistorySelect = (event) => {
+    const entry = history[event.target.value];
+    if (!entry) return;
+    setRubyCode(entry.code);
+    setKey(prevKey => prevKey + 1);
+    renderCode(entry.code);
+  };
+
+  const handleExampleSelect = (event) => {
+    const [group, i] = event.target.value.split(':');
+    const entry = exampleGroups[group] && exampleGroups[group].examples[i];
+    if (!entry) return;
+    setRubyCode(entry.code);
+    setKey(prevKey => prevKey + 1);
+    renderCode(entry.code);
+  };
+
+  const handleGenerate = async () => {
+    try {
+      const response = await fetch(`${API_URL}/generate?parse=false`);
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+      const { data: { code } } = await response.json();
+      setRubyCode(code);
+      setKey(prevKey => prevKey + 1);
+      renderCode(code);
+    } catch (error) {
+      console.error('Failed to generate code:', error);
+      alert('Failed to generate a program. Please ensure the server is running.');
+    }
+  };
+
+  const handleShare = async () => {
+    try {
+      const response = await fetch(`${API_URL}/snippets`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ code: rubyCode }),
+      });
+
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+
+      const { data: { id } } = await response.json();
+      const url = new URL(window.location.href);
+      url.search = `?snippet=${encodeURIComponent(id)}`;
+      window.history.replaceState(null, '', url);
+      window.prompt('Share this link to the AST:', url.toString());
+    } catch (error) {
+      console.error('Failed to share snippet:', error);
+      alert('Failed to share. Please check your input and ensure the server is running.');
+    }
+  };
+
+  const handleExport = async (kind) => {
+    try {
+      const response = await fetch(`${API_URL}/export/${kind}`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ code: rubyCode }),
+      });
+
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+
+      const url = URL.createObjectURL(await response.blob());
+      const link = document.createElement('a');
+      link.href = url;
+      link.download = `ast.${kind}`;
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch (error) {
+      console.error(`Failed to export ${kind}:`, error);
+      alert('Failed to export. Please check your input and ensure the server is running.');
+    }
+  };
+
+  useEffect(() => {
+    const id = new URLSearchParams(window.location.search).get('snippet');
+    if (!id) return;
+
+    fetch(`${API_URL}/snippets/${encodeURIComponent(id)}`)
+      .then((response) => {
+        if (!response.ok) {
+          throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        return response.json();
+      })
+      .then(({ data: snippet }) => {
+        setRubyCode(snippet.code);
+        setKey(prevKey => prevKey + 1);
+        showAst(snippet.ast);
+      })
+      .catch((error) => {
+        console.error('Failed to load snippet:', error);
+        alert('That shared snippet could not be loaded.');
+      });
+  }, [showAst]);
+
   const handleNodeClick = useCallback((event, node) => {
     const astNode = node.data.astNode;
     if (astNode && astNode.location) {
@@ -172,16 +340,38 @@ function App() {
     }
   }, []);
 
+  const handleNodeMouseEnter = useCallback((event, node) => {
+    const type = node.data.astNode && node.data.astNode.type;
+    if (!type) return;
+    const cached = explanationsRef.current[type];
+    if (cached !== undefined) {
+      setExplanation(cached);
+      return;
+    }
+    fetch(`${API_URL}/explain/${encodeURIComponent(type)}`)
+      .then((response) => (response.ok ? response.json() : { data: null }))
+      .then(({ data }) => {
+        explanationsRef.current[type] = data;
+        setExplanation(data);
+      })
+      .catch((error) => console.error('Failed to explain node:', error));
+  }, []);
+
+  const handleNodeMouseLeave = useCallback(() => setExplanation(null), []);
+
   const highlightCode = (code) => {
     if (!selectedRange) return highlight(code, languages.ruby);
 
     const { start_line, start_column, end_line, end_column } = selectedRange;
     const lines = code.split('\n');
 
+    // Locations count characters, not UTF-16 units, so lines are measured
+    // and cut by code point.
     let charCount = 0;
     return lines.map((line, i) => {
       const lineNum = i + 1;
-      const lineLength = line.length + 1;
+      const chars = Array.from(line);
+      const lineLength = chars.length + 1;
 
       const lineStartChar = charCount;
       const lineEndChar = charCount + lineLength;
@@ -194,9 +384,9 @@ function App() {
       let startIdx = Math.max(0, start_line === lineNum ? start_column - lineStartChar : 0);
       let endIdx = end_line === lineNum ? end_column - lineStartChar : lineLength - 1;
 
-      const beforeHighlight = line.slice(0, startIdx);
-      const highlightedPart = line.slice(startIdx, endIdx);
-      const afterHighlight = line.slice(endIdx);
+      const beforeHighlight = chars.slice(0, startIdx).join('');
+      const highlightedPart = chars.slice(startIdx, endIdx).join('');
+      const afterHighlight = chars.slice(endIdx).join('');
 
       return (
         highlight(beforeHighlight, languages.ruby) +
@@ -234,6 +424,58 @@ function App() {
             }}
           />
           <button onClick={handleRenderAst} style={{ marginTop: '10px' }}>Render AST</button>
+          <button onClick={handleGenerate} style={{ marginTop: '10px', marginLeft: '10px' }}>Random</button>
+          <button onClick={handleShare} style={{ marginTop: '10px', marginLeft: '10px' }}>Share</button>
+          <button onClick={() => handleExport('html')} style={{ marginTop: '10px', marginLeft: '10px' }}>Export HTML</button>
+          <button onClick={() => handleExport('png')} style={{ marginTop: '10px', marginLeft: '10px' }}>Export PNG</button>
+          {history.length > 0 && (
+            <select value="" onChange={handleHistorySelect} style={{ marginTop: '10px', marginLeft: '10px' }}>
+              <option value="" disabled>History</option>
+              {history.map((entry, i) => (
+                <option key={entry.created_at} value={i}>
+                  {new Date(entry.created_at).toLocaleTimeString()}: {entry.code.split('\n')[0].slice(0, 40)}
+                </option>
+              ))}
+            </select>
+          )}
+          {exampleGroups.length > 0 && (
+            <select value="" onChange={handleExampleSelect} style={{ marginTop: '10px', marginLeft: '10px' }}>
+              <option value="" disabled>Load example</option>
+              {exampleGroups.map((group, g) => (
+                <optgroup key={group.id} label={group.title}>
+                  {group.examples.map((entry, i) => (
+                    <option key={entry.id} value={`${g}:${i}`}>{entry.title}</option>
+                  ))}
+                </optgroup>
+              ))}
+            </select>
+          )}
+          {diagnostics.length > 0 && (
+            <ul className="diagnostics">
+              {diagnostics.map((diagnostic, i) => (
+                <li key={i} className={diagnostic.level}>
+                  Line {diagnostic.location[0]}: {diagnostic.message}
+                </li>
+              ))}
+            </ul>
+          )}
+          {explanation && (
+            <div className="explanation">
+              <h3>{explanation.title} <code>{explanation.type}</code></h3>
+              <p>{explanation.description}</p>
+              <pre>{explanation.example}</pre>
+              {explanation.fields.length > 0 && (
+                <dl>
+                  {explanation.fields.map((field) => (
+                    <React.Fragment key={field.name}>
+                      <dt><code>{field.name}</code></dt>
+                      <dd>{field.description}</dd>
+                    </React.Fragment>
+                  ))}
+                </dl>
+              )}
+            </div>
+          )}
         </div>
         <div style={{ width: '70%', height: '100%' }}>
           <ReactFlow
@@ -248,6 +490,8 @@ function App() {
             onNodesChange={onNodesChange}
             onEdgesChange={onEdgesChange}
             onNodeClick={handleNodeClick}
+            onNodeMouseEnter={handleNodeMouseEnter}
+            onNodeMouseLeave={handleNodeMouseLeave}
             fitView
             fitViewOptions={{ padding: 0.2, maxZoom: 1 }}
             minZoom={0.1}